@@ -0,0 +1,151 @@
+package apptest_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/kevin-chtw/tw_common/apptest"
+	"github.com/kevin-chtw/tw_common/gamebase/game"
+	"github.com/kevin-chtw/tw_common/gamebase/service"
+	"github.com/kevin-chtw/tw_proto/cproto"
+	"github.com/kevin-chtw/tw_proto/sproto"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// idleBot 不做任何操作的bot
+type idleBot struct{}
+
+func (idleBot) OnBotMsg(msg proto.Message) error { return nil }
+func (idleBot) OnTimer() error                   { return nil }
+
+func TestAdmin(t *testing.T) {
+	gameApp := apptest.NewApp("gametest")
+	game.Init(gameApp, func(table *game.Table, id int32) game.IGame {
+		return &roundGame{table: table, id: id}
+	}, func(uid string, matchid, tableid int32, scorebase int64) *game.BotPlayer {
+		p := game.NewBotPlayer(uid, matchid, tableid, scorebase)
+		p.Bot = idleBot{}
+		return p
+	})
+	remote := service.NewRemote(gameApp)
+	remote.Init()
+	ctx := context.Background()
+	send2Game := func(msg proto.Message) {
+		data, err := anypb.New(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := remote.Message(ctx, &sproto.GameReq{Matchid: 1, Tableid: 1, Req: data}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	send2Game(&sproto.AddTableReq{MatchType: "normal", PlayerCount: 2, GameCount: 1})
+	send2Game(&sproto.AddPlayerReq{Playerid: "p0", Seat: 0})
+	send2Game(&sproto.AddPlayerReq{Playerid: "p1", Seat: 1})
+	svc := service.NewPlayer(gameApp)
+	for _, uid := range []string{"p0", "p1"} {
+		sendGameMsg(t, gameApp, svc, uid, "tcp", 1, &cproto.EnterGameReq{})
+	}
+
+	admin := service.NewAdmin(gameApp)
+	admin.Init()
+	var mu sync.Mutex
+	var audits []*service.AdminAudit
+	admin.SetAuditor(func(audit *service.AdminAudit) {
+		mu.Lock()
+		defer mu.Unlock()
+		audits = append(audits, audit)
+	})
+	call := func(args map[string]any) (any, error) {
+		in, err := structpb.NewStruct(args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := admin.Message(ctx, in)
+		if err != nil {
+			return nil, err
+		}
+		return out.AsMap()["result"], nil
+	}
+
+	// 没有设置授权时拒绝
+	if _, err := call(map[string]any{"op": service.AdminList}); err == nil {
+		t.Fatal("admin allowed without authorizer")
+	}
+	admin.SetAuthorizer(func(ctx context.Context, req *service.AdminReq) error {
+		if req.Operator != "ops" {
+			return errors.New("forbidden")
+		}
+		return nil
+	})
+
+	result, err := call(map[string]any{"op": service.AdminList, "operator": "ops", "matchid": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tables := result.([]any)
+	if len(tables) != 1 {
+		t.Fatalf("tables = %v", tables)
+	}
+	info := tables[0].(map[string]any)
+	if info["playing"] != true || len(info["players"].([]any)) != 2 {
+		t.Errorf("table = %v", info)
+	}
+
+	if _, err := call(map[string]any{"op": service.AdminKick, "operator": "ops", "matchid": 1, "tableid": 1, "uid": "p1"}); err == nil {
+		t.Error("kick while playing")
+	}
+	if _, err := call(map[string]any{"op": service.AdminReplace, "operator": "ops", "matchid": 1, "tableid": 1, "uid": "p1"}); err != nil {
+		t.Fatal(err)
+	}
+	if game.GetPlayerManager().Get(false, "p1") != nil || game.GetPlayerManager().Get(true, "p1") == nil {
+		t.Error("player not replaced by bot")
+	}
+
+	gameApp.ClearPushes()
+	if _, err := call(map[string]any{"op": service.AdminNotice, "operator": "ops", "matchid": 1, "tableid": 1, "text": "maintenance"}); err != nil {
+		t.Fatal(err)
+	}
+	pushes := gameApp.Pushes("p0")
+	if len(pushes) != 1 {
+		t.Fatalf("pushes = %d", len(pushes))
+	}
+	ack := &cproto.GameAck{}
+	if err := proto.Unmarshal(pushes[0].Data, ack); err != nil {
+		t.Fatal(err)
+	}
+	notice := &wrapperspb.StringValue{}
+	if err := ack.Ack.UnmarshalTo(notice); err != nil || notice.Value != "maintenance" {
+		t.Errorf("notice = %v, %v", notice, err)
+	}
+
+	if _, err := call(map[string]any{"op": service.AdminDump, "operator": "guest", "matchid": 1, "tableid": 1}); err == nil {
+		t.Error("unauthorized operator allowed")
+	}
+	if result, err := call(map[string]any{"op": service.AdminDump, "operator": "ops", "matchid": 1, "tableid": 1}); err != nil || result.(map[string]any)["tableid"] != float64(1) {
+		t.Errorf("dump = %v, %v", result, err)
+	}
+	if _, err := call(map[string]any{"op": service.AdminDissolve, "operator": "ops", "matchid": 1, "tableid": 1}); err != nil {
+		t.Fatal(err)
+	}
+	if game.GetTableManager().Get(1, 1) != nil {
+		t.Error("table not dissolved")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(audits) != 8 {
+		t.Fatalf("audits = %d", len(audits))
+	}
+	if last := audits[len(audits)-1]; last.Req.Op != service.AdminDissolve || last.Req.Operator != "ops" || last.Err != "" {
+		t.Errorf("last audit = %+v", last)
+	}
+	if audits[0].Err == "" {
+		t.Error("denied call not audited as error")
+	}
+}
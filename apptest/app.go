@@ -0,0 +1,209 @@
+// Package apptest 提供进程内的假pitaya应用，不需要集群就可以在go test里跑通比赛服和游戏服
+package apptest
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/kevin-chtw/tw_common/utils"
+	"github.com/kevin-chtw/tw_proto/sproto"
+	pitaya "github.com/topfreegames/pitaya/v3/pkg"
+	"github.com/topfreegames/pitaya/v3/pkg/cluster"
+	"github.com/topfreegames/pitaya/v3/pkg/constants"
+	"github.com/topfreegames/pitaya/v3/pkg/interfaces"
+	"github.com/topfreegames/pitaya/v3/pkg/session"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/runtime/protoiface"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// Handler 处理路由到本进程的rpc
+type Handler func(ctx context.Context, arg proto.Message) (proto.Message, error)
+
+// Push 推送给玩家的消息
+type Push struct {
+	Route string
+	Data  []byte
+}
+
+// App 假的pitaya应用，只实现比赛服和游戏服用到的接口，其他接口调用时panic
+type App struct {
+	pitaya.Pitaya
+	server   *cluster.Server
+	sessions session.SessionPool
+
+	mu       sync.Mutex
+	handlers map[string]Handler // route或serverID/route -> handler
+	servers  map[string]*cluster.Server
+	pushes   map[string][]*Push // uid -> 推送
+	modules  map[string]interfaces.Module
+}
+
+// NewApp 创建指定服务器类型的假应用，默认处理account.remote.message
+func NewApp(serverType string) *App {
+	a := &App{
+		server:   &cluster.Server{ID: serverType + "-test", Type: serverType, Frontend: false},
+		sessions: session.NewSessionPool(),
+		handlers: make(map[string]Handler),
+		servers:  make(map[string]*cluster.Server),
+		pushes:   make(map[string][]*Push),
+		modules:  make(map[string]interfaces.Module),
+	}
+	a.Handle("account.remote.message", AccountHandler)
+	return a
+}
+
+// Handle 注册route的处理函数，route形如<servertype>.remote.message
+func (a *App) Handle(route string, h Handler) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.handlers[route] = h
+}
+
+// HandleServer 注册指定服务器上route的处理函数，RPCTo按serverID路由，
+// GetServersByType能找到这个服务器，用于模拟同类型的多个服务器
+func (a *App) HandleServer(serverID, route string, h Handler) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	serverType, _, _ := strings.Cut(route, ".")
+	a.servers[serverID] = &cluster.Server{ID: serverID, Type: serverType}
+	a.handlers[serverID+"/"+route] = h
+}
+
+// RemoteHandler 把remote组件的Message这类方法包装成Handler
+func RemoteHandler[T, R proto.Message](fn func(context.Context, T) (R, error)) Handler {
+	return func(ctx context.Context, arg proto.Message) (proto.Message, error) {
+		return fn(ctx, arg.(T))
+	}
+}
+
+// AccountHandler 默认的账号服，按uid返回玩家信息
+func AccountHandler(ctx context.Context, arg proto.Message) (proto.Message, error) {
+	req := arg.(*sproto.AccountReq)
+	info := &sproto.PlayerInfoReq{}
+	if err := req.Req.UnmarshalTo(info); err != nil {
+		return nil, err
+	}
+	data, err := anypb.New(&sproto.PlayerInfoAck{Uid: info.Uid, Nickname: info.Uid})
+	if err != nil {
+		return nil, err
+	}
+	return &sproto.AccountAck{Ack: data}, nil
+}
+
+// NewContext 创建带session的玩家上下文，netType为ws时消息按json编码
+func (a *App) NewContext(uid, netType string) context.Context {
+	s := a.sessions.NewSession(nil, false, uid)
+	s.Set(utils.NetType, netType)
+	return context.WithValue(context.Background(), constants.SessionCtxKey, s)
+}
+
+// Pushes 获取推送给玩家的全部消息
+func (a *App) Pushes(uid string) []*Push {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]*Push(nil), a.pushes[uid]...)
+}
+
+// ClearPushes 清空推送记录
+func (a *App) ClearPushes() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.pushes = make(map[string][]*Push)
+}
+
+func (a *App) GetServerID() string {
+	return a.server.ID
+}
+
+func (a *App) GetServer() *cluster.Server {
+	return a.server
+}
+
+func (a *App) GetSessionFromCtx(ctx context.Context) session.Session {
+	s := ctx.Value(constants.SessionCtxKey)
+	if s == nil {
+		return nil
+	}
+	return s.(session.Session)
+}
+
+func (a *App) RPC(ctx context.Context, routeStr string, reply, arg protoiface.MessageV1) error {
+	a.mu.Lock()
+	h, ok := a.handlers[routeStr]
+	a.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("route %s not found", routeStr)
+	}
+	rsp, err := h(ctx, arg.(proto.Message))
+	if err != nil {
+		return err
+	}
+	out := reply.(proto.Message)
+	proto.Reset(out)
+	if rsp != nil && rsp.ProtoReflect().IsValid() {
+		proto.Merge(out, rsp)
+	}
+	return nil
+}
+
+// RPCTo 优先使用HandleServer注册的处理函数，没有时和RPC一样按route处理
+func (a *App) RPCTo(ctx context.Context, serverID, routeStr string, reply, arg protoiface.MessageV1) error {
+	a.mu.Lock()
+	_, ok := a.handlers[serverID+"/"+routeStr]
+	a.mu.Unlock()
+	if ok {
+		routeStr = serverID + "/" + routeStr
+	}
+	return a.RPC(ctx, routeStr, reply, arg)
+}
+
+// GetServersByType 返回HandleServer注册的服务器
+func (a *App) GetServersByType(t string) (map[string]*cluster.Server, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	servers := make(map[string]*cluster.Server)
+	for id, server := range a.servers {
+		if server.Type == t {
+			servers[id] = server
+		}
+	}
+	if len(servers) == 0 {
+		return nil, constants.ErrNoServersAvailableOfType
+	}
+	return servers, nil
+}
+
+func (a *App) SendPushToUsers(route string, v any, uids []string, frontendType string) ([]string, error) {
+	data, ok := v.([]byte)
+	if !ok {
+		return uids, fmt.Errorf("unsupported push %T", v)
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, uid := range uids {
+		a.pushes[uid] = append(a.pushes[uid], &Push{Route: route, Data: data})
+	}
+	return nil, nil
+}
+
+func (a *App) RegisterModule(module interfaces.Module, name string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.modules[name]; ok {
+		return fmt.Errorf("module %s already registered", name)
+	}
+	a.modules[name] = module
+	return nil
+}
+
+func (a *App) GetModule(name string) (interfaces.Module, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if m, ok := a.modules[name]; ok {
+		return m, nil
+	}
+	return nil, fmt.Errorf("module %s not found", name)
+}
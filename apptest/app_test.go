@@ -0,0 +1,157 @@
+package apptest_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kevin-chtw/tw_common/apptest"
+	"github.com/kevin-chtw/tw_common/gamebase/game"
+	"github.com/kevin-chtw/tw_common/gamebase/service"
+	"github.com/kevin-chtw/tw_common/matchbase"
+	"github.com/kevin-chtw/tw_common/storage"
+	"github.com/kevin-chtw/tw_common/utils"
+	"github.com/kevin-chtw/tw_proto/cproto"
+	"github.com/kevin-chtw/tw_proto/sproto"
+	"github.com/topfreegames/pitaya/v3/pkg/config"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// roundGame 收到第一条游戏消息时发送者赢1分并结束本局
+type roundGame struct {
+	table *game.Table
+	id    int32
+}
+
+func (g *roundGame) OnGameBegin() {}
+
+func (g *roundGame) OnPlayerMsg(player *game.Player, data []byte) error {
+	player.AddScore(1)
+	g.table.GetGamePlayer(1 - player.GetSeat()).AddScore(-1)
+	g.table.NotifyGameOver(g.id, "round")
+	return nil
+}
+
+func (g *roundGame) OnGameTimer()                                  {}
+func (g *roundGame) OnNetChange(player *game.Player, offline bool) {}
+
+func sendGameMsg(t *testing.T, app *apptest.App, svc *service.Player, uid, netType string, tableID int32, msg proto.Message) {
+	data, err := anypb.New(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := app.NewContext(uid, netType)
+	payload, err := utils.Marshal(ctx, &cproto.GameReq{Matchid: 1, Tableid: tableID, Req: data})
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc.Message(ctx, payload)
+}
+
+func newTable(t *testing.T, match *matchbase.Match) *matchbase.Table {
+	t.Helper()
+	table, err := matchbase.NewTableErr(match, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return table
+}
+
+func TestMatchFlow(t *testing.T) {
+	gameApp := apptest.NewApp("gametest")
+	matchApp := apptest.NewApp("matchtest")
+
+	game.Init(gameApp, func(table *game.Table, id int32) game.IGame {
+		return &roundGame{table: table, id: id}
+	}, nil)
+	remote := service.NewRemote(gameApp)
+	remote.Init()
+	matchApp.Handle("gametest.remote.message", apptest.RemoteHandler(remote.Message))
+	results := make(chan *sproto.GameResultReq, 1)
+	var match *matchbase.Match
+	gameApp.Handle("matchtest.remote.message", func(ctx context.Context, arg proto.Message) (proto.Message, error) {
+		msg, err := arg.(*sproto.MatchReq).Req.UnmarshalNew()
+		if err != nil {
+			return nil, err
+		}
+		if result, ok := msg.(*sproto.GameResultReq); ok && match.AcceptResult(result) {
+			results <- result
+		}
+		return &sproto.MatchAck{}, nil
+	})
+
+	file := filepath.Join(t.TempDir(), "match.yaml")
+	conf := "matchid: 1\ngame_type: gametest\nplayer_per_table: 2\nscore_base: 1\n"
+	if err := os.WriteFile(file, []byte(conf), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	matchApp.RegisterModule(storage.NewETCDMatching(matchApp.GetServer(), config.ETCDBindingConfig{}), "matchingstorage")
+	match = matchbase.NewMatch(matchApp, file, nil)
+	table := newTable(t, match)
+	match.AddTable(table)
+	if err := table.SendAddTableReq(1, "", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	uids := []string{"ws", "tcp"}
+	for _, uid := range uids {
+		p := matchbase.NewPlayer(nil, matchApp.NewContext(uid, uid), uid, 1, 1000)
+		if err := table.AddPlayer(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	svc := service.NewPlayer(gameApp)
+	for _, uid := range uids {
+		sendGameMsg(t, gameApp, svc, uid, uid, table.ID, &cproto.EnterGameReq{})
+	}
+	sendGameMsg(t, gameApp, svc, "ws", "ws", table.ID, &cproto.TableMsgReq{Msg: []byte{1}})
+
+	result := <-results
+	if result.Tableid != table.ID || result.RoundData != "round" {
+		t.Errorf("result = %v", result)
+	}
+	if result.Scores["ws"] != 1001 || result.Scores["tcp"] != 999 {
+		t.Errorf("scores = %v", result.Scores)
+	}
+	if match.AcceptResult(result) {
+		t.Error("resent result accepted")
+	}
+	// 桌子删除后重发的结果不再处理，桌号不会马上复用
+	match.DelTable(table.ID)
+	if match.AcceptResult(result) {
+		t.Error("result of deleted table accepted")
+	}
+	next := newTable(t, match)
+	if next.ID == table.ID {
+		t.Error("table id reused immediately")
+	}
+	match.PutBackTableId(next.ID)
+
+	// websocket玩家收到json，其他玩家收到protobuf
+	for _, uid := range uids {
+		pushes := gameApp.Pushes(uid)
+		if len(pushes) == 0 {
+			t.Fatalf("%s has no push", uid)
+		}
+		ack := &cproto.GameAck{}
+		if err := utils.Unmarshal(gameApp.NewContext(uid, uid), pushes[0].Data, ack); err != nil {
+			t.Fatalf("%s: %v", uid, err)
+		}
+		if ack.Tableid != table.ID {
+			t.Errorf("%s push = %v", uid, ack)
+		}
+	}
+
+	// 等游戏服删除桌子，下一个测试会重新初始化game
+	deadline := time.Now().Add(5 * time.Second)
+	for game.GetTableManager().Len() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("game table not removed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
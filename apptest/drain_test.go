@@ -0,0 +1,86 @@
+package apptest_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/kevin-chtw/tw_common/apptest"
+	"github.com/kevin-chtw/tw_common/gamebase/game"
+	"github.com/kevin-chtw/tw_common/gamebase/service"
+	"github.com/kevin-chtw/tw_common/matchbase"
+	"github.com/kevin-chtw/tw_common/storage"
+	"github.com/kevin-chtw/tw_proto/sproto"
+	"github.com/topfreegames/pitaya/v3/pkg/config"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func TestMatchSkipsDrainingServer(t *testing.T) {
+	gameApp := apptest.NewApp("gametest")
+	matchApp := apptest.NewApp("matchtest")
+	game.Init(gameApp, func(table *game.Table, id int32) game.IGame {
+		return &roundGame{table: table, id: id}
+	}, nil)
+	remote := service.NewRemote(gameApp)
+	remote.Init()
+
+	// game-a是本进程的游戏服，game-b接受所有请求
+	var accepted []int32
+	matchApp.HandleServer("game-a", "gametest.remote.message", apptest.RemoteHandler(remote.Message))
+	matchApp.HandleServer("game-b", "gametest.remote.message", func(ctx context.Context, arg proto.Message) (proto.Message, error) {
+		accepted = append(accepted, arg.(*sproto.GameReq).Tableid)
+		return &sproto.GameAck{}, nil
+	})
+
+	// 通过管理接口开始排空
+	admin := service.NewAdmin(gameApp)
+	admin.Init()
+	admin.SetAuthorizer(func(context.Context, *service.AdminReq) error { return nil })
+	in, err := structpb.NewStruct(map[string]any{"op": service.AdminDrain, "timeout": 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := admin.Message(context.Background(), in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 没有桌子，直接排空完成
+	if state := out.AsMap()["result"].(map[string]any)["state"]; state != "drained" {
+		t.Errorf("state = %v", state)
+	}
+
+	file := filepath.Join(t.TempDir(), "match.yaml")
+	conf := "matchid: 1\ngame_type: gametest\nplayer_per_table: 2\nscore_base: 1\n"
+	if err := os.WriteFile(file, []byte(conf), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	matchApp.RegisterModule(storage.NewETCDMatching(matchApp.GetServer(), config.ETCDBindingConfig{}), "matchingstorage")
+	match := matchbase.NewMatch(matchApp, file, nil)
+
+	// 随机选服，多建几张桌子保证选到过game-a
+	for range 20 {
+		table := newTable(t, match)
+		if err := table.SendAddTableReq(1, "", nil); err != nil {
+			t.Fatal(err)
+		}
+		if table.ServerID != "game-b" {
+			t.Errorf("table %d on %s", table.ID, table.ServerID)
+		}
+		// 之后的消息发到同一个游戏服
+		if err := table.SendNetState(matchbase.NewPlayer(nil, context.Background(), "p", 1, 0), true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(accepted) != 40 {
+		t.Errorf("game-b received %v", accepted)
+	}
+	if drained := match.DrainedServers(); !slices.Equal(drained, []string{"game-a"}) {
+		t.Errorf("drained = %v", drained)
+	}
+	if game.GetTableManager().Len() != 0 {
+		t.Error("draining server accepted a table")
+	}
+}
@@ -0,0 +1,14 @@
+package apptest_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/kevin-chtw/tw_common/matchbase"
+)
+
+func TestMain(m *testing.M) {
+	// 测试用的游戏类型，property最多3项
+	matchbase.RegisterGameType("gametest", 3)
+	os.Exit(m.Run())
+}
@@ -0,0 +1,151 @@
+package apptest_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kevin-chtw/tw_common/apptest"
+	"github.com/kevin-chtw/tw_common/gamebase/game"
+	"github.com/kevin-chtw/tw_common/gamebase/service"
+	"github.com/kevin-chtw/tw_common/matchbase"
+	"github.com/kevin-chtw/tw_common/storage"
+	"github.com/kevin-chtw/tw_common/utils"
+	"github.com/kevin-chtw/tw_proto/sproto"
+	"github.com/topfreegames/pitaya/v3/pkg/config"
+)
+
+func TestQueue(t *testing.T) {
+	gameApp := apptest.NewApp("gametest")
+	matchApp := apptest.NewApp("matchtest")
+	game.Init(gameApp, func(table *game.Table, id int32) game.IGame {
+		return &roundGame{table: table, id: id}
+	}, func(uid string, matchid, tableid int32, scorebase int64) *game.BotPlayer {
+		p := game.NewBotPlayer(uid, matchid, tableid, scorebase)
+		p.Bot = idleBot{}
+		return p
+	})
+	remote := service.NewRemote(gameApp)
+	remote.Init()
+	matchApp.Handle("gametest.remote.message", apptest.RemoteHandler(remote.Message))
+
+	file := filepath.Join(t.TempDir(), "match.yaml")
+	conf := "matchid: 1\ngame_type: gametest\nplayer_per_table: 2\nscore_base: 1\n" +
+		"queue:\n  initial_window: 50\n  widen_rate: 10\n  max_window: 0\n  bot_wait: 60\n"
+	if err := os.WriteFile(file, []byte(conf), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	matchApp.RegisterModule(storage.NewETCDMatching(matchApp.GetServer(), config.ETCDBindingConfig{}), "matchingstorage")
+	match := matchbase.NewMatch(matchApp, file, nil)
+
+	store, err := storage.NewFileTableStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for uid, value := range map[string]float64{"a": 1500, "b": 1520, "c": 1700, "d": 1600} {
+		data, _ := json.Marshal(matchbase.Rating{Value: value, Deviation: 350})
+		if err := store.Save("rating:"+uid, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	clock := utils.NewManualClock(time.Unix(1000, 0))
+	q := matchbase.NewQueue(match, matchbase.ParseQueueConfig(match.Viper))
+	q.SetClock(clock)
+	q.SetRatingStore(store)
+
+	players := make(map[string]*matchbase.Player)
+	enqueue := func(uids ...string) {
+		t.Helper()
+		party := make([]*matchbase.Player, len(uids))
+		for i, uid := range uids {
+			players[uid] = matchbase.NewPlayer(nil, matchApp.NewContext(uid, "tcp"), uid, 1, 1000)
+			party[i] = players[uid]
+		}
+		if err := q.Enqueue(party...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tablePlayers := func(uid string) map[string]*matchbase.Player {
+		t.Helper()
+		table := match.GetTable(players[uid].TableId)
+		if table == nil {
+			t.Fatalf("%s not seated", uid)
+		}
+		return table.Players
+	}
+
+	// 分差在范围内的玩家同桌，组队的玩家同桌
+	enqueue("a")
+	enqueue("c")
+	enqueue("b")
+	enqueue("p1", "p2")
+	if err := q.Enqueue(players["a"]); err == nil {
+		t.Error("enqueued twice")
+	}
+	q.Tick()
+	if seated := tablePlayers("a"); seated["b"] == nil {
+		t.Errorf("a seated with %v", seated)
+	}
+	if seated := tablePlayers("p1"); seated["p2"] == nil {
+		t.Errorf("p1 seated with %v", seated)
+	}
+	if players["c"].TableId != 0 || q.Len() != 1 {
+		t.Fatalf("c seated, queue = %d", q.Len())
+	}
+
+	// 等待时范围扩大，d和c分差100，双方都要等够5秒
+	enqueue("d")
+	clock.Advance(4 * time.Second)
+	q.Tick()
+	if players["d"].TableId != 0 {
+		t.Fatal("window widened too early")
+	}
+	if !q.Dequeue("d") || q.Dequeue("d") {
+		t.Fatal("dequeue")
+	}
+	enqueue("d")
+	clock.Advance(4 * time.Second)
+	q.Tick()
+	if players["d"].TableId != 0 {
+		t.Fatal("d matched before its own window widened")
+	}
+	clock.Advance(time.Second)
+	q.Tick()
+	if seated := tablePlayers("d"); seated["c"] == nil {
+		t.Fatalf("d seated with %v", seated)
+	}
+
+	// 等待超时用bot补位
+	enqueue("e")
+	clock.Advance(time.Minute)
+	q.Tick()
+	seated := tablePlayers("e")
+	if len(seated) != 2 {
+		t.Fatalf("e seated with %v", seated)
+	}
+	for uid, p := range seated {
+		if uid != "e" && !p.Bot {
+			t.Errorf("%s is not a bot", uid)
+		}
+	}
+
+	// 对局结果更新等级分
+	before := [2]matchbase.Rating{q.Rating("a"), q.Rating("b")}
+	q.OnResult(&sproto.GameResultReq{Tableid: players["a"].TableId, CurGameCount: 1, Scores: map[string]int64{"a": 1001, "b": 999}})
+	after := [2]matchbase.Rating{q.Rating("a"), q.Rating("b")}
+	if after[0].Value <= before[0].Value || after[1].Value >= before[1].Value {
+		t.Errorf("ratings %v -> %v", before, after)
+	}
+	if gain, loss := after[0].Value-before[0].Value, before[1].Value-after[1].Value; gain-loss > 1e-9 || loss-gain > 1e-9 {
+		t.Errorf("gain %v, loss %v", gain, loss)
+	}
+	if after[0].Games != 1 || after[0].Deviation >= before[0].Deviation {
+		t.Errorf("rating a = %+v", after[0])
+	}
+	saved := matchbase.Rating{}
+	if data, err := store.Load("rating:a"); err != nil || json.Unmarshal(data, &saved) != nil || saved != after[0] {
+		t.Errorf("saved = %+v, %v", saved, err)
+	}
+}
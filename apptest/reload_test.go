@@ -0,0 +1,125 @@
+package apptest_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kevin-chtw/tw_common/apptest"
+	"github.com/kevin-chtw/tw_common/matchbase"
+	"github.com/kevin-chtw/tw_common/storage"
+	pitaya "github.com/topfreegames/pitaya/v3/pkg"
+	"github.com/topfreegames/pitaya/v3/pkg/config"
+)
+
+type nopMatch struct{}
+
+func (nopMatch) Tick() {}
+
+func TestMatchReload(t *testing.T) {
+	t.Chdir(t.TempDir())
+	dir := filepath.Join("etc", "matchtest")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	write := func(name, conf string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(conf), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("m1.yaml", "matchid: 1\ngame_type: gametest\nplayer_per_table: 2\nscore_base: 1\n")
+
+	matchApp := apptest.NewApp("matchtest")
+	matchApp.RegisterModule(storage.NewETCDMatching(matchApp.GetServer(), config.ETCDBindingConfig{}), "matchingstorage")
+	matchbase.Init(matchApp, func(app pitaya.Pitaya, file string) *matchbase.Match {
+		return matchbase.NewMatch(app, file, nopMatch{})
+	}, nil)
+	match := matchbase.GetMatch(1)
+	if match == nil {
+		t.Fatal("match not loaded")
+	}
+	old := newTable(t, match)
+	match.AddTable(old)
+
+	// 修改只影响新桌子
+	write("m1.yaml", "matchid: 1\ngame_type: gametest\nplayer_per_table: 2\nscore_base: 5\n")
+	if err := matchbase.ReloadMatchs(); err != nil {
+		t.Fatal(err)
+	}
+	if got := match.Config().GetInt64("score_base"); got != 5 {
+		t.Errorf("score_base = %d", got)
+	}
+	if got := old.Conf.GetInt64("score_base"); got != 1 {
+		t.Errorf("old table score_base = %d", got)
+	}
+	if got := newTable(t, match).Conf.GetInt64("score_base"); got != 5 {
+		t.Errorf("new table score_base = %d", got)
+	}
+
+	// 不能修改matchid
+	write("m1.yaml", "matchid: 3\ngame_type: gametest\nplayer_per_table: 2\nscore_base: 6\n")
+	if err := matchbase.ReloadMatchs(); err != nil {
+		t.Fatal(err)
+	}
+	if matchbase.GetMatch(3) != nil || match.Config().GetInt64("score_base") != 5 {
+		t.Error("matchid change applied")
+	}
+
+	// 不合法的修改不生效
+	write("m1.yaml", "matchid: 1\ngame_type: gametest\nplayer_per_tabel: 2\nscore_base: 6\nproperty: 1,2,3,4\n")
+	if err := matchbase.ReloadMatchs(); err != nil {
+		t.Fatal(err)
+	}
+	if match.MatchConfig().PlayerPerTable != 2 || match.MatchConfig().ScoreBase != 5 {
+		t.Errorf("invalid config applied: %+v", match.MatchConfig())
+	}
+
+	// 新文件创建比赛，matchid重复的不创建
+	write("m2.yaml", "matchid: 2\ngame_type: gametest\nplayer_per_table: 4\nscore_base: 1\n")
+	if err := matchbase.ReloadMatchs(); err != nil {
+		t.Fatal(err)
+	}
+	if m2 := matchbase.GetMatch(2); m2 == nil || m2.MatchConfig().PlayerPerTable != 4 {
+		t.Fatal("new match not loaded")
+	}
+	write("m3.yaml", "matchid: 2\ngame_type: gametest\nplayer_per_table: 3\nscore_base: 1\n")
+	if err := matchbase.ReloadMatchs(); err != nil {
+		t.Fatal(err)
+	}
+	if matchbase.GetMatch(2).MatchConfig().PlayerPerTable != 4 {
+		t.Error("duplicate matchid overwrote match")
+	}
+	if err := matchbase.ValidateMatchFiles([]string{filepath.Join(dir, "m2.yaml"), filepath.Join(dir, "m3.yaml")}); err == nil {
+		t.Error("duplicate matchid passed validation")
+	}
+
+	// 删除文件后等桌子结束再移除
+	if err := os.Remove(filepath.Join(dir, "m1.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	if err := matchbase.ReloadMatchs(); err != nil {
+		t.Fatal(err)
+	}
+	if matchbase.GetMatch(1) != match || !match.Retiring() {
+		t.Fatal("match with tables removed")
+	}
+	match.DelTable(old.ID)
+	if err := matchbase.ReloadMatchs(); err != nil {
+		t.Fatal(err)
+	}
+	if matchbase.GetMatch(1) != nil {
+		t.Error("retired match not removed")
+	}
+
+	// 启动时有不合法的配置文件直接失败
+	write("m4.yaml", "matchid: 4\ngame_type: unknown\nplayer_per_table: 2\nscore_base: 1\n")
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("started with invalid config")
+			}
+		}()
+		matchbase.NewMatchmgr(matchApp)
+	}()
+}
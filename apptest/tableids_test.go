@@ -0,0 +1,156 @@
+package apptest_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/kevin-chtw/tw_common/apptest"
+	"github.com/kevin-chtw/tw_common/matchbase"
+	"github.com/kevin-chtw/tw_common/storage"
+	pitaya "github.com/topfreegames/pitaya/v3/pkg"
+	"github.com/topfreegames/pitaya/v3/pkg/config"
+	"github.com/topfreegames/pitaya/v3/pkg/modules"
+)
+
+// seqTableIDs 按顺序分配的集群分配器
+type seqTableIDs struct {
+	modules.Base
+	mu       sync.Mutex
+	next     int32
+	returned []int32
+	err      error
+	onLost   func(ids []int32)
+}
+
+func (s *seqTableIDs) Take() (int32, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return 0, s.err
+	}
+	s.next++
+	return s.next, nil
+}
+
+func (s *seqTableIDs) PutBack(id int32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.returned = append(s.returned, id)
+}
+
+func (s *seqTableIDs) SetOnLost(f func(ids []int32)) {
+	s.onLost = f
+}
+
+// lostMatch 记录ID丢失的桌子
+type lostMatch struct {
+	tables chan []*matchbase.Table
+}
+
+func (lostMatch) Tick() {}
+
+func (m lostMatch) OnTableIDsLost(tables []*matchbase.Table) {
+	m.tables <- tables
+}
+
+func TestTableIDs(t *testing.T) {
+	ids := matchbase.NewTableIDRange(1, 10)
+	taken := make([]int32, 0, 10)
+	for range 10 {
+		id, err := ids.Take()
+		if err != nil {
+			t.Fatal(err)
+		}
+		taken = append(taken, id)
+	}
+	slices.Sort(taken)
+	if !slices.Equal(taken, []int32{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}) {
+		t.Fatalf("taken = %v", taken)
+	}
+	if _, err := ids.Take(); !errors.Is(err, matchbase.ErrExhausted) {
+		t.Fatalf("err = %v", err)
+	}
+	ids.PutBack(3)
+	if id, err := ids.Take(); id != 3 || err != nil {
+		t.Fatalf("Take() = %d, %v", id, err)
+	}
+
+	// 注册了tableids模块时所有比赛共用
+	matchApp := apptest.NewApp("matchtest")
+	matchApp.RegisterModule(storage.NewETCDMatching(matchApp.GetServer(), config.ETCDBindingConfig{}), "matchingstorage")
+	seq := &seqTableIDs{}
+	matchApp.RegisterModule(seq, "tableids")
+	dir := t.TempDir()
+	var matchs []*matchbase.Match
+	for i, conf := range []string{"matchid: 1\n", "matchid: 2\n"} {
+		file := filepath.Join(dir, string(rune('a'+i))+".yaml")
+		if err := os.WriteFile(file, []byte(conf), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		matchs = append(matchs, matchbase.NewMatch(matchApp, file, nil))
+	}
+	t1 := newTable(t, matchs[0])
+	t2 := newTable(t, matchs[1])
+	if t1.ID != 1 || t2.ID != 2 {
+		t.Errorf("table ids = %d, %d", t1.ID, t2.ID)
+	}
+	matchs[1].DelTable(t2.ID)
+	if !slices.Equal(seq.returned, []int32{2}) {
+		t.Errorf("returned = %v", seq.returned)
+	}
+
+	// 分配失败时不创建桌子
+	seq.err = errors.New("etcd down")
+	if table, err := matchbase.NewTableErr(matchs[0], nil); table != nil || !errors.Is(err, seq.err) {
+		t.Errorf("NewTableErr() = %v, %v", table, err)
+	}
+	// 兼容旧代码的NewTable分配失败时返回ID为0的桌子
+	if table := matchbase.NewTable(matchs[0], nil); table == nil || table.ID != 0 {
+		t.Errorf("NewTable() = %v", table)
+	}
+}
+
+func TestTableIDsLost(t *testing.T) {
+	t.Chdir(t.TempDir())
+	dir := filepath.Join("etc", "matchtest")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "m1.yaml"), []byte("matchid: 1\ngame_type: gametest\nplayer_per_table: 2\nscore_base: 1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	matchApp := apptest.NewApp("matchtest")
+	matchApp.RegisterModule(storage.NewETCDMatching(matchApp.GetServer(), config.ETCDBindingConfig{}), "matchingstorage")
+	seq := &seqTableIDs{}
+	matchApp.RegisterModule(seq, "tableids")
+	sub := lostMatch{tables: make(chan []*matchbase.Table, 1)}
+	matchbase.Init(matchApp, func(app pitaya.Pitaya, file string) *matchbase.Match {
+		return matchbase.NewMatch(app, file, sub)
+	}, nil)
+	if seq.onLost == nil {
+		t.Fatal("lost callback not set")
+	}
+	match := matchbase.GetMatch(1)
+	table := newTable(t, match)
+	match.AddTable(table)
+	newTable(t, match)
+
+	// 只通知比赛中还在使用的桌子
+	seq.onLost([]int32{table.ID, table.ID + 1, 999})
+	select {
+	case tables := <-sub.tables:
+		if len(tables) != 1 || tables[0] != table {
+			t.Errorf("lost tables = %v", tables)
+		}
+	default:
+		t.Fatal("match not notified")
+	}
+	seq.onLost([]int32{999})
+	if len(sub.tables) != 0 {
+		t.Error("notified without lost tables")
+	}
+}
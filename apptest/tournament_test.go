@@ -0,0 +1,297 @@
+package apptest_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kevin-chtw/tw_common/apptest"
+	"github.com/kevin-chtw/tw_common/gamebase/game"
+	"github.com/kevin-chtw/tw_common/gamebase/service"
+	"github.com/kevin-chtw/tw_common/matchbase"
+	"github.com/kevin-chtw/tw_common/storage"
+	"github.com/kevin-chtw/tw_common/utils"
+	"github.com/kevin-chtw/tw_proto/cproto"
+	"github.com/kevin-chtw/tw_proto/sproto"
+	"github.com/spf13/viper"
+	"github.com/topfreegames/pitaya/v3/pkg/config"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func TestTournament(t *testing.T) {
+	tests := []struct {
+		name   string
+		conf   string
+		tables [][][]string // 每轮的分桌，每桌报名顺序靠前的选手获胜
+		ranks  []string
+	}{
+		{
+			name:   "knockout",
+			conf:   "  format: knockout\n  advance: 1\n",
+			tables: [][][]string{{{"a", "d"}, {"b", "c"}}, {{"a", "b"}}},
+			ranks:  []string{"a", "b", "c", "d"},
+		},
+		{
+			name:   "swiss",
+			conf:   "  format: swiss\n  rounds: 2\n",
+			tables: [][][]string{{{"a", "b"}, {"c", "d"}}, {{"a", "c"}, {"b", "d"}}},
+			ranks:  []string{"a", "b", "c", "d"},
+		},
+		{
+			name:   "dingju",
+			conf:   "  format: dingju\n  rounds: 2\n  eliminate_below: 1000\n  final_game_count: 1\n",
+			tables: [][][]string{{{"a", "d"}, {"b", "c"}}, {{"a", "b"}}, {{"a", "b"}}},
+			ranks:  []string{"a", "b", "c", "d"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			playTournament(t, tt.conf, tt.tables, tt.ranks)
+		})
+	}
+}
+
+func playTournament(t *testing.T, tourneyConf string, rounds [][][]string, ranks []string) {
+	gameApp := apptest.NewApp("gametest")
+	matchApp := apptest.NewApp("matchtest")
+	game.Init(gameApp, func(table *game.Table, id int32) game.IGame {
+		return &roundGame{table: table, id: id}
+	}, nil)
+	remote := service.NewRemote(gameApp)
+	remote.Init()
+	matchApp.Handle("gametest.remote.message", apptest.RemoteHandler(remote.Message))
+
+	file := filepath.Join(t.TempDir(), "match.yaml")
+	conf := "matchid: 1\ngame_type: gametest\nplayer_per_table: 2\nscore_base: 1\ntournament:\n" + tourneyConf
+	if err := os.WriteFile(file, []byte(conf), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	matchApp.RegisterModule(storage.NewETCDMatching(matchApp.GetServer(), config.ETCDBindingConfig{}), "matchingstorage")
+	match := matchbase.NewMatch(matchApp, file, nil)
+	tourneyConfig, err := matchbase.ParseTournamentConfig(match.Config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tourney := matchbase.NewTournament(match, tourneyConfig)
+	gameApp.Handle("matchtest.remote.message", func(ctx context.Context, arg proto.Message) (proto.Message, error) {
+		msg, err := arg.(*sproto.MatchReq).Req.UnmarshalNew()
+		if err != nil {
+			return nil, err
+		}
+		if result, ok := msg.(*sproto.GameResultReq); !ok || match.AcceptResult(result) {
+			tourney.OnResult(msg)
+		}
+		return &sproto.MatchAck{}, nil
+	})
+
+	type seated struct {
+		id   int32
+		uids []string
+	}
+	tables := make(chan seated, 8)
+	tourney.SetOnTable(func(table *matchbase.Table) {
+		// 回调不持有锁，可以查询赛事状态
+		tourney.Round()
+		s := seated{id: table.ID}
+		for uid := range table.Players {
+			s.uids = append(s.uids, uid)
+		}
+		slices.Sort(s.uids)
+		tables <- s
+	})
+	for _, uid := range ranks {
+		if err := tourney.Join(matchbase.NewPlayer(nil, matchApp.NewContext(uid, "tcp"), uid, 1, 1000)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tourney.Join(matchbase.NewPlayer(nil, matchApp.NewContext("a", "tcp"), "a", 1, 1000)); err == nil {
+		t.Error("joined twice")
+	}
+	if err := tourney.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 等待时Tick重试上一轮桌子还没删除导致失败的分桌
+	timeout := time.After(5 * time.Second)
+	wait := func(done func() bool) {
+		t.Helper()
+		for !done() {
+			select {
+			case <-timeout:
+				t.Fatal("timeout")
+			case <-time.After(10 * time.Millisecond):
+				tourney.Tick()
+			}
+		}
+	}
+	svc := service.NewPlayer(gameApp)
+	for round, want := range rounds {
+		var got []seated
+		wait(func() bool {
+			select {
+			case s := <-tables:
+				got = append(got, s)
+			default:
+			}
+			return len(got) == len(want)
+		})
+		if r := tourney.Round(); r != round+1 {
+			t.Errorf("round = %d, want %d", r, round+1)
+		}
+		for i, s := range got {
+			if !slices.Equal(s.uids, want[i]) {
+				t.Fatalf("round %d table %d = %v, want %v", round+1, i, s.uids, want[i])
+			}
+		}
+		for _, s := range got {
+			for _, uid := range s.uids {
+				sendGameMsg(t, gameApp, svc, uid, "tcp", s.id, &cproto.EnterGameReq{})
+			}
+			sendGameMsg(t, gameApp, svc, s.uids[0], "tcp", s.id, &cproto.TableMsgReq{Msg: []byte{1}})
+		}
+	}
+	wait(tourney.Finished)
+	// 等游戏服删除决赛桌，下一个子测试会重新初始化game
+	wait(func() bool { return game.GetTableManager().Len() == 0 })
+
+	standings := tourney.Standings()
+	for i, s := range standings {
+		if s.PlayerID != ranks[i] || s.Rank != i+1 {
+			t.Errorf("standings[%d] = %+v, want %s", i, s, ranks[i])
+		}
+	}
+
+	// 每轮结束后推送排名，最后一次是最终排名
+	pushes := matchApp.Pushes(ranks[len(ranks)-1])
+	if len(pushes) != len(rounds) {
+		t.Fatalf("%d pushes", len(pushes))
+	}
+	ack := &cproto.MatchAck{}
+	if err := utils.Unmarshal(matchApp.NewContext("d", "tcp"), pushes[len(pushes)-1].Data, ack); err != nil {
+		t.Fatal(err)
+	}
+	pushed := &structpb.Struct{}
+	if err := ack.Ack.UnmarshalTo(pushed); err != nil {
+		t.Fatal(err)
+	}
+	list := pushed.Fields["standings"].GetListValue().GetValues()
+	if !pushed.Fields["finished"].GetBoolValue() || len(list) != len(ranks) ||
+		list[0].GetStructValue().Fields["uid"].GetStringValue() != ranks[0] {
+		t.Errorf("pushed %v", pushed)
+	}
+}
+
+func TestTournamentUnevenKnockout(t *testing.T) {
+	matchApp := apptest.NewApp("matchtest")
+	matchApp.Handle("gametest.remote.message", func(ctx context.Context, arg proto.Message) (proto.Message, error) {
+		return &sproto.GameAck{}, nil
+	})
+	file := filepath.Join(t.TempDir(), "match.yaml")
+	conf := "matchid: 1\ngame_type: gametest\nplayer_per_table: 4\nscore_base: 1\ntournament:\n  format: knockout\n  advance: 3\n"
+	if err := os.WriteFile(file, []byte(conf), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	matchApp.RegisterModule(storage.NewETCDMatching(matchApp.GetServer(), config.ETCDBindingConfig{}), "matchingstorage")
+	match := matchbase.NewMatch(matchApp, file, nil)
+	tourneyConfig, err := matchbase.ParseTournamentConfig(match.Config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tourney := matchbase.NewTournament(match, tourneyConfig)
+
+	type seated struct {
+		id   int32
+		uids []string
+	}
+	var tables []seated
+	tourney.SetOnTable(func(table *matchbase.Table) {
+		s := seated{id: table.ID}
+		for uid, p := range table.Players {
+			if !p.Bot {
+				s.uids = append(s.uids, uid)
+			}
+		}
+		slices.Sort(s.uids)
+		tables = append(tables, s)
+	})
+	ranks := []string{"a", "b", "c", "d", "e"}
+	for _, uid := range ranks {
+		if err := tourney.Join(matchbase.NewPlayer(nil, matchApp.NewContext(uid, "tcp"), uid, 1, 1000)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tourney.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 蛇形分成3人桌和2人桌，每桌都要淘汰一人，第二轮剩3人打决赛桌
+	rounds := [][][]string{{{"a", "d", "e"}, {"b", "c"}}, {{"a", "b", "d"}}}
+	scores := make(map[string]int64)
+	for _, uid := range ranks {
+		scores[uid] = 1000
+	}
+	for round, want := range rounds {
+		got := tables
+		tables = nil
+		if len(got) != len(want) {
+			t.Fatalf("round %d tables = %v, want %v", round+1, got, want)
+		}
+		for i, s := range got {
+			if !slices.Equal(s.uids, want[i]) {
+				t.Fatalf("round %d table %d = %v, want %v", round+1, i, s.uids, want[i])
+			}
+		}
+		// 报名顺序靠前的选手分数高
+		for _, s := range got {
+			result := &sproto.GameResultReq{Tableid: s.id, CurGameCount: 1, Scores: make(map[string]int64)}
+			for i, uid := range s.uids {
+				scores[uid] += int64(10 * (len(s.uids) - i))
+				result.Scores[uid] = scores[uid]
+			}
+			tourney.OnResult(result)
+			tourney.OnResult(&sproto.GameOverReq{Tableid: s.id, CurGameCount: 1})
+		}
+	}
+	if !tourney.Finished() || len(tables) != 0 {
+		t.Fatalf("finished = %v, tables = %v", tourney.Finished(), tables)
+	}
+	// 决赛桌的选手排在第一轮被淘汰的选手前面
+	want := []string{"a", "b", "d", "c", "e"}
+	for i, s := range tourney.Standings() {
+		if s.PlayerID != want[i] {
+			t.Errorf("standings[%d] = %+v, want %s", i, s, want[i])
+		}
+	}
+}
+
+func TestParseTournamentConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		conf string
+		ok   bool
+	}{
+		{"knockout", "player_per_table: 4\ntournament:\n  format: knockout\n  advance: 3\n", true},
+		{"knockout advance all", "player_per_table: 4\ntournament:\n  format: knockout\n  advance: 4\n", false},
+		{"knockout advance none", "player_per_table: 4\ntournament:\n  format: knockout\n", false},
+		{"knockout single seat", "player_per_table: 1\ntournament:\n  format: knockout\n  advance: 1\n", false},
+		{"swiss no rounds", "player_per_table: 4\ntournament:\n  format: swiss\n", false},
+		{"unknown format", "player_per_table: 4\ntournament:\n  format: league\n", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := viper.New()
+			v.SetConfigType("yaml")
+			if err := v.ReadConfig(strings.NewReader(tt.conf)); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := matchbase.ParseTournamentConfig(v); (err == nil) != tt.ok {
+				t.Fatalf("err = %v", err)
+			}
+		})
+	}
+}
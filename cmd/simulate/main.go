@@ -0,0 +1,327 @@
+// simulate 用bot在本进程内打完整的麻将牌局，检查规则并输出统计：
+//
+//	go run github.com/kevin-chtw/tw_common/cmd/simulate -rounds 1000 -records /tmp/records
+//
+// 内置一套只有万字、摸打和胡的两人基础玩法，用于检查gamebase/mahjong本身。
+// 玩法仓库复制这个文件，把newConfig换成自己的GameCreator、BotCreator和Service
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/kevin-chtw/tw_common/gamebase/game"
+	"github.com/kevin-chtw/tw_common/gamebase/mahjong"
+	"github.com/kevin-chtw/tw_proto/cproto"
+	"github.com/kevin-chtw/tw_proto/game/pbmj"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+func main() {
+	rounds := flag.Int("rounds", 1000, "rounds to play")
+	records := flag.String("records", "", "write a json record of every round to `dir`")
+	flag.Parse()
+
+	mahjong.Service = basicService{}
+	conf := newConfig(*rounds)
+	if *records != "" {
+		if err := os.MkdirAll(*records, os.ModePerm); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		conf.RecordSink = recordWriter(*records)
+	}
+	stats, err := mahjong.RunSimulations(os.Stdout, conf)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	for _, s := range stats {
+		if len(s.Violations) > 0 {
+			os.Exit(1)
+		}
+	}
+}
+
+// recordWriter 同步写录像，进程退出时不会丢
+func recordWriter(dir string) mahjong.RecordSink {
+	return func(data *mahjong.RecordData) {
+		b, err := data.ToJSON()
+		if err == nil {
+			err = os.WriteFile(filepath.Join(dir, fmt.Sprintf("%d.json", data.GameID)), b, 0o644)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
+}
+
+func newConfig(rounds int) *mahjong.SimConfig {
+	return &mahjong.SimConfig{
+		Name:        "basic",
+		PlayerCount: 2,
+		ScoreBase:   1,
+		InitScore:   1000,
+		ScoreType:   mahjong.ScoreTypeNatural,
+		Rounds:      rounds,
+		GameCreator: func(table *game.Table, id int32) game.IGame {
+			g := &basicGame{}
+			g.Game = mahjong.NewGame(g, table, id)
+			return g
+		},
+		BotCreator: func(uid string, matchid, tableid int32, scorebase int64) *game.BotPlayer {
+			p := game.NewBotPlayer(uid, matchid, tableid, scorebase)
+			p.Bot = &basicBot{BotPlayer: p}
+			return p
+		},
+	}
+}
+
+// basicService 只有万字，13张手牌，bot只打摸到的牌也能经常胡
+type basicService struct{}
+
+func (basicService) GetAllTiles(conf *mahjong.Rule) map[mahjong.Tile]int {
+	tiles := make(map[mahjong.Tile]int)
+	for p := range 9 {
+		tiles[mahjong.MakeTile(mahjong.ColorCharacter, p)] = 4
+	}
+	return tiles
+}
+
+func (basicService) GetHandCount() int            { return 13 }
+func (basicService) GetDefaultRules() []int       { return []int{0} }
+func (basicService) GetFdRules() map[string]int32 { return map[string]int32{} }
+func (basicService) GetHuResult(data *mahjong.HuData) *pbmj.MJHuData {
+	return &pbmj.MJHuData{Multi: 1, HuTypes: []int32{1}}
+}
+
+type basicPlay struct{}
+
+func (basicPlay) CheckHu(data *mahjong.HuData) mahjong.HuCoreType {
+	return mahjong.DefaultHuCore.CheckBasicHu(slices.Clone(data.Tiles), 0)
+}
+
+func (basicPlay) GetExtraHuTypes(data *mahjong.PlayData, self bool) []int32 {
+	return nil
+}
+
+// packer 用Any包装消息，bot可以知道消息类型
+type packer struct{}
+
+func (packer) PackMsg(msg proto.Message) (proto.Message, error) {
+	return anypb.New(msg)
+}
+
+// basicGame 只能摸打和胡，请求也用Any包装的pbmj消息
+type basicGame struct {
+	*mahjong.Game
+	play   *mahjong.Play
+	sender *mahjong.Sender
+}
+
+func (g *basicGame) OnStart() {
+	g.play = mahjong.NewPlay(basicPlay{}, g.Game, mahjong.NewDealer(g.Game))
+	g.play.PlayConf = &mahjong.PlayConf{}
+	g.play.RegisterSelfCheck(mahjong.NewCheckerHu(g.play))
+	g.play.RegisterWaitCheck(mahjong.NewCheckerPao(g.play))
+	g.sender = mahjong.NewSender(g.Game, g.play, packer{})
+	g.play.Initialize(mahjong.NewPlayData)
+	g.play.Deal()
+	g.sender.SendGameStartAck()
+	g.sender.SendOpenDoorAck()
+	g.SetNextState(newDiscardState)
+}
+
+func (g *basicGame) OnReqMsg(player *game.Player, data []byte) error {
+	req := &anypb.Any{}
+	if err := proto.Unmarshal(data, req); err != nil {
+		return err
+	}
+	msg, err := req.UnmarshalNew()
+	if err != nil {
+		return err
+	}
+	return g.CurState.OnPlayerMsg(player.GetSeat(), msg)
+}
+
+// end 结算，multiples为nil时流局
+func (g *basicGame) end(multiples []int64) {
+	for i, multi := range multiples {
+		g.GetPlayer(int32(i)).AddScoreChange(multi * g.GetScoreBase())
+	}
+	g.sender.SendResult(multiples == nil)
+	g.OnGameOver()
+}
+
+type discardState struct {
+	*mahjong.State
+	game *basicGame
+	opt  *mahjong.Operates
+}
+
+func newDiscardState(g mahjong.IGame, _ ...any) mahjong.IState {
+	bg := g.(*basicGame)
+	return &discardState{State: mahjong.NewState(bg.Game, bg.sender), game: bg}
+}
+
+func (s *discardState) OnEnter() {
+	s.opt = s.game.play.FetchSelfOperates(s.game.sender)
+	s.game.sender.SendRequestAck(s.game.play.GetCurSeat(), s.opt)
+	s.AsyncMsgTimer(s.onMsg, 10*time.Second, func() { s.discard(mahjong.TileNull) })
+}
+
+func (s *discardState) onMsg(seat int32, req proto.Message) error {
+	if seat != s.game.play.GetCurSeat() {
+		return errors.New("not current seat")
+	}
+	switch msg := req.(type) {
+	case *pbmj.MJHuAck:
+		if !s.opt.HasOperate(mahjong.OperateHu) {
+			return errors.New("cannot hu")
+		}
+		multiples := s.game.play.Zimo()
+		s.game.sender.SendHuAck([]int32{seat}, mahjong.SeatNull)
+		s.game.end(multiples)
+	case *pbmj.MJDiscardAck:
+		return s.discard(mahjong.Tile(msg.Tile))
+	default:
+		return errors.New("unknown request")
+	}
+	return nil
+}
+
+func (s *discardState) discard(tile mahjong.Tile) error {
+	if !s.game.play.Discard(tile) {
+		return errors.New("cannot discard")
+	}
+	s.game.sender.SendDiscardAck()
+	s.game.SetNextState(newWaitState)
+	return nil
+}
+
+type waitState struct {
+	*mahjong.State
+	game    *basicGame
+	waiting map[int32]bool
+}
+
+func newWaitState(g mahjong.IGame, _ ...any) mahjong.IState {
+	bg := g.(*basicGame)
+	return &waitState{State: mahjong.NewState(bg.Game, bg.sender), game: bg, waiting: make(map[int32]bool)}
+}
+
+func (s *waitState) OnEnter() {
+	for seat := range s.game.GetPlayerCount() {
+		if seat == s.game.play.GetCurSeat() {
+			continue
+		}
+		opt := s.game.play.FetchWaitOperates(seat, s.game.sender)
+		if opt.HasOperate(mahjong.OperateHu) {
+			s.waiting[seat] = true
+			s.game.sender.SendRequestAck(seat, opt)
+		}
+	}
+	if len(s.waiting) == 0 {
+		s.game.SetNextState(newDrawState)
+		return
+	}
+	s.AsyncMsgTimer(s.onMsg, 10*time.Second, func() { s.game.SetNextState(newDrawState) })
+}
+
+func (s *waitState) onMsg(seat int32, req proto.Message) error {
+	if !s.waiting[seat] {
+		return errors.New("not waiting")
+	}
+	switch req.(type) {
+	case *pbmj.MJHuAck:
+		multiples := s.game.play.PaoHu([]int32{seat})
+		s.game.sender.SendHuAck([]int32{seat}, s.game.play.GetCurSeat())
+		s.game.end(multiples)
+	case *emptypb.Empty:
+		delete(s.waiting, seat)
+		if len(s.waiting) == 0 {
+			s.game.SetNextState(newDrawState)
+		}
+	default:
+		return errors.New("unknown request")
+	}
+	return nil
+}
+
+type drawState struct {
+	game *basicGame
+}
+
+func newDrawState(g mahjong.IGame, _ ...any) mahjong.IState {
+	return &drawState{game: g.(*basicGame)}
+}
+
+func (s *drawState) OnEnter() {
+	s.game.play.DoSwitchSeat(mahjong.SeatNull)
+	tile := s.game.play.Draw()
+	if tile == mahjong.TileNull {
+		s.game.end(nil)
+		return
+	}
+	s.game.sender.SendDrawAck(tile)
+	s.game.SetNextState(newDiscardState)
+}
+
+func (s *drawState) OnPlayerMsg(seat int32, req proto.Message) error {
+	return errors.New("drawing")
+}
+
+// basicBot 能胡就胡，否则打出最后摸的牌
+type basicBot struct {
+	*game.BotPlayer
+}
+
+func (b *basicBot) OnBotMsg(msg proto.Message) error {
+	ack := &cproto.TableMsgAck{}
+	if err := msg.(*cproto.GameAck).Ack.UnmarshalTo(ack); err != nil {
+		return nil
+	}
+	data := &anypb.Any{}
+	if err := proto.Unmarshal(ack.Msg, data); err != nil {
+		return err
+	}
+	m, err := data.UnmarshalNew()
+	if err != nil {
+		return err
+	}
+	req, ok := m.(*pbmj.MJRequestAck)
+	if !ok || req.Seat != b.seat() {
+		return nil
+	}
+
+	var reply proto.Message
+	switch {
+	case req.RequestType&mahjong.OperateHu != 0:
+		reply = &pbmj.MJHuAck{}
+	case req.RequestType&mahjong.OperateDiscard != 0:
+		reply = &pbmj.MJDiscardAck{Tile: mahjong.TileNull.ToInt32()}
+	default:
+		reply = &emptypb.Empty{}
+	}
+	data, err = anypb.New(reply)
+	if err != nil {
+		return err
+	}
+	return b.SendMsg(data)
+}
+
+func (b *basicBot) OnTimer() error { return nil }
+
+// seat 模拟时bot的uid是bot加座位号
+func (b *basicBot) seat() int32 {
+	var seat int32
+	fmt.Sscanf(b.Uid, "bot%d", &seat)
+	return seat
+}
@@ -0,0 +1,83 @@
+// validate 校验比赛配置文件，可以在CI中运行：
+//
+//	go run github.com/kevin-chtw/tw_common/cmd/validate -game mjsc=20 etc/
+//
+// 参数为yaml文件或目录，目录下的yaml文件递归查找，同一个目录下的matchid不能重复。
+// -game登记游戏类型和它的默认规则数，可以重复。配置中用到的游戏类型都要登记规则数，
+// 内置的麻将类型也一样，否则校验失败
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/kevin-chtw/tw_common/matchbase"
+)
+
+// gameFlag 形如type=rules
+type gameFlag struct{}
+
+func (gameFlag) String() string { return "" }
+
+func (gameFlag) Set(value string) error {
+	name, rules, ok := strings.Cut(value, "=")
+	if !ok || name == "" {
+		return fmt.Errorf("%q should be type=rules", value)
+	}
+	n, err := strconv.Atoi(rules)
+	if err != nil || n <= 0 {
+		return fmt.Errorf("invalid rules %q", rules)
+	}
+	matchbase.RegisterGameType(name, n)
+	return nil
+}
+
+func main() {
+	flag.Var(gameFlag{}, "game", "game type and its `type=rules` (GetDefaultRules length)")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [-game type=rules]... path...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	matchbase.RequireGameRules(true)
+
+	files, err := collect(flag.Args())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if err := matchbase.ValidateMatchFiles(files); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Printf("%d match configs ok\n", len(files))
+}
+
+// collect 展开目录中的yaml文件
+func collect(paths []string) ([]string, error) {
+	files := make([]string, 0)
+	for _, path := range paths {
+		err := filepath.WalkDir(path, func(file string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if file == path && !d.IsDir() || !d.IsDir() && filepath.Ext(file) == ".yaml" {
+				files = append(files, file)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
@@ -0,0 +1,188 @@
+package game
+
+import (
+	"encoding/json"
+	"errors"
+	"slices"
+
+	"github.com/kevin-chtw/tw_proto/cproto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// IStateName 游戏可选实现，管理接口显示当前状态
+type IStateName interface {
+	StateName() string
+}
+
+// ITrustee 游戏可选实现，管理接口切换座位的托管
+type ITrustee interface {
+	SetTrusted(seat int32, trusted bool) error
+}
+
+// TableInfo 管理接口看到的桌子
+type TableInfo struct {
+	MatchID      int32         `json:"matchid"`
+	TableID      int32         `json:"tableid"`
+	MatchType    string        `json:"match_type"`
+	GameCount    int32         `json:"game_count"`
+	CurGameCount int32         `json:"cur_game_count"`
+	Playing      bool          `json:"playing"`
+	State        string        `json:"state,omitempty"`
+	Observers    int           `json:"observers"`
+	Players      []*PlayerInfo `json:"players"`
+}
+
+// PlayerInfo 管理接口看到的玩家
+type PlayerInfo struct {
+	Uid    string `json:"uid"`
+	Seat   int32  `json:"seat"`
+	Score  int64  `json:"score"`
+	Bot    bool   `json:"bot"`
+	Online bool   `json:"online"`
+}
+
+var (
+	errPlaying     = errors.New("game is playing")
+	errNoBot       = errors.New("bot creator not set")
+	errNoTrustee   = errors.New("game does not support trustee")
+	errNoPlayer    = errors.New("player not on table")
+	errPlayerIsBot = errors.New("player is already a bot")
+)
+
+// Tables 获取比赛的所有桌子，matchID为0时获取全部
+func (t *TableManager) Tables(matchID int32) []*Table {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	tables := make([]*Table, 0, len(t.tables))
+	for _, table := range t.tables {
+		if matchID == 0 || table.MatchID == matchID {
+			tables = append(tables, table)
+		}
+	}
+	slices.SortFunc(tables, func(a, b *Table) int {
+		if a.MatchID != b.MatchID {
+			return int(a.MatchID - b.MatchID)
+		}
+		return int(a.tableID - b.tableID)
+	})
+	return tables
+}
+
+// Info 获取桌子概况
+func (t *Table) Info() (*TableInfo, error) {
+	var info *TableInfo
+	err := t.callErr(func() error {
+		info = t.info()
+		return nil
+	})
+	return info, err
+}
+
+func (t *Table) info() *TableInfo {
+	info := &TableInfo{
+		MatchID:      t.MatchID,
+		TableID:      t.tableID,
+		MatchType:    t.MatchType,
+		GameCount:    t.gameCount,
+		CurGameCount: t.curGameCount,
+		Playing:      t.playing,
+		Observers:    len(t.obs.members),
+		Players:      make([]*PlayerInfo, 0, len(t.players)),
+	}
+	if g, ok := t.game.(IStateName); ok && t.playing {
+		info.State = g.StateName()
+	}
+	for _, p := range t.players {
+		info.Players = append(info.Players, &PlayerInfo{
+			Uid:    p.ack.Uid,
+			Seat:   p.ack.Seat,
+			Score:  p.score,
+			Bot:    p.isBot,
+			Online: p.online,
+		})
+	}
+	slices.SortFunc(info.Players, func(a, b *PlayerInfo) int { return int(a.Seat - b.Seat) })
+	return info
+}
+
+// Dump 获取桌子完整状态，格式和检查点相同
+func (t *Table) Dump() (json.RawMessage, error) {
+	var data []byte
+	err := t.callErr(func() error {
+		var err error
+		data, err = t.marshal()
+		return err
+	})
+	return data, err
+}
+
+// Kick 局间踢出玩家并通知比赛服，牌局进行中时用ReplaceWithBot
+func (t *Table) Kick(uid string) error {
+	return t.callErr(func() error {
+		player, ok := t.players[uid]
+		if !ok {
+			return errNoPlayer
+		}
+		if t.playing {
+			return errPlaying
+		}
+		t.kickPlayer(player)
+		t.saveCheckpoint()
+		return nil
+	})
+}
+
+// ReplaceWithBot 用bot接管玩家的座位和积分，玩家收到GameExitAck
+func (t *Table) ReplaceWithBot(uid string) error {
+	return t.callErr(func() error {
+		player, ok := t.players[uid]
+		if !ok {
+			return errNoPlayer
+		}
+		if player.isBot {
+			return errPlayerIsBot
+		}
+		if botCreator == nil {
+			return errNoBot
+		}
+		t.sendMsg(t.newMsg(&cproto.GameExitAck{Uid: uid}), player)
+		playerManager.Delete(false, uid)
+		player.isBot = true
+		player.online = true
+		if err := playerManager.restore(player); err != nil {
+			return err
+		}
+		botManager.AddBot(botCreator(uid, t.MatchID, t.tableID, t.scoreBase))
+		if t.game != nil {
+			t.game.OnNetChange(player, false)
+		}
+		t.handleEnterGame(player, nil)
+		t.markDirty()
+		t.saveCheckpoint()
+		return nil
+	})
+}
+
+// SetTrusted 切换座位的托管，游戏需要实现ITrustee
+func (t *Table) SetTrusted(seat int32, trusted bool) error {
+	return t.callErr(func() error {
+		g, ok := t.game.(ITrustee)
+		if !ok || !t.playing {
+			return errNoTrustee
+		}
+		if err := g.SetTrusted(seat, trusted); err != nil {
+			return err
+		}
+		t.markDirty()
+		t.checkpoint()
+		return nil
+	})
+}
+
+// Notice 给桌子上的玩家和观战者推送系统公告，消息为google.protobuf.StringValue
+func (t *Table) Notice(text string) error {
+	return t.callErr(func() error {
+		t.broadcast(wrapperspb.String(text))
+		return nil
+	})
+}
@@ -1,130 +1,406 @@
 package game
 
 import (
+	"runtime"
 	"runtime/debug"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/kevin-chtw/tw_common/utils"
 	"github.com/kevin-chtw/tw_proto/cproto"
 	"github.com/topfreegames/pitaya/v3/pkg/logger"
 	"google.golang.org/protobuf/proto"
 )
 
-// BotManager 全局管理所有的bot玩家
+// BotOverflow bot消息队列满时的处理方式
+type BotOverflow int
+
+const (
+	BotDropOldest BotOverflow = iota // 丢弃最早的消息
+	BotDropNewest                    // 丢弃新消息
+	BotDropTimer                     // 积压超过队列长度时跳过OnTimer等bot追上，超过MaxQueue时丢弃最早的消息
+)
+
+// BotManagerConfig BotManager配置
+type BotManagerConfig struct {
+	Workers       int           // 执行bot回调的goroutine数
+	QueueSize     int           // 每个bot的消息队列长度
+	MaxQueue      int           // 每个bot积压的消息和延迟请求的上限，默认QueueSize的4倍
+	Overflow      BotOverflow   // 队列满时的处理方式
+	SlowThreshold time.Duration // 回调执行超过这个时间算慢，还没返回的算卡住
+}
+
+// DefaultBotManagerConfig 默认配置
+var DefaultBotManagerConfig = BotManagerConfig{
+	Workers:       runtime.GOMAXPROCS(0),
+	QueueSize:     64,
+	Overflow:      BotDropTimer,
+	SlowThreshold: time.Second,
+}
+
+var botManagerConfig = DefaultBotManagerConfig
+
+// SetBotManagerConfig 设置BotManager配置，需要在Init之前调用
+func SetBotManagerConfig(conf BotManagerConfig) {
+	botManagerConfig = conf
+}
+
+// BotStats BotManager的运行指标
+type BotStats struct {
+	Bots      int    // bot数量
+	Queued    int    // 等待处理的消息数
+	Stuck     int    // 回调还没返回且超过SlowThreshold的bot数
+	Received  uint64 // 收到的消息数
+	Processed uint64 // 处理完的消息数
+	Dropped   uint64 // 队列满或bot不存在丢弃的消息数
+	Skipped   uint64 // BotDropTimer时积压跳过的OnTimer次数
+	Slow      uint64 // 执行超过SlowThreshold的回调次数
+}
+
+// BotManager 全局管理所有的bot玩家。每个bot有自己的有界队列，由worker池处理，
+// 同一个bot的回调不会并发执行，一个bot卡住只占用一个worker，不影响桌子和其他bot
 type BotManager struct {
-	bots       map[string]*BotPlayer // bot ID -> BotPlayer
-	msgInChan  chan *BotMessage      // 接收消息通道
-	msgOutChan chan *BotMessage      // 发送消息通道
-	mu         sync.RWMutex
-	ticker     *time.Ticker
+	conf      BotManagerConfig
+	mailboxes map[string]*botMailbox // bot ID -> 队列
+	mu        sync.RWMutex
+	ready     []*botMailbox // 有消息待处理的bot
+	readyMu   sync.Mutex
+	readyCond *sync.Cond
+	ticker    utils.Ticker
+	delay     *utils.TimingWheel // 延迟发送的请求
+	clock     utils.Clock
+	done      chan struct{}
+	stopped   bool // 由readyMu保护
+
+	received  atomic.Uint64
+	processed atomic.Uint64
+	dropped   atomic.Uint64
+	skipped   atomic.Uint64
+	slow      atomic.Uint64
 }
 
-// BotMessage 定义bot消息结构
-type BotMessage struct {
-	BotID string
-	Msg   interface{}
+// botMailbox 单个bot的消息队列，scheduled为true时在ready里或正在被worker处理
+type botMailbox struct {
+	bot       *BotPlayer
+	mu        sync.Mutex
+	msgs      []*cproto.GameAck
+	out       []proto.Message                // 到期要发送的请求
+	pending   map[*utils.WheelTimer]struct{} // 还没到期的请求
+	timer     bool                           // 待执行OnTimer，多次触发合并为一次
+	scheduled bool
+	busySince time.Time // 回调开始执行的时间，空闲时为零值
 }
 
-// GetBotManager 获取BotManager单例
+// queueInterval 延迟请求的时间精度
+const queueInterval = 100 * time.Millisecond
+
+// NewBotManager 按SetBotManagerConfig的配置创建BotManager
 func NewBotManager() *BotManager {
+	conf := botManagerConfig
+	conf.Workers = max(conf.Workers, 1)
+	conf.QueueSize = max(conf.QueueSize, 1)
+	if conf.MaxQueue <= 0 {
+		conf.MaxQueue = conf.QueueSize * 4
+	}
+	conf.MaxQueue = max(conf.MaxQueue, conf.QueueSize)
 	bm := &BotManager{
-		bots:       make(map[string]*BotPlayer),
-		msgInChan:  make(chan *BotMessage, 100),
-		msgOutChan: make(chan *BotMessage, 100),
-		mu:         sync.RWMutex{},
-		ticker:     time.NewTicker(time.Second),
-	}
-	go func() {
-		defer func() {
-			if r := recover(); r != nil {
-				logger.Log.Errorf("panic recovered %s\n %s", r, string(debug.Stack()))
-			}
-		}()
-		for range bm.ticker.C {
-			bm.tick()
-		}
-	}()
-	go bm.processIncomingMessages()
-	go bm.processOutgoingMessages()
+		conf:      conf,
+		mailboxes: make(map[string]*botMailbox),
+		ticker:    clock.NewTicker(time.Second),
+		delay:     utils.NewTimingWheel(clock, queueInterval),
+		clock:     clock,
+		done:      make(chan struct{}),
+	}
+	bm.readyCond = sync.NewCond(&bm.readyMu)
+	for range conf.Workers {
+		go bm.work()
+	}
+	go bm.run()
+	go bm.delay.Run(bm.done)
 	return bm
 }
 
-func (b *BotManager) tick() {
-	b.mu.RLock()
-	bots := make([]*BotPlayer, 0, len(b.bots))
-	for _, bot := range b.bots {
-		bots = append(bots, bot)
+// Stop 停止worker、bot定时器和延迟队列，正在执行的回调会执行完
+func (m *BotManager) Stop() {
+	m.readyMu.Lock()
+	if m.stopped {
+		m.readyMu.Unlock()
+		return
+	}
+	m.stopped = true
+	m.readyMu.Unlock()
+	m.readyCond.Broadcast()
+	close(m.done)
+}
+
+// run 触发bot定时器，不执行bot回调
+func (m *BotManager) run() {
+	defer m.ticker.Stop()
+	for {
+		select {
+		case <-m.ticker.C():
+			m.tick()
+		case <-m.done:
+			return
+		}
+	}
+}
+
+func (m *BotManager) tick() {
+	m.mu.RLock()
+	boxes := make([]*botMailbox, 0, len(m.mailboxes))
+	for _, mb := range m.mailboxes {
+		boxes = append(boxes, mb)
+	}
+	m.mu.RUnlock()
+
+	for _, mb := range boxes {
+		mb.mu.Lock()
+		if m.conf.Overflow == BotDropTimer && len(mb.msgs) >= m.conf.QueueSize {
+			m.skipped.Add(1)
+		} else {
+			mb.timer = true
+			m.schedule(mb)
+		}
+		mb.mu.Unlock()
+	}
+}
+
+// schedule 需要持有mb.mu
+func (m *BotManager) schedule(mb *botMailbox) {
+	if mb.scheduled {
+		return
+	}
+	mb.scheduled = true
+	m.readyMu.Lock()
+	m.ready = append(m.ready, mb)
+	m.readyMu.Unlock()
+	m.readyCond.Signal()
+}
+
+// work worker从ready里取bot执行回调
+func (m *BotManager) work() {
+	for {
+		m.readyMu.Lock()
+		for len(m.ready) == 0 && !m.stopped {
+			m.readyCond.Wait()
+		}
+		if m.stopped {
+			m.readyMu.Unlock()
+			return
+		}
+		mb := m.ready[0]
+		m.ready[0] = nil
+		m.ready = m.ready[1:]
+		m.readyMu.Unlock()
+		m.process(mb)
+	}
+}
+
+// process 执行一批回调，期间新到的消息留到下一轮，避免一个bot一直占着worker
+func (m *BotManager) process(mb *botMailbox) {
+	mb.mu.Lock()
+	msgs, out, timer := mb.msgs, mb.out, mb.timer
+	mb.msgs, mb.out, mb.timer = nil, nil, false
+	start := time.Now()
+	mb.busySince = start
+	mb.mu.Unlock()
+
+	bot := mb.bot
+	for _, req := range out {
+		m.safeRun(bot.Uid, func() {
+			if err := bot.SendMsg(req); err != nil {
+				logger.Log.Errorf("bot %s send failed: %v", bot.Uid, err)
+			}
+		})
+	}
+	for _, msg := range msgs {
+		m.safeRun(bot.Uid, func() { bot.OnBotMsg(msg) })
+		m.processed.Add(1)
+	}
+	if timer {
+		m.safeRun(bot.Uid, func() { bot.Bot.OnTimer() })
+	}
+	if cost := time.Since(start); cost > m.conf.SlowThreshold {
+		m.slow.Add(1)
+		logger.Log.Warnf("bot %s slow: %d msgs cost %v", bot.Uid, len(msgs), cost)
 	}
-	b.mu.RUnlock()
 
-	for _, bot := range bots {
-		bot.Bot.OnTimer()
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	mb.busySince = time.Time{}
+	mb.scheduled = false
+	if len(mb.msgs) > 0 || len(mb.out) > 0 || mb.timer {
+		m.schedule(mb)
 	}
 }
 
+// safeRun 执行bot回调，panic时记录事故，不影响其他bot
+func (m *BotManager) safeRun(botID string, fn func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			incident := newIncident(0, 0, r, debug.Stack())
+			incident.BotID = botID
+			if bot := m.GetBot(botID); bot != nil {
+				incident.MatchID = bot.matchid
+				incident.TableID = bot.tableid
+			}
+			recordIncident(incident)
+		}
+	}()
+	fn()
+}
+
 // AddBot 添加一个新的bot玩家
 func (m *BotManager) AddBot(bot *BotPlayer) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	m.bots[bot.Uid] = bot
+	m.mailboxes[bot.Uid] = &botMailbox{bot: bot, pending: make(map[*utils.WheelTimer]struct{})}
 }
 
-// RemoveBot 移除一个bot玩家
+// RemoveBot 移除一个bot玩家，丢弃它还没处理的消息和还没发送的请求
 func (m *BotManager) RemoveBot(botID string) {
 	m.mu.Lock()
-	defer m.mu.Unlock()
-	delete(m.bots, botID)
+	mb := m.mailboxes[botID]
+	delete(m.mailboxes, botID)
+	m.mu.Unlock()
+	if mb != nil {
+		m.cancelPending(mb)
+	}
 }
 
 // GetBot 获取指定bot玩家
 func (m *BotManager) GetBot(botID string) *BotPlayer {
+	if mb := m.getMailbox(botID); mb != nil {
+		return mb.bot
+	}
+	return nil
+}
+
+func (m *BotManager) getMailbox(botID string) *botMailbox {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	return m.bots[botID]
+	return m.mailboxes[botID]
 }
 
-// processIncomingMessages 处理接收到的消息
-func (m *BotManager) processIncomingMessages() {
-	defer func() {
-		if r := recover(); r != nil {
-			logger.Log.Errorf("panic recovered %s\n %s", r, string(debug.Stack()))
+// SendToTable 发送消息到指定table，桌子异步处理
+func (m *BotManager) SendToTable(botID string, msg proto.Message) {
+	tableManager.OnBotMsg(botID, msg)
+}
+
+// OnBotMessage 把发给bot的消息放入它的队列，不会阻塞调用的桌子
+func (m *BotManager) OnBotMessage(botID string, msg *cproto.GameAck) {
+	m.received.Add(1)
+	mb := m.getMailbox(botID)
+	if mb == nil {
+		m.dropped.Add(1)
+		logger.Log.Errorf("bot not found %s", botID)
+		return
+	}
+
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	switch {
+	case len(mb.msgs) < m.conf.QueueSize:
+	case m.conf.Overflow == BotDropTimer && len(mb.msgs) < m.conf.MaxQueue:
+		if len(mb.msgs) == m.conf.QueueSize {
+			logger.Log.Warnf("bot %s queue full, skip timer until it catches up", botID)
 		}
-	}()
-	for msg := range m.msgInChan {
-		bot := m.GetBot(msg.BotID)
-		ack := msg.Msg.(*cproto.GameAck)
-		if bot == nil {
-			logger.Log.Errorf("bot not found %s", msg.BotID)
-			continue
+	case m.conf.Overflow == BotDropNewest:
+		m.dropped.Add(1)
+		logger.Log.Warnf("bot %s queue full, drop message", botID)
+		return
+	default:
+		m.dropped.Add(1)
+		logger.Log.Warnf("bot %s queue full, drop message", botID)
+		mb.msgs[0] = nil
+		mb.msgs = mb.msgs[1:]
+	}
+	mb.msgs = append(mb.msgs, msg)
+	m.schedule(mb)
+}
+
+// Stats 获取运行指标
+func (m *BotManager) Stats() BotStats {
+	m.mu.RLock()
+	boxes := make([]*botMailbox, 0, len(m.mailboxes))
+	for _, mb := range m.mailboxes {
+		boxes = append(boxes, mb)
+	}
+	m.mu.RUnlock()
+
+	stats := BotStats{
+		Bots:      len(boxes),
+		Received:  m.received.Load(),
+		Processed: m.processed.Load(),
+		Dropped:   m.dropped.Load(),
+		Skipped:   m.skipped.Load(),
+		Slow:      m.slow.Load(),
+	}
+	now := time.Now()
+	for _, mb := range boxes {
+		mb.mu.Lock()
+		stats.Queued += len(mb.msgs)
+		if !mb.busySince.IsZero() && now.Sub(mb.busySince) > m.conf.SlowThreshold {
+			stats.Stuck++
 		}
-		bot.OnBotMsg(ack)
+		mb.mu.Unlock()
 	}
+	return stats
 }
 
-// processOutgoingMessages 处理要发送的消息
-func (m *BotManager) processOutgoingMessages() {
-	defer func() {
-		if r := recover(); r != nil {
-			logger.Log.Errorf("panic recovered %s\n %s", r, string(debug.Stack()))
+// enqueue 把请求加入延迟队列，同一时间到期的请求按加入的顺序发送，超过MaxQueue时丢弃
+func (m *BotManager) enqueue(botID string, p *PendingReq) {
+	mb := m.getMailbox(botID)
+	if mb == nil {
+		m.dropped.Add(1)
+		return
+	}
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	if len(mb.pending)+len(mb.out) >= m.conf.MaxQueue {
+		m.dropped.Add(1)
+		logger.Log.Warnf("bot %s has too many pending requests, drop request", botID)
+		return
+	}
+	var timer *utils.WheelTimer
+	timer = m.delay.AfterFunc(time.Duration(p.Delay)*time.Millisecond, func() {
+		mb.mu.Lock()
+		defer mb.mu.Unlock()
+		if _, ok := mb.pending[timer]; !ok {
+			return
 		}
-	}()
-	for msg := range m.msgOutChan {
-		tableManager.OnBotMsg(msg.BotID, msg.Msg.(proto.Message))
+		delete(mb.pending, timer)
+		mb.out = append(mb.out, p.Req)
+		m.schedule(mb)
+	})
+	mb.pending[timer] = struct{}{}
+}
+
+// cancel 丢弃botID还没发送的请求
+func (m *BotManager) cancel(botID string) {
+	if mb := m.getMailbox(botID); mb != nil {
+		m.cancelPending(mb)
 	}
 }
 
-// SendToTable 发送消息到指定table
-func (m *BotManager) SendToTable(botID string, msg proto.Message) {
-	m.msgOutChan <- &BotMessage{
-		BotID: botID,
-		Msg:   msg,
+func (m *BotManager) cancelPending(mb *botMailbox) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	for timer := range mb.pending {
+		m.delay.Stop(timer)
 	}
+	clear(mb.pending)
 }
 
-// OnBotMessage 处理接收到的bot消息
-func (m *BotManager) OnBotMessage(botID string, msg *cproto.GameAck) {
-	m.msgInChan <- &BotMessage{
-		BotID: botID,
-		Msg:   msg,
+// Pending 返回botID排队中的请求数
+func (m *BotManager) Pending(botID string) int {
+	mb := m.getMailbox(botID)
+	if mb == nil {
+		return 0
 	}
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	return len(mb.pending)
 }
@@ -0,0 +1,180 @@
+package game_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kevin-chtw/tw_common/gamebase/game"
+	"github.com/kevin-chtw/tw_common/utils"
+	"github.com/kevin-chtw/tw_proto/cproto"
+	"google.golang.org/protobuf/proto"
+)
+
+// chanBot 把收到的消息序号转给got，release不为nil时先等release关闭
+type chanBot struct {
+	got     chan int32
+	release chan struct{}
+}
+
+func (b *chanBot) OnBotMsg(msg proto.Message) error {
+	if b.release != nil {
+		<-b.release
+	}
+	b.got <- msg.(*cproto.GameAck).Tableid
+	return nil
+}
+
+func (b *chanBot) OnTimer() error { return nil }
+
+func newChanBot(uid string, release chan struct{}) (*game.BotPlayer, *chanBot) {
+	p := game.NewBotPlayer(uid, 1, 1, 1)
+	bot := &chanBot{got: make(chan int32, 16), release: release}
+	p.Bot = bot
+	return p, bot
+}
+
+func waitStats(t *testing.T, bm *game.BotManager, ok func(game.BotStats) bool) game.BotStats {
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		stats := bm.Stats()
+		if ok(stats) {
+			return stats
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("stats = %+v", stats)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestBotManagerPipeline(t *testing.T) {
+	game.SetBotManagerConfig(game.BotManagerConfig{Workers: 2, QueueSize: 2, Overflow: game.BotDropOldest, SlowThreshold: 10 * time.Millisecond})
+	t.Cleanup(func() { game.SetBotManagerConfig(game.DefaultBotManagerConfig) })
+	bm := game.NewBotManager()
+	t.Cleanup(bm.Stop)
+
+	release := make(chan struct{})
+	stuckPlayer, stuck := newChanBot("stuck", release)
+	fastPlayer, fast := newChanBot("fast", nil)
+	bm.AddBot(stuckPlayer)
+	bm.AddBot(fastPlayer)
+
+	// 第一条消息卡住，之后的消息只保留最新的两条
+	bm.OnBotMessage("stuck", &cproto.GameAck{Tableid: 0})
+	waitStats(t, bm, func(s game.BotStats) bool { return s.Stuck == 1 })
+	for i := int32(1); i < 6; i++ {
+		bm.OnBotMessage("stuck", &cproto.GameAck{Tableid: i})
+	}
+	bm.OnBotMessage("missing", &cproto.GameAck{})
+
+	// 卡住的bot不影响其他bot
+	for i := range int32(2) {
+		bm.OnBotMessage("fast", &cproto.GameAck{Tableid: i})
+	}
+	for i := range int32(2) {
+		select {
+		case got := <-fast.got:
+			if got != i {
+				t.Errorf("fast got %d, want %d", got, i)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("fast bot blocked by stuck bot")
+		}
+	}
+
+	stats := bm.Stats()
+	if stats.Bots != 2 || stats.Queued != 2 || stats.Received != 9 || stats.Dropped != 4 {
+		t.Errorf("stats = %+v", stats)
+	}
+
+	close(release)
+	for _, want := range []int32{0, 4, 5} {
+		select {
+		case got := <-stuck.got:
+			if got != want {
+				t.Errorf("stuck got %d, want %d", got, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("stuck bot not resumed")
+		}
+	}
+	stats = waitStats(t, bm, func(s game.BotStats) bool { return s.Processed == 5 && s.Stuck == 0 })
+	if stats.Queued != 0 || stats.Slow == 0 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestBotManagerDropTimer(t *testing.T) {
+	clock := utils.NewManualClock(time.Unix(1000, 0))
+	game.SetClock(clock)
+	t.Cleanup(func() { game.SetClock(utils.SystemClock) })
+	game.SetBotManagerConfig(game.BotManagerConfig{Workers: 1, QueueSize: 2, Overflow: game.BotDropTimer, SlowThreshold: 10 * time.Millisecond})
+	t.Cleanup(func() { game.SetBotManagerConfig(game.DefaultBotManagerConfig) })
+	bm := game.NewBotManager()
+	t.Cleanup(bm.Stop)
+
+	release := make(chan struct{})
+	player, bot := newChanBot("slow", release)
+	bm.AddBot(player)
+
+	// 第一条消息卡住，积压超过队列长度时不丢消息，只跳过定时器
+	bm.OnBotMessage("slow", &cproto.GameAck{Tableid: 0})
+	waitStats(t, bm, func(s game.BotStats) bool { return s.Stuck == 1 })
+	for i := int32(1); i < 5; i++ {
+		bm.OnBotMessage("slow", &cproto.GameAck{Tableid: i})
+	}
+	clock.Advance(time.Second)
+	waitStats(t, bm, func(s game.BotStats) bool { return s.Skipped == 1 })
+	close(release)
+	for want := range int32(5) {
+		select {
+		case got := <-bot.got:
+			if got != want {
+				t.Errorf("got %d, want %d", got, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("message lost")
+		}
+	}
+	if stats := bm.Stats(); stats.Dropped != 0 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestBotManagerMaxQueue(t *testing.T) {
+	clock := utils.NewManualClock(time.Unix(1000, 0))
+	game.SetClock(clock)
+	t.Cleanup(func() { game.SetClock(utils.SystemClock) })
+	game.SetBotManagerConfig(game.BotManagerConfig{Workers: 1, QueueSize: 2, MaxQueue: 4, Overflow: game.BotDropTimer, SlowThreshold: 10 * time.Millisecond})
+	t.Cleanup(func() { game.SetBotManagerConfig(game.DefaultBotManagerConfig) })
+	bm := game.NewBotManager()
+	t.Cleanup(bm.Stop)
+
+	release := make(chan struct{})
+	player, bot := newChanBot("flooded", release)
+	bm.AddBot(player)
+
+	// 卡住的bot积压不超过MaxQueue，超过时丢弃最早的消息
+	bm.OnBotMessage("flooded", &cproto.GameAck{Tableid: 0})
+	waitStats(t, bm, func(s game.BotStats) bool { return s.Stuck == 1 })
+	for i := int32(1); i < 100; i++ {
+		bm.OnBotMessage("flooded", &cproto.GameAck{Tableid: i})
+		if stats := bm.Stats(); stats.Queued > 4 {
+			t.Fatalf("queued %d", stats.Queued)
+		}
+	}
+	if stats := bm.Stats(); stats.Queued != 4 || stats.Dropped != 95 {
+		t.Errorf("stats = %+v", stats)
+	}
+	close(release)
+	for _, want := range []int32{0, 96, 97, 98, 99} {
+		select {
+		case got := <-bot.got:
+			if got != want {
+				t.Errorf("got %d, want %d", got, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("message lost")
+		}
+	}
+}
@@ -13,9 +13,10 @@ type IBot interface {
 	OnTimer() error
 }
 
+// PendingReq 延迟发送的请求，由BotManager排队到时发送
 type PendingReq struct {
 	Req   proto.Message // 待发送的请求
-	Delay int           // 剩余延迟(ms)
+	Delay int           // 延迟(ms)
 }
 
 // BotPlayer 表示游戏中的bot玩家
@@ -24,8 +25,10 @@ type BotPlayer struct {
 	Uid       string
 	Seat      int32
 	Scorebase int64
+	Profile   *BotProfile // 所在比赛的bot配置
 	matchid   int32
 	tableid   int32
+	local     func(req *cproto.GameReq) // 本地桌子的请求出口
 }
 
 // NewBotPlayer 创建新的bot玩家实例
@@ -35,6 +38,7 @@ func NewBotPlayer(uid string, matchid, tableid int32, scorebase int64) *BotPlaye
 		matchid:   matchid,
 		tableid:   tableid,
 		Scorebase: scorebase,
+		Profile:   GetMatchBotProfile(matchid),
 	}
 	return b
 }
@@ -64,6 +68,36 @@ func (b *BotPlayer) SendMsg(msg proto.Message) error {
 		Matchid: b.matchid,
 		Req:     data,
 	}
+	if b.local != nil {
+		b.local(req)
+		return nil
+	}
 	botManager.SendToTable(b.Uid, req)
 	return nil
 }
+
+// SendMsgAfter 延迟delay毫秒后发送，本地桌子不延迟
+func (b *BotPlayer) SendMsgAfter(msg proto.Message, delay int) error {
+	if b.local != nil || delay <= 0 {
+		return b.SendMsg(msg)
+	}
+	botManager.enqueue(b.Uid, &PendingReq{Req: msg, Delay: delay})
+	return nil
+}
+
+// Reply 按action的思考时间延迟发送，action为具体游戏定义的动作名
+func (b *BotPlayer) Reply(action string, msg proto.Message) error {
+	return b.SendMsgAfter(msg, b.Profile.GetPacing().Delay(action))
+}
+
+// CancelPending 丢弃还没发送的请求，例如请求已经超时被桌子处理掉
+func (b *BotPlayer) CancelPending() {
+	if b.local == nil {
+		botManager.cancel(b.Uid)
+	}
+}
+
+// SetLocalSender 设置后请求直接交给fn，不经过BotManager，用于离线模拟
+func (b *BotPlayer) SetLocalSender(fn func(req *cproto.GameReq)) {
+	b.local = fn
+}
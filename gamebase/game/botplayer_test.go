@@ -0,0 +1,126 @@
+package game_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kevin-chtw/tw_common/apptest"
+	"github.com/kevin-chtw/tw_common/gamebase/game"
+	"github.com/kevin-chtw/tw_common/utils"
+	"github.com/kevin-chtw/tw_proto/sproto"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestBotPacing(t *testing.T) {
+	tt := game.ThinkTime{Base: 1000, Jitter: 200}
+	for range 100 {
+		if d := tt.Sample(); d < 800 || d > 1200 {
+			t.Fatalf("sample = %d", d)
+		}
+	}
+	if d := (game.ThinkTime{Base: 100, Jitter: 500}).Sample(); d < 0 {
+		t.Errorf("negative sample %d", d)
+	}
+
+	pacing := &game.BotPacing{Default: game.ThinkTime{Base: 300}, Actions: map[string]game.ThinkTime{"Pon": {Base: 50}}}
+	if pacing.Delay("Pon") != 50 || pacing.Delay("Discard") != 300 {
+		t.Errorf("pacing delay wrong")
+	}
+	var none *game.BotProfile
+	if none.GetPacing().Delay("Pon") != 0 {
+		t.Errorf("nil profile should not delay")
+	}
+
+	game.SetMatchBotProfile(7, &game.BotProfile{Strategy: "random", Level: game.BotLevelEasy})
+	t.Cleanup(func() { game.SetMatchBotProfile(7, nil) })
+	if p := game.NewBotPlayer("b", 7, 1, 1).Profile; p.Strategy != "random" || p.GetPacing() == nil {
+		t.Errorf("match profile = %+v", p)
+	}
+	if p := game.NewBotPlayer("b", 8, 1, 1).Profile; p.Strategy != "" || p.Level != game.BotLevelNormal {
+		t.Errorf("default profile = %+v", p)
+	}
+}
+
+// replyGame 把收到的消息转给msgs
+type replyGame struct {
+	msgs chan []byte
+}
+
+func (g *replyGame) OnGameBegin() {}
+func (g *replyGame) OnPlayerMsg(player *game.Player, data []byte) error {
+	g.msgs <- data
+	return nil
+}
+func (g *replyGame) OnGameTimer()                                  {}
+func (g *replyGame) OnNetChange(player *game.Player, offline bool) {}
+
+// replyBot 开局后思考一会回复一条消息
+type replyBot struct {
+	*game.BotPlayer
+	replied bool
+}
+
+func (b *replyBot) OnBotMsg(msg proto.Message) error {
+	if b.replied {
+		return nil
+	}
+	b.replied = true
+	return b.Reply("Discard", wrapperspb.Int32(9))
+}
+
+func (b *replyBot) OnTimer() error { return nil }
+
+func TestBotDelayedReply(t *testing.T) {
+	clock := utils.NewManualClock(time.Unix(1000, 0))
+	game.SetClock(clock)
+	t.Cleanup(func() { game.SetClock(utils.SystemClock) })
+	g := &replyGame{msgs: make(chan []byte, 1)}
+	game.SetMatchBotProfile(1, &game.BotProfile{Pacing: &game.BotPacing{Actions: map[string]game.ThinkTime{"Discard": {Base: 2000}}}})
+	t.Cleanup(func() { game.SetMatchBotProfile(1, nil) })
+	game.Init(apptest.NewApp("game"), func(*game.Table, int32) game.IGame { return g },
+		func(uid string, matchid, tableid int32, scorebase int64) *game.BotPlayer {
+			p := game.NewBotPlayer(uid, matchid, tableid, scorebase)
+			p.Bot = &replyBot{BotPlayer: p}
+			return p
+		})
+
+	ctx := context.Background()
+	table := game.GetTableManager().LoadOrStore(1, 1)
+	t.Cleanup(func() { table.HandleCancelTable(ctx, &sproto.CancelTableReq{}) })
+	if _, err := table.HandleAddTable(ctx, &sproto.AddTableReq{MatchType: "normal", PlayerCount: 1, GameCount: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := table.HandleAddPlayer(ctx, &sproto.AddPlayerReq{Playerid: "bot", Bot: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 等bot处理完消息，回复进入队列
+	deadline := time.Now().Add(2 * time.Second)
+	for game.GetBotManager().Pending("bot") == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("reply not queued")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	clock.Advance(time.Second)
+	select {
+	case <-g.msgs:
+		t.Fatal("reply sent before think time")
+	case <-time.After(50 * time.Millisecond):
+	}
+	clock.Advance(time.Second)
+	select {
+	case data := <-g.msgs:
+		reply := &wrapperspb.Int32Value{}
+		if err := proto.Unmarshal(data, reply); err != nil || reply.Value != 9 {
+			t.Errorf("reply = %v", data)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("reply not sent after think time")
+	}
+	if game.GetBotManager().Pending("bot") != 0 {
+		t.Error("queue not empty")
+	}
+}
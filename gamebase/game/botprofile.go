@@ -0,0 +1,114 @@
+package game
+
+import (
+	"math/rand"
+	"sync"
+)
+
+// BotLevel bot难度
+type BotLevel int32
+
+const (
+	BotLevelEasy   BotLevel = iota // 简单，经常失误，想得慢
+	BotLevelNormal                 // 普通
+	BotLevelHard                   // 困难，不失误
+)
+
+// ThinkTime 思考时间，在Base上下随机浮动Jitter毫秒
+type ThinkTime struct {
+	Base   int // 平均思考时间(ms)
+	Jitter int // 随机浮动(ms)
+}
+
+// Sample 随机一个思考时间(ms)，不小于0
+func (t ThinkTime) Sample() int {
+	delay := t.Base
+	if t.Jitter > 0 {
+		delay += rand.Intn(2*t.Jitter+1) - t.Jitter
+	}
+	return max(delay, 0)
+}
+
+// BotPacing 按动作类型配置的思考时间，动作名由具体游戏定义，没有配置的动作用Default
+type BotPacing struct {
+	Default ThinkTime
+	Actions map[string]ThinkTime
+}
+
+// Delay 随机一个action的思考时间(ms)，p为nil时不延迟
+func (p *BotPacing) Delay(action string) int {
+	if p == nil {
+		return 0
+	}
+	if t, ok := p.Actions[action]; ok {
+		return t.Sample()
+	}
+	return p.Default.Sample()
+}
+
+// DefaultBotPacing 各难度默认的思考时间，难度越低想得越久
+func DefaultBotPacing(level BotLevel) *BotPacing {
+	scale := 1
+	if level == BotLevelEasy {
+		scale = 2
+	}
+	return &BotPacing{
+		Default: ThinkTime{Base: 800 * scale, Jitter: 400 * scale},
+		Actions: map[string]ThinkTime{
+			"Pass": {Base: 500 * scale, Jitter: 300 * scale},
+			"Win":  {Base: 400, Jitter: 200},
+		},
+	}
+}
+
+// BotProfile 比赛中bot的策略、难度和思考时间
+type BotProfile struct {
+	Strategy string     // 策略名，由具体游戏注册
+	Level    BotLevel   // 难度
+	Pacing   *BotPacing // 思考时间，为nil时用DefaultBotPacing(Level)
+}
+
+// GetPacing 实际使用的思考时间，p为nil时不延迟
+func (p *BotProfile) GetPacing() *BotPacing {
+	if p == nil {
+		return nil
+	}
+	if p.Pacing != nil {
+		return p.Pacing
+	}
+	return DefaultBotPacing(p.Level)
+}
+
+var (
+	botProfiles       = make(map[int32]*BotProfile)
+	botProfilesMu     sync.RWMutex
+	defaultBotProfile = &BotProfile{Level: BotLevelNormal}
+)
+
+// SetDefaultBotProfile 设置没有单独配置的比赛使用的bot配置
+func SetDefaultBotProfile(profile *BotProfile) {
+	botProfilesMu.Lock()
+	defer botProfilesMu.Unlock()
+	defaultBotProfile = profile
+}
+
+// SetMatchBotProfile 设置比赛matchid的bot配置，profile为nil时恢复默认
+func SetMatchBotProfile(matchid int32, profile *BotProfile) {
+	botProfilesMu.Lock()
+	defer botProfilesMu.Unlock()
+	if profile == nil {
+		delete(botProfiles, matchid)
+		return
+	}
+	botProfiles[matchid] = profile
+}
+
+// GetMatchBotProfile 获取比赛matchid的bot配置
+func GetMatchBotProfile(matchid int32) *BotProfile {
+	botProfilesMu.RLock()
+	defer botProfilesMu.RUnlock()
+	if profile, ok := botProfiles[matchid]; ok {
+		return profile
+	}
+	return defaultBotProfile
+}
@@ -0,0 +1,267 @@
+package game
+
+import (
+	"errors"
+	"time"
+
+	"github.com/kevin-chtw/tw_proto/cproto"
+	"google.golang.org/protobuf/proto"
+)
+
+// DissolveRule 解散投票的通过规则
+type DissolveRule int32
+
+const (
+	DissolveUnanimous DissolveRule = iota // 所有人同意，有人反对立即取消
+	DissolveMajority                      // 超过一半同意
+)
+
+// fdproperty中的解散配置，没有配置的使用DefaultDissolvePolicy
+const (
+	FdDissolveRule         = "dissolve_rule"          // DissolveRule
+	FdDissolveTimeout      = "dissolve_timeout"       // 投票时长，秒
+	FdDissolveTimeoutAgree = "dissolve_timeout_agree" // 超时没投票的算同意，0算反对
+	FdDissolveCreatorVeto  = "dissolve_creator_veto"  // 创建者反对时立即取消
+	FdDissolveCooldown     = "dissolve_cooldown"      // 上次投票结束后多少秒才能再发起
+	FdDissolveMaxRequests  = "dissolve_max_requests"  // 每局最多发起次数
+	FdDissolveMaxRounds    = "dissolve_max_rounds"    // 打完这么多局之后不能解散
+	FdDissolveOfflineAgree = "dissolve_offline_agree" // 离线玩家多少秒后自动同意
+)
+
+// DissolvePolicy 解散投票策略
+type DissolvePolicy struct {
+	Rule         DissolveRule
+	Timeout      time.Duration
+	TimeoutAgree bool
+	CreatorVeto  bool
+	Cooldown     time.Duration
+	MaxRequests  int32         // 0不限制
+	MaxRounds    int32         // 0不限制
+	OfflineAgree time.Duration // 0不自动同意
+}
+
+// DefaultDissolvePolicy 所有人同意，5分钟超时算同意
+var DefaultDissolvePolicy = DissolvePolicy{
+	Rule:         DissolveUnanimous,
+	Timeout:      5 * time.Minute,
+	TimeoutAgree: true,
+}
+
+var (
+	errNoDissolve       = errors.New("no dissolve vote")
+	errDissolveRounds   = errors.New("too many rounds to dissolve")
+	errDissolveRequests = errors.New("too many dissolve requests")
+	errDissolveCooldown = errors.New("dissolve request too frequent")
+)
+
+// ParseDissolvePolicy 从房间属性读取解散策略
+func ParseDissolvePolicy(fdproperty map[string]int32) DissolvePolicy {
+	policy := DefaultDissolvePolicy
+	seconds := func(v int32) time.Duration { return time.Duration(v) * time.Second }
+	if v, ok := fdproperty[FdDissolveRule]; ok {
+		policy.Rule = DissolveRule(v)
+	}
+	if v, ok := fdproperty[FdDissolveTimeout]; ok && v > 0 {
+		policy.Timeout = seconds(v)
+	}
+	if v, ok := fdproperty[FdDissolveTimeoutAgree]; ok {
+		policy.TimeoutAgree = v != 0
+	}
+	if v, ok := fdproperty[FdDissolveCreatorVeto]; ok {
+		policy.CreatorVeto = v != 0
+	}
+	if v, ok := fdproperty[FdDissolveCooldown]; ok {
+		policy.Cooldown = seconds(v)
+	}
+	if v, ok := fdproperty[FdDissolveMaxRequests]; ok {
+		policy.MaxRequests = v
+	}
+	if v, ok := fdproperty[FdDissolveMaxRounds]; ok {
+		policy.MaxRounds = v
+	}
+	if v, ok := fdproperty[FdDissolveOfflineAgree]; ok {
+		policy.OfflineAgree = seconds(v)
+	}
+	return policy
+}
+
+// dissolveState 解散投票之外需要记住的状态
+type dissolveState struct {
+	requests int32                 // 本局发起的次数
+	last     time.Time             // 上次投票结束的时间
+	offline  map[int32]*TableTimer // 离线玩家自动同意的定时器
+}
+
+// GetDissolvePolicy 获取桌子的解散策略
+func (t *Table) GetDissolvePolicy() DissolvePolicy {
+	return ParseDissolvePolicy(t.fdproperty)
+}
+
+func (t *Table) handleGameDissolve(player *Player, msg proto.Message) error {
+	req := msg.(*cproto.GameDissolveReq)
+	if !t.isOnTable(player.ack.Uid) {
+		return errors.New("player not on table")
+	}
+	if t.dissovle == nil {
+		if !req.Agree {
+			return errNoDissolve
+		}
+		if err := t.startDissolve(player); err != nil {
+			return err
+		}
+	}
+	t.voteDissolve(player.ack.Seat, req.Agree)
+	return nil
+}
+
+// startDissolve 发起解散投票
+func (t *Table) startDissolve(player *Player) error {
+	policy := t.GetDissolvePolicy()
+	// 局间curGameCount就是打完的局数，游戏中要去掉正在打的这一局
+	played := t.curGameCount
+	if t.playing {
+		played--
+	}
+	if policy.MaxRounds > 0 && played >= policy.MaxRounds {
+		return errDissolveRounds
+	}
+	if policy.MaxRequests > 0 && t.dis.requests >= policy.MaxRequests {
+		return errDissolveRequests
+	}
+	if !t.dis.last.IsZero() && t.Now().Before(t.dis.last.Add(policy.Cooldown)) {
+		return errDissolveCooldown
+	}
+
+	t.dis.requests++
+	t.dissovle = &cproto.GameDissolveAck{
+		Starttime: t.Now().Unix(),
+		Endtime:   t.Now().Add(policy.Timeout).Unix(),
+		Seat:      player.GetSeat(),
+		Agreed:    make(map[int32]bool),
+	}
+	// Endtime之后的一秒投票超时
+	t.dissolveTimer = t.AfterFunc(time.Unix(t.dissovle.Endtime+1, 0).Sub(t.Now()), t.checkDissolve)
+	for _, p := range t.players {
+		if !p.online {
+			t.offlineDissolve(p)
+		}
+	}
+	return nil
+}
+
+func (t *Table) voteDissolve(seat int32, agree bool) {
+	t.dissovle.Agreed[seat] = agree
+	t.broadcast(t.dissovle)
+	t.checkDissolve()
+}
+
+// offlineDissolve 投票中离线的玩家过一段时间自动同意，上线后取消
+func (t *Table) offlineDissolve(player *Player) {
+	seat := player.GetSeat()
+	t.dis.offline[seat].Stop()
+	delete(t.dis.offline, seat)
+	policy := t.GetDissolvePolicy()
+	if t.dissovle == nil || player.online || policy.OfflineAgree <= 0 {
+		return
+	}
+	if _, ok := t.dissovle.Agreed[seat]; ok {
+		return
+	}
+	if t.dis.offline == nil {
+		t.dis.offline = make(map[int32]*TableTimer)
+	}
+	t.dis.offline[seat] = t.AfterFunc(policy.OfflineAgree, func() {
+		delete(t.dis.offline, seat)
+		if t.dissovle == nil {
+			return
+		}
+		if _, ok := t.dissovle.Agreed[seat]; !ok {
+			t.voteDissolve(seat, true)
+		}
+	})
+}
+
+// dissolveResult 投票是否有结果，timeout为true时没投票的按策略计票
+func (t *Table) dissolveResult(policy DissolvePolicy, timeout bool) (decided, dissolve bool) {
+	agreed, refused := 0, 0
+	for seat, agree := range t.dissovle.Agreed {
+		if agree {
+			agreed++
+			continue
+		}
+		refused++
+		if policy.CreatorVeto && t.creator != "" {
+			if p := t.GetGamePlayer(seat); p != nil && p.ack.Uid == t.creator {
+				return true, false
+			}
+		}
+	}
+	total := int(t.playerCount)
+	if timeout {
+		if policy.TimeoutAgree {
+			agreed = total - refused
+		} else {
+			refused = total - agreed
+		}
+	}
+
+	switch policy.Rule {
+	case DissolveMajority:
+		if agreed*2 > total {
+			return true, true
+		}
+		// 剩下的人都同意也不过半
+		if (total-refused)*2 <= total {
+			return true, false
+		}
+	default:
+		if refused > 0 {
+			return true, false
+		}
+		if agreed >= total {
+			return true, true
+		}
+	}
+	return false, false
+}
+
+func (t *Table) checkDissolve() {
+	if t.dissovle == nil {
+		return
+	}
+	timeout := t.dissovle.Endtime < t.Now().Unix()
+	decided, dissolve := t.dissolveResult(t.GetDissolvePolicy(), timeout)
+	if !decided {
+		return
+	}
+	t.endDissolve()
+	if !dissolve {
+		t.broadcast(&cproto.GameDissolveResultAck{Dissovle: false})
+		return
+	}
+
+	for _, p := range t.players {
+		p.ack.Ready = false
+	}
+	ack := &cproto.GameDissolveResultAck{
+		Dissovle: true,
+	}
+	t.broadcast(ack)
+	t.gameOver()
+}
+
+// endDissolve 结束投票，记录冷却时间
+func (t *Table) endDissolve() {
+	t.dissovle = nil
+	t.dissolveTimer.Stop()
+	for _, timer := range t.dis.offline {
+		timer.Stop()
+	}
+	t.dis.offline = nil
+	t.dis.last = t.Now()
+}
+
+// resetDissolve 新的一局重新计算发起次数
+func (t *Table) resetDissolve() {
+	t.dis.requests = 0
+}
@@ -0,0 +1,187 @@
+package game_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/kevin-chtw/tw_common/apptest"
+	"github.com/kevin-chtw/tw_common/gamebase/game"
+	"github.com/kevin-chtw/tw_common/utils"
+	"github.com/kevin-chtw/tw_proto/cproto"
+	"github.com/kevin-chtw/tw_proto/sproto"
+)
+
+func TestParseDissolvePolicy(t *testing.T) {
+	if policy := game.ParseDissolvePolicy(nil); policy != game.DefaultDissolvePolicy {
+		t.Errorf("default policy = %+v", policy)
+	}
+	policy := game.ParseDissolvePolicy(map[string]int32{
+		game.FdDissolveRule:         int32(game.DissolveMajority),
+		game.FdDissolveTimeout:      60,
+		game.FdDissolveTimeoutAgree: 0,
+		game.FdDissolveOfflineAgree: 30,
+	})
+	want := game.DissolvePolicy{Rule: game.DissolveMajority, Timeout: time.Minute, OfflineAgree: 30 * time.Second}
+	if policy != want {
+		t.Errorf("policy = %+v, want %+v", policy, want)
+	}
+}
+
+func TestDissolvePolicies(t *testing.T) {
+	clock := utils.NewManualClock(time.Unix(1000, 0))
+	game.SetClock(clock)
+	t.Cleanup(func() { game.SetClock(utils.SystemClock) })
+	game.Init(apptest.NewApp("game"), func(table *game.Table, id int32) game.IGame {
+		return &countGame{table: table, id: id}
+	}, nil)
+
+	var tableID int32
+	// start 创建3人桌，第一个玩家是创建者
+	start := func(t *testing.T, fd map[string]int32) (*game.Table, []*game.Player) {
+		tableID++
+		uids := make([]string, 3)
+		for i := range uids {
+			uids[i] = fmt.Sprintf("d%d_%d", tableID, i)
+		}
+		req := &sproto.AddTableReq{MatchType: "normal", PlayerCount: 3, GameCount: 4, Creator: uids[0], Fdproperty: fd}
+		return startTableWith(t, tableID, req, uids...)
+	}
+	vote := func(table *game.Table, player *game.Player, agree bool) error {
+		return table.OnPlayerMsg(player, newGameReq(t, table.GetTableID(), &cproto.GameDissolveReq{Agree: agree}))
+	}
+	mustVote := func(t *testing.T, table *game.Table, player *game.Player, agree bool) {
+		t.Helper()
+		if err := vote(table, player, agree); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dissolved := func(table *game.Table) bool {
+		return game.GetTableManager().Get(1, table.GetTableID()) == nil
+	}
+	advance := func(table *game.Table, d time.Duration) {
+		clock.Advance(d)
+		table.Tick()
+		waitTable(table)
+	}
+
+	t.Run("unanimous", func(t *testing.T) {
+		table, players := start(t, nil)
+		mustVote(t, table, players[0], true)
+		mustVote(t, table, players[1], false)
+		if dissolved(table) {
+			t.Fatal("dissolved after refusal")
+		}
+		// 投票已经取消，重新发起
+		mustVote(t, table, players[0], true)
+		mustVote(t, table, players[1], true)
+		if dissolved(table) {
+			t.Fatal("dissolved before everyone agreed")
+		}
+		mustVote(t, table, players[2], true)
+		if !dissolved(table) {
+			t.Fatal("not dissolved")
+		}
+	})
+
+	t.Run("majority", func(t *testing.T) {
+		table, players := start(t, map[string]int32{game.FdDissolveRule: int32(game.DissolveMajority)})
+		mustVote(t, table, players[0], true)
+		mustVote(t, table, players[1], false)
+		if dissolved(table) {
+			t.Fatal("dissolved without majority")
+		}
+		mustVote(t, table, players[2], true)
+		if !dissolved(table) {
+			t.Fatal("majority not dissolved")
+		}
+	})
+
+	t.Run("creator veto", func(t *testing.T) {
+		fd := map[string]int32{game.FdDissolveRule: int32(game.DissolveMajority), game.FdDissolveCreatorVeto: 1}
+		table, players := start(t, fd)
+		mustVote(t, table, players[1], true)
+		mustVote(t, table, players[0], false)
+		// 投票已经取消，重新发起
+		mustVote(t, table, players[1], true)
+		mustVote(t, table, players[2], true)
+		if !dissolved(table) {
+			t.Fatal("new vote not dissolved")
+		}
+	})
+
+	t.Run("cooldown and max requests", func(t *testing.T) {
+		table, players := start(t, map[string]int32{game.FdDissolveCooldown: 60, game.FdDissolveMaxRequests: 2})
+		mustVote(t, table, players[0], true)
+		mustVote(t, table, players[1], false)
+		if err := vote(table, players[0], true); err == nil {
+			t.Fatal("request during cooldown")
+		}
+		advance(table, time.Minute)
+		mustVote(t, table, players[0], true)
+		mustVote(t, table, players[1], false)
+		advance(table, time.Minute)
+		if err := vote(table, players[0], true); err == nil {
+			t.Fatal("request over max requests")
+		}
+	})
+
+	t.Run("max rounds", func(t *testing.T) {
+		table, players := start(t, map[string]int32{game.FdDissolveMaxRounds: 1})
+		// 第一局还在打，没有超过局数
+		mustVote(t, table, players[0], true)
+		mustVote(t, table, players[1], false)
+		if err := table.OnPlayerMsg(players[0], newGameReq(t, table.GetTableID(), &cproto.TableMsgReq{Msg: []byte{2}})); err != nil {
+			t.Fatal(err)
+		}
+		// 局间已经打完了一局
+		if err := vote(table, players[0], true); err == nil {
+			t.Fatal("dissolve between rounds after max rounds")
+		}
+		advance(table, 5*time.Second)
+		if err := vote(table, players[0], true); err == nil {
+			t.Fatal("dissolve after max rounds")
+		}
+	})
+
+	t.Run("timeout refuse", func(t *testing.T) {
+		fd := map[string]int32{game.FdDissolveTimeout: 60, game.FdDissolveTimeoutAgree: 0}
+		table, players := start(t, fd)
+		mustVote(t, table, players[0], true)
+		advance(table, 62*time.Second)
+		if dissolved(table) {
+			t.Fatal("timeout should refuse")
+		}
+		// 上一次投票已经结束，可以重新发起
+		mustVote(t, table, players[0], true)
+		mustVote(t, table, players[1], true)
+		mustVote(t, table, players[2], true)
+		if !dissolved(table) {
+			t.Fatal("not dissolved")
+		}
+	})
+
+	t.Run("offline agree", func(t *testing.T) {
+		table, players := start(t, map[string]int32{game.FdDissolveOfflineAgree: 30})
+		setOnline := func(p *game.Player, online bool) {
+			if _, err := table.HandleNetState(context.Background(), &sproto.NetStateReq{Uid: p.GetUid(), Online: online}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		setOnline(players[1], false)
+		mustVote(t, table, players[0], true)
+		// 投票中掉线，回来后不再自动同意
+		setOnline(players[2], false)
+		advance(table, 20*time.Second)
+		setOnline(players[2], true)
+		advance(table, 10*time.Second)
+		if dissolved(table) {
+			t.Fatal("online player agreed automatically")
+		}
+		mustVote(t, table, players[2], true)
+		if !dissolved(table) {
+			t.Fatal("offline player not agreed")
+		}
+	})
+}
@@ -0,0 +1,158 @@
+package game
+
+import (
+	"errors"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/kevin-chtw/tw_common/utils"
+	"github.com/topfreegames/pitaya/v3/pkg/logger"
+)
+
+// DrainState 游戏服排空状态，滚动发布前先排空再停服
+type DrainState int
+
+const (
+	DrainNone     DrainState = iota // 正常接受新桌子
+	DrainDraining                   // 不接受新桌子，等已有桌子打完所有局
+	DrainDrained                    // 已经排空，可以停服
+)
+
+func (s DrainState) String() string {
+	switch s {
+	case DrainDraining:
+		return "draining"
+	case DrainDrained:
+		return "drained"
+	default:
+		return "serving"
+	}
+}
+
+// ErrDraining 排空中不接受新桌子，比赛服应该换一个游戏服重试
+var ErrDraining = errors.New("game server is draining")
+
+// drainState TableManager的排空状态，由TableManager.mu保护
+type drainState struct {
+	state    DrainState
+	done     chan struct{} // 排空完成时关闭
+	deadline time.Time
+	timer    *utils.WheelTimer
+}
+
+// AddTable 比赛服创建桌子，排空中只返回已有的桌子
+func (t *TableManager) AddTable(matchID, tableID int32) (*Table, error) {
+	t.mu.RLock()
+	table, draining := t.tables[getTableKey(matchID, tableID)], t.drain.state != DrainNone
+	t.mu.RUnlock()
+	if table != nil {
+		return table, nil
+	}
+	if draining {
+		return nil, ErrDraining
+	}
+	return t.LoadOrStore(matchID, tableID), nil
+}
+
+// Drain 开始排空，返回排空完成时关闭的channel。所有桌子结束时排空完成，
+// timeout大于0时到期后给剩下的桌子保存检查点并认为排空完成，桌子继续运行到停服。重复调用返回同一个channel
+func (t *TableManager) Drain(timeout time.Duration) <-chan struct{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.drain.done != nil {
+		return t.drain.done
+	}
+	t.drain.state = DrainDraining
+	t.drain.done = make(chan struct{})
+	if timeout > 0 {
+		t.drain.deadline = clock.Now().Add(timeout)
+		t.drain.timer = scheduler.AfterFunc(timeout, func() { go t.drainTimeout() })
+	}
+	logger.Log.Infof("game server draining, %d tables left, timeout %v", len(t.tables), timeout)
+	t.checkDrained()
+	return t.drain.done
+}
+
+// DrainInfo 排空状态，管理接口使用
+type DrainInfo struct {
+	State    string `json:"state"`
+	Tables   int    `json:"tables"`             // 剩余桌子数
+	Deadline int64  `json:"deadline,omitempty"` // unix毫秒，0表示没有截止时间
+}
+
+// DrainState 获取排空状态
+func (t *TableManager) DrainState() DrainState {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.drain.state
+}
+
+// DrainInfo 获取排空状态和剩余桌子数
+func (t *TableManager) DrainInfo() *DrainInfo {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	info := &DrainInfo{State: t.drain.state.String(), Tables: len(t.tables)}
+	if !t.drain.deadline.IsZero() {
+		info.Deadline = t.drain.deadline.UnixMilli()
+	}
+	return info
+}
+
+// Len 桌子数
+func (t *TableManager) Len() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return len(t.tables)
+}
+
+// checkDrained 需要持有t.mu，排空中没有桌子时完成排空
+func (t *TableManager) checkDrained() {
+	if t.drain.state == DrainDraining && len(t.tables) == 0 {
+		t.drained()
+	}
+}
+
+// drained 需要持有t.mu
+func (t *TableManager) drained() {
+	t.drain.state = DrainDrained
+	if t.drain.timer != nil {
+		scheduler.Stop(t.drain.timer)
+	}
+	close(t.drain.done)
+	logger.Log.Infof("game server drained, %d tables left", len(t.tables))
+}
+
+// drainTimeout 排空超时，保存剩下的桌子，停服后可以从检查点恢复
+func (t *TableManager) drainTimeout() {
+	for _, table := range t.Tables(0) {
+		if err := table.callErr(func() error {
+			table.markDirty()
+			table.saveCheckpoint()
+			return nil
+		}); err != nil {
+			logger.Log.Errorf("table %d checkpoint before stop failed: %v", table.tableID, err)
+		}
+	}
+	FlushCheckpoints()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.drain.state == DrainDraining {
+		t.drained()
+	}
+}
+
+// DrainOnSignal 收到sig时开始排空，排空完成后调用onDrained，一般在里面停服
+func DrainOnSignal(timeout time.Duration, onDrained func(), sig ...os.Signal) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, sig...)
+	go func() {
+		s := <-ch
+		signal.Stop(ch)
+		logger.Log.Infof("received %v, start draining", s)
+		<-tableManager.Drain(timeout)
+		if onDrained != nil {
+			onDrained()
+		}
+	}()
+}
@@ -0,0 +1,76 @@
+package game_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kevin-chtw/tw_common/apptest"
+	"github.com/kevin-chtw/tw_common/gamebase/game"
+	"github.com/kevin-chtw/tw_common/storage"
+	"github.com/kevin-chtw/tw_common/utils"
+	"github.com/kevin-chtw/tw_proto/cproto"
+	"github.com/kevin-chtw/tw_proto/sproto"
+)
+
+func TestDrain(t *testing.T) {
+	clock := utils.NewManualClock(time.Unix(1000, 0))
+	game.SetClock(clock)
+	store, err := storage.NewFileTableStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	game.SetTableStore(store)
+	t.Cleanup(func() {
+		game.SetClock(utils.SystemClock)
+		game.SetTableStore(nil)
+	})
+	game.Init(apptest.NewApp("game"), func(table *game.Table, id int32) game.IGame {
+		return &countGame{table: table, id: id}
+	}, nil)
+
+	req := &sproto.AddTableReq{MatchType: "normal", PlayerCount: 2, GameCount: 1}
+	table1, players := startTableWith(t, 1, req, "d0", "d1")
+	table2, _ := startTableWith(t, 2, req, "d2", "d3")
+
+	tm := game.GetTableManager()
+	done := tm.Drain(time.Minute)
+	if _, err := tm.AddTable(1, 3); !errors.Is(err, game.ErrDraining) {
+		t.Fatalf("add table while draining: %v", err)
+	}
+	if table, err := tm.AddTable(1, 2); err != nil || table != table2 {
+		t.Fatalf("existing table = %v, %v", table, err)
+	}
+
+	// 已有的桌子打完后删除
+	if err := players[0].HandleMessage(t.Context(), newGameReq(t, 1, &cproto.TableMsgReq{Msg: []byte{2}})); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Second)
+	table1.Tick()
+	waitTable(table1)
+	if tm.Get(1, 1) != nil {
+		t.Fatal("finished table not deleted")
+	}
+	if info := tm.DrainInfo(); info.State != "draining" || info.Tables != 1 {
+		t.Fatalf("info = %+v", info)
+	}
+
+	// 到期后保存剩下的桌子
+	clock.Advance(time.Minute)
+	table2.Tick()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("not drained after deadline")
+	}
+	if tm.DrainState() != game.DrainDrained || tm.Get(1, 2) == nil {
+		t.Errorf("state = %v", tm.DrainState())
+	}
+	if saved, _ := store.LoadAll(); saved["1:2"] == nil {
+		t.Error("table not checkpointed before stop")
+	}
+	if tm.Drain(0) != done {
+		t.Error("drain again returned a new channel")
+	}
+}
@@ -0,0 +1,91 @@
+package game
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/kevin-chtw/tw_proto/cproto"
+	"github.com/topfreegames/pitaya/v3/pkg/logger"
+)
+
+const maxIncidents = 100 // 最多保留的事故记录
+
+// Incident 桌子或bot回调panic的事故记录
+type Incident struct {
+	Time         time.Time `json:"time"`
+	MatchID      int32     `json:"matchid"`
+	TableID      int32     `json:"tableid"`
+	CurGameCount int32     `json:"cur_game_count,omitempty"`
+	BotID        string    `json:"bot_id,omitempty"` // bot回调panic时的bot
+	Players      []string  `json:"players,omitempty"`
+	Panic        string    `json:"panic"`
+	Stack        string    `json:"stack"`
+}
+
+var (
+	incidents     []*Incident
+	incidentMutex sync.Mutex
+)
+
+func newIncident(matchID, tableID int32, r any, stack []byte) *Incident {
+	return &Incident{
+		Time:    clock.Now(),
+		MatchID: matchID,
+		TableID: tableID,
+		Panic:   fmt.Sprint(r),
+		Stack:   string(stack),
+	}
+}
+
+// recordIncident 记录事故并输出结构化日志
+func recordIncident(incident *Incident) {
+	logger.Log.WithFields(map[string]any{
+		"matchid":        incident.MatchID,
+		"tableid":        incident.TableID,
+		"cur_game_count": incident.CurGameCount,
+		"bot_id":         incident.BotID,
+		"players":        incident.Players,
+	}).Errorf("panic recovered %s\n %s", incident.Panic, incident.Stack)
+
+	incidentMutex.Lock()
+	defer incidentMutex.Unlock()
+	incidents = append(incidents, incident)
+	if len(incidents) > maxIncidents {
+		incidents = incidents[len(incidents)-maxIncidents:]
+	}
+}
+
+// GetIncidents 获取最近的事故记录
+func GetIncidents() []*Incident {
+	incidentMutex.Lock()
+	defer incidentMutex.Unlock()
+	result := make([]*Incident, len(incidents))
+	copy(result, incidents)
+	return result
+}
+
+// quarantine 隔离panic的桌子，通知玩家解散，通知比赛服结束，然后停止事件循环
+func (t *Table) quarantine(r any, stack []byte) {
+	if t.quarantined {
+		return
+	}
+	t.quarantined = true
+	incident := newIncident(t.MatchID, t.tableID, r, stack)
+	incident.CurGameCount = t.curGameCount
+	for uid := range t.players {
+		incident.Players = append(incident.Players, uid)
+	}
+	recordIncident(incident)
+
+	defer func() {
+		// 桌子状态可能已经损坏，清理失败也要移除桌子
+		if r := recover(); r != nil {
+			logger.Log.Errorf("table %d quarantine failed %s\n %s", t.tableID, r, string(debug.Stack()))
+			tableManager.Delete(t.MatchID, t.tableID)
+		}
+	}()
+	t.broadcast(&cproto.GameDissolveResultAck{Dissovle: true})
+	t.gameOver()
+}
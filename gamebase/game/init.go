@@ -1,6 +1,7 @@
 package game
 
 import (
+	"github.com/kevin-chtw/tw_common/utils"
 	pitaya "github.com/topfreegames/pitaya/v3/pkg"
 )
 
@@ -14,18 +15,40 @@ var (
 	playerManager *PlayerManager
 	tableManager  *TableManager
 	botManager    *BotManager
+	outbox        *Outbox
+	scheduler     *utils.TimingWheel // 所有桌子共用的定时器调度
+	schedulerStop chan struct{}
+	clock         = utils.SystemClock
 )
 
 type GameCreator func(*Table, int32) IGame
 type BotCreator func(uid string, matchid, tableid int32, scorebase int64) *BotPlayer
 
-// Init 初始化游戏模块
+// Init 初始化游戏模块，重复调用时停止上一次创建的调度和BotManager
 func Init(app pitaya.Pitaya, gc GameCreator, bc BotCreator) {
 	gameCreator = gc
 	botCreator = bc
+	if schedulerStop != nil {
+		close(schedulerStop)
+	}
+	schedulerStop = make(chan struct{})
+	scheduler = utils.NewTimingWheel(clock, schedulerTick)
+	go scheduler.Run(schedulerStop)
 	playerManager = NewPlayerManager()
 	tableManager = NewTableManager(app)
+	if botManager != nil {
+		botManager.Stop()
+	}
 	botManager = NewBotManager()
+	if outbox != nil {
+		outbox.Close()
+	}
+	outbox = NewOutbox(app, outboxStore)
+}
+
+// SetClock 设置桌子、定时器使用的时钟，需要在Init之前调用
+func SetClock(c utils.Clock) {
+	clock = c
 }
 
 // GetPlayerManager 获取玩家管理器实例
@@ -37,3 +60,13 @@ func GetPlayerManager() *PlayerManager {
 func GetTableManager() *TableManager {
 	return tableManager
 }
+
+// GetBotManager 获取bot管理器实例
+func GetBotManager() *BotManager {
+	return botManager
+}
+
+// GetOutbox 获取发给比赛服的发件箱
+func GetOutbox() *Outbox {
+	return outbox
+}
@@ -0,0 +1,125 @@
+package game
+
+import (
+	"errors"
+	"runtime/debug"
+
+	"google.golang.org/protobuf/proto"
+)
+
+const tableMailboxSize = 256 // 桌子事件队列长度
+
+var (
+	errTableClosed = errors.New("table closed")
+	errTablePanic  = errors.New("table panic")
+)
+
+// start 启动桌子的事件循环，之后桌子的所有状态只在这个goroutine里修改
+func (t *Table) start() {
+	t.mailbox = make(chan func(), tableMailboxSize)
+	t.done = make(chan struct{})
+	t.exited = make(chan struct{})
+	go t.run()
+}
+
+// stop 停止事件循环，可以在事件循环内调用
+func (t *Table) stop() {
+	if t.done != nil {
+		t.stopOnce.Do(func() { close(t.done) })
+	}
+}
+
+func (t *Table) run() {
+	defer close(t.exited)
+	for {
+		select {
+		case fn := <-t.mailbox:
+			t.execute(fn)
+		case <-t.done:
+			return
+		}
+		select {
+		case <-t.done:
+			return
+		default:
+		}
+	}
+}
+
+// execute 执行事件，panic时隔离桌子，不影响其他桌子
+func (t *Table) execute(fn func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			t.quarantine(r, debug.Stack())
+		}
+	}()
+	fn()
+}
+
+// post 把事件放入队列，队列满时等待；没有启动事件循环的本地桌子直接执行
+func (t *Table) post(fn func()) bool {
+	if t.mailbox == nil {
+		fn()
+		return true
+	}
+	select {
+	case t.mailbox <- fn:
+		return true
+	case <-t.done:
+		return false
+	}
+}
+
+// tryPost 队列满时直接丢弃，用于定时器这类可以跳过的事件
+func (t *Table) tryPost(fn func()) bool {
+	if t.mailbox == nil {
+		fn()
+		return true
+	}
+	select {
+	case t.mailbox <- fn:
+		return true
+	default:
+		return false
+	}
+}
+
+// call 在事件循环中执行并等待结果，不能在事件循环内调用
+func (t *Table) call(fn func() (proto.Message, error)) (proto.Message, error) {
+	if t.mailbox == nil {
+		return fn()
+	}
+	type result struct {
+		rsp proto.Message
+		err error
+	}
+	ch := make(chan result, 1)
+	if !t.post(func() {
+		r := result{err: errTablePanic}
+		defer func() { ch <- r }()
+		// 桌子隔离完成后再返回
+		t.execute(func() { r.rsp, r.err = fn() })
+	}) {
+		return nil, errTableClosed
+	}
+	select {
+	case r := <-ch:
+		return r.rsp, r.err
+	case <-t.exited:
+		// 事件循环退出前执行完的事件仍然返回结果
+		select {
+		case r := <-ch:
+			return r.rsp, r.err
+		default:
+			return nil, errTableClosed
+		}
+	}
+}
+
+// callErr 同call，只返回错误
+func (t *Table) callErr(fn func() error) error {
+	_, err := t.call(func() (proto.Message, error) {
+		return nil, fn()
+	})
+	return err
+}
@@ -0,0 +1,266 @@
+package game
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/kevin-chtw/tw_common/utils"
+	"github.com/kevin-chtw/tw_proto/cproto"
+	"github.com/topfreegames/pitaya/v3/pkg/logger"
+	"google.golang.org/protobuf/proto"
+)
+
+// ObserverVisibility 观战者能看到的信息
+type ObserverVisibility int
+
+const (
+	ObserverPublic ObserverVisibility = iota // 只看公开信息，手牌和别人摸的牌被隐藏
+	ObserverFull                             // 能看所有人的手牌，用于教练、复盘
+)
+
+// ObserverPolicy 观战策略
+type ObserverPolicy struct {
+	Visibility   ObserverVisibility
+	Delay        time.Duration // 延迟发给观战者，防止场外报牌
+	MaxObservers int           // 最多观战人数，0不限制，小于0不允许观战
+}
+
+// DefaultObserverPolicy 新桌子默认的观战策略
+var DefaultObserverPolicy = ObserverPolicy{}
+
+// IObserverSnapshot 游戏可选实现，观战者中途进入时发送的快照，内容按visibility决定
+type IObserverSnapshot interface {
+	ObserverSnapshot(visibility ObserverVisibility) []proto.Message
+}
+
+// Observer 观战者，不占座位，不能操作
+type Observer struct {
+	Uid  string
+	Ctx  context.Context
+	next uint64 // 下一条要发送的消息序号
+}
+
+// observedMsg 发给观战者的消息，game为true时是游戏消息
+type observedMsg struct {
+	seq  uint64
+	at   time.Time
+	ack  proto.Message
+	game bool
+	uid  string // 不为空时只发给这个观战者，用于进入时的快照
+}
+
+// observers 桌子的观战状态，只在事件循环里访问
+type observers struct {
+	policy  ObserverPolicy
+	members map[string]*Observer
+	log     []*observedMsg // 还没发给所有观战者的消息，没有观战者时不记录
+	seq     uint64         // 最后一条消息的序号
+	timer   *TableTimer    // 延迟消息的发送定时器
+}
+
+var (
+	errObserverFull     = errors.New("too many observers")
+	errObserverIsPlayer = errors.New("player cannot observe own table")
+)
+
+// SetObserverPolicy 设置观战策略，在事件循环内调用，一般在创建游戏时设置
+func (t *Table) SetObserverPolicy(policy ObserverPolicy) {
+	t.obs.policy = policy
+}
+
+// GetObserverPolicy 获取观战策略，游戏按Visibility决定观战者看到的消息
+func (t *Table) GetObserverPolicy() ObserverPolicy {
+	return t.obs.policy
+}
+
+// AddObserver 观战者进入，收到桌子信息、玩家信息，游戏中时延迟收到进入时的快照
+func (t *Table) AddObserver(ctx context.Context, uid string) error {
+	return t.callErr(func() error {
+		return t.addObserver(ctx, uid)
+	})
+}
+
+// RemoveObserver 观战者离开
+func (t *Table) RemoveObserver(uid string) error {
+	return t.callErr(func() error {
+		delete(t.obs.members, uid)
+		t.trimObserved()
+		return nil
+	})
+}
+
+// GetObservers 获取观战者uid，需要在事件循环内调用
+func (t *Table) GetObservers() []string {
+	uids := make([]string, 0, len(t.obs.members))
+	for uid := range t.obs.members {
+		uids = append(uids, uid)
+	}
+	return uids
+}
+
+func (t *Table) addObserver(ctx context.Context, uid string) error {
+	if _, ok := t.players[uid]; ok {
+		return errObserverIsPlayer
+	}
+	limit := t.obs.policy.MaxObservers
+	if _, ok := t.obs.members[uid]; !ok && (limit < 0 || limit > 0 && len(t.obs.members) >= limit) {
+		return errObserverFull
+	}
+	if t.obs.members == nil {
+		t.obs.members = make(map[string]*Observer)
+	}
+	ob := &Observer{Uid: uid, Ctx: ctx, next: t.obs.seq + 1}
+	t.obs.members[uid] = ob
+
+	t.pushObserver(ob, t.newMsg(t.enterGameAck()))
+	for _, p := range t.players {
+		t.pushObserver(ob, t.newMsg(p.ack))
+	}
+	// 快照和之后的消息一样延迟发送
+	if s, ok := t.game.(IObserverSnapshot); ok && t.playing {
+		if msgs := s.ObserverSnapshot(t.obs.policy.Visibility); len(msgs) > 0 {
+			t.appendObserved(&cproto.HisBeginAck{}, false, uid)
+			for _, msg := range msgs {
+				t.appendObserved(msg, true, uid)
+			}
+			t.appendObserved(&cproto.HisEndAck{}, false, uid)
+		}
+	}
+	t.sendObserved()
+	return nil
+}
+
+// Send2Observers 把游戏消息发给观战者，观战者看到的内容由游戏决定
+func (t *Table) Send2Observers(ack proto.Message) {
+	t.observe(ack, true)
+}
+
+// observe 有观战者时记录消息并按策略发送
+func (t *Table) observe(ack proto.Message, game bool) {
+	if len(t.obs.members) == 0 {
+		return
+	}
+	t.appendObserved(ack, game, "")
+	t.sendObserved()
+}
+
+func (t *Table) appendObserved(ack proto.Message, game bool, uid string) {
+	t.obs.seq++
+	t.obs.log = append(t.obs.log, &observedMsg{
+		seq:  t.obs.seq,
+		at:   t.Now(),
+		ack:  proto.Clone(ack), // 发送方可能继续修改消息
+		game: game,
+		uid:  uid,
+	})
+}
+
+// sendObserved 没有延迟时立即发送，否则等到时间再发
+func (t *Table) sendObserved() {
+	if t.obs.policy.Delay <= 0 {
+		t.flushObservers()
+		return
+	}
+	t.scheduleObservers()
+}
+
+// flushObservers 给每个观战者发送已经到时间的消息
+func (t *Table) flushObservers() {
+	now := t.Now()
+	for _, ob := range t.obs.members {
+		t.flushObserver(ob, now)
+	}
+	t.trimObserved()
+	t.scheduleObservers()
+}
+
+func (t *Table) flushObserver(ob *Observer, now time.Time) {
+	for _, m := range t.obs.log {
+		if m.seq < ob.next {
+			continue
+		}
+		if m.at.Add(t.obs.policy.Delay).After(now) {
+			break
+		}
+		ob.next = m.seq + 1
+		if m.uid == "" || m.uid == ob.Uid {
+			t.pushObserved(ob, m)
+		}
+	}
+}
+
+// scheduleObservers 还有没到时间的消息时，在最早的一条到时间时发送
+func (t *Table) scheduleObservers() {
+	if t.obs.timer != nil && !t.obs.timer.stopped {
+		return
+	}
+	for _, m := range t.obs.log {
+		if t.observersPending(m) {
+			delay := m.at.Add(t.obs.policy.Delay).Sub(t.Now())
+			t.obs.timer = t.AfterFunc(delay, t.flushObservers)
+			return
+		}
+	}
+}
+
+// observersPending 是否有观战者还没收到m
+func (t *Table) observersPending(m *observedMsg) bool {
+	for _, ob := range t.obs.members {
+		if ob.next <= m.seq && (m.uid == "" || m.uid == ob.Uid) {
+			return true
+		}
+	}
+	return false
+}
+
+// trimObserved 去掉已经发给所有观战者的消息
+func (t *Table) trimObserved() {
+	keep := t.obs.seq + 1
+	for _, ob := range t.obs.members {
+		keep = min(keep, ob.next)
+	}
+	i := 0
+	for i < len(t.obs.log) && t.obs.log[i].seq < keep {
+		i++
+	}
+	t.obs.log = t.obs.log[i:]
+}
+
+// closeObservers 桌子结束，不再延迟，把剩下的消息发完
+func (t *Table) closeObservers() {
+	t.obs.timer.Stop()
+	end := t.Now().Add(t.obs.policy.Delay)
+	for _, ob := range t.obs.members {
+		t.flushObserver(ob, end)
+	}
+	t.obs.members = nil
+	t.obs.log = nil
+}
+
+func (t *Table) pushObserved(ob *Observer, m *observedMsg) {
+	if !m.game {
+		t.pushObserver(ob, t.newMsg(m.ack))
+		return
+	}
+	msg, err := t.encodeTableMsg(m.ack, utils.IsWebsocket(ob.Ctx))
+	if err != nil {
+		logger.Log.Error(err.Error())
+		return
+	}
+	t.pushObserver(ob, msg)
+}
+
+func (t *Table) pushObserver(ob *Observer, msg *cproto.GameAck) {
+	if t.App == nil {
+		return
+	}
+	data, err := utils.Marshal(ob.Ctx, msg)
+	if err != nil {
+		logger.Log.Error(err.Error())
+		return
+	}
+	if _, err := t.App.SendPushToUsers(t.App.GetServer().Type, data, []string{ob.Uid}, "proxy"); err != nil {
+		logger.Log.Errorf("observer %v failed: %v", ob.Uid, err)
+	}
+}
@@ -0,0 +1,123 @@
+package game_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kevin-chtw/tw_common/apptest"
+	"github.com/kevin-chtw/tw_common/gamebase/game"
+	"github.com/kevin-chtw/tw_common/utils"
+	"github.com/kevin-chtw/tw_proto/cproto"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// echoGame 把玩家消息的第一个字节广播给所有人，观战快照是最后一次广播的值
+type echoGame struct {
+	countGame
+	last int32
+}
+
+func (g *echoGame) OnPlayerMsg(player *game.Player, data []byte) error {
+	g.last = int32(data[0])
+	g.table.Send2Player(wrapperspb.Int32(g.last), game.SeatAll)
+	return nil
+}
+
+func (g *echoGame) ObserverSnapshot(visibility game.ObserverVisibility) []proto.Message {
+	return []proto.Message{wrapperspb.Int32(g.last)}
+}
+
+// observedValues 解出推送给uid的广播内容
+func observedValues(t *testing.T, app *apptest.App, uid string) []int32 {
+	values := make([]int32, 0)
+	for _, push := range app.Pushes(uid) {
+		ack := &cproto.GameAck{}
+		if err := proto.Unmarshal(push.Data, ack); err != nil {
+			t.Fatal(err)
+		}
+		msg, err := ack.Ack.UnmarshalNew()
+		if err != nil {
+			t.Fatal(err)
+		}
+		tableMsg, ok := msg.(*cproto.TableMsgAck)
+		if !ok {
+			continue
+		}
+		value := &wrapperspb.Int32Value{}
+		if err := proto.Unmarshal(tableMsg.Msg, value); err != nil {
+			t.Fatal(err)
+		}
+		values = append(values, value.Value)
+	}
+	return values
+}
+
+func TestTableObserver(t *testing.T) {
+	clock := utils.NewManualClock(time.Unix(1000, 0))
+	game.SetClock(clock)
+	t.Cleanup(func() { game.SetClock(utils.SystemClock) })
+	app := apptest.NewApp("game")
+	game.Init(app, func(table *game.Table, id int32) game.IGame {
+		table.SetObserverPolicy(game.ObserverPolicy{Delay: 10 * time.Second, MaxObservers: 1})
+		return &echoGame{countGame: countGame{table: table, id: id}}
+	}, nil)
+
+	table, players := startTestTable(t, 1, "p0", "p1")
+	send := func(b byte) {
+		if err := table.OnPlayerMsg(players[0], newGameReq(t, 1, &cproto.TableMsgReq{Msg: []byte{b}})); err != nil {
+			t.Fatal(err)
+		}
+	}
+	send(1)
+	clock.Advance(10 * time.Second)
+	send(2)
+
+	ctx := context.Background()
+	if err := table.AddObserver(ctx, "p0"); err == nil {
+		t.Error("player should not observe own table")
+	}
+	if err := table.AddObserver(ctx, "o1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := table.AddObserver(ctx, "o2"); err == nil {
+		t.Error("observer limit not applied")
+	}
+
+	// 进入前的消息没有记录，进入时的快照和之后的消息一样延迟发送
+	if got := observedValues(t, app, "o1"); len(got) != 0 {
+		t.Fatalf("history = %v", got)
+	}
+	clock.Advance(5 * time.Second)
+	send(3)
+	clock.Advance(4 * time.Second)
+	table.Tick()
+	waitTable(table)
+	if got := observedValues(t, app, "o1"); len(got) != 0 {
+		t.Fatalf("delayed message sent early: %v", got)
+	}
+	clock.Advance(time.Second)
+	table.Tick()
+	waitTable(table)
+	if got := observedValues(t, app, "o1"); len(got) != 1 || got[0] != 2 {
+		t.Fatalf("observed = %v", got)
+	}
+	clock.Advance(5 * time.Second)
+	table.Tick()
+	waitTable(table)
+	if got := observedValues(t, app, "o1"); len(got) != 2 || got[1] != 3 {
+		t.Fatalf("observed = %v", got)
+	}
+
+	if err := table.RemoveObserver("o1"); err != nil {
+		t.Fatal(err)
+	}
+	send(4)
+	clock.Advance(10 * time.Second)
+	table.Tick()
+	waitTable(table)
+	if got := observedValues(t, app, "o1"); len(got) != 2 {
+		t.Fatalf("removed observer still receives: %v", got)
+	}
+}
@@ -0,0 +1,298 @@
+package game
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kevin-chtw/tw_common/storage"
+	"github.com/kevin-chtw/tw_common/utils"
+	"github.com/kevin-chtw/tw_proto/sproto"
+	pitaya "github.com/topfreegames/pitaya/v3/pkg"
+	"github.com/topfreegames/pitaya/v3/pkg/logger"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// OutboxConfig 发件箱重试配置
+type OutboxConfig struct {
+	MinBackoff time.Duration // 第一次失败后的重试间隔，之后每次翻倍
+	MaxBackoff time.Duration
+}
+
+// DefaultOutboxConfig 默认配置
+var DefaultOutboxConfig = OutboxConfig{
+	MinBackoff: time.Second,
+	MaxBackoff: time.Minute,
+}
+
+var (
+	outboxConfig = DefaultOutboxConfig
+	outboxStore  storage.TableStore
+)
+
+// SetOutboxConfig 设置发件箱配置，需要在Init之前调用
+func SetOutboxConfig(conf OutboxConfig) {
+	outboxConfig = conf
+}
+
+// SetOutboxStore 设置发件箱存储，需要在Init之前调用，不能和桌子检查点共用目录或前缀。
+// 为nil时只在内存中重试，进程退出会丢失
+func SetOutboxStore(store storage.TableStore) {
+	outboxStore = store
+}
+
+// OutboxStats 发件箱运行指标
+type OutboxStats struct {
+	Pending int           // 等待发送的消息数
+	Oldest  time.Duration // 最早一条消息已经等待的时间
+	Sent    uint64        // 发送成功的消息数
+	Retries uint64        // 发送失败等待重试的次数
+}
+
+// Outbox 发给比赛服的对局结果先保存再发送，失败后退避重试直到成功。
+// 保存由检查点的后台写入完成，不阻塞桌子。同一张桌子的消息按顺序发送，比赛服按(tableid, curGameCount)去重
+type Outbox struct {
+	app     pitaya.Pitaya
+	store   storage.TableStore
+	clock   utils.Clock
+	conf    OutboxConfig
+	mu      sync.Mutex
+	entries []*outboxEntry
+	last    time.Time  // 最后一条消息的创建时间
+	sending sync.Mutex // 保证同时只有一个Flush
+	notify  chan struct{}
+	done    chan struct{}
+	ticker  utils.Ticker
+
+	sent    atomic.Uint64
+	retries atomic.Uint64
+}
+
+// outboxEntry 保存在存储中的消息
+type outboxEntry struct {
+	Key       string    `json:"key"`
+	MatchType string    `json:"match_type"`
+	ServerID  string    `json:"server_id"`
+	MatchID   int32     `json:"matchid"`
+	TableID   int32     `json:"tableid"`
+	Req       []byte    `json:"req"`     // sproto.MatchReq
+	Created   time.Time `json:"created"` // 严格递增，恢复时按它排序
+	stored    string    // 存储中的key，Key相同的新消息用新的key保存，删除时不会删掉新消息
+	attempts  int
+	next      time.Time   // 下次重试的时间
+	saved     atomic.Bool // 写入存储后才发送，避免发送成功后迟到的保存把消息写回去
+}
+
+// outboxInterval 检查重试的间隔
+const outboxInterval = 100 * time.Millisecond
+
+// NewOutbox 创建发件箱并加载store中还没发送成功的消息
+func NewOutbox(app pitaya.Pitaya, store storage.TableStore) *Outbox {
+	o := &Outbox{
+		app:    app,
+		store:  store,
+		clock:  clock,
+		conf:   outboxConfig,
+		notify: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+		ticker: clock.NewTicker(outboxInterval),
+	}
+	o.load()
+	go o.run()
+	return o
+}
+
+func (o *Outbox) load() {
+	if o.store == nil {
+		return
+	}
+	datas, err := o.store.LoadAll()
+	if err != nil {
+		logger.Log.Errorf("load outbox failed: %v", err)
+		return
+	}
+	for key, data := range datas {
+		entry := &outboxEntry{stored: key}
+		if err := json.Unmarshal(data, entry); err != nil {
+			logger.Log.Errorf("invalid outbox entry %s: %v", key, err)
+			continue
+		}
+		entry.saved.Store(true)
+		o.entries = append(o.entries, entry)
+		if entry.Created.After(o.last) {
+			o.last = entry.Created
+		}
+	}
+	// 按创建时间恢复顺序，保存新消息后没来得及删除的旧消息在这里去掉
+	slices.SortStableFunc(o.entries, func(a, b *outboxEntry) int { return a.Created.Compare(b.Created) })
+	latest := make(map[string]*outboxEntry, len(o.entries))
+	for _, entry := range o.entries {
+		latest[entry.Key] = entry
+	}
+	o.entries = slices.DeleteFunc(o.entries, func(e *outboxEntry) bool {
+		if latest[e.Key] == e {
+			return false
+		}
+		o.deleteStored(e)
+		return true
+	})
+}
+
+func (o *Outbox) run() {
+	for {
+		select {
+		case <-o.ticker.C():
+		case <-o.notify:
+		case <-o.done:
+			o.ticker.Stop()
+			return
+		}
+		o.Flush()
+	}
+}
+
+// Close 停止后台发送，没有发送的消息留在存储中
+func (o *Outbox) Close() {
+	close(o.done)
+}
+
+// Post 保存消息并尽快发送，同一张桌子上id和消息类型都相同的只保留最新的一条，
+// 对局结果的id是局数
+func (o *Outbox) Post(matchType, serverID string, matchID, tableID int32, id string, msg proto.Message) error {
+	data, err := anypb.New(msg)
+	if err != nil {
+		return err
+	}
+	req, err := proto.Marshal(&sproto.MatchReq{Matchid: matchID, Req: data})
+	if err != nil {
+		return err
+	}
+	entry := &outboxEntry{
+		Key:       fmt.Sprintf("%d:%d:%s:%s", matchID, tableID, id, msg.ProtoReflect().Descriptor().Name()),
+		MatchType: matchType,
+		ServerID:  serverID,
+		MatchID:   matchID,
+		TableID:   tableID,
+		Req:       req,
+	}
+	o.mu.Lock()
+	entry.Created = o.clock.Now()
+	if !entry.Created.After(o.last) {
+		entry.Created = o.last.Add(time.Nanosecond)
+	}
+	o.last = entry.Created
+	o.mu.Unlock()
+	entry.stored = fmt.Sprintf("%s:%d", entry.Key, entry.Created.UnixNano())
+	if o.store != nil {
+		b, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		// 保存失败也放入内存重试
+		checkpoints.enqueue(checkpointKey{o.store, entry.stored}, &checkpointOp{data: b, done: func() {
+			entry.saved.Store(true)
+			o.wake()
+		}})
+	} else {
+		entry.saved.Store(true)
+	}
+
+	var replaced []*outboxEntry
+	o.mu.Lock()
+	o.entries = slices.DeleteFunc(o.entries, func(e *outboxEntry) bool {
+		if e.Key != entry.Key {
+			return false
+		}
+		replaced = append(replaced, e)
+		return true
+	})
+	o.entries = append(o.entries, entry)
+	o.mu.Unlock()
+	for _, e := range replaced {
+		o.deleteStored(e)
+	}
+	o.wake()
+	return nil
+}
+
+func (o *Outbox) wake() {
+	select {
+	case o.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Flush 发送所有到期的消息，一张桌子的消息发送失败时，这张桌子后面的消息等下次再发
+func (o *Outbox) Flush() {
+	o.sending.Lock()
+	defer o.sending.Unlock()
+
+	o.mu.Lock()
+	entries := slices.Clone(o.entries)
+	o.mu.Unlock()
+
+	now := o.clock.Now()
+	blocked := make(map[[2]int32]bool)
+	for _, entry := range entries {
+		table := [2]int32{entry.MatchID, entry.TableID}
+		if blocked[table] || !entry.saved.Load() || now.Before(entry.next) {
+			blocked[table] = true
+			continue
+		}
+		if err := o.send(entry); err != nil {
+			entry.attempts++
+			backoff := o.conf.MinBackoff << min(entry.attempts-1, 30)
+			entry.next = now.Add(min(backoff, o.conf.MaxBackoff))
+			o.retries.Add(1)
+			logger.Log.Warnf("outbox %s attempt %d failed: %v", entry.Key, entry.attempts, err)
+			blocked[table] = true
+			continue
+		}
+		o.sent.Add(1)
+		o.remove(entry)
+	}
+}
+
+func (o *Outbox) send(entry *outboxEntry) error {
+	req := &sproto.MatchReq{}
+	if err := proto.Unmarshal(entry.Req, req); err != nil {
+		return err
+	}
+	ack := &sproto.MatchAck{}
+	return o.app.RPCTo(context.Background(), entry.ServerID, entry.MatchType+".remote.message", ack, req)
+}
+
+func (o *Outbox) remove(entry *outboxEntry) {
+	o.mu.Lock()
+	o.entries = slices.DeleteFunc(o.entries, func(e *outboxEntry) bool { return e == entry })
+	o.mu.Unlock()
+	o.deleteStored(entry)
+}
+
+// deleteStored 删除entry自己的存储记录，同一个Key后来保存的消息不受影响
+func (o *Outbox) deleteStored(entry *outboxEntry) {
+	if o.store == nil {
+		return
+	}
+	checkpoints.remove(o.store, entry.stored)
+}
+
+// Stats 获取运行指标
+func (o *Outbox) Stats() OutboxStats {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	stats := OutboxStats{
+		Pending: len(o.entries),
+		Sent:    o.sent.Load(),
+		Retries: o.retries.Load(),
+	}
+	if len(o.entries) > 0 {
+		stats.Oldest = o.clock.Now().Sub(o.entries[0].Created)
+	}
+	return stats
+}
@@ -0,0 +1,186 @@
+package game_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kevin-chtw/tw_common/apptest"
+	"github.com/kevin-chtw/tw_common/gamebase/game"
+	"github.com/kevin-chtw/tw_common/storage"
+	"github.com/kevin-chtw/tw_common/utils"
+	"github.com/kevin-chtw/tw_proto/cproto"
+	"github.com/kevin-chtw/tw_proto/sproto"
+	"google.golang.org/protobuf/proto"
+)
+
+// flakyMatch 假的比赛服，fail返回true时回复失败
+type flakyMatch struct {
+	mu       sync.Mutex
+	fail     func(msg proto.Message) bool
+	received []proto.Message
+	calls    int
+}
+
+func (m *flakyMatch) handle(ctx context.Context, arg proto.Message) (proto.Message, error) {
+	msg, err := arg.(*sproto.MatchReq).Req.UnmarshalNew()
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls++
+	if m.fail(msg) {
+		return nil, errors.New("match server unavailable")
+	}
+	m.received = append(m.received, msg)
+	return &sproto.MatchAck{}, nil
+}
+
+func (m *flakyMatch) counts() (calls, received int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.calls, len(m.received)
+}
+
+func TestOutboxRetry(t *testing.T) {
+	clock := utils.NewManualClock(time.Unix(1000, 0))
+	game.SetClock(clock)
+	store, err := storage.NewFileTableStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	game.SetOutboxStore(store)
+	t.Cleanup(func() {
+		game.SetClock(utils.SystemClock)
+		game.SetOutboxStore(nil)
+	})
+
+	failures := 2
+	match := &flakyMatch{fail: func(proto.Message) bool {
+		failures--
+		return failures >= 0
+	}}
+	app := apptest.NewApp("game")
+	app.Handle("normal.remote.message", match.handle)
+	game.Init(app, func(table *game.Table, id int32) game.IGame {
+		return &countGame{table: table, id: id}
+	}, nil)
+
+	table, players := startTestTable(t, 1, "p0", "p1")
+	if err := table.OnPlayerMsg(players[0], newGameReq(t, 1, &cproto.TableMsgReq{Msg: []byte{2}})); err != nil {
+		t.Fatal(err)
+	}
+	outbox := game.GetOutbox()
+	check := func(calls, received, pending int) {
+		t.Helper()
+		game.FlushCheckpoints()
+		outbox.Flush()
+		game.FlushCheckpoints()
+		c, r := match.counts()
+		if c != calls || r != received || outbox.Stats().Pending != pending {
+			t.Fatalf("calls = %d, received = %d, stats = %+v", c, r, outbox.Stats())
+		}
+		if saved, _ := store.LoadAll(); len(saved) != pending {
+			t.Fatalf("saved = %d, want %d", len(saved), pending)
+		}
+	}
+
+	// 失败后按1秒、2秒退避
+	check(1, 0, 1)
+	clock.Advance(time.Second)
+	check(2, 0, 1)
+	clock.Advance(time.Second)
+	check(2, 0, 1)
+	clock.Advance(time.Second)
+	check(3, 1, 0)
+	if _, ok := match.received[0].(*sproto.GameResultReq); !ok {
+		t.Errorf("received %v", match.received[0])
+	}
+	if stats := outbox.Stats(); stats.Sent != 1 || stats.Retries != 2 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestOutboxRestart(t *testing.T) {
+	store, err := storage.NewFileTableStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 结果发送失败时，同一张桌子后面的消息不能先发
+	down := &flakyMatch{fail: func(msg proto.Message) bool {
+		_, ok := msg.(*sproto.GameResultReq)
+		return ok
+	}}
+	app := apptest.NewApp("game")
+	app.Handle("normal.remote.message", down.handle)
+	outbox := game.NewOutbox(app, store)
+	if err := outbox.Post("normal", "", 1, 1, "1", &sproto.GameResultReq{Tableid: 1, CurGameCount: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := outbox.Post("normal", "", 1, 1, "1", &sproto.GameOverReq{Tableid: 1, CurGameCount: 1}); err != nil {
+		t.Fatal(err)
+	}
+	game.FlushCheckpoints()
+	outbox.Flush()
+	outbox.Close()
+	if _, received := down.counts(); received != 0 {
+		t.Fatal("game over sent before result")
+	}
+
+	// 重启后从存储加载，按顺序发送
+	up := &flakyMatch{fail: func(proto.Message) bool { return false }}
+	app = apptest.NewApp("game")
+	app.Handle("normal.remote.message", up.handle)
+	outbox = game.NewOutbox(app, store)
+	defer outbox.Close()
+	outbox.Flush()
+	game.FlushCheckpoints()
+	if _, received := up.counts(); received != 2 {
+		t.Fatalf("received %d", received)
+	}
+	if _, ok := up.received[0].(*sproto.GameResultReq); !ok {
+		t.Errorf("first = %v", up.received[0])
+	}
+	if saved, _ := store.LoadAll(); len(saved) != 0 {
+		t.Errorf("saved = %d", len(saved))
+	}
+}
+
+func TestOutboxRepost(t *testing.T) {
+	store, err := storage.NewFileTableStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := apptest.NewApp("game")
+	outbox := game.NewOutbox(app, store)
+	defer outbox.Close()
+
+	// 发送旧消息的过程中又保存了同一个key的新消息，旧消息发送成功后不能删掉新消息。
+	// 新消息一直发送失败，留在存储中
+	match := &flakyMatch{fail: func(msg proto.Message) bool {
+		if msg.(*sproto.GameResultReq).RoundData == "new" {
+			return true
+		}
+		if err := outbox.Post("normal", "", 1, 1, "1", &sproto.GameResultReq{Tableid: 1, CurGameCount: 1, RoundData: "new"}); err != nil {
+			t.Error(err)
+		}
+		return false
+	}}
+	app.Handle("normal.remote.message", match.handle)
+	if err := outbox.Post("normal", "", 1, 1, "1", &sproto.GameResultReq{Tableid: 1, CurGameCount: 1, RoundData: "old"}); err != nil {
+		t.Fatal(err)
+	}
+	game.FlushCheckpoints()
+	outbox.Flush()
+	game.FlushCheckpoints()
+	if _, received := match.counts(); received != 1 {
+		t.Fatalf("received %d", received)
+	}
+	if saved, _ := store.LoadAll(); len(saved) != 1 || outbox.Stats().Pending != 1 {
+		t.Fatalf("saved = %d, stats = %+v", len(saved), outbox.Stats())
+	}
+}
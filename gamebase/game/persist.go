@@ -0,0 +1,399 @@
+package game
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kevin-chtw/tw_common/storage"
+	"github.com/kevin-chtw/tw_common/utils"
+	"github.com/kevin-chtw/tw_proto/cproto"
+	"github.com/topfreegames/pitaya/v3/pkg/logger"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// IPersist 游戏可选实现，支持在检查点保存和恢复进行中的牌局
+type IPersist interface {
+	// Checkpoint 保存牌局状态，返回nil表示当前不需要保存
+	Checkpoint() ([]byte, error)
+	// Restore 用Checkpoint的数据恢复牌局，替代OnGameBegin
+	Restore(data []byte) error
+}
+
+// IJournal 游戏可选实现，录像这类只追加的数据不放进Checkpoint，每次检查点只保存新增的部分
+type IJournal interface {
+	// Journal 返回上次调用之后新增的数据，没有新增时返回nil
+	Journal() ([]byte, error)
+	// RestoreJournal 在Restore之前按保存的顺序传入本局的全部数据
+	RestoreJournal(segments [][]byte) error
+}
+
+// CheckpointInterval 牌局中的状态变化最多等这么久合并保存一次，开局、结束、加人这类状态转换立即保存
+var CheckpointInterval = time.Second
+
+// tableData 桌子检查点
+type tableData struct {
+	MatchType     string           `json:"match_type"`
+	MatchID       int32            `json:"matchid"`
+	TableID       int32            `json:"tableid"`
+	MatchServerId string           `json:"match_server_id"`
+	ScoreBase     int64            `json:"score_base"`
+	GameCount     int32            `json:"game_count"`
+	CurGameCount  int32            `json:"cur_game_count"`
+	PlayerCount   int32            `json:"player_count"`
+	Property      string           `json:"property"`
+	Creator       string           `json:"creator"`
+	Description   string           `json:"description"`
+	Fdproperty    map[string]int32 `json:"fdproperty"`
+	GameOverTime  int64            `json:"game_over_time,omitempty"` // unix毫秒，0表示牌局进行中
+	ReadyDeadline int64            `json:"ready_deadline,omitempty"` // unix毫秒，局间准备截止时间
+	Players       []*playerData    `json:"players"`
+	Game          json.RawMessage  `json:"game,omitempty"`
+	Journal       int              `json:"journal,omitempty"` // 本局已保存的IJournal段数
+}
+
+type playerData struct {
+	Ack     json.RawMessage  `json:"ack"`
+	Datas   map[string]int32 `json:"datas"`
+	Score   int64            `json:"score"`
+	Entered bool             `json:"entered"`
+	Bot     bool             `json:"bot"`
+}
+
+var tableStore storage.TableStore
+
+// SetTableStore 设置桌子检查点存储，为nil时不保存
+func SetTableStore(store storage.TableStore) {
+	tableStore = store
+}
+
+// markDirty 桌子状态有变化，下次检查点时保存
+func (t *Table) markDirty() {
+	t.dirty = true
+}
+
+// checkpoint 状态有变化时CheckpointInterval之后保存，期间的变化合并成一次
+func (t *Table) checkpoint() {
+	if tableStore == nil || t.App == nil || !t.dirty || t.removed || t.saveTimer != nil {
+		return
+	}
+	t.saveTimer = t.afterFunc(CheckpointInterval, func() {
+		t.saveTimer = nil
+		t.saveCheckpoint()
+	})
+}
+
+// saveCheckpoint 在事件处理完的安全点立即保存，用于状态转换。
+// 序列化在事件循环里进行，写存储由checkpoints在后台完成，本地桌子不保存
+func (t *Table) saveCheckpoint() {
+	if tableStore == nil || t.App == nil || !t.dirty || t.removed {
+		return
+	}
+	t.saveTimer.Stop()
+	t.saveTimer = nil
+	t.dirty = false
+	key := getTableKey(t.MatchID, t.tableID)
+	if j, ok := t.game.(IJournal); ok {
+		segment, err := j.Journal()
+		if err != nil {
+			logger.Log.Errorf("table %d journal failed: %v", t.tableID, err)
+			return
+		}
+		if segment != nil {
+			checkpoints.save(tableStore, getJournalKey(key, t.journal), segment)
+			t.journal++
+		}
+	}
+	data, err := t.marshal()
+	if err != nil {
+		logger.Log.Errorf("table %d checkpoint failed: %v", t.tableID, err)
+		return
+	}
+	checkpoints.save(tableStore, key, data)
+}
+
+// resetJournal 新的一局开始时删除上一局的IJournal数据
+func (t *Table) resetJournal() {
+	if tableStore == nil || t.App == nil {
+		return
+	}
+	key := getTableKey(t.MatchID, t.tableID)
+	for i := range t.journal {
+		checkpoints.remove(tableStore, getJournalKey(key, i))
+	}
+	t.journal = 0
+}
+
+// deleteCheckpoint 桌子结束时删除检查点，之后不再保存
+func (t *Table) deleteCheckpoint() {
+	t.saveTimer.Stop()
+	t.saveTimer = nil
+	t.dirty = false
+	t.removed = true
+	if tableStore == nil {
+		return
+	}
+	t.resetJournal()
+	checkpoints.remove(tableStore, getTableKey(t.MatchID, t.tableID))
+}
+
+// getJournalKey IJournal数据的key，桌子的key加上段号
+func getJournalKey(key string, n int) string {
+	return key + ":j" + strconv.Itoa(n)
+}
+
+func (t *Table) marshal() ([]byte, error) {
+	data := &tableData{
+		MatchType:     t.MatchType,
+		MatchID:       t.MatchID,
+		TableID:       t.tableID,
+		MatchServerId: t.matchServerId,
+		ScoreBase:     t.scoreBase,
+		GameCount:     t.gameCount,
+		CurGameCount:  t.curGameCount,
+		PlayerCount:   t.playerCount,
+		Property:      t.property,
+		Creator:       t.creator,
+		Description:   t.description,
+		Fdproperty:    t.fdproperty,
+		Players:       make([]*playerData, 0, len(t.players)),
+		Journal:       t.journal,
+	}
+	if t.gameOverTime != nil {
+		data.GameOverTime = t.gameOverTime.UnixMilli()
+	}
+	if !t.readyDeadline.IsZero() {
+		data.ReadyDeadline = t.readyDeadline.UnixMilli()
+	}
+	for _, p := range t.players {
+		ack, err := utils.JsonMarshal.Marshal(p.ack)
+		if err != nil {
+			return nil, err
+		}
+		data.Players = append(data.Players, &playerData{
+			Ack:     ack,
+			Datas:   p.datas,
+			Score:   p.score,
+			Entered: p.entered,
+			Bot:     p.isBot,
+		})
+	}
+
+	if g, ok := t.game.(IPersist); ok {
+		state, err := g.Checkpoint()
+		if err != nil {
+			return nil, err
+		}
+		data.Game = state
+	}
+	return json.Marshal(data)
+}
+
+// restore 从检查点恢复桌子，玩家都处于离线状态，等待重新进入
+func (t *Table) restore(b []byte, journal [][]byte) error {
+	data := &tableData{}
+	if err := json.Unmarshal(b, data); err != nil {
+		return err
+	}
+	t.MatchType = data.MatchType
+	t.matchServerId = data.MatchServerId
+	t.scoreBase = data.ScoreBase
+	t.gameCount = data.GameCount
+	t.curGameCount = data.CurGameCount
+	t.playerCount = data.PlayerCount
+	t.property = data.Property
+	t.creator = data.Creator
+	t.description = data.Description
+	if data.Fdproperty != nil {
+		t.fdproperty = data.Fdproperty
+	}
+	if data.GameOverTime > 0 {
+		gameOverTime := time.UnixMilli(data.GameOverTime)
+		t.gameOverTime = &gameOverTime
+	}
+	if data.ReadyDeadline > 0 {
+		t.readyDeadline = time.UnixMilli(data.ReadyDeadline)
+	}
+
+	for _, pd := range data.Players {
+		ack := &cproto.TablePlayerAck{}
+		if err := protojson.Unmarshal(pd.Ack, ack); err != nil {
+			return err
+		}
+		player := &Player{
+			Ctx:     context.Background(),
+			ack:     ack,
+			datas:   pd.Datas,
+			score:   pd.Score,
+			online:  pd.Bot,
+			enter:   false,
+			entered: pd.Entered,
+			isBot:   pd.Bot,
+		}
+		if player.datas == nil {
+			player.datas = make(map[string]int32)
+		}
+		if err := playerManager.restore(player); err != nil {
+			return err
+		}
+		t.players[ack.Uid] = player
+	}
+
+	t.journal = data.Journal
+	if len(data.Game) > 0 {
+		if err := t.restoreGame(data.Game, journal); err != nil {
+			logger.Log.Errorf("table %d restore game failed: %v", t.tableID, err)
+		}
+	}
+	if t.curGameCount > 0 && t.game == nil && t.gameOverTime == nil {
+		// 牌局无法恢复，按这一局已经结束处理
+		logger.Log.Warnf("table %d game %d lost on restart", t.tableID, t.curGameCount)
+		now := t.Now()
+		t.gameOverTime = &now
+	}
+	if t.gameOverTime != nil {
+		t.scheduleNextGame()
+	} else if !t.readyDeadline.IsZero() {
+		t.scheduleReady()
+	}
+
+	for _, player := range t.players {
+		if player.isBot {
+			botManager.AddBot(botCreator(player.ack.Uid, t.MatchID, t.tableID, t.scoreBase))
+			t.handleEnterGame(player, nil)
+		}
+	}
+	return nil
+}
+
+func (t *Table) restoreGame(data []byte, journal [][]byte) error {
+	game := gameCreator(t, t.curGameCount)
+	g, ok := game.(IPersist)
+	if !ok {
+		return errors.New("game does not support restore")
+	}
+	if j, ok := game.(IJournal); ok {
+		// 写表数据之前可能已经写了下一段，只用表数据记录的段数
+		if len(journal) < t.journal || slices.ContainsFunc(journal[:t.journal], func(b []byte) bool { return b == nil }) {
+			return fmt.Errorf("journal segments missing, want %d", t.journal)
+		}
+		if err := j.RestoreJournal(journal[:t.journal]); err != nil {
+			return err
+		}
+	}
+	if err := g.Restore(data); err != nil {
+		return err
+	}
+	t.game = game
+	t.playing = t.gameOverTime == nil // 局间恢复的牌局只用于读取上一局的数据
+	t.scheduleGameTimer()
+	return nil
+}
+
+// checkpointWriter 在后台按顺序写检查点和发件箱，同一个store和key只写最新的数据
+type checkpointWriter struct {
+	once    sync.Once
+	mu      sync.Mutex
+	cond    *sync.Cond
+	pending map[checkpointKey]*checkpointOp
+	order   []checkpointKey
+	writing bool
+}
+
+type checkpointKey struct {
+	store storage.TableStore
+	key   string
+}
+
+// checkpointOp data为nil表示删除，写完后调用done，被同一个key后来的操作替换时不调用
+type checkpointOp struct {
+	data []byte
+	done func()
+}
+
+var checkpoints = &checkpointWriter{}
+
+func (w *checkpointWriter) save(store storage.TableStore, key string, data []byte) {
+	w.enqueue(checkpointKey{store, key}, &checkpointOp{data: data})
+}
+
+func (w *checkpointWriter) remove(store storage.TableStore, key string) {
+	w.enqueue(checkpointKey{store, key}, &checkpointOp{})
+}
+
+// start 第一次使用时启动写入协程
+func (w *checkpointWriter) start() {
+	w.once.Do(func() {
+		w.cond = sync.NewCond(&w.mu)
+		w.pending = make(map[checkpointKey]*checkpointOp)
+		go w.run()
+	})
+}
+
+func (w *checkpointWriter) enqueue(key checkpointKey, op *checkpointOp) {
+	w.start()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.pending[key]; ok {
+		// 重新排到最后，保证引用的数据先写入
+		w.order = slices.DeleteFunc(w.order, func(k checkpointKey) bool { return k == key })
+	}
+	w.pending[key] = op
+	w.order = append(w.order, key)
+	w.cond.Broadcast()
+}
+
+func (w *checkpointWriter) run() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for {
+		for len(w.order) == 0 {
+			w.cond.Wait()
+		}
+		key := w.order[0]
+		w.order = w.order[1:]
+		op := w.pending[key]
+		delete(w.pending, key)
+		w.writing = true
+		w.mu.Unlock()
+		w.write(key, op)
+		w.mu.Lock()
+		w.writing = false
+		w.cond.Broadcast()
+	}
+}
+
+func (w *checkpointWriter) write(key checkpointKey, op *checkpointOp) {
+	var err error
+	if op.data == nil {
+		err = key.store.Delete(key.key)
+	} else {
+		err = key.store.Save(key.key, op.data)
+	}
+	if err != nil {
+		logger.Log.Errorf("write checkpoint %s failed: %v", key.key, err)
+	}
+	if op.done != nil {
+		op.done()
+	}
+}
+
+// flush 等待已经提交的检查点写完
+func (w *checkpointWriter) flush() {
+	w.start()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for len(w.order) > 0 || w.writing {
+		w.cond.Wait()
+	}
+}
+
+// FlushCheckpoints 等待已经提交的检查点和发件箱消息写入存储，停服前调用
+func FlushCheckpoints() {
+	checkpoints.flush()
+}
@@ -0,0 +1,241 @@
+package game_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kevin-chtw/tw_common/apptest"
+	"github.com/kevin-chtw/tw_common/gamebase/game"
+	"github.com/kevin-chtw/tw_common/storage"
+	"github.com/kevin-chtw/tw_common/utils"
+	"github.com/kevin-chtw/tw_proto/cproto"
+	"github.com/kevin-chtw/tw_proto/sproto"
+	"google.golang.org/protobuf/proto"
+)
+
+// countStore 记录每个key保存的次数
+type countStore struct {
+	storage.TableStore
+	mu    sync.Mutex
+	saves map[string]int
+}
+
+func (s *countStore) Save(key string, data []byte) error {
+	s.mu.Lock()
+	s.saves[key]++
+	s.mu.Unlock()
+	return s.TableStore.Save(key, data)
+}
+
+func (s *countStore) count(key string) int {
+	game.FlushCheckpoints()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.saves[key]
+}
+
+// journalGame 把收到的消息追加到Journal，检查点保存消息数
+type journalGame struct {
+	countGame
+	pending  []byte
+	restored []byte // RestoreJournal恢复的消息
+}
+
+func (g *journalGame) OnPlayerMsg(player *game.Player, data []byte) error {
+	g.pending = append(g.pending, data[0])
+	return g.countGame.OnPlayerMsg(player, data)
+}
+
+func (g *journalGame) Checkpoint() ([]byte, error) { return json.Marshal(g.msgs) }
+func (g *journalGame) Restore(data []byte) error   { return json.Unmarshal(data, &g.msgs) }
+
+func (g *journalGame) Journal() ([]byte, error) {
+	if len(g.pending) == 0 {
+		return nil, nil
+	}
+	b := g.pending
+	g.pending = nil
+	return b, nil
+}
+
+func (g *journalGame) RestoreJournal(segments [][]byte) error {
+	g.restored = bytes.Join(segments, nil)
+	return nil
+}
+
+type quietBot struct{}
+
+func (quietBot) OnBotMsg(msg proto.Message) error { return nil }
+func (quietBot) OnTimer() error                   { return nil }
+
+func TestCheckpointDebounce(t *testing.T) {
+	clock := utils.NewManualClock(time.Unix(1000, 0))
+	game.SetClock(clock)
+	dir, err := storage.NewFileTableStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &countStore{TableStore: dir, saves: make(map[string]int)}
+	game.SetTableStore(store)
+	t.Cleanup(func() {
+		game.SetClock(utils.SystemClock)
+		game.SetTableStore(nil)
+	})
+	game.Init(apptest.NewApp("game"), func(table *game.Table, id int32) game.IGame {
+		return &journalGame{countGame: countGame{table: table, id: id}}
+	}, nil)
+
+	table, players := startTestTable(t, 1, "c0", "c1")
+	begin := store.count("1:1")
+
+	// 牌局中的消息合并保存
+	for range 3 {
+		if err := players[0].HandleMessage(t.Context(), newGameReq(t, 1, &cproto.TableMsgReq{Msg: []byte{1}})); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := store.count("1:1"); n != begin {
+		t.Fatalf("saved %d times before interval", n-begin)
+	}
+	clock.Advance(game.CheckpointInterval)
+	table.Tick()
+	waitTable(table)
+	if n := store.count("1:1"); n != begin+1 {
+		t.Fatalf("saved %d times after interval, want 1", n-begin)
+	}
+	if b, _ := dir.Load("1:1:j0"); !bytes.Equal(b, []byte{1, 1, 1}) {
+		t.Fatalf("journal = %v", b)
+	}
+
+	// 一局结束立即保存，只追加新的部分
+	if err := players[0].HandleMessage(t.Context(), newGameReq(t, 1, &cproto.TableMsgReq{Msg: []byte{2}})); err != nil {
+		t.Fatal(err)
+	}
+	if n := store.count("1:1"); n != begin+2 {
+		t.Fatalf("game over saved %d times, want 1", n-begin-1)
+	}
+	if b, _ := dir.Load("1:1:j1"); !bytes.Equal(b, []byte{2}) {
+		t.Fatalf("journal = %v", b)
+	}
+}
+
+func TestTableRestore(t *testing.T) {
+	clock := utils.NewManualClock(time.Unix(1000, 0))
+	game.SetClock(clock)
+	store, err := storage.NewFileTableStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	game.SetTableStore(store)
+	t.Cleanup(func() {
+		game.SetClock(utils.SystemClock)
+		game.SetTableStore(nil)
+	})
+	var games []*journalGame
+	// restart 模拟停服重启，用同一个存储恢复桌子
+	restart := func() *game.Table {
+		t.Helper()
+		game.FlushCheckpoints()
+		if tm := game.GetTableManager(); tm != nil {
+			tm.Delete(1, 1)
+		}
+		games = nil
+		game.Init(apptest.NewApp("game"), func(table *game.Table, id int32) game.IGame {
+			g := &journalGame{countGame: countGame{table: table, id: id}}
+			games = append(games, g)
+			return g
+		}, func(uid string, matchid, tableid int32, scorebase int64) *game.BotPlayer {
+			p := game.NewBotPlayer(uid, matchid, tableid, scorebase)
+			p.Bot = quietBot{}
+			return p
+		})
+		if err := game.GetTableManager().Restore(); err != nil {
+			t.Fatal(err)
+		}
+		return game.GetTableManager().Get(1, 1)
+	}
+	restart()
+
+	ctx := t.Context()
+	table := game.GetTableManager().LoadOrStore(1, 1)
+	req := &sproto.AddTableReq{MatchType: "normal", PlayerCount: 2, GameCount: 2, Fdproperty: map[string]int32{game.FdReadyTimeout: 10}}
+	if _, err := table.HandleAddTable(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := table.HandleAddPlayer(ctx, &sproto.AddPlayerReq{Playerid: "h0", Seat: 0, Score: 100}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := table.HandleAddPlayer(ctx, &sproto.AddPlayerReq{Playerid: "b1", Seat: 1, Bot: true}); err != nil {
+		t.Fatal(err)
+	}
+	send := func(b byte) {
+		t.Helper()
+		player := game.GetPlayerManager().Get(false, "h0")
+		if err := player.HandleMessage(ctx, newGameReq(t, 1, &cproto.TableMsgReq{Msg: []byte{b}})); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := game.GetPlayerManager().Get(false, "h0").HandleMessage(ctx, newGameReq(t, 1, &cproto.EnterGameReq{})); err != nil {
+		t.Fatal(err)
+	}
+	for range 3 {
+		send(1)
+	}
+	clock.Advance(game.CheckpointInterval)
+	table.Tick()
+	waitTable(table)
+
+	// 牌局中重启，恢复玩家、bot和牌局
+	table = restart()
+	if table == nil {
+		t.Fatal("table not restored")
+	}
+	info, err := table.Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.CurGameCount != 1 || !info.Playing || len(info.Players) != 2 {
+		t.Fatalf("info = %+v", info)
+	}
+	if p := info.Players[0]; p.Uid != "h0" || p.Bot || p.Online || p.Score != 100 {
+		t.Errorf("human = %+v", p)
+	}
+	if p := info.Players[1]; p.Uid != "b1" || !p.Bot || !p.Online {
+		t.Errorf("bot = %+v", p)
+	}
+	if game.GetBotManager().GetBot("b1") == nil {
+		t.Error("bot not restored")
+	}
+	if len(games) != 1 || games[0].msgs != 3 || !bytes.Equal(games[0].restored, []byte{1, 1, 1}) {
+		t.Fatalf("game not restored: %d games", len(games))
+	}
+
+	// 局间重启，恢复准备截止时间，到期后自动准备开始下一局
+	send(2)
+	deadline := table.ReadyDeadline()
+	table = restart()
+	if table == nil {
+		t.Fatal("table not restored")
+	}
+	if info, _ := table.Info(); info.Playing || info.CurGameCount != 1 {
+		t.Fatalf("info = %+v", info)
+	}
+	if got := table.ReadyDeadline(); !got.Equal(deadline) || got.IsZero() {
+		t.Errorf("ready deadline = %v, want %v", got, deadline)
+	}
+	clock.Advance(5 * time.Second)
+	table.Tick()
+	waitTable(table)
+	if info, _ := table.Info(); info.Playing {
+		t.Fatal("next game started before offline player ready")
+	}
+	clock.Advance(5 * time.Second)
+	table.Tick()
+	waitTable(table)
+	if info, _ := table.Info(); !info.Playing || info.CurGameCount != 2 {
+		t.Fatalf("next game not started: %+v", info)
+	}
+}
@@ -20,6 +20,8 @@ type Player struct {
 	enter   bool             // 玩家是否进入游戏
 	entered bool             // 玩家是否进入过游戏
 	isBot   bool             // 是否是bot玩家
+
+	autoReady bool // 准备超时后自动准备
 }
 
 // newPlayer 创建新玩家实例
@@ -47,6 +49,14 @@ func (p *Player) GetSeat() int32 {
 	return p.ack.Seat
 }
 
+func (p *Player) GetUid() string {
+	return p.ack.Uid
+}
+
+func (p *Player) IsBot() bool {
+	return p.isBot
+}
+
 // AddScore 增加玩家积分
 func (p *Player) AddScore(score int64) {
 	p.score += score
@@ -75,6 +85,8 @@ func (p *Player) HandleMessage(ctx context.Context, req *cproto.GameReq) error {
 	if nil == table {
 		return fmt.Errorf("table not found %d", req.Tableid)
 	}
-	p.Ctx = ctx
-	return table.OnPlayerMsg(p, req)
+	return table.callErr(func() error {
+		p.Ctx = ctx
+		return table.onPlayerMsg(p, req)
+	})
 }
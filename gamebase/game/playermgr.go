@@ -41,6 +41,18 @@ func (p *PlayerManager) Store(ack *sproto.PlayerInfoAck, bot bool, seat int32, s
 	return player, nil
 }
 
+// restore 恢复检查点中的玩家
+func (p *PlayerManager) restore(player *Player) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	key := getKey(player.isBot, player.ack.Uid)
+	if _, ok := p.players[key]; ok {
+		return errors.New("player is already in game")
+	}
+	p.players[key] = player
+	return nil
+}
+
 // DeletePlayer 删除玩家实例
 func (p *PlayerManager) Delete(bot bool, userID string) {
 	p.mu.Lock()
@@ -0,0 +1,138 @@
+package game
+
+import (
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/kevin-chtw/tw_proto/cproto"
+	"github.com/kevin-chtw/tw_proto/sproto"
+)
+
+// fdproperty中的局间配置，没有配置的使用默认值
+const (
+	FdNextDelay        = "next_delay"         // 一局结束后等待多少秒开始下一局
+	FdReadyTimeout     = "ready_timeout"      // 一局结束后多少秒内必须准备，0不限制，客户端收到GameOverAck后按它倒计时
+	FdReadyKick        = "ready_kick"         // 超时没准备的踢出桌子，0自动准备
+	FdAutoReadyOffline = "auto_ready_offline" // 离线玩家自动准备
+	FdAutoReadyTrusted = "auto_ready_trusted" // 托管玩家自动准备
+)
+
+// ReadyPolicy 局间准备策略
+type ReadyPolicy struct {
+	NextDelay        time.Duration
+	ReadyTimeout     time.Duration // 从一局结束开始计算，不会早于NextDelay
+	Kick             bool
+	AutoReadyOffline bool
+	AutoReadyTrusted bool
+}
+
+// ITrust 游戏实现后，AutoReadyTrusted时托管的玩家自动准备
+type ITrust interface {
+	IsTrusted(player *Player) bool
+}
+
+// ParseReadyPolicy 从房间属性读取准备策略，默认5秒后开始下一局，训练赛不等待
+func ParseReadyPolicy(matchType string, fdproperty map[string]int32) ReadyPolicy {
+	policy := ReadyPolicy{NextDelay: 5 * time.Second}
+	if matchType == "trainer" {
+		policy.NextDelay = 0
+	}
+	if v, ok := fdproperty[FdNextDelay]; ok && v >= 0 {
+		policy.NextDelay = time.Duration(v) * time.Second
+	}
+	if v, ok := fdproperty[FdReadyTimeout]; ok && v > 0 {
+		policy.ReadyTimeout = max(time.Duration(v)*time.Second, policy.NextDelay)
+	}
+	policy.Kick = fdproperty[FdReadyKick] != 0
+	policy.AutoReadyOffline = fdproperty[FdAutoReadyOffline] != 0
+	policy.AutoReadyTrusted = fdproperty[FdAutoReadyTrusted] != 0
+	return policy
+}
+
+// GetReadyPolicy 获取桌子的准备策略
+func (t *Table) GetReadyPolicy() ReadyPolicy {
+	return ParseReadyPolicy(t.MatchType, t.fdproperty)
+}
+
+// ReadyDeadline 下一局准备的截止时间，没有限制或牌局进行中时为零值
+func (t *Table) ReadyDeadline() time.Time {
+	return t.readyDeadline
+}
+
+// isReady 玩家是否可以开始下一局，离线和托管的自动准备只在局间生效
+func (t *Table) isReady(player *Player) bool {
+	if player.isBot || player.autoReady {
+		return true
+	}
+	if t.curGameCount > 0 {
+		policy := t.GetReadyPolicy()
+		if policy.AutoReadyOffline && !player.online {
+			return true
+		}
+		if g, ok := t.game.(ITrust); ok && policy.AutoReadyTrusted && g.IsTrusted(player) {
+			return true
+		}
+	}
+	return player.enter && (player.ack.Ready || t.MatchType != "fdtable")
+}
+
+// scheduleReady 准备超时后处理没准备的玩家
+func (t *Table) scheduleReady() {
+	policy := t.GetReadyPolicy()
+	if t.readyDeadline.IsZero() {
+		if policy.ReadyTimeout <= 0 || t.gameOverTime == nil || t.curGameCount >= t.gameCount {
+			return
+		}
+		t.readyDeadline = t.gameOverTime.Add(policy.ReadyTimeout)
+	}
+	t.readyTimer.Stop()
+	t.readyTimer = t.AfterFunc(t.readyDeadline.Sub(t.Now()), t.checkReady)
+}
+
+func (t *Table) checkReady() {
+	if t.readyDeadline.IsZero() {
+		return
+	}
+	kick := t.GetReadyPolicy().Kick
+	for _, player := range t.players {
+		if t.isReady(player) {
+			continue
+		}
+		if kick {
+			t.kickPlayer(player)
+			continue
+		}
+		player.autoReady = true
+		if !player.ack.Ready {
+			player.ack.Ready = true
+			t.broadcastReady(player)
+		}
+	}
+	t.markDirty()
+	if t.gameOverTime == nil {
+		t.checkBegin()
+	}
+}
+
+// resetReady 新的一局开始，清除局间状态
+func (t *Table) resetReady() {
+	t.readyTimer.Stop()
+	t.readyDeadline = time.Time{}
+	for _, player := range t.players {
+		player.autoReady = false
+	}
+}
+
+// kickPlayer 踢出没有准备的玩家，通过发件箱通知比赛服，等比赛服补充玩家
+func (t *Table) kickPlayer(player *Player) {
+	ack := &cproto.GameExitAck{
+		Uid: player.ack.Uid,
+	}
+	t.sendMsg(t.newMsg(ack), player)
+	t.removePlayer(player)
+	// uid可能包含存储key不能用的字符
+	t.postMatchAs(fmt.Sprintf("%d:%s", t.curGameCount, hex.EncodeToString([]byte(player.ack.Uid))), &sproto.ExitTableReq{
+		Playerid: player.ack.Uid,
+	})
+}
@@ -0,0 +1,130 @@
+package game_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/kevin-chtw/tw_common/apptest"
+	"github.com/kevin-chtw/tw_common/gamebase/game"
+	"github.com/kevin-chtw/tw_common/utils"
+	"github.com/kevin-chtw/tw_proto/cproto"
+	"github.com/kevin-chtw/tw_proto/sproto"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestParseReadyPolicy(t *testing.T) {
+	if policy := game.ParseReadyPolicy("trainer", nil); policy.NextDelay != 0 || policy.ReadyTimeout != 0 {
+		t.Errorf("trainer policy = %+v", policy)
+	}
+	policy := game.ParseReadyPolicy("fdtable", map[string]int32{game.FdNextDelay: 10, game.FdReadyTimeout: 3, game.FdReadyKick: 1})
+	want := game.ReadyPolicy{NextDelay: 10 * time.Second, ReadyTimeout: 10 * time.Second, Kick: true}
+	if policy != want {
+		t.Errorf("policy = %+v, want %+v", policy, want)
+	}
+}
+
+func TestReadyFlow(t *testing.T) {
+	clock := utils.NewManualClock(time.Unix(1000, 0))
+	game.SetClock(clock)
+	t.Cleanup(func() { game.SetClock(utils.SystemClock) })
+	games := make(map[int32]int32) // 桌子 -> 开始的局数
+	match := &flakyMatch{fail: func(proto.Message) bool { return false }}
+	app := apptest.NewApp("game")
+	app.Handle("fdtable.remote.message", match.handle)
+	game.Init(app, func(table *game.Table, id int32) game.IGame {
+		games[table.GetTableID()] = id
+		return &countGame{table: table, id: id}
+	}, nil)
+
+	var tableID int32
+	// start 创建需要准备的2人桌，所有人准备后打完第一局
+	start := func(t *testing.T, fd map[string]int32) (*game.Table, []*game.Player) {
+		tableID++
+		uids := []string{fmt.Sprintf("r%d_0", tableID), fmt.Sprintf("r%d_1", tableID)}
+		req := &sproto.AddTableReq{MatchType: "fdtable", PlayerCount: 2, GameCount: 3, Fdproperty: fd}
+		table, players := startTableWith(t, tableID, req, uids...)
+		for _, p := range players {
+			ready(t, table, p)
+		}
+		if games[tableID] != 1 {
+			t.Fatal("first game not started")
+		}
+		if err := table.OnPlayerMsg(players[0], newGameReq(t, tableID, &cproto.TableMsgReq{Msg: []byte{2}})); err != nil {
+			t.Fatal(err)
+		}
+		return table, players
+	}
+	advance := func(table *game.Table, d time.Duration) {
+		clock.Advance(d)
+		table.Tick()
+		waitTable(table)
+	}
+
+	t.Run("auto ready", func(t *testing.T) {
+		table, players := start(t, map[string]int32{game.FdReadyTimeout: 30})
+		if got := table.ReadyDeadline(); !got.Equal(clock.Now().Add(30 * time.Second)) {
+			t.Errorf("deadline = %v", got)
+		}
+		ready(t, table, players[0])
+		advance(table, 29*time.Second)
+		if games[tableID] != 1 {
+			t.Fatal("started before everyone ready")
+		}
+		advance(table, time.Second)
+		if games[tableID] != 2 {
+			t.Fatal("not auto readied")
+		}
+		if !table.ReadyDeadline().IsZero() {
+			t.Error("deadline not cleared")
+		}
+	})
+
+	t.Run("kick", func(t *testing.T) {
+		table, players := start(t, map[string]int32{game.FdReadyTimeout: 30, game.FdReadyKick: 1})
+		ready(t, table, players[0])
+		advance(table, 30*time.Second)
+		if games[tableID] != 1 {
+			t.Fatal("started after kick")
+		}
+		if game.GetPlayerManager().Get(false, players[1].GetUid()) != nil {
+			t.Fatal("player not kicked")
+		}
+		if game.GetPlayerManager().Get(false, players[0].GetUid()) == nil {
+			t.Fatal("ready player kicked")
+		}
+		// 比赛服通过发件箱收到踢人通知
+		game.FlushCheckpoints()
+		game.GetOutbox().Flush()
+		match.mu.Lock()
+		defer match.mu.Unlock()
+		kicked := false
+		for _, msg := range match.received {
+			if req, ok := msg.(*sproto.ExitTableReq); ok {
+				kicked = req.Playerid == players[1].GetUid()
+			}
+		}
+		if !kicked {
+			t.Errorf("match received %v", match.received)
+		}
+	})
+
+	t.Run("auto ready offline", func(t *testing.T) {
+		table, players := start(t, map[string]int32{game.FdNextDelay: 3, game.FdAutoReadyOffline: 1})
+		if _, err := table.HandleNetState(context.Background(), &sproto.NetStateReq{Uid: players[1].GetUid()}); err != nil {
+			t.Fatal(err)
+		}
+		ready(t, table, players[0])
+		if games[tableID] != 2 {
+			t.Fatal("offline player not auto readied")
+		}
+	})
+}
+
+func ready(t *testing.T, table *game.Table, player *game.Player) {
+	t.Helper()
+	if err := table.OnPlayerMsg(player, newGameReq(t, table.GetTableID(), &cproto.GameReadyReq{Ready: true})); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -3,6 +3,8 @@ package game
 import (
 	"context"
 	"errors"
+	"slices"
+	"strconv"
 	"sync"
 	"time"
 
@@ -22,12 +24,28 @@ type IGame interface {
 	OnGameBegin()
 	// OnPlayerMsg 处理玩家消息
 	OnPlayerMsg(player *Player, data []byte) error
-	// OnGameTimer 每秒调用一次
+	// OnGameTimer 牌局中每GameTimerInterval调用一次，实现ITableTimer的游戏不调用
 	OnGameTimer()
 	// OnNetChange 处理玩家网络状态变化
 	OnNetChange(player *Player, offline bool)
 }
 
+// ITableTimer 游戏可选实现，定时逻辑都用Table.AfterFunc注册时返回true，桌子不再周期调用OnGameTimer
+type ITableTimer interface {
+	UseTableTimer() bool
+}
+
+// GameTimerInterval 调用OnGameTimer的间隔
+var GameTimerInterval = time.Second
+
+// ISnapshot 游戏可选实现，断线重连时发送快照代替重发历史消息
+type ISnapshot interface {
+	// HasSnapshot 是否支持快照，支持时桌子不再保存历史消息
+	HasSnapshot() bool
+	// Snapshot 玩家重连时需要发送的消息
+	Snapshot(player *Player) []proto.Message
+}
+
 // Table 表示一个游戏桌实例
 type Table struct {
 	MatchType     string //
@@ -46,40 +64,100 @@ type Table struct {
 	fdproperty    map[string]int32   // 房间属性
 	lastHandData  any
 	handlers      map[string]func(*Player, proto.Message) error
-	gameMutex     sync.Mutex // 保护game的对象锁
-	game          IGame      // 游戏逻辑处理接口
+	game          IGame // 游戏逻辑处理接口
 
-	historyMsg     map[string][]*cproto.GameAck
-	historyJsonMsg map[string][]*cproto.GameAck
-	historyMutex   sync.Mutex // 保护historyMsg的锁
-	gameOnce       sync.Once  // 确保每局游戏结束只执行一次
+	historyMsg map[string][]proto.Message // 玩家本局收到的游戏消息，不支持快照时重连重发
+	gameOnce   sync.Once                  // 确保每局游戏结束只执行一次
+
+	// 事件循环，远程调用、玩家消息、bot消息和定时器都在同一个goroutine里依次处理
+	mailbox  chan func()
+	done     chan struct{}
+	exited   chan struct{}
+	stopOnce sync.Once
 
 	//dissolveMutex sync.Mutex // 保护dissovle的对象锁
-	dissovle     *cproto.GameDissolveAck
-	gameOverTime *time.Time // 游戏结束时间，用于延迟开始下一局
-}
+	dissovle      *cproto.GameDissolveAck
+	gameOverTime  *time.Time  // 游戏结束时间，用于延迟开始下一局
+	playing       bool        // 牌局进行中
+	dirty         bool        // 检查点之后状态有变化
+	removed       bool        // 检查点已删除，桌子不再保存
+	saveTimer     *TableTimer // 等待合并保存的检查点
+	journal       int         // 本局已保存的IJournal段数
+	quarantined   bool        // panic后被隔离
+	clock         utils.Clock
+	wheel         *utils.TimingWheel // 定时器调度，本地桌子使用自己的时间轮
+	nextTimer     *TableTimer        // 一局结束后开始下一局
+	dissolveTimer *TableTimer        // 解散投票超时
+	readyTimer    *TableTimer        // 局间准备超时
+	gameTimer     *TableTimer        // 下一次调用OnGameTimer
+	readyDeadline time.Time          // 局间准备截止时间
+	localSink     LocalSink          // 本地桌子的消息出口
+	obs           observers          // 观战者
+	dis           dissolveState      // 解散投票
+}
+
+// LocalSink 本地桌子发给玩家的消息都交给它，用于离线模拟
+type LocalSink func(player *Player, msg *cproto.GameAck)
 
 // NewTable 创建新的游戏桌实例
 func NewTable(matchID, tableID int32, app pitaya.Pitaya) *Table {
 	t := &Table{
-		MatchID:        matchID,
-		tableID:        tableID,
-		curGameCount:   0,
-		matchServerId:  "",
-		players:        make(map[string]*Player),
-		fdproperty:     make(map[string]int32),
-		App:            app,
-		handlers:       make(map[string]func(*Player, proto.Message) error),
-		gameMutex:      sync.Mutex{},
-		game:           nil,
-		historyMsg:     make(map[string][]*cproto.GameAck),
-		historyJsonMsg: make(map[string][]*cproto.GameAck),
+		MatchID:       matchID,
+		tableID:       tableID,
+		curGameCount:  0,
+		matchServerId: "",
+		players:       make(map[string]*Player),
+		fdproperty:    make(map[string]int32),
+		App:           app,
+		handlers:      make(map[string]func(*Player, proto.Message) error),
+		game:          nil,
+		historyMsg:    make(map[string][]proto.Message),
+		clock:         clock,
+		wheel:         scheduler,
+	}
+	if t.wheel == nil {
+		t.wheel = utils.NewTimingWheel(t.clock, schedulerTick)
 	}
 
 	t.init()
 	return t
 }
 
+// NewLocalTable 创建不依赖pitaya的本地桌子，只用于录像回放等离线计算，不能收发消息
+func NewLocalTable(matchID, tableID int32, matchType string, playerCount int32, property string, scoreBase int64) *Table {
+	t := NewTable(matchID, tableID, nil)
+	t.wheel = utils.NewTimingWheel(t.clock, schedulerTick)
+	t.MatchType = matchType
+	t.playerCount = playerCount
+	t.property = property
+	t.scoreBase = scoreBase
+	return t
+}
+
+// AddLocalPlayer 向本地桌子添加玩家，不经过account和PlayerManager
+func (t *Table) AddLocalPlayer(uid string, seat int32, score int64, bot bool) *Player {
+	player := newPlayer(&sproto.PlayerInfoAck{Uid: uid}, bot, seat, score)
+	t.players[uid] = player
+	return player
+}
+
+// SetLocalSink 设置本地桌子的消息出口，设置后本地桌子可以收发消息
+func (t *Table) SetLocalSink(sink LocalSink) {
+	t.localSink = sink
+}
+
+// SetClock 设置本地桌子的时钟，需要在开局之前调用，之前的定时器作废
+func (t *Table) SetClock(c utils.Clock) {
+	t.clock = c
+	t.wheel = utils.NewTimingWheel(c, schedulerTick)
+}
+
+// BeginLocalGame 本地桌子用gc创建游戏并开始新的一局
+func (t *Table) BeginLocalGame(gc GameCreator) IGame {
+	t.beginGame(gc)
+	return t.game
+}
+
 func TypeUrl(src proto.Message) string {
 	any, err := anypb.New(src)
 	if err != nil {
@@ -96,8 +174,14 @@ func (t *Table) init() {
 	t.handlers[TypeUrl(&cproto.TableMsgReq{})] = t.handleTableMsg
 }
 
-// OnPlayerMsg 处理玩家消息
+// OnPlayerMsg 处理玩家消息，等待处理完成
 func (t *Table) OnPlayerMsg(player *Player, req *cproto.GameReq) error {
+	return t.callErr(func() error {
+		return t.onPlayerMsg(player, req)
+	})
+}
+
+func (t *Table) onPlayerMsg(player *Player, req *cproto.GameReq) error {
 	if req == nil || req.Req == nil {
 		return errors.New("invalid request")
 	}
@@ -113,6 +197,8 @@ func (t *Table) OnPlayerMsg(player *Player, req *cproto.GameReq) error {
 		return err
 	}
 	if handler, ok := t.handlers[req.Req.TypeUrl]; ok {
+		t.markDirty()
+		defer t.checkpoint()
 		return handler(tablePlayer, msg)
 	}
 
@@ -156,71 +242,31 @@ func (t *Table) handleGameReady(player *Player, msg proto.Message) error {
 	return nil
 }
 
-func (t *Table) handleGameDissolve(player *Player, msg proto.Message) error {
-	req := msg.(*cproto.GameDissolveReq)
-	if !t.isOnTable(player.ack.Uid) {
-		return errors.New("player not on table")
-	}
-	//t.dissolveMutex.Lock()
-	if t.dissovle == nil {
-		t.dissovle = &cproto.GameDissolveAck{
-			Starttime: time.Now().Unix(),
-			Endtime:   time.Now().Add(5 * time.Minute).Unix(),
-			Seat:      player.GetSeat(),
-			Agreed:    make(map[int32]bool),
-		}
-	}
-	t.dissovle.Agreed[player.ack.Seat] = req.Agree
-	t.broadcast(t.dissovle)
-	if req.Agree {
-		t.checkDissolve()
-	} else {
-		ack := &cproto.GameDissolveResultAck{
-			Dissovle: false,
-		}
-		t.broadcast(ack)
-		t.dissovle = nil
-	}
-	return nil
-}
-
-func (t *Table) checkDissolve() {
-	if t.dissovle == nil {
-		return
-	}
-	if t.dissovle.Endtime >= time.Now().Unix() && len(t.dissovle.Agreed) < int(t.playerCount) {
-		return
-	}
-	t.dissovle = nil
-	for _, p := range t.players {
-		p.ack.Ready = false
-	}
-
-	ack := &cproto.GameDissolveResultAck{
-		Dissovle: true,
-	}
-	t.broadcast(ack)
-	t.gameOver()
+func (t *Table) gameBegin() {
+	t.beginGame(gameCreator)
 }
 
-func (t *Table) gameBegin() {
-	t.gameMutex.Lock()
-	defer t.gameMutex.Unlock()
+func (t *Table) beginGame(gc GameCreator) {
 	t.curGameCount++
 	// 重置gameOnce以允许新一局游戏的NotifyGameOver执行
 	t.gameOnce = sync.Once{}
 	// 清除游戏结束时间，避免重复触发
 	t.gameOverTime = nil
+	t.nextTimer.Stop()
+	t.resetDissolve()
+	t.resetReady()
 	t.sendGameBegin()
-	t.historyMsg = make(map[string][]*cproto.GameAck)
-	t.game = gameCreator(t, t.curGameCount)
+	t.historyMsg = make(map[string][]proto.Message)
+	t.playing = true
+	t.resetJournal()
+	t.game = gc(t, t.curGameCount)
 	t.game.OnGameBegin()
+	t.scheduleGameTimer()
+	t.markDirty()
+	t.saveCheckpoint()
 }
 
 func (t *Table) handleTableMsg(player *Player, msg proto.Message) error {
-	t.gameMutex.Lock()
-	defer t.gameMutex.Unlock()
-
 	data := msg.(*cproto.TableMsgReq).GetMsg()
 	if t.game != nil && data != nil {
 		return t.game.OnPlayerMsg(player, data)
@@ -265,7 +311,12 @@ func (t *Table) notifyTablePlayer(player *Player, resume bool) {
 }
 
 func (t *Table) sendEnterGame(player *Player) {
-	ack := &cproto.EnterGameAck{
+	msg := t.newMsg(t.enterGameAck())
+	t.sendMsg(msg, player)
+}
+
+func (t *Table) enterGameAck() *cproto.EnterGameAck {
+	return &cproto.EnterGameAck{
 		Tableid:      t.tableID,
 		ScoreBase:    t.scoreBase,
 		GameCount:    t.gameCount,
@@ -276,8 +327,6 @@ func (t *Table) sendEnterGame(player *Player) {
 		Desn:         t.description,
 		Fdproperty:   t.fdproperty,
 	}
-	msg := t.newMsg(ack)
-	t.sendMsg(msg, player)
 }
 
 func (t *Table) newMsg(ack proto.Message) *cproto.GameAck {
@@ -285,12 +334,15 @@ func (t *Table) newMsg(ack proto.Message) *cproto.GameAck {
 	if err != nil {
 		return nil
 	}
-	return &cproto.GameAck{
-		Serverid: t.App.GetServerID(),
-		Tableid:  t.tableID,
-		Matchid:  t.MatchID,
-		Ack:      data,
+	msg := &cproto.GameAck{
+		Tableid: t.tableID,
+		Matchid: t.MatchID,
+		Ack:     data,
+	}
+	if t.App != nil {
+		msg.Serverid = t.App.GetServerID()
 	}
+	return msg
 }
 
 func (t *Table) isOnTable(playerID string) bool {
@@ -306,19 +358,21 @@ func (t *Table) checkBegin() {
 	}
 
 	for _, player := range t.players {
-		if player.isBot {
-			continue
-		}
-		if !player.enter || (!player.ack.Ready && t.MatchType == "fdtable") {
+		if !t.isReady(player) {
 			return
 		}
 	}
 	t.gameBegin()
 }
 
-// HandleStartGame 处理开始游戏请求
+// HandleAddTable 处理创建桌子请求
 func (t *Table) HandleAddTable(ctx context.Context, msg proto.Message) (proto.Message, error) {
-	req := msg.(*sproto.AddTableReq)
+	return t.call(func() (proto.Message, error) {
+		return t.handleAddTable(msg.(*sproto.AddTableReq))
+	})
+}
+
+func (t *Table) handleAddTable(req *sproto.AddTableReq) (proto.Message, error) {
 	t.MatchType = req.GetMatchType()
 	t.scoreBase = int64(req.GetScoreBase())
 	t.gameCount = req.GetGameCount()
@@ -327,15 +381,14 @@ func (t *Table) HandleAddTable(ctx context.Context, msg proto.Message) (proto.Me
 	t.creator = req.GetCreator()
 	t.description = req.GetDesn()
 	t.fdproperty = req.GetFdproperty()
+	t.markDirty()
+	t.saveCheckpoint()
 	return &sproto.EmptyAck{}, nil
 }
 
+// HandleAddPlayer 处理添加玩家请求，查询账号不占用桌子的事件循环
 func (t *Table) HandleAddPlayer(ctx context.Context, msg proto.Message) (proto.Message, error) {
 	req := msg.(*sproto.AddPlayerReq)
-	if t.isOnTable(req.Playerid) {
-		return nil, errors.New("player already on table")
-	}
-
 	rsp, err := t.send2Account(req.Bot, &sproto.PlayerInfoReq{Uid: req.Playerid})
 	if err != nil {
 		return nil, err
@@ -345,12 +398,23 @@ func (t *Table) HandleAddPlayer(ctx context.Context, msg proto.Message) (proto.M
 	if err != nil {
 		return nil, err
 	}
-	player, err := playerManager.Store(account.(*sproto.PlayerInfoAck), req.Bot, req.Seat, req.Score)
+	return t.call(func() (proto.Message, error) {
+		return t.handleAddPlayer(ctx, req, account.(*sproto.PlayerInfoAck))
+	})
+}
+
+func (t *Table) handleAddPlayer(ctx context.Context, req *sproto.AddPlayerReq, account *sproto.PlayerInfoAck) (proto.Message, error) {
+	if t.isOnTable(req.Playerid) {
+		return nil, errors.New("player already on table")
+	}
+	player, err := playerManager.Store(account, req.Bot, req.Seat, req.Score)
 	if err != nil {
 		return nil, err
 	}
 	player.Ctx = ctx
 	t.players[req.Playerid] = player
+	t.markDirty()
+	defer t.saveCheckpoint()
 
 	if player.isBot {
 		botManager.AddBot(botCreator(player.ack.Uid, t.MatchID, t.tableID, t.scoreBase))
@@ -360,7 +424,12 @@ func (t *Table) HandleAddPlayer(ctx context.Context, msg proto.Message) (proto.M
 	return &sproto.EmptyAck{}, nil
 }
 
+// HandleCancelTable 处理取消桌子请求
 func (t *Table) HandleCancelTable(ctx context.Context, msg proto.Message) (proto.Message, error) {
+	return t.call(t.handleCancelTable)
+}
+
+func (t *Table) handleCancelTable() (proto.Message, error) {
 	ack := &cproto.GameDissolveResultAck{
 		Dissovle: true,
 	}
@@ -369,8 +438,14 @@ func (t *Table) HandleCancelTable(ctx context.Context, msg proto.Message) (proto
 	return &sproto.EmptyAck{}, nil
 }
 
+// HandleExitTable 处理玩家离开桌子请求
 func (t *Table) HandleExitTable(ctx context.Context, msg proto.Message) (proto.Message, error) {
-	req := msg.(*sproto.ExitTableReq)
+	return t.call(func() (proto.Message, error) {
+		return t.handleExitTable(msg.(*sproto.ExitTableReq))
+	})
+}
+
+func (t *Table) handleExitTable(req *sproto.ExitTableReq) (proto.Message, error) {
 	if !t.isOnTable(req.Playerid) {
 		return nil, errors.New("player not on table")
 	}
@@ -384,18 +459,30 @@ func (t *Table) HandleExitTable(ctx context.Context, msg proto.Message) (proto.M
 		return nil, errors.New("player not on table")
 	}
 
-	delete(t.players, req.Playerid)
-	playerManager.Delete(player.isBot, req.Playerid) // 从玩家管理器中删除玩家
+	t.removePlayer(player)
+	t.saveCheckpoint()
+	return &sproto.EmptyAck{}, nil
+}
+
+// removePlayer 玩家离开桌子，通知其他玩家
+func (t *Table) removePlayer(player *Player) {
+	delete(t.players, player.ack.Uid)
+	playerManager.Delete(player.isBot, player.ack.Uid) // 从玩家管理器中删除玩家
 	ack := &cproto.GameExitAck{
-		Uid: req.Playerid,
+		Uid: player.ack.Uid,
 	}
 	t.broadcast(ack)
-	return &sproto.EmptyAck{}, nil
+	t.markDirty()
 }
 
+// HandleNetState 处理玩家网络状态变化
 func (t *Table) HandleNetState(ctx context.Context, msg proto.Message) (proto.Message, error) {
-	req := msg.(*sproto.NetStateReq)
+	return t.call(func() (proto.Message, error) {
+		return t.handleNetState(msg.(*sproto.NetStateReq))
+	})
+}
 
+func (t *Table) handleNetState(req *sproto.NetStateReq) (proto.Message, error) {
 	// 检查玩家是否在当前桌子上（match 服务可能发送所有玩家的状态）
 	player, ok := t.players[req.Uid]
 	if !ok {
@@ -412,11 +499,14 @@ func (t *Table) HandleNetState(ctx context.Context, msg proto.Message) (proto.Me
 		player.enter = false
 	}
 
-	t.gameMutex.Lock()
-	defer t.gameMutex.Unlock()
+	if t.dissovle != nil {
+		t.offlineDissolve(player)
+	}
 	if t.game != nil {
-		t.game.OnNetChange(player, req.Online)
+		t.game.OnNetChange(player, !req.Online)
 	}
+	t.markDirty()
+	t.checkpoint()
 
 	return &sproto.NetStateAck{Uid: req.Uid}, nil
 }
@@ -439,10 +529,15 @@ func (t *Table) NotifyGameOver(gameId int32, roundData string) {
 			result.Scores[p.ack.Uid] = p.score
 			result.PlayerData[p.ack.Uid] = p.GetDatas()
 		}
-		t.Send2Match(result)
-		t.sendGameOver()
-		now := time.Now()
+		t.postMatch(result)
+		t.playing = false
+		t.gameTimer.Stop()
+		now := t.Now()
 		t.gameOverTime = &now
+		t.scheduleNextGame()
+		t.sendGameOver()
+		t.markDirty()
+		t.saveCheckpoint()
 		logger.Log.Warnf("Game over: %d", t.curGameCount)
 	})
 }
@@ -452,7 +547,12 @@ func (t *Table) gameOver() {
 		CurGameCount: t.curGameCount,
 		Tableid:      t.tableID,
 	}
-	t.Send2Match(gameOver)
+	t.postMatch(gameOver)
+	t.nextTimer.Stop()
+	t.readyTimer.Stop()
+	t.gameTimer.Stop()
+	t.endDissolve()
+	t.closeObservers()
 	for _, player := range t.players {
 		playerManager.Delete(player.isBot, player.ack.Uid) // 从玩家管理器中删除玩家
 		if player.isBot {
@@ -460,11 +560,10 @@ func (t *Table) gameOver() {
 		}
 	}
 	tableManager.Delete(t.MatchID, t.tableID) // 从桌子管理器中删除
+	t.deleteCheckpoint()
 
 	// 清理game对象
-	t.gameMutex.Lock()
 	t.game = nil
-	t.gameMutex.Unlock()
 }
 
 func (t *Table) send2Account(bot bool, msg proto.Message) (proto.Message, error) {
@@ -485,7 +584,7 @@ func (t *Table) send2Account(bot bool, msg proto.Message) (proto.Message, error)
 }
 
 func (t *Table) Send2Match(msg proto.Message) {
-	if t.MatchType == "trainer" {
+	if t.MatchType == "trainer" || t.App == nil {
 		return
 	}
 	logger.Log.Info(msg)
@@ -504,7 +603,31 @@ func (t *Table) Send2Match(msg proto.Message) {
 	}
 }
 
+// postMatch 通过发件箱发送对局结果，比赛服暂时不可用时重试
+func (t *Table) postMatch(msg proto.Message) {
+	t.postMatchAs(strconv.Itoa(int(t.curGameCount)), msg)
+}
+
+// postMatchAs 通过发件箱发送，这张桌子上id和消息类型都相同的只保留最新一条
+func (t *Table) postMatchAs(id string, msg proto.Message) {
+	if t.MatchType == "trainer" || t.App == nil {
+		return
+	}
+	if err := outbox.Post(t.MatchType, t.matchServerId, t.MatchID, t.tableID, id, msg); err != nil {
+		logger.Log.Errorf("table %d post %v failed: %v", t.tableID, msg, err)
+	}
+}
+
+// Send2Player 发送游戏消息给seat，SeatAll时同时发给观战者
 func (t *Table) Send2Player(ack proto.Message, seat int32) {
+	t.Send2Seat(ack, seat)
+	if seat == SeatAll {
+		t.Send2Observers(ack)
+	}
+}
+
+// Send2Seat 只发送给玩家，自己决定观战者可见内容的游戏用它和Send2Observers
+func (t *Table) Send2Seat(ack proto.Message, seat int32) {
 	logger.Log.Infof("seat: %d ack: %v", seat, utils.JsonMarshal.Format(ack))
 
 	if seat != SeatAll {
@@ -518,33 +641,35 @@ func (t *Table) Send2Player(ack proto.Message, seat int32) {
 }
 
 func (t *Table) sendTableMsg(ack proto.Message, player *Player) {
-	pbData, err := proto.Marshal(ack)
+	msg, err := t.newTableMsg(ack, player)
 	if err != nil {
 		logger.Log.Error(err.Error())
 		return
 	}
-	pbTableMsg := &cproto.TableMsgAck{
-		Msg: pbData,
-	}
-	pbMsg := t.newMsg(pbTableMsg)
-
-	jsonData, err := utils.JsonMarshal.Marshal(ack)
-	if err != nil {
-		logger.Log.Error(err.Error())
-		return
+	t.sendMsg(msg, player)
+	if s, ok := t.game.(ISnapshot); !ok || !s.HasSnapshot() {
+		t.addHisMsg(player.ack.Uid, ack)
 	}
+}
 
-	jsonTableMsg := &cproto.TableMsgAck{
-		Msg: jsonData,
-	}
-	jsonMsg := t.newMsg(jsonTableMsg)
+// newTableMsg 按玩家的连接方式编码游戏消息，websocket用json，其他用protobuf
+func (t *Table) newTableMsg(ack proto.Message, player *Player) (*cproto.GameAck, error) {
+	return t.encodeTableMsg(ack, !player.isBot && utils.IsWebsocket(player.Ctx))
+}
 
-	if !player.isBot && utils.IsWebsocket(player.Ctx) {
-		t.sendMsg(jsonMsg, player)
+// encodeTableMsg 把游戏消息包装成TableMsgAck，json为true时用json编码
+func (t *Table) encodeTableMsg(ack proto.Message, json bool) (*cproto.GameAck, error) {
+	var data []byte
+	var err error
+	if json {
+		data, err = utils.JsonMarshal.Marshal(ack)
 	} else {
-		t.sendMsg(pbMsg, player)
+		data, err = proto.Marshal(ack)
+	}
+	if err != nil {
+		return nil, err
 	}
-	t.addHisMsg(player.ack.Uid, pbMsg, jsonMsg)
+	return t.newMsg(&cproto.TableMsgAck{Msg: data}), nil
 }
 
 func (t *Table) GetLastGameData() any {
@@ -568,6 +693,20 @@ func (t *Table) GetGamePlayer(seat int32) *Player {
 	return nil
 }
 
+// GetClock 桌子使用的时钟，游戏逻辑读时间和设置定时器都要用它
+func (t *Table) GetClock() utils.Clock {
+	return t.clock
+}
+
+// Now 桌子时钟的当前时间
+func (t *Table) Now() time.Time {
+	return t.clock.Now()
+}
+
+func (t *Table) GetTableID() int32 {
+	return t.tableID
+}
+
 func (t *Table) GetPlayerCount() int32 {
 	return t.playerCount
 }
@@ -584,23 +723,51 @@ func (t *Table) GetScoreBase() int64 {
 	return int64(t.scoreBase)
 }
 
+// Tick 推进定时器，到期的回调和OnGameTimer投递到事件循环执行。
+// 调度器会按时推进，Tick只用于测试和离线模拟
 func (t *Table) Tick() {
-	t.checkDissolve()
-
-	if t.gameOverTime != nil {
-		if t.curGameCount >= t.gameCount {
-			t.gameOverTime = nil
-			t.gameOver()
-		} else if t.MatchType == "trainer" || time.Since(*t.gameOverTime) >= 5*time.Second {
-			t.gameOverTime = nil
-			t.checkBegin()
+	t.wheel.Advance()
+}
+
+// scheduleGameTimer 牌局中每GameTimerInterval调用一次OnGameTimer
+func (t *Table) scheduleGameTimer() {
+	t.gameTimer.Stop()
+	t.gameTimer = nil
+	if g, ok := t.game.(ITableTimer); ok && g.UseTableTimer() {
+		return
+	}
+	if !t.playing || t.game == nil {
+		return
+	}
+	t.gameTimer = t.AfterFunc(GameTimerInterval, func() {
+		t.gameTimer = nil
+		if t.playing && t.game != nil {
+			t.game.OnGameTimer()
+			t.scheduleGameTimer()
 		}
+	})
+}
+
+// scheduleNextGame 一局结束NextDelay后开始下一局，打完所有局不等待
+func (t *Table) scheduleNextGame() {
+	delay := time.Duration(0)
+	if t.curGameCount < t.gameCount {
+		delay = max(t.GetReadyPolicy().NextDelay-t.Now().Sub(*t.gameOverTime), 0)
 	}
+	t.nextTimer.Stop()
+	t.nextTimer = t.AfterFunc(delay, t.checkNextGame)
+	t.scheduleReady()
+}
 
-	t.gameMutex.Lock()
-	defer t.gameMutex.Unlock()
-	if t.game != nil {
-		t.game.OnGameTimer()
+func (t *Table) checkNextGame() {
+	if t.gameOverTime == nil {
+		return
+	}
+	t.gameOverTime = nil
+	if t.curGameCount >= t.gameCount {
+		t.gameOver()
+	} else {
+		t.checkBegin()
 	}
 }
 
@@ -610,9 +777,14 @@ func (t *Table) broadcast(ack proto.Message) {
 	for _, player := range t.players {
 		t.sendMsg(msg, player)
 	}
+	t.observe(ack, false)
 }
 
 func (t *Table) sendMsg(msg *cproto.GameAck, player *Player) {
+	if t.localSink != nil {
+		t.localSink(player, msg)
+		return
+	}
 	if player.isBot {
 		// 如果是bot玩家，通过BotManager处理消息
 		botManager.OnBotMessage(player.ack.Uid, msg)
@@ -632,28 +804,33 @@ func (t *Table) sendMsg(msg *cproto.GameAck, player *Player) {
 	}
 }
 
-func (t *Table) addHisMsg(uid string, pbAck, jsonAck *cproto.GameAck) {
-	t.historyMutex.Lock()
-	defer t.historyMutex.Unlock()
-	if _, exists := t.historyMsg[uid]; !exists {
-		t.historyMsg[uid] = make([]*cproto.GameAck, 0)
-		t.historyJsonMsg[uid] = make([]*cproto.GameAck, 0)
-	}
-	t.historyMsg[uid] = append(t.historyMsg[uid], pbAck)
-	t.historyJsonMsg[uid] = append(t.historyJsonMsg[uid], jsonAck)
+func (t *Table) addHisMsg(uid string, ack proto.Message) {
+	t.historyMsg[uid] = append(t.historyMsg[uid], ack)
 }
 
+// sendHisMsges 重连时恢复游戏画面，游戏支持快照时发送快照，否则重发本局的历史消息
 func (t *Table) sendHisMsges(player *Player) {
-	t.historyMutex.Lock()
-	defer t.historyMutex.Unlock()
+	if t.game == nil {
+		return
+	}
 
-	if len(t.historyMsg[player.ack.Uid]) == 0 {
+	var historys []proto.Message
+	if s, ok := t.game.(ISnapshot); ok && s.HasSnapshot() {
+		historys = s.Snapshot(player)
+	} else {
+		historys = slices.Clone(t.historyMsg[player.ack.Uid])
+	}
+	if len(historys) == 0 {
 		return
 	}
 
 	t.sendMsg(t.newMsg(&cproto.HisBeginAck{}), player)
-	historys := t.getHistoryMsg(player)
-	for _, msg := range historys {
+	for _, ack := range historys {
+		msg, err := t.newTableMsg(ack, player)
+		if err != nil {
+			logger.Log.Error(err.Error())
+			continue
+		}
 		t.sendMsg(msg, player)
 	}
 	t.sendMsg(t.newMsg(&cproto.HisEndAck{}), player)
@@ -661,11 +838,3 @@ func (t *Table) sendHisMsges(player *Player) {
 		t.sendMsg(t.newMsg(t.dissovle), player)
 	}
 }
-
-// 辅助函数：获取历史消息
-func (t *Table) getHistoryMsg(player *Player) []*cproto.GameAck {
-	if utils.IsWebsocket(player.Ctx) {
-		return t.historyJsonMsg[player.ack.Uid]
-	}
-	return t.historyMsg[player.ack.Uid]
-}
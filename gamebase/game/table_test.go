@@ -0,0 +1,369 @@
+package game_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kevin-chtw/tw_common/apptest"
+	"github.com/kevin-chtw/tw_common/gamebase/game"
+	"github.com/kevin-chtw/tw_common/utils"
+	"github.com/kevin-chtw/tw_proto/cproto"
+	"github.com/kevin-chtw/tw_proto/sproto"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// countGame 不加锁地计数，并发调用时由-race发现
+type countGame struct {
+	table  *game.Table
+	id     int32
+	msgs   int
+	timers int
+	nets   int
+	report chan [3]int
+}
+
+func (g *countGame) OnGameBegin() {}
+
+// OnPlayerMsg 消息为0时在事件循环里报告计数，为2时结束本局
+func (g *countGame) OnPlayerMsg(player *game.Player, data []byte) error {
+	switch data[0] {
+	case 0:
+		g.report <- [3]int{g.msgs, g.timers, g.nets}
+	case 2:
+		g.table.NotifyGameOver(g.id, "")
+	default:
+		g.msgs++
+	}
+	return nil
+}
+
+func (g *countGame) OnGameTimer()                                  { g.timers++ }
+func (g *countGame) OnNetChange(player *game.Player, offline bool) { g.nets++ }
+
+func newGameReq(t *testing.T, tableID int32, msg proto.Message) *cproto.GameReq {
+	data, err := anypb.New(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &cproto.GameReq{Matchid: 1, Tableid: tableID, Req: data}
+}
+
+// panicGame 网络状态变化时panic
+type panicGame struct {
+	countGame
+}
+
+func (g *panicGame) OnNetChange(player *game.Player, offline bool) { panic("net change") }
+
+// startTestTable 创建两局的桌子并开始第一局
+func startTestTable(t *testing.T, tableID int32, uids ...string) (*game.Table, []*game.Player) {
+	req := &sproto.AddTableReq{MatchType: "normal", PlayerCount: int32(len(uids)), GameCount: 2}
+	return startTableWith(t, tableID, req, uids...)
+}
+
+// startTableWith 用req创建桌子，玩家进入后开始第一局
+func startTableWith(t *testing.T, tableID int32, req *sproto.AddTableReq, uids ...string) (*game.Table, []*game.Player) {
+	ctx := context.Background()
+	table := game.GetTableManager().LoadOrStore(1, tableID)
+	// 结束时关闭桌子，牌局定时器不会影响之后的测试
+	t.Cleanup(func() { table.HandleCancelTable(ctx, &sproto.CancelTableReq{}) })
+	if _, err := table.HandleAddTable(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i, uid := range uids {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := table.HandleAddPlayer(ctx, &sproto.AddPlayerReq{Playerid: uid, Seat: int32(i)}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	players := make([]*game.Player, len(uids))
+	for i, uid := range uids {
+		players[i] = game.GetPlayerManager().Get(false, uid)
+		if err := players[i].HandleMessage(ctx, newGameReq(t, tableID, &cproto.EnterGameReq{})); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return table, players
+}
+
+func TestTableEventLoop(t *testing.T) {
+	clock := utils.NewManualClock(time.Unix(1000, 0))
+	game.SetClock(clock)
+	t.Cleanup(func() { game.SetClock(utils.SystemClock) })
+	var g *countGame
+	game.Init(apptest.NewApp("game"), func(*game.Table, int32) game.IGame {
+		g = &countGame{report: make(chan [3]int, 1)}
+		return g
+	}, nil)
+
+	const tableID = 1
+	uids := []string{"p0", "p1"}
+	table, players := startTestTable(t, tableID, uids...)
+	if g == nil {
+		t.Fatal("game not started")
+	}
+
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	const count = 200
+	for i, player := range players {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			for range count {
+				if err := table.OnPlayerMsg(player, newGameReq(t, tableID, &cproto.TableMsgReq{Msg: []byte{1}})); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for range count {
+				clock.Advance(game.GameTimerInterval)
+				table.Tick()
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := range count {
+				req := &sproto.NetStateReq{Uid: uids[i], Online: j%2 == 1}
+				if _, err := table.HandleNetState(ctx, req); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// 之前投递的事件都已处理完
+	if err := table.OnPlayerMsg(players[0], newGameReq(t, tableID, &cproto.TableMsgReq{Msg: []byte{0}})); err != nil {
+		t.Fatal(err)
+	}
+	counts := <-g.report
+	if counts[0] != count*len(players) {
+		t.Errorf("msgs = %d, want %d", counts[0], count*len(players))
+	}
+	if counts[1] == 0 {
+		t.Error("timer not called")
+	}
+	if counts[2] != count*len(players) {
+		t.Errorf("nets = %d, want %d", counts[2], count*len(players))
+	}
+
+	if _, err := table.HandleCancelTable(ctx, &sproto.CancelTableReq{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := table.HandleNetState(ctx, &sproto.NetStateReq{Uid: uids[0]}); err == nil {
+		t.Error("table should be closed")
+	}
+}
+
+// netGame 记录OnNetChange收到的offline参数
+type netGame struct {
+	countGame
+	offline []bool
+}
+
+func (g *netGame) OnNetChange(player *game.Player, offline bool) {
+	g.offline = append(g.offline, offline)
+}
+
+func TestTableNetState(t *testing.T) {
+	var g *netGame
+	game.Init(apptest.NewApp("game"), func(*game.Table, int32) game.IGame {
+		g = &netGame{}
+		return g
+	}, nil)
+	table, _ := startTestTable(t, 1, "p0", "p1")
+
+	// 比赛服发送的是online，游戏收到的是offline
+	ctx := context.Background()
+	for _, online := range []bool{false, true} {
+		if _, err := table.HandleNetState(ctx, &sproto.NetStateReq{Uid: "p0", Online: online}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := table.HandleNetState(ctx, &sproto.NetStateReq{Uid: "p0", Online: true}); err == nil {
+		t.Error("unchanged net state accepted")
+	}
+	if len(g.offline) != 2 || !g.offline[0] || g.offline[1] {
+		t.Errorf("offline = %v", g.offline)
+	}
+}
+
+func TestTableQuarantine(t *testing.T) {
+	game.Init(apptest.NewApp("game"), func(table *game.Table, _ int32) game.IGame {
+		if table.GetTableID() == 1 {
+			return &panicGame{}
+		}
+		return &countGame{report: make(chan [3]int, 1)}
+	}, nil)
+
+	ctx := context.Background()
+	bad, _ := startTestTable(t, 1, "p0", "p1")
+	good, players := startTestTable(t, 2, "p2", "p3")
+	if _, err := bad.HandleNetState(ctx, &sproto.NetStateReq{Uid: "p0"}); err == nil {
+		t.Fatal("panic should return error")
+	}
+	if game.GetTableManager().Get(1, 1) != nil {
+		t.Error("quarantined table not removed")
+	}
+	if game.GetPlayerManager().Get(false, "p0") != nil {
+		t.Error("players of quarantined table not removed")
+	}
+	incidents := game.GetIncidents()
+	last := incidents[len(incidents)-1]
+	if last.TableID != 1 || last.Panic != "net change" || len(last.Players) != 2 || last.Stack == "" {
+		t.Errorf("incident = %+v", last)
+	}
+
+	// 其他桌子不受影响
+	good.Tick()
+	if _, err := good.HandleNetState(ctx, &sproto.NetStateReq{Uid: "p2"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := good.OnPlayerMsg(players[0], newGameReq(t, 2, &cproto.TableMsgReq{Msg: []byte{1}})); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// waitTable 等待之前投递给桌子的事件处理完
+func waitTable(table *game.Table) {
+	table.HandleNetState(context.Background(), &sproto.NetStateReq{})
+}
+
+func TestTableTimeout(t *testing.T) {
+	clock := utils.NewManualClock(time.Unix(1000, 0))
+	game.SetClock(clock)
+	t.Cleanup(func() { game.SetClock(utils.SystemClock) })
+	var games []*countGame
+	game.Init(apptest.NewApp("game"), func(table *game.Table, id int32) game.IGame {
+		g := &countGame{table: table, id: id}
+		games = append(games, g)
+		return g
+	}, nil)
+
+	table, players := startTestTable(t, 1, "p0", "p1")
+	if err := table.OnPlayerMsg(players[0], newGameReq(t, 1, &cproto.TableMsgReq{Msg: []byte{2}})); err != nil {
+		t.Fatal(err)
+	}
+
+	// 一局结束5秒后开始下一局
+	clock.Advance(4 * time.Second)
+	table.Tick()
+	waitTable(table)
+	if len(games) != 1 {
+		t.Fatalf("next game started after 4s")
+	}
+	clock.Advance(time.Second)
+	table.Tick()
+	waitTable(table)
+	if len(games) != 2 {
+		t.Fatalf("next game not started after 5s")
+	}
+
+	// 解散投票5分钟后超时生效
+	if err := table.OnPlayerMsg(players[0], newGameReq(t, 1, &cproto.GameDissolveReq{Agree: true})); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(4 * time.Minute)
+	table.Tick()
+	waitTable(table)
+	if game.GetTableManager().Get(1, 1) == nil {
+		t.Fatal("table dissolved before timeout")
+	}
+	clock.Advance(time.Minute + time.Second)
+	table.Tick()
+	waitTable(table)
+	if game.GetTableManager().Get(1, 1) != nil {
+		t.Fatal("table not dissolved after timeout")
+	}
+}
+
+func TestTableAfterFunc(t *testing.T) {
+	clock := utils.NewManualClock(time.Unix(1000, 0))
+	table := game.NewLocalTable(1, 1, "normal", 2, "", 1)
+	table.SetClock(clock)
+
+	var fired []string
+	table.AfterFunc(250*time.Millisecond, func() { fired = append(fired, "250ms") })
+	table.AfterFunc(90*time.Second, func() { fired = append(fired, "90s") })
+	table.AfterFunc(2*time.Hour, func() { fired = append(fired, "2h") })
+	stopped := table.AfterFunc(time.Second, func() { fired = append(fired, "stopped") })
+	stopped.Stop()
+
+	advance := func(d time.Duration) {
+		clock.Advance(d)
+		table.Tick()
+	}
+	advance(200 * time.Millisecond)
+	if len(fired) != 0 {
+		t.Fatalf("fired early: %v", fired)
+	}
+	advance(50 * time.Millisecond)
+	if len(fired) != 1 {
+		t.Fatalf("250ms timer not fired: %v", fired)
+	}
+	advance(90*time.Second - 300*time.Millisecond)
+	if len(fired) != 1 {
+		t.Fatalf("90s timer fired early: %v", fired)
+	}
+	advance(50 * time.Millisecond)
+	if len(fired) != 2 {
+		t.Fatalf("90s timer not fired: %v", fired)
+	}
+	advance(2*time.Hour - 90*time.Second)
+	if len(fired) != 3 || fired[2] != "2h" {
+		t.Fatalf("fired = %v", fired)
+	}
+}
+
+func TestTableGameTimer(t *testing.T) {
+	clock := utils.NewManualClock(time.Unix(1000, 0))
+	game.SetClock(clock)
+	t.Cleanup(func() { game.SetClock(utils.SystemClock) })
+	var g *countGame
+	game.Init(apptest.NewApp("game"), func(table *game.Table, id int32) game.IGame {
+		g = &countGame{table: table, id: id, report: make(chan [3]int, 1)}
+		return g
+	}, nil)
+
+	table, players := startTestTable(t, 1, "t0", "t1")
+	timers := func() int {
+		t.Helper()
+		if err := players[0].HandleMessage(t.Context(), newGameReq(t, 1, &cproto.TableMsgReq{Msg: []byte{0}})); err != nil {
+			t.Fatal(err)
+		}
+		return (<-g.report)[1]
+	}
+
+	// 牌局中每GameTimerInterval调用一次
+	for range 3 {
+		clock.Advance(game.GameTimerInterval)
+		table.Tick()
+		waitTable(table)
+	}
+	if n := timers(); n != 3 {
+		t.Fatalf("timers = %d, want 3", n)
+	}
+
+	// 一局结束后不再调用
+	if err := players[0].HandleMessage(t.Context(), newGameReq(t, 1, &cproto.TableMsgReq{Msg: []byte{2}})); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(game.GameTimerInterval)
+	table.Tick()
+	if n := timers(); n != 3 {
+		t.Errorf("timers after game over = %d, want 3", n)
+	}
+}
@@ -1,10 +1,9 @@
 package game
 
 import (
-	"runtime/debug"
 	"strconv"
+	"strings"
 	"sync"
-	"time"
 
 	"github.com/kevin-chtw/tw_proto/cproto"
 	pitaya "github.com/topfreegames/pitaya/v3/pkg"
@@ -17,7 +16,7 @@ type TableManager struct {
 	mu     sync.RWMutex
 	tables map[string]*Table // tableID -> Table
 	app    pitaya.Pitaya
-	ticker *time.Ticker
+	drain  drainState
 }
 
 // NewTableManager 创建游戏桌管理器
@@ -25,35 +24,10 @@ func NewTableManager(app pitaya.Pitaya) *TableManager {
 	t := &TableManager{
 		tables: make(map[string]*Table),
 		app:    app,
-		ticker: time.NewTicker(time.Second),
 	}
-	go func() {
-		defer func() {
-			if r := recover(); r != nil {
-				logger.Log.Errorf("panic recovered %s\n %s", r, string(debug.Stack()))
-			}
-		}()
-		for range t.ticker.C {
-			t.tick()
-		}
-	}()
-
 	return t
 }
 
-func (t *TableManager) tick() {
-	t.mu.RLock()
-	tables := make([]*Table, 0, len(t.tables))
-	for _, table := range t.tables {
-		tables = append(tables, table)
-	}
-	t.mu.RUnlock()
-
-	for _, table := range tables {
-		table.Tick()
-	}
-}
-
 // GetTable 获取指定比赛和桌号的游戏桌
 func (t *TableManager) Get(matchID, tableID int32) *Table {
 	t.mu.RLock()
@@ -76,16 +50,67 @@ func (t *TableManager) LoadOrStore(matchId, tableId int32) *Table {
 
 	// 创建新表
 	table := NewTable(matchId, tableId, t.app)
+	table.start()
 	t.tables[key] = table
 	return table
 }
 
+// Restore 启动时从检查点恢复游戏桌，需要在SetTableStore之后调用
+func (t *TableManager) Restore() error {
+	if tableStore == nil {
+		return nil
+	}
+	datas, err := tableStore.LoadAll()
+	if err != nil {
+		return err
+	}
+	journals := make(map[string][][]byte)
+	for key, data := range datas {
+		table, seq, ok := parseJournalKey(key)
+		if !ok {
+			continue
+		}
+		segments := journals[table]
+		for len(segments) <= seq {
+			segments = append(segments, nil)
+		}
+		segments[seq] = data
+		journals[table] = segments
+	}
+	for key, data := range datas {
+		var matchID, tableID int32
+		if !parseTableKey(key, &matchID, &tableID) {
+			if _, _, ok := parseJournalKey(key); !ok {
+				logger.Log.Errorf("invalid table checkpoint key %s", key)
+			}
+			continue
+		}
+		journal := journals[key]
+		table := t.LoadOrStore(matchID, tableID)
+		if err := table.callErr(func() error { return table.restore(data, journal) }); err != nil {
+			logger.Log.Errorf("restore table %s failed: %v", key, err)
+			for _, p := range table.players {
+				playerManager.Delete(p.isBot, p.ack.Uid)
+			}
+			t.Delete(matchID, tableID)
+			continue
+		}
+		logger.Log.Infof("table %s restored", key)
+	}
+	return nil
+}
+
 func (t *TableManager) OnBotMsg(uid string, msg proto.Message) {
 	req := msg.(*cproto.GameReq)
 	table := t.Get(req.Matchid, req.Tableid)
 	player := playerManager.Get(true, uid)
 	if table != nil && player != nil {
-		table.OnPlayerMsg(player, req)
+		// bot消息异步处理，避免和发给bot的消息互相等待
+		table.post(func() {
+			if err := table.onPlayerMsg(player, req); err != nil {
+				logger.Log.Error(err.Error())
+			}
+		})
 	}
 }
 
@@ -93,7 +118,41 @@ func (t *TableManager) OnBotMsg(uid string, msg proto.Message) {
 func (t *TableManager) Delete(matchID, tableID int32) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
-	delete(t.tables, getTableKey(matchID, tableID))
+	key := getTableKey(matchID, tableID)
+	if table, ok := t.tables[key]; ok {
+		table.stop()
+		delete(t.tables, key)
+	}
+	t.checkDrained()
+}
+
+// parseTableKey 解析getTableKey生成的key
+func parseTableKey(key string, matchID, tableID *int32) bool {
+	parts := strings.Split(key, ":")
+	if len(parts) != 2 {
+		return false
+	}
+	m, err1 := strconv.ParseInt(parts[0], 10, 32)
+	id, err2 := strconv.ParseInt(parts[1], 10, 32)
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	*matchID, *tableID = int32(m), int32(id)
+	return true
+}
+
+// parseJournalKey 解析getJournalKey生成的key，返回桌子的key和段号
+func parseJournalKey(key string) (string, int, bool) {
+	i := strings.LastIndex(key, ":j")
+	if i < 0 {
+		return "", 0, false
+	}
+	var matchID, tableID int32
+	seq, err := strconv.Atoi(key[i+2:])
+	if err != nil || seq < 0 || !parseTableKey(key[:i], &matchID, &tableID) {
+		return "", 0, false
+	}
+	return key[:i], seq, true
 }
 
 func getTableKey(matchID, tableID int32) string {
@@ -0,0 +1,55 @@
+package game
+
+import (
+	"time"
+
+	"github.com/kevin-chtw/tw_common/utils"
+)
+
+// schedulerTick 桌子定时器的精度
+const schedulerTick = 50 * time.Millisecond
+
+// TableTimer 桌子定时器，回调在桌子的事件循环里执行
+type TableTimer struct {
+	table   *Table
+	task    *utils.WheelTimer
+	stopped bool
+}
+
+// AfterFunc d之后在桌子的事件循环里执行fn，需要在事件循环内调用。
+// 桌子只在有定时任务时占用调度器，空闲的桌子没有开销
+func (t *Table) AfterFunc(d time.Duration, fn func()) *TableTimer {
+	return t.afterFunc(d, func() {
+		fn()
+		t.markDirty()
+		t.checkpoint()
+	})
+}
+
+// afterFunc 和AfterFunc一样，回调之后不保存检查点
+func (t *Table) afterFunc(d time.Duration, fn func()) *TableTimer {
+	timer := &TableTimer{table: t}
+	run := func() {
+		if timer.stopped {
+			return
+		}
+		timer.stopped = true
+		fn()
+	}
+	timer.task = t.wheel.AfterFunc(d, func() {
+		// 调度器不能等待，队列满时另起goroutine投递
+		if !t.tryPost(run) {
+			go t.post(run)
+		}
+	})
+	return timer
+}
+
+// Stop 取消定时器，需要在事件循环内调用，已经投递到队列的回调也不会执行
+func (tt *TableTimer) Stop() {
+	if tt == nil || tt.stopped {
+		return
+	}
+	tt.stopped = true
+	tt.table.wheel.Stop(tt.task)
+}
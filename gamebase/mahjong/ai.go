@@ -0,0 +1,263 @@
+package mahjong
+
+import (
+	"maps"
+	"slices"
+
+	"github.com/kevin-chtw/tw_proto/game/pbmj"
+	"google.golang.org/protobuf/proto"
+)
+
+// AIView AI决策能看到的牌局，托管时用Play.NewAIView生成，bot根据收到的消息自己维护
+type AIView struct {
+	Hand       []Tile       // 手牌，轮到自己出牌时包含刚摸的牌
+	Visible    map[Tile]int // 手牌之外看得到的牌：所有人的出牌和副露
+	Pons       []Tile       // 自己碰的牌，用于补杠
+	Lai        []Tile       // 赖子
+	AllTiles   map[Tile]int // 牌库
+	ExtraTypes []MJTingType // 玩法支持的特殊牌型，默认取Conf.TingTypes
+	Conf       *PlayConf
+}
+
+// AIDecision AI的决定
+type AIDecision struct {
+	Operate  int  // OperateXxx
+	Tile     Tile // 出牌、听牌、杠的牌
+	LeftTile Tile // 吃牌时最左边的牌
+}
+
+// NewAIView 从Play生成seat的视角
+func (p *Play) NewAIView(seat int32) *AIView {
+	view := &AIView{
+		Hand:       slices.Clone(p.playData[seat].handTiles),
+		Visible:    make(map[Tile]int),
+		Lai:        slices.Collect(maps.Keys(p.tilesLai)),
+		AllTiles:   Service.GetAllTiles(p.game.rule),
+		ExtraTypes: p.PlayConf.TingTypes(),
+		Conf:       p.PlayConf,
+	}
+	for _, data := range p.playData {
+		for _, tile := range data.outTiles {
+			view.Visible[tile]++
+		}
+		for _, group := range data.ponGroups {
+			view.Visible[group.Tile] += 3
+		}
+		for _, group := range data.konGroups {
+			view.Visible[group.Tile] += 4
+		}
+		for _, group := range data.chowGroups {
+			color, point := group.LeftTile.Info()
+			for i := range 3 {
+				view.Visible[MakeTile(color, point+i)]++
+			}
+		}
+	}
+	for _, group := range p.playData[seat].ponGroups {
+		view.Pons = append(view.Pons, group.Tile)
+	}
+	return view
+}
+
+// NewBotAIView bot使用的视角，之后用Apply根据收到的消息更新
+func NewBotAIView(allTiles map[Tile]int, conf *PlayConf) *AIView {
+	return &AIView{
+		Hand:       make([]Tile, 0),
+		Visible:    make(map[Tile]int),
+		AllTiles:   allTiles,
+		ExtraTypes: conf.TingTypes(),
+		Conf:       conf,
+	}
+}
+
+// Apply 根据self收到的消息更新视角
+func (v *AIView) Apply(self int32, msg proto.Message) {
+	switch ack := msg.(type) {
+	case *pbmj.MJOpenDoorAck:
+		if ack.Seat == self {
+			v.Hand = Int32Tile(ack.Tiles)
+		}
+	case *pbmj.MJDrawAck:
+		if ack.Seat == self && Tile(ack.Tile).IsValid() {
+			v.Hand = append(v.Hand, Tile(ack.Tile))
+		}
+	case *pbmj.MJDiscardAck:
+		v.discard(self, ack.Seat, Tile(ack.Tile))
+	case *pbmj.MJTingAck:
+		v.discard(self, ack.Seat, Tile(ack.Tile))
+	case *pbmj.MJPonAck:
+		v.meld(self, ack.Seat, Tile(ack.Tile), 2)
+		if ack.Seat == self {
+			v.Pons = append(v.Pons, Tile(ack.Tile))
+		}
+	case *pbmj.MJKonAck:
+		tile := Tile(ack.Tile)
+		switch KonType(ack.KonType) {
+		case KonTypeAn:
+			v.meld(self, ack.Seat, tile, 4)
+		case KonTypeBu:
+			v.meld(self, ack.Seat, tile, 1)
+			if ack.Seat == self {
+				v.Pons = RemoveElements(v.Pons, tile, 1)
+			}
+		default:
+			v.meld(self, ack.Seat, tile, 3)
+		}
+	case *pbmj.MJChowAck:
+		color, point := Tile(ack.LeftTile).Info()
+		for i := range 3 {
+			if tile := MakeTile(color, point+i); tile != Tile(ack.Tile) {
+				v.meld(self, ack.Seat, tile, 1)
+			}
+		}
+	}
+}
+
+func (v *AIView) discard(self, seat int32, tile Tile) {
+	v.Visible[tile]++
+	if seat == self {
+		v.Hand = RemoveElements(v.Hand, tile, 1)
+	}
+}
+
+// meld seat从手牌拿出count张tile组成副露
+func (v *AIView) meld(self, seat int32, tile Tile, count int) {
+	if !tile.IsValid() {
+		return
+	}
+	v.Visible[tile] += count
+	if seat == self {
+		v.Hand = RemoveElements(v.Hand, tile, count)
+	}
+}
+
+// AIDecide 托管或超时时替seat做决定，opt为发给玩家的可选操作
+func (p *Play) AIDecide(seat int32, opt *Operates) *AIDecision {
+	return p.NewAIView(seat).Decide(opt.Value, p.curTile)
+}
+
+// Decide 根据请求的可选操作做决定，能胡就胡，operates为MJRequestAck.RequestType，curTile为别人打出的牌
+func (v *AIView) Decide(operates int32, curTile Tile) *AIDecision {
+	if operates&OperateHu != 0 {
+		return &AIDecision{Operate: OperateHu, Tile: curTile}
+	}
+	if operates&OperateDiscard != 0 {
+		return v.decideSelf(operates)
+	}
+	return v.decideWait(operates, curTile)
+}
+
+// BestDiscard 向听数最小、有效牌最多的出牌
+func (v *AIView) BestDiscard() Tile {
+	if c := v.bestDiscard(v.Hand); c != nil {
+		return c.Tile
+	}
+	return TileNull
+}
+
+func (v *AIView) decideSelf(operates int32) *AIDecision {
+	best := v.bestDiscard(v.Hand)
+	if best == nil {
+		return &AIDecision{Operate: OperateDiscard, Tile: TileNull}
+	}
+	if operates&OperateKon != 0 {
+		for _, tile := range v.selfKonTiles() {
+			// 杠完补牌前的手牌和打完牌一样处于等牌状态
+			rest := RemoveElements(v.Hand, tile, 4)
+			if v.shanten(rest) <= best.Shanten {
+				return &AIDecision{Operate: OperateKon, Tile: tile}
+			}
+		}
+	}
+	if operates&(OperateTing|OperateTianTing) != 0 && best.Shanten == 0 {
+		return &AIDecision{Operate: int(operates & (OperateTing | OperateTianTing)), Tile: best.Tile}
+	}
+	return &AIDecision{Operate: OperateDiscard, Tile: best.Tile}
+}
+
+func (v *AIView) decideWait(operates int32, curTile Tile) *AIDecision {
+	cur := v.shanten(v.Hand)
+	if operates&OperateKon != 0 && !v.isLai(curTile) {
+		if v.shanten(RemoveElements(v.Hand, curTile, 3)) <= cur {
+			return &AIDecision{Operate: OperateKon, Tile: curTile}
+		}
+	}
+
+	// 碰吃之后还要出一张牌，比较出牌后的向听数
+	var best *AIDecision
+	var bestChoice *DiscardAdvice
+	if operates&OperatePon != 0 {
+		if c := v.bestDiscard(RemoveElements(v.Hand, curTile, 2)); c != nil && c.Shanten < cur {
+			best, bestChoice = &AIDecision{Operate: OperatePon, Tile: curTile}, c
+		}
+	}
+	if operates&OperateChow != 0 {
+		for _, left := range v.chowLeftTiles(curTile) {
+			rest, _ := v.removeChow(curTile, left)
+			if c := v.bestDiscard(rest); c != nil && c.Shanten < cur && c.better(bestChoice) {
+				best, bestChoice = &AIDecision{Operate: OperateChow, Tile: curTile, LeftTile: left}, c
+			}
+		}
+	}
+	if best != nil {
+		return best
+	}
+	return &AIDecision{Operate: OperatePass, Tile: curTile}
+}
+
+// chowLeftTiles 手牌能和curTile组成顺子时最左边的牌
+func (v *AIView) chowLeftTiles(curTile Tile) []Tile {
+	lefts := make([]Tile, 0)
+	if !curTile.IsSuit() {
+		return lefts
+	}
+	color, point := curTile.Info()
+	for left := max(point-2, 0); left <= point && left <= 6; left++ {
+		if _, ok := v.removeChow(curTile, MakeTile(color, left)); ok {
+			lefts = append(lefts, MakeTile(color, left))
+		}
+	}
+	return lefts
+}
+
+// removeChow 从手牌去掉和curTile组成顺子的两张牌
+func (v *AIView) removeChow(curTile, leftTile Tile) ([]Tile, bool) {
+	rest := slices.Clone(v.Hand)
+	color, point := leftTile.Info()
+	for i := range 3 {
+		tile := MakeTile(color, point+i)
+		if tile == curTile {
+			continue
+		}
+		if !slices.Contains(rest, tile) {
+			return nil, false
+		}
+		rest = RemoveElements(rest, tile, 1)
+	}
+	return rest, true
+}
+
+func (v *AIView) selfKonTiles() []Tile {
+	tiles := make([]Tile, 0)
+	for _, tile := range v.uniqueTiles(v.Hand) {
+		if v.isLai(tile) {
+			continue
+		}
+		if CountElement(v.Hand, tile) == 4 || slices.Contains(v.Pons, tile) {
+			tiles = append(tiles, tile)
+		}
+	}
+	return tiles
+}
+
+// bestDiscard 推荐的出牌，手牌为空时返回nil
+func (v *AIView) bestDiscard(hand []Tile) *DiscardAdvice {
+	if result := v.analyzeDiscards(hand); len(result) > 0 {
+		return result[0]
+	}
+	return nil
+}
+
+func (v *AIView) uniqueTiles(tiles []Tile) []Tile {
+	return slices.Compact(slices.Sorted(slices.Values(tiles)))
+}
@@ -0,0 +1,99 @@
+package mahjong_test
+
+import (
+	"testing"
+
+	"github.com/kevin-chtw/tw_common/gamebase/mahjong"
+	"github.com/kevin-chtw/tw_proto/game/pbmj"
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	east  = mahjong.MakeTile(mahjong.ColorWind, 0)
+	south = mahjong.MakeTile(mahjong.ColorWind, 1)
+	west  = mahjong.MakeTile(mahjong.ColorWind, 2)
+)
+
+func newAIView(hand ...mahjong.Tile) *mahjong.AIView {
+	all := make(map[mahjong.Tile]int)
+	for p := range 9 {
+		all[mahjong.MakeTile(mahjong.ColorCharacter, p)] = 4
+	}
+	for p := range 4 {
+		all[mahjong.MakeTile(mahjong.ColorWind, p)] = 4
+	}
+	return &mahjong.AIView{
+		Hand:     hand,
+		Visible:  make(map[mahjong.Tile]int),
+		AllTiles: all,
+		Conf:     &mahjong.PlayConf{},
+	}
+}
+
+func TestAIDiscard(t *testing.T) {
+	// 打1听369，打9听147，由剩余张数决定
+	hand := append(mahjong.Int32Tile(w(1, 2, 3, 4, 5, 6, 7, 8, 9, 1, 9)), east, east, east)
+	view := newAIView(hand...)
+	view.Visible[mahjong.Tile(w(1)[0])] = 2
+	if tile := view.BestDiscard(); tile != mahjong.Tile(w(1)[0]) {
+		t.Errorf("discard %s, want 1", tile.Name())
+	}
+	view.Visible = map[mahjong.Tile]int{mahjong.Tile(w(9)[0]): 2}
+	if tile := view.BestDiscard(); tile != mahjong.Tile(w(9)[0]) {
+		t.Errorf("discard %s, want 9", tile.Name())
+	}
+
+	// 孤张字牌先打，赖子不可打出时留着
+	view = newAIView(append(mahjong.Int32Tile(w(1, 1, 1, 2, 3, 4, 5, 6, 7, 8, 9, 9, 5)), south)...)
+	if tile := view.BestDiscard(); tile != south {
+		t.Errorf("discard %s, want south", tile.Name())
+	}
+	view.Lai = []mahjong.Tile{south}
+	view.Conf.CanotDiscardLai = true
+	if d := view.Decide(mahjong.OperateDiscard, mahjong.TileNull); d.Operate != mahjong.OperateDiscard || d.Tile == south {
+		t.Errorf("decision = %+v", d)
+	}
+}
+
+func TestAIDecide(t *testing.T) {
+	view := newAIView(append(mahjong.Int32Tile(w(1, 2, 3, 4, 5, 6, 7, 8, 9)), east, east, south, west)...)
+	if d := view.Decide(mahjong.OperatePass|mahjong.OperateHu|mahjong.OperatePon, east); d.Operate != mahjong.OperateHu {
+		t.Errorf("decision = %+v, want hu", d)
+	}
+	// 碰东后打一张就听牌
+	if d := view.Decide(mahjong.OperatePass|mahjong.OperatePon, east); d.Operate != mahjong.OperatePon {
+		t.Errorf("decision = %+v, want pon", d)
+	}
+
+	// 已经听牌，碰了也不会更好
+	view = newAIView(append(mahjong.Int32Tile(w(1, 2, 3, 4, 5, 6, 7, 8, 9, 5, 5)), east, east)...)
+	if d := view.Decide(mahjong.OperatePass|mahjong.OperatePon, mahjong.Tile(w(5)[0])); d.Operate != mahjong.OperatePass {
+		t.Errorf("decision = %+v, want pass", d)
+	}
+	// 暗杠不影响听牌
+	view = newAIView(append(mahjong.Int32Tile(w(1, 2, 3, 4, 5, 6, 7, 8, 9, 5)), east, east, east, east)...)
+	if d := view.Decide(mahjong.OperateDiscard|mahjong.OperateKon, mahjong.TileNull); d.Operate != mahjong.OperateKon || d.Tile != east {
+		t.Errorf("decision = %+v, want kon east", d)
+	}
+}
+
+func TestAIViewApply(t *testing.T) {
+	view := mahjong.NewBotAIView(newAIView().AllTiles, &mahjong.PlayConf{})
+	acks := []proto.Message{
+		&pbmj.MJOpenDoorAck{Seat: 0, Tiles: w(1, 1, 2, 3, 4, 5, 6, 7, 8, 9, 9, 9, 5)},
+		&pbmj.MJDiscardAck{Seat: 1, Tile: w(1)[0]},
+		&pbmj.MJPonAck{Seat: 0, From: 1, Tile: w(1)[0]},
+		&pbmj.MJDiscardAck{Seat: 0, Tile: w(5)[0]},
+		&pbmj.MJDrawAck{Seat: 1, Tile: mahjong.TileNull.ToInt32()},
+		&pbmj.MJChowAck{Seat: 1, From: 0, Tile: w(5)[0], LeftTile: w(4)[0]},
+	}
+	for _, ack := range acks {
+		view.Apply(0, ack)
+	}
+	if len(view.Hand) != 10 || len(view.Pons) != 1 {
+		t.Errorf("hand = %s pons = %v", mahjong.TilesName(view.Hand), view.Pons)
+	}
+	if view.Visible[mahjong.Tile(w(1)[0])] != 3 || view.Visible[mahjong.Tile(w(5)[0])] != 1 || view.Visible[mahjong.Tile(w(6)[0])] != 1 {
+		t.Errorf("visible = %v", view.Visible)
+	}
+}
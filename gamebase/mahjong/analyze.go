@@ -0,0 +1,116 @@
+package mahjong
+
+import (
+	"maps"
+	"slices"
+)
+
+// EffectiveTile 有效牌，摸到后能减少向听数
+type EffectiveTile struct {
+	Tile   Tile
+	Unseen int // 还没看到的张数
+}
+
+// DiscardAdvice 打出一张牌后的手牌分析
+type DiscardAdvice struct {
+	Tile      Tile // 打出的牌，分析不出牌的手牌时为TileNull
+	Shanten   int  // 向听数，听牌为0
+	Effective []EffectiveTile
+	Total     int // 有效牌剩余总张数
+}
+
+// better 向听数小的好，其次有效牌多的好，其他相同时先打字牌、幺九
+func (a *DiscardAdvice) better(o *DiscardAdvice) bool {
+	if o == nil {
+		return true
+	}
+	if a.Shanten != o.Shanten {
+		return a.Shanten < o.Shanten
+	}
+	if a.Total != o.Total {
+		return a.Total > o.Total
+	}
+	return discardPriority(a.Tile) > discardPriority(o.Tile)
+}
+
+// discardPriority 其他条件相同时先打字牌，再打幺九
+func discardPriority(tile Tile) int {
+	switch {
+	case tile.IsHonor():
+		return 2
+	case tile.IsSuit() && (tile.Point() == 0 || tile.Point() == 8):
+		return 1
+	default:
+		return 0
+	}
+}
+
+// AnalyzeDiscards 分析seat打出每一种手牌后的向听数和有效牌，推荐的出牌排在前面
+func (p *Play) AnalyzeDiscards(seat int32) []*DiscardAdvice {
+	return p.NewAIView(seat).AnalyzeDiscards()
+}
+
+// AnalyzeDiscards 分析打出每一种手牌后的向听数和有效牌，推荐的出牌排在前面。
+// 赖子不可打出时不分析赖子，除非手里只有赖子
+func (v *AIView) AnalyzeDiscards() []*DiscardAdvice {
+	return v.analyzeDiscards(v.Hand)
+}
+
+// Analyze 分析不出牌的手牌，一般是别人出牌时的13张
+func (v *AIView) Analyze() *DiscardAdvice {
+	return v.analyze(TileNull, v.Hand, v.Hand)
+}
+
+func (v *AIView) analyzeDiscards(hand []Tile) []*DiscardAdvice {
+	candidates := slices.Compact(slices.Sorted(slices.Values(hand)))
+	if v.Conf != nil && v.Conf.CanotDiscardLai {
+		if rest := slices.DeleteFunc(slices.Clone(candidates), v.isLai); len(rest) > 0 {
+			candidates = rest
+		}
+	}
+
+	result := make([]*DiscardAdvice, 0, len(candidates))
+	for _, tile := range candidates {
+		result = append(result, v.analyze(tile, RemoveElements(hand, tile, 1), hand))
+	}
+	slices.SortStableFunc(result, func(a, b *DiscardAdvice) int {
+		if a.better(b) {
+			return -1
+		}
+		if b.better(a) {
+			return 1
+		}
+		return 0
+	})
+	return result
+}
+
+// analyze seen中的牌和Visible都算已经看到
+func (v *AIView) analyze(discard Tile, tiles, seen []Tile) *DiscardAdvice {
+	advice := &DiscardAdvice{
+		Tile:      discard,
+		Shanten:   v.shanten(tiles),
+		Effective: make([]EffectiveTile, 0),
+	}
+	for _, tile := range slices.Sorted(maps.Keys(v.AllTiles)) {
+		// 手里已经有全部的牌摸不到
+		if CountElement(tiles, tile) >= v.AllTiles[tile] {
+			continue
+		}
+		if v.shanten(append(slices.Clone(tiles), tile)) >= advice.Shanten {
+			continue
+		}
+		unseen := max(v.AllTiles[tile]-CountElement(seen, tile)-v.Visible[tile], 0)
+		advice.Effective = append(advice.Effective, EffectiveTile{Tile: tile, Unseen: unseen})
+		advice.Total += unseen
+	}
+	return advice
+}
+
+func (v *AIView) shanten(tiles []Tile) int {
+	return calcShanten(tiles, v.Lai, v.ExtraTypes)
+}
+
+func (v *AIView) isLai(tile Tile) bool {
+	return slices.Contains(v.Lai, tile)
+}
@@ -0,0 +1,57 @@
+package mahjong_test
+
+import (
+	"testing"
+
+	"github.com/kevin-chtw/tw_common/gamebase/mahjong"
+)
+
+func TestAnalyzeDiscards(t *testing.T) {
+	north := mahjong.MakeTile(mahjong.ColorWind, 3)
+	view := newAIView(append(mahjong.Int32Tile(w(1, 2, 3, 4, 5, 6, 7, 8, 9, 1, 9)), east, east, east)...)
+	view.Visible[mahjong.Tile(w(3)[0])] = 3
+	result := view.AnalyzeDiscards()
+	if len(result) != 10 {
+		t.Fatalf("advices = %d, want 10", len(result))
+	}
+	// 打1听369，3已经看不到了
+	best := result[0]
+	if best.Tile != mahjong.Tile(w(9)[0]) || best.Shanten != 0 || best.Total != 8 {
+		t.Errorf("best = %+v", best)
+	}
+	for _, a := range result {
+		if a.Tile != mahjong.Tile(w(1)[0]) {
+			continue
+		}
+		want := []mahjong.EffectiveTile{{Tile: mahjong.Tile(w(3)[0]), Unseen: 0}, {Tile: mahjong.Tile(w(6)[0]), Unseen: 3}, {Tile: mahjong.Tile(w(9)[0]), Unseen: 2}}
+		if a.Shanten != 0 || a.Total != 5 || len(a.Effective) != len(want) {
+			t.Fatalf("discard 1 = %+v", a)
+		}
+		for i := range want {
+			if a.Effective[i] != want[i] {
+				t.Errorf("effective[%d] = %+v, want %+v", i, a.Effective[i], want[i])
+			}
+		}
+	}
+
+	// 七对：打南或北听另一张
+	view = newAIView(append(mahjong.Int32Tile(w(1, 1, 2, 2, 5, 5, 8, 8, 9, 9)), east, east, south, north)...)
+	if a := view.AnalyzeDiscards()[0]; a.Shanten == 0 {
+		t.Errorf("seven pairs without QiDui = %+v", a)
+	}
+	view.Conf.QiDui = true
+	view.ExtraTypes = view.Conf.TingTypes()
+	if a := view.AnalyzeDiscards()[0]; a.Shanten != 0 || a.Total != 3 {
+		t.Errorf("seven pairs = %+v", a)
+	}
+
+	// 东是赖子时可以和1或5组成对子、搭子，向听数少一
+	view = newAIView(append(mahjong.Int32Tile(w(1, 2, 3, 4, 5, 6, 7, 8, 9, 1, 5)), east, south)...)
+	if a := view.Analyze(); a.Shanten != 2 {
+		t.Errorf("without lai = %+v", a)
+	}
+	view.Lai = []mahjong.Tile{east}
+	if a := view.Analyze(); a.Shanten != 1 || a.Tile != mahjong.TileNull {
+		t.Errorf("with lai = %+v", a)
+	}
+}
@@ -0,0 +1,52 @@
+package mahjong
+
+import (
+	"github.com/kevin-chtw/tw_proto/game/pbmj"
+	"google.golang.org/protobuf/proto"
+)
+
+// BotBrain mahjong bot的决策部分，根据解包后的pbmj消息维护视角，轮到自己时用策略做决定。
+// 打包请求和发送由具体玩法的bot负责，发送时用AIDecision.Action取思考时间
+type BotBrain struct {
+	Seat     int32
+	View     *AIView
+	Strategy Strategy
+	curTile  Tile // 最近一张打出的牌
+}
+
+// NewBotBrain 创建seat的bot决策，strategy一般由NewProfileStrategy按比赛配置创建
+func NewBotBrain(seat int32, allTiles map[Tile]int, conf *PlayConf, strategy Strategy) *BotBrain {
+	return &BotBrain{
+		Seat:     seat,
+		View:     NewBotAIView(allTiles, conf),
+		Strategy: strategy,
+		curTile:  TileNull,
+	}
+}
+
+// OnMsg 处理收到的消息，是给自己的请求时返回决定，否则返回nil
+func (b *BotBrain) OnMsg(msg proto.Message) *AIDecision {
+	switch ack := msg.(type) {
+	case *pbmj.MJRequestAck:
+		if ack.Seat != b.Seat {
+			return nil
+		}
+		curTile := b.curTile
+		if ack.RequestType&OperateDiscard != 0 {
+			curTile = TileNull
+		}
+		return b.Strategy.Decide(b.View, ack.RequestType, curTile)
+	case *pbmj.MJGameStartAck:
+		// 新的一局重新开始记牌
+		b.View = NewBotAIView(b.View.AllTiles, b.View.Conf)
+		b.curTile = TileNull
+	case *pbmj.MJDiscardAck:
+		b.curTile = Tile(ack.Tile)
+	case *pbmj.MJTingAck:
+		b.curTile = Tile(ack.Tile)
+	case *pbmj.MJKonAck:
+		b.curTile = Tile(ack.Tile) // 补杠可以抢杠胡
+	}
+	b.View.Apply(b.Seat, msg)
+	return nil
+}
@@ -16,11 +16,15 @@ type Dealer struct {
 
 // NewDealer 创建新的发牌器
 func NewDealer(game *Game) *Dealer {
-	return &Dealer{
+	d := &Dealer{
 		game:     game,
-		manual:   newManual(game.App.GetServer().Type, game.MatchID),
 		tileWall: make([]Tile, 0),
 	}
+	// 本地桌子没有pitaya，不支持配牌
+	if game.App != nil {
+		d.manual = newManual(game.App.GetServer().Type, game.MatchID)
+	}
+	return d
 }
 
 // GetGame 获取关联的Game对象
@@ -35,6 +39,7 @@ func (d *Dealer) Initialize() {
 			logger.Log.Error(err)
 		} else {
 			d.tileWall = tileWall
+			d.game.record.RecordWall(d.tileWall)
 			return
 		}
 	}
@@ -59,6 +64,7 @@ func (d *Dealer) Initialize() {
 			i++
 		}
 	}
+	d.game.record.RecordWall(d.tileWall)
 }
 
 // DrawTile 抽牌
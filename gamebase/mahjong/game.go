@@ -1,6 +1,9 @@
 package mahjong
 
 import (
+	"errors"
+	"reflect"
+
 	"github.com/kevin-chtw/tw_common/gamebase/game"
 )
 
@@ -14,11 +17,16 @@ type Game struct {
 	*game.Table
 	id        int32
 	timer     *Timer
+	record    *Record
+	sender    *Sender
 	CurState  IState
 	nextState IState
 	rule      *Rule
 	players   []*Player
 	roundData string
+	over      bool
+	journaled int            // 已经交给Journal的录像条目数
+	journal   []*RecordEntry // RestoreJournal恢复的录像条目
 }
 
 func NewGame(subGame IGame, t *game.Table, id int32) *Game {
@@ -26,10 +34,10 @@ func NewGame(subGame IGame, t *game.Table, id int32) *Game {
 		IGame:   subGame,
 		Table:   t,
 		id:      id,
-		timer:   NewTimer(),
 		rule:    NewRule(),
 		players: make([]*Player, t.GetPlayerCount()),
 	}
+	g.timer = NewTableTimer(t, g.enterNextState)
 
 	g.rule.LoadRule(t.GetProperty(), Service.GetDefaultRules())
 	if t.MatchType == "fdtable" {
@@ -38,10 +46,12 @@ func NewGame(subGame IGame, t *game.Table, id int32) *Game {
 	for i := int32(0); i < t.GetPlayerCount(); i++ {
 		g.players[i] = NewPlayer(g, t.GetGamePlayer(i))
 	}
+	g.record = NewRecord(g)
 	return g
 }
 
 func (g *Game) OnGameBegin() {
+	g.record.Initialize()
 	g.IGame.OnStart()
 	g.enterNextState()
 }
@@ -54,6 +64,11 @@ func (g *Game) OnPlayerMsg(player *game.Player, data []byte) error {
 	return nil
 }
 
+// UseTableTimer 实现game.ITableTimer，定时器注册在桌子上到期回调，不需要周期调用OnGameTimer
+func (g *Game) UseTableTimer() bool {
+	return true
+}
+
 func (g *Game) OnGameTimer() {
 	g.timer.OnTick()
 	g.enterNextState()
@@ -63,16 +78,64 @@ func (g *Game) OnGameOver() {
 	for i := int32(0); i < g.GetPlayerCount(); i++ {
 		g.GetPlayer(i).SyncGameResult()
 	}
+	// 结束后当前状态的超时不再触发
+	g.timer.Cancel()
+	g.record.Finish()
+	g.over = true
 	g.NotifyGameOver(g.id, g.roundData)
 }
 
 func (g *Game) OnNetChange(player *game.Player, offline bool) {
 	if p := g.GetPlayer(player.GetSeat()); p != nil {
 		p.isOffline = offline
+		if offline {
+			g.record.AddPlayerNetBreak(p.GetSeat())
+		} else {
+			g.record.AddPlayerNetResume(p.GetSeat())
+		}
 		g.enterNextState()
 	}
 }
 
+// IsTrusted 实现game.ITrust，局间按上一局的托管状态自动准备
+func (g *Game) IsTrusted(player *game.Player) bool {
+	if p := g.GetPlayer(player.GetSeat()); p != nil {
+		return p.IsTrusted()
+	}
+	return false
+}
+
+// SetTrusted 实现game.ITrustee，管理接口切换托管
+func (g *Game) SetTrusted(seat int32, trusted bool) error {
+	if g.GetPlayer(seat) == nil {
+		return errors.New("invalid seat")
+	}
+	g.sender.SendTrustAck(seat, trusted)
+	g.enterNextState()
+	return nil
+}
+
+// StateName 实现game.IStateName，当前状态的类型名
+func (g *Game) StateName() string {
+	if g.CurState == nil {
+		return ""
+	}
+	typ := reflect.TypeOf(g.CurState)
+	if typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+	return typ.Name()
+}
+
+// MJGame 获取麻将基础Game，玩法嵌入*Game后可以从game.IGame取回
+func (g *Game) MJGame() *Game {
+	return g
+}
+
+func (g *Game) GetRecord() *Record {
+	return g.record
+}
+
 func (g *Game) GetRule() *Rule {
 	return g.rule
 }
@@ -32,3 +32,25 @@ func (lgd *LastGameData) String() string {
 	}
 	return string(data)
 }
+
+type lastGameDataJSON struct {
+	Banker int32            `json:"banker"`
+	Data   map[string]int32 `json:"data"`
+}
+
+func (lgd *LastGameData) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&lastGameDataJSON{Banker: lgd.banker, Data: lgd.data})
+}
+
+func (lgd *LastGameData) UnmarshalJSON(b []byte) error {
+	v := &lastGameDataJSON{}
+	if err := json.Unmarshal(b, v); err != nil {
+		return err
+	}
+	lgd.banker = v.Banker
+	lgd.data = v.Data
+	if lgd.data == nil {
+		lgd.data = make(map[string]int32)
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package mahjong
+
+import (
+	"github.com/kevin-chtw/tw_common/gamebase/game"
+	"github.com/kevin-chtw/tw_proto/game/pbmj"
+	"google.golang.org/protobuf/proto"
+)
+
+// ObserverView 发给seat的消息在观战者眼里的样子，返回nil时观战者看不到。
+// 广播原样转发；开门和摸牌各取一份，ObserverPublic时隐藏牌面，其他单发的消息都不转发
+func ObserverView(msg proto.Message, seat int32, visibility game.ObserverVisibility) proto.Message {
+	if seat == game.SeatAll {
+		return msg
+	}
+	switch ack := msg.(type) {
+	case *pbmj.MJOpenDoorAck:
+		if ack.Seat != seat {
+			return nil
+		}
+		view := proto.Clone(ack).(*pbmj.MJOpenDoorAck)
+		if visibility != game.ObserverFull {
+			for i := range view.Tiles {
+				view.Tiles[i] = TileNull.ToInt32()
+			}
+		}
+		return view
+	case *pbmj.MJDrawAck:
+		if ack.Seat != seat {
+			return nil
+		}
+		view := proto.Clone(ack).(*pbmj.MJDrawAck)
+		view.CallData = nil
+		if visibility != game.ObserverFull {
+			view.Tile = TileNull.ToInt32()
+		}
+		return view
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package mahjong_test
+
+import (
+	"testing"
+
+	"github.com/kevin-chtw/tw_common/gamebase/game"
+	"github.com/kevin-chtw/tw_common/gamebase/mahjong"
+	"github.com/kevin-chtw/tw_proto/game/pbmj"
+)
+
+func TestObserverView(t *testing.T) {
+	null := mahjong.TileNull.ToInt32()
+	discard := &pbmj.MJDiscardAck{Seat: 1, Tile: w(3)[0]}
+	if mahjong.ObserverView(discard, game.SeatAll, game.ObserverPublic) != discard {
+		t.Error("broadcast should be forwarded")
+	}
+	if mahjong.ObserverView(&pbmj.MJRequestAck{Seat: 0}, 0, game.ObserverFull) != nil {
+		t.Error("request should not be forwarded")
+	}
+
+	open := &pbmj.MJOpenDoorAck{Seat: 0, Tiles: w(1, 2, 3)}
+	view := mahjong.ObserverView(open, 0, game.ObserverPublic).(*pbmj.MJOpenDoorAck)
+	if len(view.Tiles) != 3 || view.Tiles[0] != null || open.Tiles[0] == null {
+		t.Errorf("public open door = %v, origin = %v", view, open)
+	}
+	view = mahjong.ObserverView(open, 0, game.ObserverFull).(*pbmj.MJOpenDoorAck)
+	if view.Tiles[0] != w(1)[0] {
+		t.Errorf("full open door = %v", view)
+	}
+
+	// 摸牌只转发摸牌人的那份，其他人的已经隐藏过
+	draw := &pbmj.MJDrawAck{Seat: 1, Tile: w(5)[0], CallData: map[int32]*pbmj.CallData{1: {}}}
+	if mahjong.ObserverView(draw, 0, game.ObserverFull) != nil {
+		t.Error("masked copy should not be forwarded")
+	}
+	drawView := mahjong.ObserverView(draw, 1, game.ObserverPublic).(*pbmj.MJDrawAck)
+	if drawView.Tile != null || drawView.CallData != nil {
+		t.Errorf("public draw = %v", drawView)
+	}
+	drawView = mahjong.ObserverView(draw, 1, game.ObserverFull).(*pbmj.MJDrawAck)
+	if drawView.Tile != w(5)[0] || drawView.CallData != nil {
+		t.Errorf("full draw = %v", drawView)
+	}
+}
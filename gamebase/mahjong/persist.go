@@ -0,0 +1,140 @@
+package mahjong
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// IRestore 玩法可选实现，进程重启后从检查点恢复牌局
+type IRestore interface {
+	// OnRestore 像OnStart一样创建Play和Sender，用Play.Restore代替Initialize和发牌，再设置要进入的状态
+	OnRestore(data *RecordData) error
+}
+
+type gameCheckpoint struct {
+	Over         bool                `json:"over,omitempty"`
+	RoundData    string              `json:"round_data,omitempty"`
+	LastGameData *LastGameData       `json:"last_game_data,omitempty"`
+	Players      []*playerCheckpoint `json:"players"`
+	Record       *RecordData         `json:"record"` // 不含条目，条目通过Journal追加保存
+}
+
+type playerCheckpoint struct {
+	Tax int64 `json:"tax,omitempty"`
+	Out bool  `json:"out,omitempty"`
+}
+
+// Checkpoint 保存牌局，玩法没有实现IRestore时不保存
+func (g *Game) Checkpoint() ([]byte, error) {
+	if _, ok := g.IGame.(IRestore); !ok {
+		return nil, nil
+	}
+	cp := &gameCheckpoint{
+		Over:      g.over,
+		RoundData: g.roundData,
+		Players:   make([]*playerCheckpoint, len(g.players)),
+	}
+	record := *g.record.GetData()
+	record.Entries = nil
+	cp.Record = &record
+	if lgd, ok := g.GetLastGameData().(*LastGameData); ok {
+		cp.LastGameData = lgd
+	}
+	for i, p := range g.players {
+		cp.Players[i] = &playerCheckpoint{Tax: p.tax, Out: p.isOut}
+	}
+	return json.Marshal(cp)
+}
+
+// Journal 返回上次调用之后新增的录像条目，检查点不用每次保存整个录像
+func (g *Game) Journal() ([]byte, error) {
+	if _, ok := g.IGame.(IRestore); !ok {
+		return nil, nil
+	}
+	entries := g.record.GetData().Entries
+	if len(entries) <= g.journaled {
+		return nil, nil
+	}
+	b, err := json.Marshal(entries[g.journaled:])
+	if err != nil {
+		return nil, err
+	}
+	g.journaled = len(entries)
+	return b, nil
+}
+
+// RestoreJournal 恢复Journal保存的录像条目，在Restore之前调用
+func (g *Game) RestoreJournal(segments [][]byte) error {
+	g.journal = make([]*RecordEntry, 0)
+	for _, b := range segments {
+		var entries []*RecordEntry
+		if err := json.Unmarshal(b, &entries); err != nil {
+			return err
+		}
+		g.journal = append(g.journal, entries...)
+	}
+	return nil
+}
+
+// Restore 从检查点恢复牌局，已经结束的牌局只恢复LastGameData
+func (g *Game) Restore(b []byte) error {
+	cp := &gameCheckpoint{}
+	if err := json.Unmarshal(b, cp); err != nil {
+		return err
+	}
+	if cp.LastGameData != nil {
+		g.SetLastGameData(cp.LastGameData)
+	}
+	g.roundData = cp.RoundData
+	g.over = cp.Over
+	if cp.Record == nil {
+		return errors.New("checkpoint without record")
+	}
+	cp.Record.Entries = g.journal
+	g.journal = nil
+	g.journaled = len(cp.Record.Entries)
+	g.record.Restore(cp.Record)
+	if g.over {
+		return nil
+	}
+
+	r, ok := g.IGame.(IRestore)
+	if !ok {
+		return errors.New("game does not support restore")
+	}
+	if err := r.OnRestore(cp.Record); err != nil {
+		return err
+	}
+	for i, p := range g.players {
+		if i < len(cp.Players) {
+			p.tax = cp.Players[i].Tax
+			p.isOut = cp.Players[i].Out
+		}
+		// 重启后玩家需要重新连接
+		p.isOffline = !p.player.IsBot()
+	}
+	g.enterNextState()
+	return nil
+}
+
+// Restore 按录像恢复牌局，在玩法的OnRestore中代替Initialize和发牌
+func (p *Play) Restore(data *RecordData, pdfn func(*Play, int32) *PlayData) error {
+	steps, err := newReplaySteps(data)
+	if err != nil {
+		return err
+	}
+	p.dealer.tileWall = Int32Tile(data.Wall)
+	p.history = make([]Action, 0)
+	p.huTiles = nil
+	for i := range p.game.GetPlayerCount() {
+		p.playData[i] = pdfn(p, i)
+	}
+	r := &Replayer{
+		data:   data,
+		steps:  steps,
+		game:   p.game,
+		play:   p,
+		dealer: p.dealer,
+	}
+	return r.Seek(len(steps))
+}
@@ -0,0 +1,88 @@
+package mahjong_test
+
+import (
+	"testing"
+
+	"github.com/kevin-chtw/tw_common/gamebase/game"
+	"github.com/kevin-chtw/tw_common/gamebase/mahjong"
+)
+
+// testGame 只支持恢复的玩法
+type testGame struct {
+	game *mahjong.Game
+	play *mahjong.Play
+}
+
+func (g *testGame) OnStart() {}
+
+func (g *testGame) OnReqMsg(player *game.Player, data []byte) error {
+	return nil
+}
+
+func (g *testGame) OnRestore(data *mahjong.RecordData) error {
+	g.play = newTestPlay(g.game, mahjong.NewDealer(g.game))
+	g.play.PlayConf = &mahjong.PlayConf{}
+	return g.play.Restore(data, mahjong.NewPlayData)
+}
+
+func newTestGame() *testGame {
+	table := game.NewLocalTable(1001, 1, "normal", 2, "", 1)
+	table.AddLocalPlayer("u0", 0, 100, false)
+	table.AddLocalPlayer("u1", 1, 50, true)
+	g := &testGame{}
+	g.game = mahjong.NewGame(g, table, 1)
+	return g
+}
+
+func TestGameCheckpoint(t *testing.T) {
+	data := newTestReplayRecord(t)
+	// 去掉结算，停在胡牌之前
+	data.Entries = data.Entries[:len(data.Entries)-3]
+
+	g1 := newTestGame()
+	g1.game.GetRecord().Restore(data)
+	b, err := g1.game.Checkpoint()
+	if err != nil || b == nil {
+		t.Fatalf("Checkpoint() = %v, %v", b, err)
+	}
+	// 录像条目不在检查点里，分两段追加保存
+	var journal [][]byte
+	for range 2 {
+		segment, err := g1.game.Journal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if segment != nil {
+			journal = append(journal, segment)
+		}
+		g1.game.GetRecord().RecordLog("after checkpoint")
+	}
+	if len(journal) != 2 {
+		t.Fatalf("journal segments = %d", len(journal))
+	}
+
+	g2 := newTestGame()
+	if err := g2.game.RestoreJournal(journal); err != nil {
+		t.Fatal(err)
+	}
+	if err := g2.game.Restore(b); err != nil {
+		t.Fatal(err)
+	}
+	state := g2.play.GetState(game.SeatAll)
+	if state.CurSeat != 1 || state.CurTile != mahjong.Tile(w(6)[0]) {
+		t.Errorf("cur = %d %s", state.CurSeat, state.CurTile.Name())
+	}
+	if len(state.Seats[0].HandTiles) != 13 || len(state.Seats[1].PonGroups) != 1 || len(state.Seats[1].OutTiles) != 2 {
+		t.Errorf("seats = %+v %+v", state.Seats[0], state.Seats[1])
+	}
+	if !g2.game.GetPlayer(0).IsOffline() || g2.game.GetPlayer(1).IsOffline() {
+		t.Error("human players should be offline after restore")
+	}
+	// 最后一条日志在保存之后
+	if n, want := len(g2.game.GetRecord().GetData().Entries), len(data.Entries)-1; n != want {
+		t.Errorf("record entries = %d, want %d", n, want)
+	}
+	if segment, err := g2.game.Journal(); segment != nil || err != nil {
+		t.Errorf("Journal() after restore = %s, %v", segment, err)
+	}
+}
@@ -22,6 +22,7 @@ type Play struct {
 	history      []Action
 	playData     []*PlayData
 	huResult     []*pbmj.MJHuData
+	huTiles      []Tile // 点炮胡时从出牌区拿走的牌
 	selfCheckers []CheckerSelf
 	waitcheckers []CheckerWait
 }
@@ -56,6 +57,7 @@ func (p *Play) Initialize(pdfn func(*Play, int32) *PlayData) {
 	p.curSeat = p.banker
 	p.dealer.Initialize()
 	p.history = make([]Action, 0)
+	p.huTiles = nil
 	for i := range p.game.GetPlayerCount() {
 		p.playData[i] = pdfn(p, int32(i))
 	}
@@ -167,17 +169,18 @@ func (p *Play) Discard(tile Tile) bool {
 	return false
 }
 
-func (p *Play) ZhiKon(seat int32) {
+func (p *Play) ZhiKon(seat int32) bool {
 	playData := p.playData[seat]
 	if !playData.canKon(p.curTile, KonTypeZhi) {
 		logrus.Error("player cannot zhi kon")
-		return
+		return false
 	}
 	playData.kon(p.curTile, p.curSeat, KonTypeZhi)
 	p.playData[p.curSeat].RemoveOutTile()
 	p.addHistory(seat, p.curSeat, OperateKon, p.curTile, 0)
 	p.game.GetGamePlayer(seat).AddData("kon", 1)
 	p.FreshCallData(seat)
+	return true
 }
 
 func (p *Play) TryKon(tile Tile, konType KonType) bool {
@@ -194,24 +197,26 @@ func (p *Play) TryKon(tile Tile, konType KonType) bool {
 	return true
 }
 
-func (p *Play) Pon(seat int32) {
+func (p *Play) Pon(seat int32) bool {
 	playData := p.playData[seat]
 	if !playData.canPon(p.curTile, p.PlayConf.CanotOnlyLaiAfterPon) {
 		logrus.Error("player cannot pon")
-		return
+		return false
 	}
 	playData.Pon(p.curTile, p.curSeat)
 	p.playData[p.curSeat].RemoveOutTile()
 	p.addHistory(seat, p.curSeat, OperatePon, p.curTile, 0)
 	p.game.GetGamePlayer(seat).AddData("pon", 1)
 	p.FreshCallData(seat)
+	return true
 }
 
-func (p *Play) Chow(seat int32, leftTile Tile) {
+func (p *Play) Chow(seat int32, leftTile Tile) bool {
 	playData := p.playData[seat]
 	tiles, ok := playData.tryChow(p.curTile, leftTile)
 	if !ok {
 		logrus.Error("player cannot chow")
+		return false
 	}
 
 	playData.chow(tiles, p.curTile, leftTile, seat)
@@ -219,6 +224,7 @@ func (p *Play) Chow(seat int32, leftTile Tile) {
 	p.addHistory(seat, p.curSeat, OperateChow, p.curTile, leftTile)
 	p.game.GetGamePlayer(seat).AddData("chow", 1)
 	p.FreshCallData(seat)
+	return true
 }
 
 func (p *Play) Zimo() (multiples []int64) {
@@ -240,6 +246,7 @@ func (p *Play) Zimo() (multiples []int64) {
 
 func (p *Play) PaoHu(huSeats []int32) []int64 {
 	p.playData[p.curSeat].RemoveOutTile()
+	p.huTiles = append(p.huTiles, p.curTile)
 	multiples := make([]int64, p.game.GetPlayerCount())
 	for _, seat := range huSeats {
 		huResult := p.huResult[seat]
@@ -12,6 +12,8 @@ type PlayConf struct {
 	TianTing             bool  // 有天听玩法
 	BuKonPass            bool  // 补杠区分过手杠
 	ZhiKonAfterPon       bool  // 碰后补杠算直杠
+	QiDui                bool  // 可以胡七对
+	ShiSanYao            bool  // 可以胡十三幺
 	MinMultipleLimit     int64 // 起胡倍数
 	MaxMultipleLimit     int64 // 封顶倍数
 }
@@ -34,3 +36,18 @@ func (p *PlayConf) IsTopMultiple(mult int64) bool {
 	}
 	return mult >= p.MaxMultipleLimit
 }
+
+// TingTypes 计算向听数时考虑的特殊牌型
+func (p *PlayConf) TingTypes() []MJTingType {
+	types := make([]MJTingType, 0)
+	if p == nil {
+		return types
+	}
+	if p.QiDui {
+		types = append(types, TingTypeQiDui)
+	}
+	if p.ShiSanYao {
+		types = append(types, TingType13Yao)
+	}
+	return types
+}
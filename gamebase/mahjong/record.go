@@ -1,13 +1,45 @@
 package mahjong
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
 	"time"
+
+	"github.com/kevin-chtw/tw_proto/game/pbmj"
+	"github.com/topfreegames/pitaya/v3/pkg/logger"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
 )
 
 const (
-	RecordPBFlag = 512 // f=<0:begin,1:end>
+	RecordPBFlag  = 512 // f=<0:begin,1:end>
+	RecordVersion = 1   // 录像格式版本
 )
 
+// RecordType 录像条目类型
+type RecordType int32
+
+const (
+	RecordTypeAck       RecordType = iota // 发给玩家的消息
+	RecordTypeNetBreak                    // 玩家断网
+	RecordTypeNetResume                   // 玩家恢复网络
+	RecordTypeExitMatch                   // 玩家退出比赛
+	RecordTypeItem                        // 自定义记录项
+	RecordTypeLog                         // 日志
+)
+
+// RecordSink 录像输出，局结束时在桌子的事件循环中调用，耗时的输出需要异步处理
+type RecordSink func(data *RecordData)
+
+// DefaultRecordSink 新牌局默认的录像输出，默认不输出，服务启动时按需设置，例如FileRecordSink。
+// 单个牌局用Record.SetSink修改
+var DefaultRecordSink RecordSink
+
+// recordQueueSize FileRecordSink等待写入的录像数，写入跟不上时丢弃新的录像
+const recordQueueSize = 256
+
 type RecordItem struct {
 	ActionID   int
 	ActionName string
@@ -44,45 +76,155 @@ func (i *RecordItem) AddProperty(name string, value interface{}) {
 	i.Properties[name] = ToString(value)
 }
 
+// RecordPlayer 录像中的玩家信息
+type RecordPlayer struct {
+	Seat  int32  `json:"seat"`
+	Uid   string `json:"uid"`
+	Score int64  `json:"score"`
+	Bot   bool   `json:"bot,omitempty"`
+}
+
+// RecordEntry 录像中的一条记录
+type RecordEntry struct {
+	Type       RecordType        `json:"type"`
+	Seat       int32             `json:"seat"`
+	Time       int64             `json:"time"` // 相对开局的毫秒数
+	Msg        *anypb.Any        `json:"-"`
+	ActionID   int32             `json:"action_id,omitempty"`
+	ActionName string            `json:"action_name,omitempty"`
+	Properties map[string]string `json:"properties,omitempty"`
+	Log        string            `json:"log,omitempty"`
+}
+
+// RecordData 一局的完整录像
+type RecordData struct {
+	Version   int32           `json:"version"`
+	MatchID   int32           `json:"matchid"`
+	TableID   int32           `json:"tableid"`
+	GameID    int32           `json:"game_id"`
+	MatchType string          `json:"match_type"`
+	Property  string          `json:"property"`
+	ScoreBase int64           `json:"score_base"`
+	StartTime int64           `json:"start_time"` // unix毫秒
+	EndTime   int64           `json:"end_time"`   // unix毫秒
+	Wall      []int32         `json:"wall"`
+	Players   []*RecordPlayer `json:"players"`
+	Entries   []*RecordEntry  `json:"entries"`
+	Winners   []int32         `json:"winners"`
+}
+
 type Record struct {
 	startTime   time.Time
 	currentItem *RecordItem
 	hasPBRecord bool
 	game        *Game
+	data        *RecordData
+	sink        RecordSink
 }
 
 func NewRecord(game *Game) *Record {
-	return &Record{
+	r := &Record{
 		game:        game,
-		startTime:   time.Now(),
 		currentItem: NewRecordItem(0),
+		sink:        DefaultRecordSink,
+	}
+	r.startTime = r.now()
+	r.data = r.newData()
+	return r
+}
+
+func (r *Record) now() time.Time {
+	if r.game == nil {
+		return time.Now()
+	}
+	return r.game.Now()
+}
+
+func (r *Record) newData() *RecordData {
+	data := &RecordData{
+		Version:   RecordVersion,
+		StartTime: r.startTime.UnixMilli(),
+		Wall:      make([]int32, 0),
+		Players:   make([]*RecordPlayer, 0),
+		Entries:   make([]*RecordEntry, 0),
+		Winners:   make([]int32, 0),
+	}
+	if r.game == nil {
+		return data
+	}
+	data.MatchID = r.game.MatchID
+	data.TableID = r.game.GetTableID()
+	data.GameID = r.game.id
+	data.MatchType = r.game.MatchType
+	data.Property = r.game.rule.ToString()
+	data.ScoreBase = r.game.GetScoreBase()
+	for i := range r.game.GetPlayerCount() {
+		if p := r.game.GetGamePlayer(i); p != nil {
+			data.Players = append(data.Players, &RecordPlayer{
+				Seat:  i,
+				Uid:   p.GetUid(),
+				Score: p.GetScore(),
+				Bot:   p.IsBot(),
+			})
+		}
 	}
+	return data
 }
 
 func (r *Record) Initialize() {
-	r.startTime = time.Now()
+	r.startTime = r.now()
 	r.currentItem = NewRecordItem(0)
 	r.hasPBRecord = false
+	r.data = r.newData()
+}
+
+// Restore 从检查点恢复录像，之后的记录接着追加
+func (r *Record) Restore(data *RecordData) {
+	r.data = data
+	r.startTime = time.UnixMilli(data.StartTime)
+	r.hasPBRecord = len(data.Entries) > 0
+}
+
+// GetData 获取当前录像
+func (r *Record) GetData() *RecordData {
+	return r.data
+}
+
+// SetSink 设置这一局的录像输出，为nil时不输出
+func (r *Record) SetSink(sink RecordSink) {
+	r.sink = sink
 }
 
 func (r *Record) Finish() {
-	// 游戏结束时调用
+	r.RecordPBEnd()
+	if r.sink != nil {
+		r.sink(r.data)
+	}
+}
+
+// RecordWall 记录初始牌墙
+func (r *Record) RecordWall(tiles []Tile) {
+	r.data.Wall = TilesInt32(tiles)
 }
 
 func (r *Record) RecordAction(ack interface{}, seat int32) {
-	// 记录动作
+	if msg, ok := ack.(proto.Message); ok {
+		r.RecordPBAction(msg, seat)
+		return
+	}
+	r.RecordLog(ToString(ack))
 }
 
 func (r *Record) AddPlayerNetBreak(seat int32) {
-	// 记录玩家断网
+	r.addEntry(&RecordEntry{Type: RecordTypeNetBreak, Seat: seat})
 }
 
 func (r *Record) AddPlayerNetResume(seat int32) {
-	// 记录玩家恢复网络
+	r.addEntry(&RecordEntry{Type: RecordTypeNetResume, Seat: seat})
 }
 
 func (r *Record) AddPlayerExitMatch(seat int32) {
-	// 记录玩家退出比赛
+	r.addEntry(&RecordEntry{Type: RecordTypeExitMatch, Seat: seat})
 }
 
 func (r *Record) HasPBRecord() bool {
@@ -90,26 +232,97 @@ func (r *Record) HasPBRecord() bool {
 }
 
 func (r *Record) AddItem(item *RecordItem) {
-	// 添加记录项
+	entry := &RecordEntry{
+		Type:       RecordTypeItem,
+		Seat:       SeatNull,
+		ActionID:   int32(item.ActionID),
+		ActionName: item.ActionName,
+		Properties: make(map[string]string, len(item.Properties)),
+	}
+	for k, v := range item.Properties {
+		entry.Properties[k] = v
+	}
+	r.addEntry(entry)
 }
 
 func (r *Record) RecordLog(str string) {
-	// 记录日志
+	r.addEntry(&RecordEntry{Type: RecordTypeLog, Seat: SeatNull, Log: str})
 }
 
 func (r *Record) RecordPBBegin() {
-	// 记录PB开始
 	r.hasPBRecord = true
 }
 
 func (r *Record) RecordPBAction(ack interface{}, seat int32) {
-	// 记录PB动作
+	msg, ok := ack.(proto.Message)
+	if !ok {
+		return
+	}
+	data, err := anypb.New(msg)
+	if err != nil {
+		logger.Log.Error(err)
+		return
+	}
+	r.hasPBRecord = true
+	r.addEntry(&RecordEntry{Type: RecordTypeAck, Seat: seat, Msg: data})
+
+	if huAck, ok := msg.(*pbmj.MJHuAck); ok {
+		for _, hu := range huAck.HuData {
+			if !slices.Contains(r.data.Winners, hu.Seat) {
+				r.data.Winners = append(r.data.Winners, hu.Seat)
+			}
+		}
+	}
 }
 
 func (r *Record) RecordPBResult(winners []int32) {
-	// 记录PB结果
+	r.data.Winners = append(r.data.Winners[:0], winners...)
 }
 
 func (r *Record) RecordPBEnd() {
-	// 记录PB结束
+	r.data.EndTime = r.now().UnixMilli()
+}
+
+func (r *Record) addEntry(entry *RecordEntry) {
+	entry.Time = r.now().Sub(r.startTime).Milliseconds()
+	r.data.Entries = append(r.data.Entries, entry)
+}
+
+// FileRecordSink 把录像以json写到指定目录，由单独的协程写文件，不阻塞桌子的事件循环。
+// 不负责清理旧文件
+func FileRecordSink(dir string) RecordSink {
+	queue := make(chan *RecordData, recordQueueSize)
+	go func() {
+		for data := range queue {
+			writeRecordFile(dir, data)
+		}
+	}()
+	return func(data *RecordData) {
+		select {
+		case queue <- data:
+		default:
+			logger.Log.Errorf("record queue full, drop record %d_%d_%d", data.MatchID, data.TableID, data.GameID)
+		}
+	}
+}
+
+func writeRecordFile(dir string, data *RecordData) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		logger.Log.Error(err)
+		return
+	}
+	b, err := data.ToJSON()
+	if err != nil {
+		logger.Log.Error(err)
+		return
+	}
+	// 先写临时文件再改名，读取方不会读到半个文件
+	name := filepath.Join(dir, fmt.Sprintf("%d_%d_%d_%d.json", data.MatchID, data.TableID, data.GameID, data.StartTime))
+	if err := os.WriteFile(name+".tmp", b, 0o644); err != nil {
+		logger.Log.Error(err)
+		return
+	}
+	if err := os.Rename(name+".tmp", name); err != nil {
+		logger.Log.Error(err)
+	}
 }
@@ -0,0 +1,60 @@
+package mahjong
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/kevin-chtw/tw_common/utils"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+type recordEntryJSON struct {
+	*recordEntryAlias
+	Msg json.RawMessage `json:"msg,omitempty"`
+}
+
+type recordEntryAlias RecordEntry
+
+// MarshalJSON 消息部分使用protojson编码
+func (e *RecordEntry) MarshalJSON() ([]byte, error) {
+	out := recordEntryJSON{recordEntryAlias: (*recordEntryAlias)(e)}
+	if e.Msg != nil {
+		msg, err := utils.JsonMarshal.Marshal(e.Msg)
+		if err != nil {
+			return nil, err
+		}
+		out.Msg = msg
+	}
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON 消息部分使用protojson解码
+func (e *RecordEntry) UnmarshalJSON(b []byte) error {
+	in := recordEntryJSON{recordEntryAlias: (*recordEntryAlias)(e)}
+	if err := json.Unmarshal(b, &in); err != nil {
+		return err
+	}
+	if len(in.Msg) > 0 {
+		e.Msg = &anypb.Any{}
+		return protojson.Unmarshal(in.Msg, e.Msg)
+	}
+	return nil
+}
+
+// ToJSON 录像的json格式
+func (d *RecordData) ToJSON() ([]byte, error) {
+	return json.Marshal(d)
+}
+
+// UnmarshalRecordJSON 从json还原录像
+func UnmarshalRecordJSON(b []byte) (*RecordData, error) {
+	data := &RecordData{}
+	if err := json.Unmarshal(b, data); err != nil {
+		return nil, err
+	}
+	if data.Version > RecordVersion {
+		return nil, fmt.Errorf("unsupported record version %d", data.Version)
+	}
+	return data, nil
+}
@@ -0,0 +1,103 @@
+package mahjong_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kevin-chtw/tw_common/gamebase/mahjong"
+	"github.com/kevin-chtw/tw_proto/game/pbmj"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+func newTestRecordData(t *testing.T) *mahjong.RecordData {
+	discard, err := anypb.New(&pbmj.MJDiscardAck{Seat: 1, Tile: 0x111})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &mahjong.RecordData{
+		Version:   mahjong.RecordVersion,
+		MatchID:   1001,
+		TableID:   123456,
+		GameID:    2,
+		MatchType: "fdtable",
+		Property:  "1,0,2",
+		StartTime: 1700000000000,
+		EndTime:   1700000060000,
+		Wall:      []int32{0x11, 0x21, 0x111},
+		Players: []*mahjong.RecordPlayer{
+			{Seat: 0, Uid: "u0", Score: 100},
+			{Seat: 1, Uid: "u1", Score: -20, Bot: true},
+		},
+		Entries: []*mahjong.RecordEntry{
+			{Type: mahjong.RecordTypeAck, Seat: -2, Time: 10, Msg: discard},
+			{Type: mahjong.RecordTypeNetBreak, Seat: 1, Time: 20},
+			{Type: mahjong.RecordTypeItem, Seat: mahjong.SeatNull, Time: 30, ActionName: "swap", Properties: map[string]string{"k": "v"}},
+		},
+		Winners: []int32{1},
+	}
+}
+
+func checkRecordData(t *testing.T, want, got *mahjong.RecordData) {
+	if got.Version != want.Version || got.MatchID != want.MatchID || got.TableID != want.TableID ||
+		got.GameID != want.GameID || got.MatchType != want.MatchType || got.Property != want.Property ||
+		got.StartTime != want.StartTime || got.EndTime != want.EndTime {
+		t.Fatalf("header mismatch: %+v", got)
+	}
+	if len(got.Wall) != len(want.Wall) || len(got.Players) != len(want.Players) ||
+		len(got.Entries) != len(want.Entries) || len(got.Winners) != len(want.Winners) {
+		t.Fatalf("length mismatch: %+v", got)
+	}
+	if *got.Players[1] != *want.Players[1] {
+		t.Errorf("player = %+v, want %+v", got.Players[1], want.Players[1])
+	}
+	if got.Entries[0].Seat != -2 || !proto.Equal(got.Entries[0].Msg, want.Entries[0].Msg) {
+		t.Errorf("ack entry = %+v", got.Entries[0])
+	}
+	if got.Entries[1].Type != mahjong.RecordTypeNetBreak || got.Entries[1].Seat != 1 {
+		t.Errorf("net break entry = %+v", got.Entries[1])
+	}
+	if got.Entries[2].Seat != mahjong.SeatNull || got.Entries[2].Properties["k"] != "v" {
+		t.Errorf("item entry = %+v", got.Entries[2])
+	}
+}
+
+func TestRecordJSON(t *testing.T) {
+	want := newTestRecordData(t)
+	b, err := want.ToJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := mahjong.UnmarshalRecordJSON(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRecordData(t, want, got)
+}
+
+func TestFileRecordSink(t *testing.T) {
+	dir := t.TempDir()
+	want := newTestRecordData(t)
+	mahjong.FileRecordSink(dir)(want)
+
+	// 由写文件的协程异步写入
+	file := filepath.Join(dir, "1001_123456_2_1700000000000.json")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		b, err := os.ReadFile(file)
+		if err == nil {
+			got, err := mahjong.UnmarshalRecordJSON(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkRecordData(t, want, got)
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
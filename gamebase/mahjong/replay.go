@@ -0,0 +1,306 @@
+package mahjong
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/kevin-chtw/tw_common/gamebase/game"
+	"github.com/kevin-chtw/tw_proto/game/pbmj"
+	"google.golang.org/protobuf/proto"
+)
+
+// ReplayPlayCreator 由具体玩法创建回放用的Play，需要设置好PlayImp和PlayConf
+type ReplayPlayCreator func(g *Game, dealer *Dealer) *Play
+
+// ReplayStep 回放中的一步，对应录像里的一条记录
+type ReplayStep struct {
+	Index     int
+	Entry     *RecordEntry
+	Msg       proto.Message // 录像消息，非消息记录为nil
+	Multiples []int64       // 胡牌时Play计算出的倍数
+}
+
+// ReplayView 回放当前步的牌桌状态
+type ReplayView struct {
+	*PlayState
+	Cursor   int
+	Finished bool
+	Liuju    bool
+}
+
+// Replayer 录像回放，按录像顺序调用Play的方法重建牌局
+type Replayer struct {
+	data     *RecordData
+	creator  ReplayPlayCreator
+	steps    []*ReplayStep
+	game     *Game
+	play     *Play
+	dealer   *Dealer
+	cursor   int
+	dealt    bool
+	finished bool
+	liuju    bool
+}
+
+// NewReplayer 创建录像回放，Service需要已经设置
+func NewReplayer(data *RecordData, creator ReplayPlayCreator) (*Replayer, error) {
+	if data == nil || creator == nil {
+		return nil, errors.New("invalid replay args")
+	}
+	if data.Version > RecordVersion {
+		return nil, fmt.Errorf("unsupported record version %d", data.Version)
+	}
+	steps, err := newReplaySteps(data)
+	if err != nil {
+		return nil, err
+	}
+	r := &Replayer{
+		data:    data,
+		creator: creator,
+		steps:   steps,
+	}
+	r.Reset()
+	return r, nil
+}
+
+func newReplaySteps(data *RecordData) ([]*ReplayStep, error) {
+	steps := make([]*ReplayStep, 0, len(data.Entries))
+	for _, entry := range data.Entries {
+		step := &ReplayStep{Entry: entry}
+		if entry.Msg != nil {
+			msg, err := entry.Msg.UnmarshalNew()
+			if err != nil {
+				return nil, err
+			}
+			// 摸牌消息会给每个座位各发一份，只保留摸牌者自己那份
+			if ack, ok := msg.(*pbmj.MJDrawAck); ok && entry.Seat != ack.Seat {
+				continue
+			}
+			step.Msg = msg
+		}
+		step.Index = len(steps)
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+// Reset 回到开局前
+func (r *Replayer) Reset() {
+	t := game.NewLocalTable(r.data.MatchID, r.data.TableID, r.data.MatchType, r.playerCount(), r.data.Property, r.data.ScoreBase)
+	for _, p := range r.data.Players {
+		t.AddLocalPlayer(p.Uid, p.Seat, p.Score, p.Bot)
+	}
+	r.game = NewGame(nil, t, r.data.GameID)
+	r.dealer = NewDealer(r.game)
+	r.dealer.tileWall = Int32Tile(r.data.Wall)
+	r.play = r.creator(r.game, r.dealer)
+	if r.play.PlayConf == nil {
+		r.play.PlayConf = &PlayConf{}
+	}
+	for i := range r.play.playData {
+		r.play.playData[i] = NewPlayData(r.play, int32(i))
+	}
+	r.cursor = 0
+	r.dealt = false
+	r.finished = false
+	r.liuju = false
+}
+
+// Len 总步数
+func (r *Replayer) Len() int {
+	return len(r.steps)
+}
+
+// Cursor 已经执行的步数
+func (r *Replayer) Cursor() int {
+	return r.cursor
+}
+
+// GetData 获取录像
+func (r *Replayer) GetData() *RecordData {
+	return r.data
+}
+
+// GetGame 获取回放的Game
+func (r *Replayer) GetGame() *Game {
+	return r.game
+}
+
+// GetPlay 获取回放的Play
+func (r *Replayer) GetPlay() *Play {
+	return r.play
+}
+
+// Step 执行下一步，录像结束返回io.EOF
+func (r *Replayer) Step() (*ReplayStep, error) {
+	if r.cursor >= len(r.steps) {
+		return nil, io.EOF
+	}
+	step := r.steps[r.cursor]
+	r.cursor++
+	if err := r.apply(step); err != nil {
+		return step, fmt.Errorf("replay step %d: %w", step.Index, err)
+	}
+	return step, nil
+}
+
+// Seek 跳到执行完前n步的状态，向前跳时从头重放
+func (r *Replayer) Seek(n int) error {
+	if n < 0 || n > len(r.steps) {
+		return fmt.Errorf("seek %d out of range [0,%d]", n, len(r.steps))
+	}
+	if n < r.cursor {
+		r.Reset()
+	}
+	for r.cursor < n {
+		if _, err := r.Step(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// View 获取当前状态，viewer为game.SeatAll时可以看到所有人的牌，否则只能看到自己的
+func (r *Replayer) View(viewer int32) *ReplayView {
+	return &ReplayView{
+		PlayState: r.play.GetState(viewer),
+		Cursor:    r.cursor,
+		Finished:  r.finished,
+		Liuju:     r.liuju,
+	}
+}
+
+func (r *Replayer) playerCount() int32 {
+	count := int32(len(r.data.Players))
+	for _, p := range r.data.Players {
+		count = max(count, p.Seat+1)
+	}
+	return count
+}
+
+func (r *Replayer) apply(step *ReplayStep) error {
+	switch step.Entry.Type {
+	case RecordTypeNetBreak, RecordTypeNetResume:
+		if p := r.game.GetPlayer(step.Entry.Seat); p != nil {
+			p.SetOffline(step.Entry.Type == RecordTypeNetBreak)
+		}
+		return nil
+	case RecordTypeAck:
+	default:
+		return nil
+	}
+
+	p := r.play
+	switch ack := step.Msg.(type) {
+	case *pbmj.MJGameStartAck:
+		p.banker = ack.Banker
+		p.curSeat = ack.Banker
+	case *pbmj.MJOpenDoorAck:
+		if !r.dealt {
+			p.Deal()
+			r.dealt = true
+		}
+		if !r.game.IsValidSeat(ack.Seat) {
+			return fmt.Errorf("invalid seat %d", ack.Seat)
+		}
+		p.playData[ack.Seat].handTiles = Int32Tile(ack.Tiles)
+		p.FreshCallData(ack.Seat)
+	case *pbmj.MJDrawAck:
+		tile := Tile(ack.Tile)
+		i := slices.Index(r.dealer.tileWall, tile)
+		if i < 0 {
+			return fmt.Errorf("tile %s not in wall", tile.Name())
+		}
+		r.dealer.tileWall[0], r.dealer.tileWall[i] = r.dealer.tileWall[i], r.dealer.tileWall[0]
+		p.curSeat = ack.Seat
+		p.Draw()
+	case *pbmj.MJDiscardAck:
+		p.curSeat = ack.Seat
+		if !p.Discard(Tile(ack.Tile)) {
+			return fmt.Errorf("seat %d cannot discard %s", ack.Seat, Tile(ack.Tile).Name())
+		}
+	case *pbmj.MJTingAck:
+		p.curSeat = ack.Seat
+		if !p.Ting(Tile(ack.Tile)) {
+			if !p.Discard(Tile(ack.Tile)) {
+				return fmt.Errorf("seat %d cannot ting %s", ack.Seat, Tile(ack.Tile).Name())
+			}
+			p.playData[ack.Seat].SetTing(ack.TianTing)
+		}
+	case *pbmj.MJPonAck:
+		p.curSeat, p.curTile = ack.From, Tile(ack.Tile)
+		if !p.Pon(ack.Seat) {
+			return fmt.Errorf("seat %d cannot pon %s", ack.Seat, Tile(ack.Tile).Name())
+		}
+		p.curSeat = ack.Seat
+	case *pbmj.MJChowAck:
+		p.curSeat, p.curTile = ack.From, Tile(ack.Tile)
+		if !p.Chow(ack.Seat, Tile(ack.LeftTile)) {
+			return fmt.Errorf("seat %d cannot chow %s", ack.Seat, Tile(ack.Tile).Name())
+		}
+		p.curSeat = ack.Seat
+	case *pbmj.MJKonAck:
+		if KonType(ack.KonType) == KonTypeZhi && ack.From != ack.Seat {
+			p.curSeat, p.curTile = ack.From, Tile(ack.Tile)
+			if !p.ZhiKon(ack.Seat) {
+				return fmt.Errorf("seat %d cannot kon %s", ack.Seat, Tile(ack.Tile).Name())
+			}
+			p.curSeat = ack.Seat
+		} else {
+			p.curSeat = ack.Seat
+			if !p.TryKon(Tile(ack.Tile), KonType(ack.KonType)) {
+				return fmt.Errorf("seat %d cannot kon %s", ack.Seat, Tile(ack.Tile).Name())
+			}
+		}
+	case *pbmj.MJHuAck:
+		multiples, err := r.applyHu(ack)
+		if err != nil {
+			return err
+		}
+		step.Multiples = multiples
+	case *pbmj.MJScoreChangeAck:
+		for i, score := range ack.Scores {
+			if player := r.game.GetPlayer(int32(i)); player != nil {
+				player.AddScoreChange(score)
+			}
+		}
+	case *pbmj.MJTrustAck:
+		if player := r.game.GetPlayer(ack.Seat); player != nil {
+			player.SetTrusted(ack.Trust)
+		}
+	case *pbmj.MJResultAck:
+		r.finished = true
+		r.liuju = ack.Liuju
+	}
+	return nil
+}
+
+func (r *Replayer) applyHu(ack *pbmj.MJHuAck) ([]int64, error) {
+	p := r.play
+	if ack.PaoSeat != SeatNull && !r.game.IsValidSeat(ack.PaoSeat) {
+		return nil, fmt.Errorf("invalid pao seat %d", ack.PaoSeat)
+	}
+	huSeats := make([]int32, 0, len(ack.HuData))
+	for _, data := range ack.HuData {
+		if !r.game.IsValidSeat(data.Seat) {
+			return nil, fmt.Errorf("invalid hu seat %d", data.Seat)
+		}
+		p.huResult[data.Seat] = data
+		huSeats = append(huSeats, data.Seat)
+	}
+	if len(huSeats) == 0 {
+		return nil, nil
+	}
+	p.curTile = Tile(ack.Tile)
+	if ack.PaoSeat == SeatNull || (len(huSeats) == 1 && ack.PaoSeat == huSeats[0]) {
+		p.curSeat = huSeats[0]
+		return p.Zimo(), nil
+	}
+	if len(huSeats) == 1 && huSeats[0] == p.curSeat {
+		return p.DianKonHua(ack.PaoSeat), nil
+	}
+	p.curSeat = ack.PaoSeat
+	return p.PaoHu(huSeats), nil
+}
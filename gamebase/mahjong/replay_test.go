@@ -0,0 +1,210 @@
+package mahjong_test
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/kevin-chtw/tw_common/gamebase/game"
+	"github.com/kevin-chtw/tw_common/gamebase/mahjong"
+	"github.com/kevin-chtw/tw_proto/game/pbmj"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// testService 只有万字牌的简单玩法
+type testService struct{}
+
+func (testService) GetAllTiles(conf *mahjong.Rule) map[mahjong.Tile]int {
+	tiles := make(map[mahjong.Tile]int)
+	for p := range 9 {
+		tiles[mahjong.MakeTile(mahjong.ColorCharacter, p)] = 4
+	}
+	return tiles
+}
+
+func (testService) GetHandCount() int            { return 13 }
+func (testService) GetDefaultRules() []int       { return []int{0} }
+func (testService) GetFdRules() map[string]int32 { return map[string]int32{} }
+func (testService) GetHuResult(data *mahjong.HuData) *pbmj.MJHuData {
+	return &pbmj.MJHuData{Multi: 1, HuTypes: []int32{1}}
+}
+
+type testPlay struct{}
+
+func (testPlay) CheckHu(data *mahjong.HuData) mahjong.HuCoreType {
+	return mahjong.DefaultHuCore.CheckBasicHu(slices.Clone(data.Tiles), 0)
+}
+
+func (testPlay) GetExtraHuTypes(data *mahjong.PlayData, self bool) []int32 {
+	return nil
+}
+
+func newTestPlay(g *mahjong.Game, dealer *mahjong.Dealer) *mahjong.Play {
+	return mahjong.NewPlay(testPlay{}, g, dealer)
+}
+
+// w 第p张万字牌，p从1开始
+func w(p ...int) []int32 {
+	tiles := make([]int32, len(p))
+	for i := range p {
+		tiles[i] = mahjong.MakeTile(mahjong.ColorCharacter, p[i]-1).ToInt32()
+	}
+	return tiles
+}
+
+func newTestReplayRecord(t *testing.T) *mahjong.RecordData {
+	mahjong.Service = testService{}
+	table := game.NewLocalTable(1001, 1, "normal", 2, "", 1)
+	table.AddLocalPlayer("u0", 0, 100, false)
+	table.AddLocalPlayer("u1", 1, 50, true)
+	g := mahjong.NewGame(nil, table, 1)
+	record := g.GetRecord()
+	record.Initialize()
+
+	hand0 := w(1, 1, 1, 2, 3, 4, 5, 6, 7, 8, 9, 9, 9)
+	hand1 := w(2, 2, 2, 3, 3, 3, 4, 4, 4, 6, 6, 6, 7)
+	wall := slices.Concat(hand0, hand1, w(5), w(8, 8, 7, 1, 5, 5, 7, 8, 9))
+	record.RecordWall(mahjong.Int32Tile(wall))
+
+	acks := []struct {
+		msg  proto.Message
+		seat int32
+	}{
+		{&pbmj.MJGameStartAck{Banker: 0}, game.SeatAll},
+		{&pbmj.MJOpenDoorAck{Seat: 0, Tiles: append(hand0, w(5)...)}, 0},
+		{&pbmj.MJOpenDoorAck{Seat: 1, Tiles: hand1}, 1},
+		{&pbmj.MJDiscardAck{Seat: 0, Tile: w(6)[0]}, game.SeatAll},
+		{&pbmj.MJPonAck{Seat: 1, From: 0, Tile: w(6)[0]}, game.SeatAll},
+		{&pbmj.MJDiscardAck{Seat: 1, Tile: w(7)[0]}, game.SeatAll},
+		{&pbmj.MJDrawAck{Seat: 0, Tile: w(8)[0]}, 0},
+		{&pbmj.MJDrawAck{Seat: 0, Tile: 0}, 1},
+		{&pbmj.MJDiscardAck{Seat: 0, Tile: w(8)[0]}, game.SeatAll},
+		{&pbmj.MJDrawAck{Seat: 1, Tile: w(8)[0]}, 1},
+		{&pbmj.MJDrawAck{Seat: 1, Tile: 0}, 0},
+		{&pbmj.MJDiscardAck{Seat: 1, Tile: w(6)[0]}, game.SeatAll},
+		{&pbmj.MJHuAck{PaoSeat: 1, Tile: w(6)[0], HuData: []*pbmj.MJHuData{{Seat: 0, Multi: 2}}}, game.SeatAll},
+		{&pbmj.MJScoreChangeAck{Scores: []int64{2, -2}}, game.SeatAll},
+		{&pbmj.MJResultAck{}, game.SeatAll},
+	}
+	for i, ack := range acks {
+		record.RecordAction(ack.msg, ack.seat)
+		if i == 5 {
+			record.AddPlayerNetBreak(1)
+		}
+	}
+
+	b, err := record.GetData().ToJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := mahjong.UnmarshalRecordJSON(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestReplay(t *testing.T) {
+	r, err := mahjong.NewReplayer(newTestReplayRecord(t), newTestPlay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Len() != 14 {
+		t.Fatalf("Len() = %d, want 14", r.Len())
+	}
+
+	// 座位0摸牌之后
+	if err := r.Seek(8); err != nil {
+		t.Fatal(err)
+	}
+	all := r.View(game.SeatAll)
+	if len(all.Seats[0].HandTiles) != 14 || all.CurTile != mahjong.Tile(w(8)[0]) {
+		t.Errorf("omniscient view = %+v", all.Seats[0])
+	}
+	if len(all.Seats[1].PonGroups) != 1 || all.Seats[1].PonGroups[0].From != 0 || !all.Seats[1].Offline {
+		t.Errorf("seat 1 view = %+v", all.Seats[1])
+	}
+	seat1 := r.View(1)
+	if seat1.CurTile != mahjong.TileNull || seat1.Seats[0].HandTiles[0] != mahjong.TileNull {
+		t.Errorf("seat 1 can see seat 0 tiles: %+v", seat1)
+	}
+	if seat1.Seats[1].HandTiles[0] == mahjong.TileNull {
+		t.Errorf("seat 1 cannot see own tiles: %+v", seat1.Seats[1])
+	}
+
+	for {
+		step, err := r.Step()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := step.Msg.(*pbmj.MJHuAck); ok && !slices.Equal(step.Multiples, []int64{2, -2}) {
+			t.Errorf("hu multiples = %v", step.Multiples)
+		}
+	}
+	end := r.View(game.SeatAll)
+	if !end.Finished || end.Seats[0].Score != 102 || end.Seats[1].Score != 48 {
+		t.Errorf("end view = %+v %+v %+v", end, end.Seats[0], end.Seats[1])
+	}
+	if len(end.Seats[1].OutTiles) != 1 || len(end.Seats[0].OutTiles) != 1 {
+		t.Errorf("out tiles = %v %v", end.Seats[0].OutTiles, end.Seats[1].OutTiles)
+	}
+
+	// 向回跳转
+	if err := r.Seek(3); err != nil {
+		t.Fatal(err)
+	}
+	back := r.View(game.SeatAll)
+	if len(back.Seats[0].HandTiles) != 14 || len(back.Seats[1].PonGroups) != 0 || back.Seats[0].Score != 100 {
+		t.Errorf("seek back view = %+v", back.Seats[0])
+	}
+}
+
+func TestReplayDiverged(t *testing.T) {
+	tests := []struct {
+		name string
+		is   proto.Message
+		msg  proto.Message
+	}{
+		// 座位1手里没有5万，碰不了
+		{"pon", &pbmj.MJPonAck{}, &pbmj.MJPonAck{Seat: 1, From: 0, Tile: w(5)[0]}},
+		// 损坏的录像里座位越界
+		{"hu seat", &pbmj.MJHuAck{}, &pbmj.MJHuAck{PaoSeat: 1, Tile: w(6)[0], HuData: []*pbmj.MJHuData{{Seat: 9, Multi: 2}}}},
+		{"pao seat", &pbmj.MJHuAck{}, &pbmj.MJHuAck{PaoSeat: -7, Tile: w(6)[0], HuData: []*pbmj.MJHuData{{Seat: 0, Multi: 2}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := newTestReplayRecord(t)
+			for _, entry := range data.Entries {
+				if entry.Msg.MessageIs(tt.is) {
+					msg, err := anypb.New(tt.msg)
+					if err != nil {
+						t.Fatal(err)
+					}
+					entry.Msg = msg
+				}
+			}
+			r, err := mahjong.NewReplayer(data, newTestPlay)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for {
+				step, err := r.Step()
+				if err == io.EOF {
+					t.Fatal("diverged replay finished")
+				}
+				if err != nil {
+					if !proto.Equal(step.Msg, tt.msg) || !strings.Contains(err.Error(), fmt.Sprintf("replay step %d:", step.Index)) {
+						t.Errorf("step %d %v: %v", step.Index, step.Msg, err)
+					}
+					return
+				}
+			}
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"github.com/kevin-chtw/tw_common/gamebase/game"
 	"github.com/kevin-chtw/tw_common/utils"
 	"github.com/kevin-chtw/tw_proto/game/pbmj"
+	"github.com/topfreegames/pitaya/v3/pkg/logger"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -15,8 +16,9 @@ type Sender struct {
 	game        *Game
 	play        *Play
 	packer      MsgPacker
-	increasedID int32   // 当前请求ID
-	requestIDs  []int32 // 记录每个玩家的请求ID
+	increasedID int32                // 当前请求ID
+	requestIDs  []int32              // 记录每个玩家的请求ID
+	requests    []*pbmj.MJRequestAck // 每个玩家未完成的请求，重连时补发
 }
 
 func ToCallData(callData map[Tile]map[Tile]int64) map[int32]*pbmj.CallData {
@@ -34,13 +36,16 @@ func ToCallData(callData map[Tile]map[Tile]int64) map[int32]*pbmj.CallData {
 }
 
 func NewSender(game *Game, play *Play, packer MsgPacker) *Sender {
-	return &Sender{
+	s := &Sender{
 		game:        game,
 		play:        play,
 		packer:      packer,
 		increasedID: 1,
 		requestIDs:  make([]int32, game.GetPlayerCount()),
+		requests:    make([]*pbmj.MJRequestAck, game.GetPlayerCount()),
 	}
+	game.sender = s
+	return s
 }
 
 func (s *Sender) GetRequestID(seat int32) int32 {
@@ -62,15 +67,43 @@ func (s *Sender) IsRequestID(seat, id int32) bool {
 	return s.requestIDs[seat] == id
 }
 
+// GetPendingRequest 获取玩家未完成的请求
+func (s *Sender) GetPendingRequest(seat int32) *pbmj.MJRequestAck {
+	if !s.game.IsValidSeat(seat) {
+		return nil
+	}
+	return s.requests[seat]
+}
+
 func (s *Sender) SendMsg(msg proto.Message, seat int32) error {
+	s.game.record.RecordAction(msg, seat)
+	if seat == game.SeatAll {
+		// 广播的动作意味着之前的请求都已经结束
+		clear(s.requests)
+	}
 	ack, err := s.packer.PackMsg(msg)
 	if err != nil {
 		return err
 	}
-	s.game.Send2Player(ack, seat)
+	s.game.Send2Seat(ack, seat)
+	s.sendObservers(msg, seat)
 	return nil
 }
 
+// sendObservers 按观战策略把发给seat的消息转给观战者
+func (s *Sender) sendObservers(msg proto.Message, seat int32) {
+	view := ObserverView(msg, seat, s.game.GetObserverPolicy().Visibility)
+	if view == nil {
+		return
+	}
+	ack, err := s.packer.PackMsg(view)
+	if err != nil {
+		logger.Log.Error(err.Error())
+		return
+	}
+	s.game.Send2Observers(ack)
+}
+
 func (s *Sender) SendGameStartAck() {
 	startAck := &pbmj.MJGameStartAck{
 		Banker:    s.play.banker,
@@ -114,6 +147,13 @@ func (s *Sender) SendRequestAck(seat int32, operates *Operates) {
 	}
 
 	s.SendMsg(requestAck, seat)
+	if s.game.IsValidSeat(seat) {
+		s.requests[seat] = requestAck
+	} else {
+		for i := range s.requests {
+			s.requests[i] = requestAck
+		}
+	}
 }
 
 func (s *Sender) SendDiscardAck() {
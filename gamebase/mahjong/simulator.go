@@ -0,0 +1,423 @@
+package mahjong
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"runtime/debug"
+	"slices"
+	"time"
+
+	"github.com/kevin-chtw/tw_common/gamebase/game"
+	"github.com/kevin-chtw/tw_common/utils"
+	"github.com/kevin-chtw/tw_proto/cproto"
+	"github.com/kevin-chtw/tw_proto/game/pbmj"
+)
+
+const (
+	simMaxSteps     = 20000            // 每局默认最多处理的事件数
+	simRequestSlack = 10 * time.Second // 请求超过GameTimeoutMS后再等待的时间
+)
+
+// 模拟时检查的规则
+const (
+	SimRuleTiles    = "tiles"     // 牌墙、手牌、副露、出牌的总数和牌库一致
+	SimRuleZeroSum  = "zero_sum"  // 自然分时分数变化之和为0
+	SimRuleMinScore = "min_score" // 积分最小化时不能输成负分
+	SimRuleRequest  = "request"   // 每个请求都被回复或超时
+	SimRuleSteps    = "steps"     // 一局处理的事件数超过上限
+	SimRulePanic    = "panic"     // 牌局panic
+)
+
+// SimConfig 一种规则的模拟配置
+type SimConfig struct {
+	Name        string
+	PlayerCount int32
+	Property    string // 规则配置，和比赛配置的game_config相同
+	ScoreBase   int64
+	InitScore   int64
+	ScoreType   ScoreType // 玩法使用的算分方式，决定检查哪条分数规则
+	Rounds      int
+	MaxSteps    int        // 每局最多处理的事件数，0取默认值
+	RecordSink  RecordSink // 每局的录像输出，为nil时不输出
+	GameCreator game.GameCreator
+	BotCreator  game.BotCreator
+}
+
+// SimViolation 违反规则的记录
+type SimViolation struct {
+	Round  int
+	Step   int
+	Rule   string
+	Detail string
+}
+
+// HuTypeStat 胡牌类型统计
+type HuTypeStat struct {
+	Count      int
+	TotalMulti int64
+}
+
+// SimStats 一种规则的模拟结果
+type SimStats struct {
+	Name        string
+	Rounds      int
+	HuRounds    int
+	LiujuRounds int
+	HuTypes     map[int32]*HuTypeStat
+	Violations  []*SimViolation
+}
+
+// HuRate 胡牌局占比
+func (s *SimStats) HuRate() float64 {
+	return s.rate(s.HuRounds)
+}
+
+// LiujuRate 流局占比
+func (s *SimStats) LiujuRate() float64 {
+	return s.rate(s.LiujuRounds)
+}
+
+// AvgMulti 胡牌类型的平均倍数
+func (s *SimStats) AvgMulti(huType int32) float64 {
+	stat, ok := s.HuTypes[huType]
+	if !ok || stat.Count == 0 {
+		return 0
+	}
+	return float64(stat.TotalMulti) / float64(stat.Count)
+}
+
+func (s *SimStats) rate(count int) float64 {
+	if s.Rounds == 0 {
+		return 0
+	}
+	return float64(count) / float64(s.Rounds)
+}
+
+// Print 输出统计结果
+func (s *SimStats) Print(w io.Writer) {
+	fmt.Fprintf(w, "[%s] rounds=%d hu=%.2f%% liuju=%.2f%% violations=%d\n",
+		s.Name, s.Rounds, s.HuRate()*100, s.LiujuRate()*100, len(s.Violations))
+	for _, huType := range slices.Sorted(maps.Keys(s.HuTypes)) {
+		fmt.Fprintf(w, "  hutype=%d count=%d avg_multi=%.2f\n", huType, s.HuTypes[huType].Count, s.AvgMulti(huType))
+	}
+	for _, v := range s.Violations {
+		fmt.Fprintf(w, "  round=%d step=%d %s: %s\n", v.Round, v.Step, v.Rule, v.Detail)
+	}
+}
+
+// RunSimulations 依次模拟每种规则并输出统计结果
+func RunSimulations(w io.Writer, confs ...*SimConfig) ([]*SimStats, error) {
+	result := make([]*SimStats, 0, len(confs))
+	for _, conf := range confs {
+		stats, err := Simulate(conf)
+		if err != nil {
+			return result, fmt.Errorf("simulate %s: %w", conf.Name, err)
+		}
+		stats.Print(w)
+		result = append(result, stats)
+	}
+	return result, nil
+}
+
+// Simulate 在本进程内用bot打完conf.Rounds局，每处理一个事件检查一次规则。
+// Service需要已经设置，录像只输出到conf.RecordSink
+func Simulate(conf *SimConfig) (*SimStats, error) {
+	if conf.GameCreator == nil || conf.BotCreator == nil || conf.PlayerCount <= 0 {
+		return nil, errors.New("invalid simulate config")
+	}
+
+	s := newSimulator(conf)
+	for round := 1; round <= conf.Rounds; round++ {
+		s.runRound(round)
+	}
+	return s.stats, nil
+}
+
+// simMsg bot收到的消息或者发出的请求
+type simMsg struct {
+	seat int32
+	ack  *cproto.GameAck
+	req  *cproto.GameReq
+}
+
+type simulator struct {
+	conf     *SimConfig
+	stats    *SimStats
+	clock    *utils.ManualClock
+	table    *game.Table
+	players  []*game.Player
+	bots     []*game.BotPlayer
+	queue    []*simMsg
+	game     *Game
+	round    int
+	step     int
+	entries  int         // 已检查的录像条目数
+	requests []time.Time // 每个座位未回复请求的发送时间
+	broken   map[string]bool
+}
+
+func newSimulator(conf *SimConfig) *simulator {
+	s := &simulator{
+		conf:     conf,
+		stats:    &SimStats{Name: conf.Name, HuTypes: make(map[int32]*HuTypeStat)},
+		clock:    utils.NewManualClock(time.Unix(0, 0)),
+		players:  make([]*game.Player, conf.PlayerCount),
+		bots:     make([]*game.BotPlayer, conf.PlayerCount),
+		requests: make([]time.Time, conf.PlayerCount),
+	}
+	// 多局共用一张桌子，庄家等上局数据可以延续
+	s.table = game.NewLocalTable(0, 1, "simulate", conf.PlayerCount, conf.Property, conf.ScoreBase)
+	s.table.SetClock(s.clock)
+	s.table.SetLocalSink(func(player *game.Player, msg *cproto.GameAck) {
+		s.queue = append(s.queue, &simMsg{seat: player.GetSeat(), ack: msg})
+	})
+	for i := range conf.PlayerCount {
+		s.players[i] = s.table.AddLocalPlayer(fmt.Sprintf("bot%d", i), i, conf.InitScore, true)
+	}
+	return s
+}
+
+func (s *simulator) runRound(round int) {
+	s.round = round
+	s.step = 0
+	s.entries = 0
+	s.queue = nil
+	s.game = nil
+	s.broken = make(map[string]bool)
+	clear(s.requests)
+	for i, p := range s.players {
+		p.AddScore(s.conf.InitScore - p.GetScore())
+		bot := s.conf.BotCreator(p.GetUid(), 0, s.table.GetTableID(), s.conf.ScoreBase)
+		seat := int32(i)
+		bot.SetLocalSender(func(req *cproto.GameReq) {
+			s.queue = append(s.queue, &simMsg{seat: seat, req: req})
+		})
+		s.bots[i] = bot
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			s.violate(SimRulePanic, fmt.Sprintf("%v\n%s", r, debug.Stack()))
+		}
+		s.stats.Rounds++
+		s.collect()
+	}()
+
+	g, ok := s.table.BeginLocalGame(s.conf.GameCreator).(interface{ MJGame() *Game })
+	if !ok {
+		panic("game is not a mahjong game")
+	}
+	s.game = g.MJGame()
+	s.game.GetRecord().SetSink(s.conf.RecordSink)
+	s.check()
+
+	maxSteps := s.conf.MaxSteps
+	if maxSteps <= 0 {
+		maxSteps = simMaxSteps
+	}
+	for !s.game.over {
+		if s.step++; s.step > maxSteps {
+			s.violate(SimRuleSteps, fmt.Sprintf("game not over after %d steps", maxSteps))
+			return
+		}
+		if len(s.queue) > 0 {
+			msg := s.queue[0]
+			s.queue = s.queue[1:]
+			s.dispatch(msg)
+		} else {
+			// 没有消息时时间前进一秒，bot和桌子的定时器依次触发
+			s.clock.Advance(time.Second)
+			for _, bot := range s.bots {
+				if bot.Bot != nil {
+					bot.Bot.OnTimer()
+				}
+			}
+			s.table.Tick()
+		}
+		s.check()
+		if s.broken[SimRuleRequest] {
+			return
+		}
+	}
+}
+
+func (s *simulator) dispatch(msg *simMsg) {
+	if msg.ack != nil {
+		s.bots[msg.seat].OnBotMsg(msg.ack)
+		return
+	}
+	// bot可能回复已经过期的请求，出错不算违规
+	if err := s.table.OnPlayerMsg(s.players[msg.seat], msg.req); err == nil {
+		s.requests[msg.seat] = time.Time{}
+	}
+}
+
+// check 检查每条规则，同一局每条规则只记录第一次违规
+func (s *simulator) check() {
+	s.checkRequests()
+	s.checkTiles()
+	s.checkScores()
+}
+
+func (s *simulator) checkRequests() {
+	entries := s.game.record.GetData().Entries
+	now := s.clock.Now()
+	for ; s.entries < len(entries); s.entries++ {
+		entry := entries[s.entries]
+		if entry.Type != RecordTypeAck {
+			continue
+		}
+		if entry.Msg.MessageIs(&pbmj.MJRequestAck{}) {
+			s.setRequest(entry.Seat, now)
+		} else if entry.Seat == game.SeatAll {
+			// 和Sender一样，广播意味着之前的请求都已经结束
+			clear(s.requests)
+		}
+	}
+
+	timeout := GameTimeoutMS*time.Millisecond + simRequestSlack
+	for seat, t := range s.requests {
+		if !t.IsZero() && now.Sub(t) > timeout {
+			s.violate(SimRuleRequest, fmt.Sprintf("seat %d request not answered in %v", seat, now.Sub(t)))
+			return
+		}
+	}
+}
+
+func (s *simulator) setRequest(seat int32, now time.Time) {
+	if seat != game.SeatAll {
+		s.requests[seat] = now
+		return
+	}
+	for i := range s.requests {
+		s.requests[i] = now
+	}
+}
+
+func (s *simulator) checkTiles() {
+	if s.game.sender == nil {
+		return
+	}
+	play := s.game.sender.play
+	tiles := make(map[Tile]int)
+	add := func(tile Tile, count int) {
+		tiles[tile] += count
+	}
+	for _, tile := range play.dealer.tileWall {
+		add(tile, 1)
+	}
+	for _, tile := range play.huTiles {
+		add(tile, 1)
+	}
+	for _, data := range play.playData {
+		if data == nil {
+			return
+		}
+		for _, tile := range data.handTiles {
+			add(tile, 1)
+		}
+		for _, tile := range data.outTiles {
+			add(tile, 1)
+		}
+		for _, group := range data.ponGroups {
+			add(group.Tile, 3)
+		}
+		for _, group := range data.konGroups {
+			add(group.Tile, 4)
+		}
+		for _, group := range data.chowGroups {
+			color, point := group.LeftTile.Info()
+			for i := range 3 {
+				add(MakeTile(color, point+i), 1)
+			}
+		}
+	}
+
+	want := Service.GetAllTiles(s.game.rule)
+	for tile, count := range want {
+		if tiles[tile] != count {
+			s.violate(SimRuleTiles, fmt.Sprintf("%s count %d, want %d", tile.Name(), tiles[tile], count))
+			return
+		}
+	}
+	for tile, count := range tiles {
+		if _, ok := want[tile]; !ok && count != 0 {
+			s.violate(SimRuleTiles, fmt.Sprintf("unexpected tile %s count %d", tile.Name(), count))
+			return
+		}
+	}
+}
+
+func (s *simulator) checkScores() {
+	switch s.conf.ScoreType {
+	case ScoreTypeNatural:
+		var sum int64
+		for _, p := range s.game.players {
+			sum += p.GetScoreChange()
+		}
+		if sum != 0 {
+			s.violate(SimRuleZeroSum, fmt.Sprintf("score changes sum %d", sum))
+		}
+	case ScoreTypeMinScore:
+		for _, p := range s.game.players {
+			if p.GetCurScore() < 0 {
+				s.violate(SimRuleMinScore, fmt.Sprintf("seat %d score %d", p.GetSeat(), p.GetCurScore()))
+				return
+			}
+		}
+	}
+}
+
+func (s *simulator) violate(rule, detail string) {
+	if s.broken[rule] {
+		return
+	}
+	s.broken[rule] = true
+	s.stats.Violations = append(s.stats.Violations, &SimViolation{
+		Round:  s.round,
+		Step:   s.step,
+		Rule:   rule,
+		Detail: detail,
+	})
+}
+
+// collect 从录像统计胡牌和流局
+func (s *simulator) collect() {
+	if s.game == nil {
+		return
+	}
+	hu := false
+	for _, entry := range s.game.record.GetData().Entries {
+		if entry.Type != RecordTypeAck || entry.Msg == nil {
+			continue
+		}
+		msg, err := entry.Msg.UnmarshalNew()
+		if err != nil {
+			continue
+		}
+		switch ack := msg.(type) {
+		case *pbmj.MJHuAck:
+			hu = hu || len(ack.HuData) > 0
+			for _, data := range ack.HuData {
+				for _, huType := range data.HuTypes {
+					stat, ok := s.stats.HuTypes[huType]
+					if !ok {
+						stat = &HuTypeStat{}
+						s.stats.HuTypes[huType] = stat
+					}
+					stat.Count++
+					stat.TotalMulti += data.Multi
+				}
+			}
+		case *pbmj.MJResultAck:
+			if ack.Liuju {
+				s.stats.LiujuRounds++
+			}
+		}
+	}
+	if hu {
+		s.stats.HuRounds++
+	}
+}
@@ -0,0 +1,292 @@
+package mahjong_test
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/kevin-chtw/tw_common/gamebase/game"
+	"github.com/kevin-chtw/tw_common/gamebase/mahjong"
+	"github.com/kevin-chtw/tw_proto/cproto"
+	"github.com/kevin-chtw/tw_proto/game/pbmj"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// simPacker 用Any包装消息，bot可以知道消息类型
+type simPacker struct{}
+
+func (simPacker) PackMsg(msg proto.Message) (proto.Message, error) {
+	return anypb.New(msg)
+}
+
+// simGame 只能摸打和胡的简单玩法，请求也用Any包装的pbmj消息
+type simGame struct {
+	*mahjong.Game
+	play     *mahjong.Play
+	sender   *mahjong.Sender
+	badScore bool // 结算时只给赢家加分
+}
+
+func (g *simGame) OnStart() {
+	g.play = newTestPlay(g.Game, mahjong.NewDealer(g.Game))
+	g.play.PlayConf = &mahjong.PlayConf{}
+	g.play.RegisterSelfCheck(mahjong.NewCheckerHu(g.play))
+	g.play.RegisterWaitCheck(mahjong.NewCheckerPao(g.play))
+	g.sender = mahjong.NewSender(g.Game, g.play, simPacker{})
+	g.play.Initialize(mahjong.NewPlayData)
+	g.play.Deal()
+	g.sender.SendGameStartAck()
+	g.sender.SendOpenDoorAck()
+	g.SetNextState(newSimDiscardState)
+}
+
+func (g *simGame) OnReqMsg(player *game.Player, data []byte) error {
+	req := &anypb.Any{}
+	if err := proto.Unmarshal(data, req); err != nil {
+		return err
+	}
+	msg, err := req.UnmarshalNew()
+	if err != nil {
+		return err
+	}
+	return g.CurState.OnPlayerMsg(player.GetSeat(), msg)
+}
+
+// simEnd 结算，multiples为nil时流局
+func (g *simGame) simEnd(multiples []int64) {
+	for i, multi := range multiples {
+		if multi > 0 || !g.badScore {
+			g.GetPlayer(int32(i)).AddScoreChange(multi * g.GetScoreBase())
+		}
+	}
+	g.sender.SendResult(multiples == nil)
+	g.OnGameOver()
+}
+
+type simDiscardState struct {
+	*mahjong.State
+	game *simGame
+	opt  *mahjong.Operates
+}
+
+func newSimDiscardState(g mahjong.IGame, _ ...any) mahjong.IState {
+	sg := g.(*simGame)
+	return &simDiscardState{State: mahjong.NewState(sg.Game, sg.sender), game: sg}
+}
+
+func (s *simDiscardState) OnEnter() {
+	s.opt = s.game.play.FetchSelfOperates(s.game.sender)
+	s.game.sender.SendRequestAck(s.game.play.GetCurSeat(), s.opt)
+	s.AsyncMsgTimer(s.onMsg, 10*time.Second, func() { s.discard(mahjong.TileNull) })
+}
+
+func (s *simDiscardState) onMsg(seat int32, req proto.Message) error {
+	if seat != s.game.play.GetCurSeat() {
+		return errors.New("not current seat")
+	}
+	switch msg := req.(type) {
+	case *pbmj.MJHuAck:
+		if !s.opt.HasOperate(mahjong.OperateHu) {
+			return errors.New("cannot hu")
+		}
+		multiples := s.game.play.Zimo()
+		s.game.sender.SendHuAck([]int32{seat}, mahjong.SeatNull)
+		s.game.simEnd(multiples)
+	case *pbmj.MJDiscardAck:
+		return s.discard(mahjong.Tile(msg.Tile))
+	default:
+		return errors.New("unknown request")
+	}
+	return nil
+}
+
+func (s *simDiscardState) discard(tile mahjong.Tile) error {
+	if !s.game.play.Discard(tile) {
+		return errors.New("cannot discard")
+	}
+	s.game.sender.SendDiscardAck()
+	s.game.SetNextState(newSimWaitState)
+	return nil
+}
+
+type simWaitState struct {
+	*mahjong.State
+	game    *simGame
+	waiting map[int32]bool
+}
+
+func newSimWaitState(g mahjong.IGame, _ ...any) mahjong.IState {
+	sg := g.(*simGame)
+	return &simWaitState{State: mahjong.NewState(sg.Game, sg.sender), game: sg, waiting: make(map[int32]bool)}
+}
+
+func (s *simWaitState) OnEnter() {
+	for seat := range s.game.GetPlayerCount() {
+		if seat == s.game.play.GetCurSeat() {
+			continue
+		}
+		opt := s.game.play.FetchWaitOperates(seat, s.game.sender)
+		if opt.HasOperate(mahjong.OperateHu) {
+			s.waiting[seat] = true
+			s.game.sender.SendRequestAck(seat, opt)
+		}
+	}
+	if len(s.waiting) == 0 {
+		s.game.SetNextState(newSimDrawState)
+		return
+	}
+	s.AsyncMsgTimer(s.onMsg, 10*time.Second, func() { s.game.SetNextState(newSimDrawState) })
+}
+
+func (s *simWaitState) onMsg(seat int32, req proto.Message) error {
+	if !s.waiting[seat] {
+		return errors.New("not waiting")
+	}
+	switch req.(type) {
+	case *pbmj.MJHuAck:
+		multiples := s.game.play.PaoHu([]int32{seat})
+		s.game.sender.SendHuAck([]int32{seat}, s.game.play.GetCurSeat())
+		s.game.simEnd(multiples)
+	case *emptypb.Empty:
+		delete(s.waiting, seat)
+		if len(s.waiting) == 0 {
+			s.game.SetNextState(newSimDrawState)
+		}
+	default:
+		return errors.New("unknown request")
+	}
+	return nil
+}
+
+type simDrawState struct {
+	game *simGame
+}
+
+func newSimDrawState(g mahjong.IGame, _ ...any) mahjong.IState {
+	return &simDrawState{game: g.(*simGame)}
+}
+
+func (s *simDrawState) OnEnter() {
+	s.game.play.DoSwitchSeat(mahjong.SeatNull)
+	tile := s.game.play.Draw()
+	if tile == mahjong.TileNull {
+		s.game.simEnd(nil)
+		return
+	}
+	s.game.sender.SendDrawAck(tile)
+	s.game.SetNextState(newSimDiscardState)
+}
+
+func (s *simDrawState) OnPlayerMsg(seat int32, req proto.Message) error {
+	return errors.New("drawing")
+}
+
+// simBot 能胡就胡，否则打出最后摸的牌，不回复的请求等超时
+type simBot struct {
+	*game.BotPlayer
+	seat int32
+}
+
+func (b *simBot) OnBotMsg(msg proto.Message) error {
+	ack := &cproto.TableMsgAck{}
+	if err := msg.(*cproto.GameAck).Ack.UnmarshalTo(ack); err != nil {
+		return nil
+	}
+	data := &anypb.Any{}
+	if err := proto.Unmarshal(ack.Msg, data); err != nil {
+		return err
+	}
+	m, err := data.UnmarshalNew()
+	if err != nil {
+		return err
+	}
+	req, ok := m.(*pbmj.MJRequestAck)
+	if !ok || req.Seat != b.seat {
+		return nil
+	}
+
+	var reply proto.Message
+	switch {
+	case req.RequestType&mahjong.OperateHu != 0:
+		reply = &pbmj.MJHuAck{}
+	case req.RequestType&mahjong.OperateDiscard != 0:
+		if b.seat == 1 {
+			return nil
+		}
+		reply = &pbmj.MJDiscardAck{Tile: mahjong.TileNull.ToInt32()}
+	default:
+		reply = &emptypb.Empty{}
+	}
+	data, err = anypb.New(reply)
+	if err != nil {
+		return err
+	}
+	return b.SendMsg(data)
+}
+
+func (b *simBot) OnTimer() error { return nil }
+
+func newSimConfig(badScore bool) *mahjong.SimConfig {
+	return &mahjong.SimConfig{
+		Name:        "sim",
+		PlayerCount: 2,
+		ScoreBase:   1,
+		InitScore:   1000,
+		ScoreType:   mahjong.ScoreTypeNatural,
+		Rounds:      50,
+		GameCreator: func(table *game.Table, id int32) game.IGame {
+			g := &simGame{badScore: badScore}
+			g.Game = mahjong.NewGame(g, table, id)
+			return g
+		},
+		BotCreator: func(uid string, matchid, tableid int32, scorebase int64) *game.BotPlayer {
+			p := game.NewBotPlayer(uid, matchid, tableid, scorebase)
+			p.Bot = &simBot{BotPlayer: p, seat: int32(uid[len(uid)-1] - '0')}
+			return p
+		},
+	}
+}
+
+func TestSimulate(t *testing.T) {
+	mahjong.Service = testService{}
+	// 录像只交给配置的输出，不用默认输出
+	mahjong.DefaultRecordSink = func(*mahjong.RecordData) { t.Error("default record sink used") }
+	defer func() { mahjong.DefaultRecordSink = nil }()
+	conf := newSimConfig(false)
+	records := 0
+	conf.RecordSink = func(*mahjong.RecordData) { records++ }
+	stats, err := mahjong.RunSimulations(os.Stdout, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := stats[0]
+	if s.Rounds != 50 || s.HuRounds+s.LiujuRounds != s.Rounds || s.HuRounds == 0 {
+		t.Errorf("stats = %+v", s)
+	}
+	if len(s.Violations) != 0 {
+		t.Errorf("violations = %+v", s.Violations[0])
+	}
+	if s.HuTypes[1] == nil || s.AvgMulti(1) != 1 {
+		t.Errorf("hu types = %v", s.HuTypes)
+	}
+	// 每局只结束一次
+	if records != s.Rounds {
+		t.Errorf("records = %d, want %d", records, s.Rounds)
+	}
+
+	// 只给赢家加分时分数之和不为0
+	s, err = mahjong.Simulate(newSimConfig(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, v := range s.Violations {
+		found = found || v.Rule == mahjong.SimRuleZeroSum
+	}
+	if !found {
+		t.Errorf("zero sum violation not found: %+v", s.Violations)
+	}
+}
@@ -0,0 +1,170 @@
+package mahjong
+
+import (
+	"slices"
+
+	"github.com/kevin-chtw/tw_common/gamebase/game"
+	"github.com/topfreegames/pitaya/v3/pkg/logger"
+	"google.golang.org/protobuf/proto"
+)
+
+// SnapshotPacker 玩法的MsgPacker可选实现，把快照转换成玩法自己的协议消息
+// 未实现时断线重连仍然重发历史消息
+type SnapshotPacker interface {
+	PackSnapshot(snapshot *Snapshot) (proto.Message, error)
+}
+
+// SeatState 某个座位的牌面状态
+type SeatState struct {
+	Seat       int32
+	Uid        string
+	HandTiles  []Tile // 看不到的手牌为TileNull
+	OutTiles   []Tile
+	ChowGroups []ChowGroup
+	PonGroups  []Group
+	KonGroups  []KonGroup     // 看不到的暗杠为TileNull
+	CallData   map[Tile]int64 // 只有自己可见
+	Ting       bool
+	Score      int64
+	Trusted    bool
+	Offline    bool
+	Out        bool
+}
+
+// PlayState 牌桌状态，viewer为game.SeatAll时是上帝视角
+type PlayState struct {
+	Viewer    int32
+	Banker    int32
+	CurSeat   int32
+	CurTile   Tile
+	RestCount int32
+	Seats     []*SeatState
+}
+
+// Snapshot 断线重连时发给玩家的快照
+type Snapshot struct {
+	*PlayState
+	Property string
+	Timeout  int64 // 当前操作剩余毫秒数
+}
+
+// GetState 获取viewer视角的牌桌状态
+func (p *Play) GetState(viewer int32) *PlayState {
+	state := &PlayState{
+		Viewer:    viewer,
+		Banker:    p.banker,
+		CurSeat:   p.curSeat,
+		CurTile:   p.curTile,
+		RestCount: p.dealer.GetRestCount(),
+		Seats:     make([]*SeatState, len(p.playData)),
+	}
+	for i := range p.playData {
+		state.Seats[i] = p.seatState(int32(i), viewer == game.SeatAll || viewer == int32(i))
+	}
+	// 别人刚摸的牌不可见
+	if viewer != game.SeatAll && len(p.history) > 0 {
+		last := p.history[len(p.history)-1]
+		if last.Operate == OperateDraw && last.Seat != viewer {
+			state.CurTile = TileNull
+		}
+	}
+	return state
+}
+
+func (p *Play) seatState(seat int32, visible bool) *SeatState {
+	playData := p.playData[seat]
+	state := &SeatState{Seat: seat}
+	if player := p.game.GetGamePlayer(seat); player != nil {
+		state.Uid = player.GetUid()
+	}
+	if player := p.game.GetPlayer(seat); player != nil {
+		state.Score = player.GetCurScore()
+		state.Trusted = player.IsTrusted()
+		state.Offline = player.IsOffline()
+		state.Out = player.IsOut()
+	}
+	if playData == nil {
+		return state
+	}
+	state.HandTiles = slices.Clone(playData.handTiles)
+	state.OutTiles = slices.Clone(playData.outTiles)
+	state.ChowGroups = slices.Clone(playData.chowGroups)
+	state.PonGroups = slices.Clone(playData.ponGroups)
+	state.KonGroups = slices.Clone(playData.konGroups)
+	state.Ting = playData.ting
+	if visible {
+		state.CallData = make(map[Tile]int64, len(playData.callData))
+		for k, v := range playData.callData {
+			state.CallData[k] = v
+		}
+		return state
+	}
+	for i := range state.HandTiles {
+		state.HandTiles[i] = TileNull
+	}
+	for i := range state.KonGroups {
+		if state.KonGroups[i].Type == KonTypeAn {
+			state.KonGroups[i].Tile = TileNull
+		}
+	}
+	return state
+}
+
+// HasSnapshot 玩法是否支持快照重连
+func (g *Game) HasSnapshot() bool {
+	if g.sender == nil {
+		return false
+	}
+	_, ok := g.sender.packer.(SnapshotPacker)
+	return ok
+}
+
+// Snapshot 玩家重连时发送的消息：快照和未完成的请求
+func (g *Game) Snapshot(player *game.Player) []proto.Message {
+	seat := player.GetSeat()
+	msg := g.packSnapshot(seat)
+	if msg == nil {
+		return nil
+	}
+	msgs := []proto.Message{msg}
+	if req := g.sender.GetPendingRequest(seat); req != nil {
+		if ack, err := g.sender.packer.PackMsg(req); err == nil {
+			msgs = append(msgs, ack)
+		}
+	}
+	return msgs
+}
+
+// ObserverSnapshot 实现game.IObserverSnapshot，公开观战时看不到任何人的手牌
+func (g *Game) ObserverSnapshot(visibility game.ObserverVisibility) []proto.Message {
+	if !g.HasSnapshot() {
+		return nil
+	}
+	viewer := SeatNull
+	if visibility == game.ObserverFull {
+		viewer = game.SeatAll
+	}
+	if msg := g.packSnapshot(viewer); msg != nil {
+		return []proto.Message{msg}
+	}
+	return nil
+}
+
+// packSnapshot 按viewer视角打包快照，玩法不支持时返回nil
+func (g *Game) packSnapshot(viewer int32) proto.Message {
+	packer, ok := g.sender.packer.(SnapshotPacker)
+	if !ok {
+		return nil
+	}
+	snapshot := &Snapshot{
+		PlayState: g.sender.play.GetState(viewer),
+		Property:  g.rule.ToString(),
+		Timeout:   g.timer.Remaining().Milliseconds(),
+	}
+	msg, err := packer.PackSnapshot(snapshot)
+	if err != nil {
+		logger.Log.Error(err)
+		return nil
+	}
+	return msg
+}
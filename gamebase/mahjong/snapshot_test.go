@@ -0,0 +1,72 @@
+package mahjong_test
+
+import (
+	"testing"
+
+	"github.com/kevin-chtw/tw_common/gamebase/game"
+	"github.com/kevin-chtw/tw_common/gamebase/mahjong"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+type testPacker struct {
+	snapshot *mahjong.Snapshot
+}
+
+func (p *testPacker) PackMsg(msg proto.Message) (proto.Message, error) {
+	return msg, nil
+}
+
+func (p *testPacker) PackSnapshot(snapshot *mahjong.Snapshot) (proto.Message, error) {
+	p.snapshot = snapshot
+	return &emptypb.Empty{}, nil
+}
+
+func TestSnapshot(t *testing.T) {
+	r, err := mahjong.NewReplayer(newTestReplayRecord(t), newTestPlay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 座位0摸牌之后
+	if err := r.Seek(8); err != nil {
+		t.Fatal(err)
+	}
+	g := r.GetGame()
+	if g.HasSnapshot() {
+		t.Fatal("game without sender should not support snapshot")
+	}
+	packer := &testPacker{}
+	mahjong.NewSender(g, r.GetPlay(), packer)
+	if !g.HasSnapshot() {
+		t.Fatal("HasSnapshot() = false")
+	}
+
+	msgs := g.Snapshot(g.GetGamePlayer(1))
+	if len(msgs) != 1 || packer.snapshot == nil {
+		t.Fatalf("Snapshot() = %v", msgs)
+	}
+	s := packer.snapshot
+	if s.Viewer != 1 || s.CurTile != mahjong.TileNull || s.Property != g.GetRule().ToString() {
+		t.Errorf("snapshot = %+v", s)
+	}
+	if len(s.Seats[0].HandTiles) != 14 || s.Seats[0].HandTiles[0] != mahjong.TileNull {
+		t.Errorf("seat 0 hand = %v", s.Seats[0].HandTiles)
+	}
+	if s.Seats[1].HandTiles[0] == mahjong.TileNull || len(s.Seats[1].PonGroups) != 1 || s.Seats[1].Score != 50 {
+		t.Errorf("seat 1 = %+v", s.Seats[1])
+	}
+
+	// 公开观战看不到任何人的手牌，完全观战都能看到
+	if msgs := g.ObserverSnapshot(game.ObserverPublic); len(msgs) != 1 {
+		t.Fatalf("ObserverSnapshot() = %v", msgs)
+	}
+	s = packer.snapshot
+	if s.Seats[0].HandTiles[0] != mahjong.TileNull || s.Seats[1].HandTiles[0] != mahjong.TileNull || s.CurTile != mahjong.TileNull {
+		t.Errorf("public snapshot = %+v", s)
+	}
+	g.ObserverSnapshot(game.ObserverFull)
+	s = packer.snapshot
+	if s.Seats[0].HandTiles[0] == mahjong.TileNull || s.Seats[1].HandTiles[0] == mahjong.TileNull || s.CurTile == mahjong.TileNull {
+		t.Errorf("full snapshot = %+v", s)
+	}
+}
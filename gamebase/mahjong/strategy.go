@@ -0,0 +1,152 @@
+package mahjong
+
+import (
+	"math/rand"
+	"slices"
+
+	"github.com/kevin-chtw/tw_common/gamebase/game"
+)
+
+// 内置的bot策略名
+const (
+	StrategyRandom  = "random"  // 随机选择可以做的操作
+	StrategyGreedy  = "greedy"  // 能吃碰杠就吃碰杠
+	StrategyShanten = "shanten" // 按向听数和有效牌决策，难度越低越容易失误
+)
+
+// Strategy bot的决策策略，参数同AIView.Decide
+type Strategy interface {
+	Decide(view *AIView, operates int32, curTile Tile) *AIDecision
+}
+
+// StrategyCreator 按难度创建策略
+type StrategyCreator func(level game.BotLevel) Strategy
+
+// StrategyFunc 把函数包装成策略
+type StrategyFunc func(view *AIView, operates int32, curTile Tile) *AIDecision
+
+func (f StrategyFunc) Decide(view *AIView, operates int32, curTile Tile) *AIDecision {
+	return f(view, operates, curTile)
+}
+
+var strategies = map[string]StrategyCreator{
+	StrategyRandom: func(game.BotLevel) Strategy { return StrategyFunc(decideRandom) },
+	StrategyGreedy: func(game.BotLevel) Strategy { return StrategyFunc(decideGreedy) },
+	StrategyShanten: func(level game.BotLevel) Strategy {
+		return &shantenStrategy{mistake: shantenMistakes[level]}
+	},
+}
+
+// shantenMistakes 各难度随机出错的概率
+var shantenMistakes = map[game.BotLevel]float64{
+	game.BotLevelEasy:   0.3,
+	game.BotLevelNormal: 0.1,
+	game.BotLevelHard:   0,
+}
+
+// RegisterStrategy 注册策略，同名覆盖，需要在创建bot之前调用
+func RegisterStrategy(name string, creator StrategyCreator) {
+	strategies[name] = creator
+}
+
+// NewStrategy 创建策略，name没有注册时使用StrategyShanten
+func NewStrategy(name string, level game.BotLevel) Strategy {
+	creator, ok := strategies[name]
+	if !ok {
+		creator = strategies[StrategyShanten]
+	}
+	return creator(level)
+}
+
+// NewProfileStrategy 按比赛的bot配置创建策略
+func NewProfileStrategy(profile *game.BotProfile) Strategy {
+	if profile == nil {
+		return NewStrategy(StrategyShanten, game.BotLevelNormal)
+	}
+	return NewStrategy(profile.Strategy, profile.Level)
+}
+
+// Action 决定对应的动作名，用于按动作取思考时间
+func (d *AIDecision) Action() string {
+	return OperateNames[d.Operate]
+}
+
+type shantenStrategy struct {
+	mistake float64
+}
+
+// Decide 能胡一定胡，其他情况按概率随机出错
+func (s *shantenStrategy) Decide(view *AIView, operates int32, curTile Tile) *AIDecision {
+	if operates&OperateHu == 0 && s.mistake > 0 && rand.Float64() < s.mistake {
+		return decideRandom(view, operates, curTile)
+	}
+	return view.Decide(operates, curTile)
+}
+
+// decideGreedy 能胡就胡，能杠碰吃就杠碰吃，听牌就报听，否则打最好的牌
+func decideGreedy(view *AIView, operates int32, curTile Tile) *AIDecision {
+	if operates&OperateHu != 0 {
+		return &AIDecision{Operate: OperateHu, Tile: curTile}
+	}
+	if operates&OperateDiscard != 0 {
+		if operates&OperateKon != 0 {
+			if tiles := view.selfKonTiles(); len(tiles) > 0 {
+				return &AIDecision{Operate: OperateKon, Tile: tiles[0]}
+			}
+		}
+		return view.decideSelf(operates &^ OperateKon)
+	}
+	if operates&OperateKon != 0 && !view.isLai(curTile) {
+		return &AIDecision{Operate: OperateKon, Tile: curTile}
+	}
+	if operates&OperatePon != 0 {
+		return &AIDecision{Operate: OperatePon, Tile: curTile}
+	}
+	if operates&OperateChow != 0 {
+		if lefts := view.chowLeftTiles(curTile); len(lefts) > 0 {
+			return &AIDecision{Operate: OperateChow, Tile: curTile, LeftTile: lefts[0]}
+		}
+	}
+	return &AIDecision{Operate: OperatePass, Tile: curTile}
+}
+
+// decideRandom 在可以做的操作里随机选一个，不报听
+func decideRandom(view *AIView, operates int32, curTile Tile) *AIDecision {
+	choices := make([]*AIDecision, 0)
+	if operates&OperateHu != 0 {
+		choices = append(choices, &AIDecision{Operate: OperateHu, Tile: curTile})
+	}
+	if operates&OperateDiscard != 0 {
+		if operates&OperateKon != 0 {
+			for _, tile := range view.selfKonTiles() {
+				choices = append(choices, &AIDecision{Operate: OperateKon, Tile: tile})
+			}
+		}
+		tiles := view.uniqueTiles(view.Hand)
+		if view.Conf != nil && view.Conf.CanotDiscardLai {
+			if rest := slices.DeleteFunc(slices.Clone(tiles), view.isLai); len(rest) > 0 {
+				tiles = rest
+			}
+		}
+		tile := TileNull
+		if len(tiles) > 0 {
+			tile = tiles[rand.Intn(len(tiles))]
+		}
+		choices = append(choices, &AIDecision{Operate: OperateDiscard, Tile: tile})
+		return choices[rand.Intn(len(choices))]
+	}
+
+	choices = append(choices, &AIDecision{Operate: OperatePass, Tile: curTile})
+	if operates&OperateKon != 0 && !view.isLai(curTile) {
+		choices = append(choices, &AIDecision{Operate: OperateKon, Tile: curTile})
+	}
+	if operates&OperatePon != 0 {
+		choices = append(choices, &AIDecision{Operate: OperatePon, Tile: curTile})
+	}
+	if operates&OperateChow != 0 {
+		for _, left := range view.chowLeftTiles(curTile) {
+			choices = append(choices, &AIDecision{Operate: OperateChow, Tile: curTile, LeftTile: left})
+		}
+	}
+	return choices[rand.Intn(len(choices))]
+}
@@ -0,0 +1,119 @@
+package mahjong_test
+
+import (
+	"testing"
+
+	"github.com/kevin-chtw/tw_common/gamebase/game"
+	"github.com/kevin-chtw/tw_common/gamebase/mahjong"
+	"github.com/kevin-chtw/tw_proto/cproto"
+	"github.com/kevin-chtw/tw_proto/game/pbmj"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+func TestStrategies(t *testing.T) {
+	// 已经听牌，shanten不碰，greedy碰
+	hand := append(mahjong.Int32Tile(w(1, 2, 3, 4, 5, 6, 7, 8, 9, 5, 5)), east, east)
+	five := mahjong.Tile(w(5)[0])
+	ops := int32(mahjong.OperatePass | mahjong.OperatePon)
+	if d := mahjong.NewStrategy(mahjong.StrategyShanten, game.BotLevelHard).Decide(newAIView(hand...), ops, five); d.Operate != mahjong.OperatePass {
+		t.Errorf("shanten decision = %+v", d)
+	}
+	if d := mahjong.NewStrategy(mahjong.StrategyGreedy, game.BotLevelHard).Decide(newAIView(hand...), ops, five); d.Operate != mahjong.OperatePon {
+		t.Errorf("greedy decision = %+v", d)
+	}
+	// 没有注册的策略用shanten
+	if d := mahjong.NewStrategy("unknown", game.BotLevelHard).Decide(newAIView(hand...), ops, five); d.Operate != mahjong.OperatePass {
+		t.Errorf("unknown decision = %+v", d)
+	}
+
+	// random只选可以做的操作，打出的牌在手里
+	random := mahjong.NewStrategy(mahjong.StrategyRandom, game.BotLevelEasy)
+	for range 50 {
+		d := random.Decide(newAIView(hand...), ops, five)
+		if d.Operate != mahjong.OperatePass && d.Operate != mahjong.OperatePon {
+			t.Fatalf("random decision = %+v", d)
+		}
+		d = random.Decide(newAIView(hand...), mahjong.OperateDiscard, mahjong.TileNull)
+		if d.Operate != mahjong.OperateDiscard || mahjong.CountElement(hand, d.Tile) == 0 {
+			t.Fatalf("random discard = %+v", d)
+		}
+	}
+
+	mahjong.RegisterStrategy("pass", func(game.BotLevel) mahjong.Strategy {
+		return mahjong.StrategyFunc(func(*mahjong.AIView, int32, mahjong.Tile) *mahjong.AIDecision {
+			return &mahjong.AIDecision{Operate: mahjong.OperatePass}
+		})
+	})
+	profile := &game.BotProfile{Strategy: "pass"}
+	if d := mahjong.NewProfileStrategy(profile).Decide(newAIView(hand...), ops, five); d.Action() != "Pass" {
+		t.Errorf("registered decision = %+v", d)
+	}
+}
+
+// brainBot 用BotBrain决策的模拟bot
+type brainBot struct {
+	*game.BotPlayer
+	brain *mahjong.BotBrain
+}
+
+func (b *brainBot) OnBotMsg(msg proto.Message) error {
+	ack := &cproto.TableMsgAck{}
+	if err := msg.(*cproto.GameAck).Ack.UnmarshalTo(ack); err != nil {
+		return nil
+	}
+	data := &anypb.Any{}
+	if err := proto.Unmarshal(ack.Msg, data); err != nil {
+		return err
+	}
+	m, err := data.UnmarshalNew()
+	if err != nil {
+		return err
+	}
+	d := b.brain.OnMsg(m)
+	if d == nil {
+		return nil
+	}
+
+	var reply proto.Message
+	switch d.Operate {
+	case mahjong.OperateHu:
+		reply = &pbmj.MJHuAck{}
+	case mahjong.OperateDiscard:
+		reply = &pbmj.MJDiscardAck{Tile: d.Tile.ToInt32()}
+	default:
+		reply = &emptypb.Empty{}
+	}
+	if data, err = anypb.New(reply); err != nil {
+		return err
+	}
+	return b.Reply(d.Action(), data)
+}
+
+func (b *brainBot) OnTimer() error { return nil }
+
+func TestStrategySimulate(t *testing.T) {
+	mahjong.Service = testService{}
+	for _, name := range []string{mahjong.StrategyRandom, mahjong.StrategyGreedy, mahjong.StrategyShanten} {
+		conf := newSimConfig(false)
+		conf.Name = name
+		conf.BotCreator = func(uid string, matchid, tableid int32, scorebase int64) *game.BotPlayer {
+			p := game.NewBotPlayer(uid, matchid, tableid, scorebase)
+			seat := int32(uid[len(uid)-1] - '0')
+			strategy := mahjong.NewStrategy(name, game.BotLevelNormal)
+			p.Bot = &brainBot{BotPlayer: p, brain: mahjong.NewBotBrain(seat, newAIView().AllTiles, &mahjong.PlayConf{}, strategy)}
+			return p
+		}
+		s, err := mahjong.Simulate(conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(s.Violations) != 0 {
+			t.Errorf("%s violations = %+v", name, s.Violations[0])
+		}
+		if name != mahjong.StrategyRandom && s.HuRounds == 0 {
+			t.Errorf("%s never hu: %+v", name, s)
+		}
+	}
+}
@@ -2,6 +2,9 @@ package mahjong
 
 import (
 	"time"
+
+	"github.com/kevin-chtw/tw_common/gamebase/game"
+	"github.com/kevin-chtw/tw_common/utils"
 )
 
 const (
@@ -9,35 +12,67 @@ const (
 	GameTimeoutMS   = 120 * 1000
 )
 
-// Timer 麻将游戏定时器
+// Timer 麻将游戏定时器，注册到桌子时到期自动回调，否则由OnTick轮询
 type Timer struct {
+	clock       utils.Clock
+	table       *game.Table
+	after       func() // 到期回调之后执行
+	task        *game.TableTimer
 	triggerTime time.Time
 	callback    func()
 	isLongLive  bool
 }
 
-// NewTimer 创建新的定时器实例
-func NewTimer() *Timer {
-	return &Timer{}
+// NewTimer 创建由OnTick轮询的定时器
+func NewTimer(clock utils.Clock) *Timer {
+	return &Timer{clock: clock}
+}
+
+// NewTableTimer 创建注册到桌子调度器的定时器，到期时在桌子的事件循环里回调，之后执行after
+func NewTableTimer(table *game.Table, after func()) *Timer {
+	return &Timer{clock: table.GetClock(), table: table, after: after}
 }
 
 // Schedule 安排定时任务
 // delay: 延迟时间
 // callback: 回调函数
 func (t *Timer) Schedule(delay time.Duration, callback func()) {
-	t.triggerTime = time.Now().Add(delay)
+	t.triggerTime = t.clock.Now().Add(delay)
 	t.callback = callback
+	t.arm()
 }
 
 // Cancel 取消定时任务
 func (t *Timer) Cancel() {
 	t.callback = nil
+	t.task.Stop()
+}
+
+// arm 按triggerTime重新注册到桌子
+func (t *Timer) arm() {
+	if t.table == nil {
+		return
+	}
+	t.task.Stop()
+	t.task = nil
+	if t.callback != nil && !t.isLongLive {
+		t.task = t.table.AfterFunc(t.Remaining(), t.fire)
+	}
+}
+
+// Remaining 距离触发的剩余时间，没有定时任务时为0
+func (t *Timer) Remaining() time.Duration {
+	if t.callback == nil {
+		return 0
+	}
+	return max(t.triggerTime.Sub(t.clock.Now()), 0)
 }
 
 // SetLongLive 设置定时器为长期存活
 // infinite: 是否长期存活
 func (t *Timer) SetLongLive(infinite bool) {
 	t.isLongLive = infinite
+	t.arm()
 }
 
 // OnTick 定时器触发时的处理
@@ -46,9 +81,23 @@ func (t *Timer) OnTick() {
 		return
 	}
 
-	if time.Now().After(t.triggerTime) {
+	if t.clock.Now().After(t.triggerTime) {
 		c := t.callback
 		t.callback = nil // 先置为nil，防止回调函数内部修改它
 		c()              // 然后执行回调
 	}
 }
+
+// fire 桌子调度器到期回调
+func (t *Timer) fire() {
+	t.task = nil
+	if t.isLongLive || t.callback == nil {
+		return
+	}
+	c := t.callback
+	t.callback = nil
+	c()
+	if t.after != nil {
+		t.after()
+	}
+}
@@ -0,0 +1,60 @@
+package mahjong_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kevin-chtw/tw_common/gamebase/game"
+	"github.com/kevin-chtw/tw_common/gamebase/mahjong"
+	"github.com/kevin-chtw/tw_common/utils"
+)
+
+func TestTimer(t *testing.T) {
+	clock := utils.NewManualClock(time.Unix(1000, 0))
+	timer := mahjong.NewTimer(clock)
+	fired := 0
+	timer.Schedule(5*time.Second, func() { fired++ })
+	if timer.Remaining() != 5*time.Second {
+		t.Errorf("Remaining() = %v", timer.Remaining())
+	}
+
+	clock.Advance(5 * time.Second)
+	timer.OnTick()
+	if fired != 0 {
+		t.Fatal("timer fired before timeout")
+	}
+	clock.Advance(time.Millisecond)
+	timer.OnTick()
+	timer.OnTick()
+	if fired != 1 || timer.Remaining() != 0 {
+		t.Fatalf("fired = %d, Remaining() = %v", fired, timer.Remaining())
+	}
+}
+
+func TestTableTimer(t *testing.T) {
+	clock := utils.NewManualClock(time.Unix(1000, 0))
+	table := game.NewLocalTable(1, 1, "normal", 2, "", 1)
+	table.SetClock(clock)
+	fired, after := 0, 0
+	timer := mahjong.NewTableTimer(table, func() { after++ })
+	timer.Schedule(1500*time.Millisecond, func() { fired++ })
+
+	clock.Advance(1450 * time.Millisecond)
+	table.Tick()
+	if fired != 0 {
+		t.Fatal("timer fired before timeout")
+	}
+	// 托管期间不触发，恢复后立即触发
+	timer.SetLongLive(true)
+	clock.Advance(time.Second)
+	table.Tick()
+	if fired != 0 {
+		t.Fatal("long live timer fired")
+	}
+	timer.SetLongLive(false)
+	clock.Advance(50 * time.Millisecond)
+	table.Tick()
+	if fired != 1 || after != 1 || timer.Remaining() != 0 {
+		t.Fatalf("fired = %d, after = %d", fired, after)
+	}
+}
@@ -220,6 +220,17 @@ func CalcTing(cards []Tile, laiziCards []Tile, extraTypes []MJTingType) (int, MJ
 
 // 内部实现：真正的计算逻辑。
 func (c *tingCore) calcTing(cards []Tile, laiziCards []Tile, extraTypes []MJTingType) (int, MJTingType) {
+	stepToHu, typ := c.calcStep(cards, laiziCards, extraTypes)
+	stepToTing := stepToHu - 1
+	if stepToTing < 0 {
+		stepToTing = 0
+	}
+	return stepToTing, typ
+}
+
+// calcStep 距离胡牌还要换几张牌，胡牌时为0，13张听牌时为1
+func (c *tingCore) calcStep(cards []Tile, laiziCards []Tile, extraTypes []MJTingType) (int, MJTingType) {
+	var typInt int
 	switch c.tileCount {
 	case 14:
 		// 14 张：用 MJTingEx14，支持平胡+七对+十三幺
@@ -227,17 +238,18 @@ func (c *tingCore) calcTing(cards []Tile, laiziCards []Tile, extraTypes []MJTing
 		for i, et := range extraTypes {
 			intTypes[i] = int(et)
 		}
-		var typInt int
-		stepToHu := c.ex14.CalcStep(cards, laiziCards, intTypes, &typInt)
-		stepToTing := stepToHu - 1
-		if stepToTing < 0 {
-			stepToTing = 0
-		}
-		return stepToTing, MJTingType(typInt)
+		return c.ex14.CalcStep(cards, laiziCards, intTypes, &typInt), MJTingType(typInt)
 	default:
 		// 其他张数：只算普通平胡
-		var typInt int
-		stepToTing := c.base.CalcTing(cards, laiziCards, nil, &typInt)
-		return stepToTing, MJTingType(typInt)
+		return c.base.CalcStep(cards, laiziCards, nil, &typInt), MJTingType(typInt)
+	}
+}
+
+// calcShanten 向听数，13张听牌时为0，14张胡牌时为-1
+func calcShanten(cards []Tile, laiziCards []Tile, extraTypes []MJTingType) int {
+	if coreInst == nil {
+		panic("mjting: Init must be called before calcShanten")
 	}
+	step, _ := coreInst.calcStep(cards, laiziCards, extraTypes)
+	return step - 1
 }
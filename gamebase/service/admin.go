@@ -0,0 +1,183 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/kevin-chtw/tw_common/gamebase/game"
+	"github.com/kevin-chtw/tw_proto/sproto"
+	pitaya "github.com/topfreegames/pitaya/v3/pkg"
+	"github.com/topfreegames/pitaya/v3/pkg/component"
+	"github.com/topfreegames/pitaya/v3/pkg/logger"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// 管理操作
+const (
+	AdminList     = "list"     // 列出比赛的桌子，matchid为0时列出全部
+	AdminDump     = "dump"     // 桌子完整状态
+	AdminDissolve = "dissolve" // 强制解散
+	AdminKick     = "kick"     // 局间踢出玩家
+	AdminReplace  = "replace"  // 用bot接管玩家
+	AdminTrust    = "trust"    // 切换座位托管
+	AdminNotice   = "notice"   // 推送系统公告
+	AdminDrain    = "drain"    // 开始排空，timeout为截止秒数
+	AdminStatus   = "status"   // 排空状态
+)
+
+// AdminReq 管理请求，以google.protobuf.Struct传输
+type AdminReq struct {
+	Op       string `json:"op"`
+	Operator string `json:"operator"` // 操作人，写入审计日志
+	Matchid  int32  `json:"matchid"`
+	Tableid  int32  `json:"tableid"`
+	Uid      string `json:"uid,omitempty"`
+	Seat     int32  `json:"seat,omitempty"`
+	Trusted  bool   `json:"trusted,omitempty"`
+	Text     string `json:"text,omitempty"`
+	Timeout  int32  `json:"timeout,omitempty"`
+}
+
+// AdminAuthorizer 检查调用方是否有权限执行req，返回错误时拒绝
+type AdminAuthorizer func(ctx context.Context, req *AdminReq) error
+
+// AdminAudit 审计日志，每次调用记录一条，包括被拒绝的调用
+type AdminAudit struct {
+	Time time.Time
+	Req  AdminReq
+	Err  string
+}
+
+// AdminAuditor 保存审计日志
+type AdminAuditor func(audit *AdminAudit)
+
+var errAdminDenied = errors.New("admin authorizer not set")
+
+// Admin 游戏服的管理服务，查看和操作运行中的桌子。没有设置授权时拒绝所有请求
+type Admin struct {
+	component.Base
+	app       pitaya.Pitaya
+	authorize AdminAuthorizer
+	audit     AdminAuditor
+	handlers  map[string]func(context.Context, *AdminReq) (any, error)
+}
+
+// NewAdmin 创建管理服务，审计日志默认写入日志
+func NewAdmin(app pitaya.Pitaya) *Admin {
+	return &Admin{
+		app: app,
+		authorize: func(context.Context, *AdminReq) error {
+			return errAdminDenied
+		},
+		audit: func(audit *AdminAudit) {
+			logger.Log.Infof("admin audit: %+v", *audit)
+		},
+		handlers: make(map[string]func(context.Context, *AdminReq) (any, error)),
+	}
+}
+
+// SetAuthorizer 设置授权检查
+func (a *Admin) SetAuthorizer(fn AdminAuthorizer) {
+	a.authorize = fn
+}
+
+// SetAuditor 设置审计日志的保存方式
+func (a *Admin) SetAuditor(fn AdminAuditor) {
+	a.audit = fn
+}
+
+// Init 组件初始化
+func (a *Admin) Init() {
+	a.handlers[AdminList] = a.list
+	a.handlers[AdminDump] = a.withTable(func(t *game.Table, req *AdminReq) (any, error) { return t.Dump() })
+	a.handlers[AdminDissolve] = a.withTable(func(t *game.Table, req *AdminReq) (any, error) {
+		_, err := t.HandleCancelTable(context.Background(), &sproto.CancelTableReq{})
+		return nil, err
+	})
+	a.handlers[AdminKick] = a.withTable(func(t *game.Table, req *AdminReq) (any, error) { return nil, t.Kick(req.Uid) })
+	a.handlers[AdminReplace] = a.withTable(func(t *game.Table, req *AdminReq) (any, error) { return nil, t.ReplaceWithBot(req.Uid) })
+	a.handlers[AdminTrust] = a.withTable(func(t *game.Table, req *AdminReq) (any, error) { return nil, t.SetTrusted(req.Seat, req.Trusted) })
+	a.handlers[AdminNotice] = a.withTable(func(t *game.Table, req *AdminReq) (any, error) { return nil, t.Notice(req.Text) })
+	a.handlers[AdminDrain] = func(ctx context.Context, req *AdminReq) (any, error) {
+		game.GetTableManager().Drain(time.Duration(req.Timeout) * time.Second)
+		return game.GetTableManager().DrainInfo(), nil
+	}
+	a.handlers[AdminStatus] = func(ctx context.Context, req *AdminReq) (any, error) {
+		return game.GetTableManager().DrainInfo(), nil
+	}
+}
+
+// Message 处理管理请求，返回{"result": ...}
+func (a *Admin) Message(ctx context.Context, in *structpb.Struct) (out *structpb.Struct, err error) {
+	req := &AdminReq{}
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Log.Errorf("panic recovered %s\n %s", r, string(debug.Stack()))
+			err = fmt.Errorf("admin %s panic: %v", req.Op, r)
+		}
+		audit := &AdminAudit{Time: time.Now(), Req: *req}
+		if err != nil {
+			audit.Err = err.Error()
+		}
+		a.audit(audit)
+	}()
+
+	data, err := protojson.Marshal(in)
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, req); err != nil {
+		return nil, err
+	}
+	handler, ok := a.handlers[req.Op]
+	if !ok {
+		return nil, fmt.Errorf("unknown admin op %q", req.Op)
+	}
+	if err = a.authorize(ctx, req); err != nil {
+		return nil, err
+	}
+	result, err := handler(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return newAdminResult(result)
+}
+
+func (a *Admin) list(ctx context.Context, req *AdminReq) (any, error) {
+	infos := make([]*game.TableInfo, 0)
+	for _, t := range game.GetTableManager().Tables(req.Matchid) {
+		info, err := t.Info()
+		if err != nil {
+			continue // 桌子已经关闭
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+func (a *Admin) withTable(fn func(*game.Table, *AdminReq) (any, error)) func(context.Context, *AdminReq) (any, error) {
+	return func(ctx context.Context, req *AdminReq) (any, error) {
+		table := game.GetTableManager().Get(req.Matchid, req.Tableid)
+		if table == nil {
+			return nil, fmt.Errorf("table not found for match %d, table %d", req.Matchid, req.Tableid)
+		}
+		return fn(table, req)
+	}
+}
+
+func newAdminResult(result any) (*structpb.Struct, error) {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return nil, err
+	}
+	var value any
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+	return structpb.NewStruct(map[string]any{"result": value})
+}
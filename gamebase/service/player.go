@@ -51,3 +51,50 @@ func (p *Player) Message(ctx context.Context, data []byte) {
 		logger.Log.Error(err.Error())
 	}
 }
+
+// Observe 观战者进入桌子，GameReq里是cproto.EnterGameReq
+func (p *Player) Observe(ctx context.Context, data []byte) {
+	p.observe(ctx, data, true)
+}
+
+// Unobserve 观战者离开桌子，GameReq里是cproto.EnterGameReq
+func (p *Player) Unobserve(ctx context.Context, data []byte) {
+	p.observe(ctx, data, false)
+}
+
+func (p *Player) observe(ctx context.Context, data []byte, observe bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Log.Errorf("panic recovered %s\n %s", r, string(debug.Stack()))
+		}
+	}()
+	userID := p.app.GetSessionFromCtx(ctx).UID()
+	if userID == "" {
+		logger.Log.Error("user ID not found in session")
+		return
+	}
+
+	req := &cproto.GameReq{}
+	if err := utils.Unmarshal(ctx, data, req); err != nil {
+		logger.Log.Error(err.Error())
+		return
+	}
+	if !req.Req.MessageIs(&cproto.EnterGameReq{}) {
+		logger.Log.Errorf("unexpected observe request %s", req.Req.GetTypeUrl())
+		return
+	}
+	table := game.GetTableManager().Get(req.Matchid, req.Tableid)
+	if table == nil {
+		logger.Log.Errorf("table not found %d", req.Tableid)
+		return
+	}
+	var err error
+	if observe {
+		err = table.AddObserver(ctx, userID)
+	} else {
+		err = table.RemoveObserver(userID)
+	}
+	if err != nil {
+		logger.Log.Error(err.Error())
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/kevin-chtw/tw_proto/sproto"
 	pitaya "github.com/topfreegames/pitaya/v3/pkg"
 	"github.com/topfreegames/pitaya/v3/pkg/component"
+	pitayaerrors "github.com/topfreegames/pitaya/v3/pkg/errors"
 	"github.com/topfreegames/pitaya/v3/pkg/logger"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/anypb"
@@ -40,6 +41,13 @@ func (m *Remote) Init() {
 	m.handlers[utils.TypeUrl(&sproto.NetStateReq{})] = (*game.Table).HandleNetState
 }
 
+// AfterInit 组件都初始化之后从检查点恢复上次停服时的桌子
+func (m *Remote) AfterInit() {
+	if err := game.GetTableManager().Restore(); err != nil {
+		logger.Log.Errorf("restore tables failed: %v", err)
+	}
+}
+
 // Message 处理匹配服务消息
 func (m *Remote) Message(ctx context.Context, req *sproto.GameReq) (*sproto.GameAck, error) {
 	defer func() {
@@ -59,7 +67,10 @@ func (m *Remote) Message(ctx context.Context, req *sproto.GameReq) (*sproto.Game
 
 	table := game.GetTableManager().Get(req.Matchid, req.Tableid)
 	if req.Req.TypeUrl == utils.TypeUrl(&sproto.AddTableReq{}) {
-		table = game.GetTableManager().LoadOrStore(req.Matchid, req.Tableid)
+		if table, err = game.GetTableManager().AddTable(req.Matchid, req.Tableid); err != nil {
+			// 带上错误码，比赛服换一个游戏服重试
+			return nil, pitayaerrors.NewError(err, utils.ErrCodeDraining, map[string]string{"server": m.app.GetServerID()})
+		}
 	}
 	if table == nil {
 		return nil, fmt.Errorf("table not found for match %d, table %d", req.Matchid, req.Tableid)
@@ -4,6 +4,7 @@ go 1.24.4
 
 require (
 	github.com/duke-git/lancet/v2 v2.3.7
+	github.com/fsnotify/fsnotify v1.6.0
 	github.com/go-resty/resty/v2 v2.16.5
 	github.com/kevin-chtw/tw_proto v0.0.0-20250817090421-de16e4c22163
 	github.com/lestrrat-go/file-rotatelogs v2.4.0+incompatible
@@ -25,7 +26,6 @@ require (
 	github.com/cespare/xxhash/v2 v2.2.0 // indirect
 	github.com/coreos/go-semver v0.3.1 // indirect
 	github.com/coreos/go-systemd/v22 v22.5.0 // indirect
-	github.com/fsnotify/fsnotify v1.6.0 // indirect
 	github.com/go-logr/logr v1.4.2 // indirect
 	github.com/go-logr/stdr v1.2.2 // indirect
 	github.com/go-playground/locales v0.14.1 // indirect
@@ -0,0 +1,170 @@
+package matchbase
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/kevin-chtw/tw_common/utils"
+	"github.com/spf13/viper"
+)
+
+// MatchConfig 比赛配置文件的公共字段，具体比赛的其他配置仍然从Config读取
+type MatchConfig struct {
+	MatchID        int32            `mapstructure:"matchid"`
+	Name           string           `mapstructure:"name"`
+	GameType       string           `mapstructure:"game_type"`
+	PlayerPerTable int32            `mapstructure:"player_per_table"`
+	ScoreBase      int64            `mapstructure:"score_base"`
+	InitialChips   int64            `mapstructure:"initial_chips"`
+	Property       string           `mapstructure:"property"`
+	SignCondition  string           `mapstructure:"sign_condition"`
+	Fdproperty     map[string]int32 `mapstructure:"fdproperty"`
+}
+
+// maxPlayerPerTable 每桌最多人数
+const maxPlayerPerTable = 8
+
+var (
+	gameTypesMu sync.RWMutex
+	// gameTypes 已知的游戏类型 -> GetDefaultRules的长度，0表示不检查property的长度。
+	// 内置类型的规则数定义在各自的游戏服里，比赛服启动时用RegisterGameType登记
+	gameTypes = map[string]int{
+		utils.MJHAEB: 0,
+		utils.MJSC:   0,
+		utils.MJXL:   0,
+	}
+	requireRules bool
+)
+
+// RegisterGameType 登记游戏类型和它GetDefaultRules的长度，用于校验game_type和property，需要在Init之前调用
+func RegisterGameType(gameType string, rules int) {
+	gameTypesMu.Lock()
+	defer gameTypesMu.Unlock()
+	gameTypes[gameType] = rules
+}
+
+// RequireGameRules 为true时规则数为0的游戏类型校验失败，离线校验时用来保证property的长度都检查过
+func RequireGameRules(require bool) {
+	gameTypesMu.Lock()
+	defer gameTypesMu.Unlock()
+	requireRules = require
+}
+
+func gameRules(gameType string) (int, bool) {
+	gameTypesMu.RLock()
+	defer gameTypesMu.RUnlock()
+	rules, ok := gameTypes[gameType]
+	return rules, ok
+}
+
+func requiresRules() bool {
+	gameTypesMu.RLock()
+	defer gameTypesMu.RUnlock()
+	return requireRules
+}
+
+// ParseMatchConfig 读取并校验公共字段，返回所有不合法的项
+func ParseMatchConfig(v *viper.Viper) (*MatchConfig, error) {
+	conf := &MatchConfig{}
+	if err := v.Unmarshal(conf); err != nil {
+		return nil, err
+	}
+	return conf, conf.Validate(v)
+}
+
+// Validate 校验必填项、取值范围、游戏类型和property，v为nil时不检查必填项
+func (c *MatchConfig) Validate(v *viper.Viper) error {
+	var errs []error
+	if v != nil {
+		for _, key := range []string{"matchid", "game_type", "player_per_table", "score_base"} {
+			if !v.IsSet(key) {
+				errs = append(errs, fmt.Errorf("%s is required", key))
+			}
+		}
+	}
+	if c.MatchID <= 0 {
+		errs = append(errs, fmt.Errorf("matchid %d must be positive", c.MatchID))
+	}
+	if c.PlayerPerTable < 1 || c.PlayerPerTable > maxPlayerPerTable {
+		errs = append(errs, fmt.Errorf("player_per_table %d out of range [1, %d]", c.PlayerPerTable, maxPlayerPerTable))
+	}
+	if c.ScoreBase <= 0 {
+		errs = append(errs, fmt.Errorf("score_base %d must be positive", c.ScoreBase))
+	}
+	if c.InitialChips < 0 {
+		errs = append(errs, fmt.Errorf("initial_chips %d must not be negative", c.InitialChips))
+	}
+	rules, ok := gameRules(c.GameType)
+	if !ok {
+		errs = append(errs, fmt.Errorf("unknown game_type %q", c.GameType))
+	} else if rules == 0 && requiresRules() {
+		errs = append(errs, fmt.Errorf("game_type %q has no registered rule count", c.GameType))
+	}
+	if err := validateProperty(c.Property, rules); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// validateProperty property为逗号分隔的整数，个数不能超过游戏的默认规则数，rules为0时只检查格式
+func validateProperty(property string, rules int) error {
+	if strings.TrimSpace(property) == "" {
+		return nil
+	}
+	parts := strings.Split(property, ",")
+	for i, p := range parts {
+		if _, err := strconv.Atoi(strings.TrimSpace(p)); err != nil {
+			return fmt.Errorf("property[%d] %q is not an integer", i, p)
+		}
+	}
+	if rules > 0 && len(parts) > rules {
+		return fmt.Errorf("property has %d rules, game has %d", len(parts), rules)
+	}
+	return nil
+}
+
+// readMatchConfig 读取并校验比赛配置文件
+func readMatchConfig(file string, data []byte) (*viper.Viper, *MatchConfig, error) {
+	v := viper.New()
+	v.SetConfigType("yaml")
+	v.SetConfigFile(file)
+	if err := v.ReadConfig(bytes.NewReader(data)); err != nil {
+		return nil, nil, err
+	}
+	conf, err := ParseMatchConfig(v)
+	if err != nil {
+		return nil, nil, err
+	}
+	return v, conf, nil
+}
+
+// ValidateMatchFiles 校验比赛配置文件，同一个目录下的matchid不能重复，返回所有文件的错误
+func ValidateMatchFiles(files []string) error {
+	var errs []error
+	ids := make(map[string]string) // 目录:matchid -> 文件
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		_, conf, err := readMatchConfig(file, data)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", file, err))
+			continue
+		}
+		key := filepath.Dir(file) + ":" + strconv.Itoa(int(conf.MatchID))
+		if other, ok := ids[key]; ok {
+			errs = append(errs, fmt.Errorf("%s: matchid %d already used by %s", file, conf.MatchID, other))
+			continue
+		}
+		ids[key] = file
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,158 @@
+package matchbase_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kevin-chtw/tw_common/matchbase"
+	"github.com/spf13/viper"
+)
+
+// testGame 测试用的游戏类型，有3个默认规则
+const testGame = "configtest"
+
+func init() {
+	matchbase.RegisterGameType(testGame, 3)
+}
+
+const validConfig = `
+matchid: 1
+name: test
+game_type: configtest
+player_per_table: 4
+score_base: 10
+`
+
+// withConfig 在合法配置上覆盖或删除字段，值为空表示删除
+func withConfig(fields map[string]string) string {
+	lines := strings.Split(strings.TrimSpace(validConfig), "\n")
+	out := make([]string, 0, len(lines)+len(fields))
+	for _, line := range lines {
+		key, _, _ := strings.Cut(line, ":")
+		if value, ok := fields[key]; ok {
+			if value != "" {
+				out = append(out, key+": "+value)
+			}
+			delete(fields, key)
+			continue
+		}
+		out = append(out, line)
+	}
+	for key, value := range fields {
+		out = append(out, key+": "+value)
+	}
+	return strings.Join(out, "\n") + "\n"
+}
+
+func readViper(t *testing.T, data string) *viper.Viper {
+	t.Helper()
+	v := viper.New()
+	v.SetConfigType("yaml")
+	if err := v.ReadConfig(bytes.NewReader([]byte(data))); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestParseMatchConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields map[string]string
+		err    string // 为空表示合法
+	}{
+		{"valid", nil, ""},
+		{"matchid required", map[string]string{"matchid": ""}, "matchid is required"},
+		{"game_type required", map[string]string{"game_type": ""}, "game_type is required"},
+		{"player_per_table required", map[string]string{"player_per_table": ""}, "player_per_table is required"},
+		{"score_base required", map[string]string{"score_base": ""}, "score_base is required"},
+		{"matchid positive", map[string]string{"matchid": "0"}, "matchid 0 must be positive"},
+		{"player_per_table min", map[string]string{"player_per_table": "0"}, "player_per_table 0 out of range"},
+		{"player_per_table max", map[string]string{"player_per_table": "9"}, "player_per_table 9 out of range"},
+		{"score_base positive", map[string]string{"score_base": "-1"}, "score_base -1 must be positive"},
+		{"initial_chips negative", map[string]string{"initial_chips": "-1"}, "initial_chips -1 must not be negative"},
+		{"initial_chips zero", map[string]string{"initial_chips": "0"}, ""},
+		{"unknown game_type", map[string]string{"game_type": "nope"}, `unknown game_type "nope"`},
+		{"property not integer", map[string]string{"property": `"1,x"`}, `property[1] "x" is not an integer`},
+		{"property too long", map[string]string{"property": `"1,2,3,4"`}, "property has 4 rules, game has 3"},
+		{"property max length", map[string]string{"property": `"1,2,3"`}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf, err := matchbase.ParseMatchConfig(readViper(t, withConfig(tt.fields)))
+			if tt.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if conf.MatchID != 1 || conf.GameType != testGame {
+					t.Fatalf("conf = %+v", conf)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("err = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestValidateRequireRules(t *testing.T) {
+	matchbase.RegisterGameType("norules", 0)
+	conf := &matchbase.MatchConfig{MatchID: 1, GameType: "norules", PlayerPerTable: 4, ScoreBase: 10, Property: "1,2,3,4,5"}
+	// 规则数为0时只检查格式
+	if err := conf.Validate(nil); err != nil {
+		t.Fatal(err)
+	}
+	matchbase.RequireGameRules(true)
+	t.Cleanup(func() { matchbase.RequireGameRules(false) })
+	if err := conf.Validate(nil); err == nil || !strings.Contains(err.Error(), "no registered rule count") {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestValidateMatchFiles(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) string {
+		t.Helper()
+		file := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return file
+	}
+	a := write("a/1.yaml", validConfig)
+	dup := write("a/2.yaml", validConfig)
+	other := write("b/1.yaml", validConfig)
+	bad := write("a/3.yaml", withConfig(map[string]string{"matchid": "3", "score_base": "0"}))
+
+	tests := []struct {
+		name  string
+		files []string
+		err   string
+	}{
+		{"valid", []string{a}, ""},
+		{"same matchid in other dir", []string{a, other}, ""},
+		{"duplicate matchid", []string{a, dup}, "matchid 1 already used by " + a},
+		{"invalid file", []string{a, bad}, bad + ": score_base 0 must be positive"},
+		{"missing file", []string{filepath.Join(dir, "none.yaml")}, "none.yaml"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := matchbase.ValidateMatchFiles(tt.files)
+			if tt.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("err = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package matchbase
+
+import (
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/topfreegames/pitaya/v3/pkg/logger"
+)
+
+// drainedServers 排空中的游戏服，新桌子不再分配到这些服务器
+type drainedServers struct {
+	mu      sync.Mutex
+	servers map[string]time.Time // serverID -> 发现排空的时间
+}
+
+// MarkDrained 标记游戏服排空中，收到排空错误码时调用
+func (m *Match) MarkDrained(serverID string) {
+	m.drained.mu.Lock()
+	defer m.drained.mu.Unlock()
+	if m.drained.servers == nil {
+		m.drained.servers = make(map[string]time.Time)
+	}
+	if _, ok := m.drained.servers[serverID]; !ok {
+		logger.Log.Infof("match %d: game server %s is draining", m.Config().GetInt32("matchid"), serverID)
+		m.drained.servers[serverID] = time.Now()
+	}
+}
+
+// DrainedServers 排空中的游戏服
+func (m *Match) DrainedServers() []string {
+	m.drained.mu.Lock()
+	defer m.drained.mu.Unlock()
+	ids := make([]string, 0, len(m.drained.servers))
+	for id := range m.drained.servers {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// pickGameServer 随机选一个没有排空的游戏服。
+// 没有服务发现时返回空串，由pitaya按路由选择
+func (m *Match) pickGameServer() string {
+	servers, err := m.App.GetServersByType(m.Config().GetString("game_type"))
+	if err != nil || len(servers) == 0 {
+		return ""
+	}
+
+	m.drained.mu.Lock()
+	defer m.drained.mu.Unlock()
+	// 已经下线的服务器不再记录
+	for id := range m.drained.servers {
+		if _, ok := servers[id]; !ok {
+			delete(m.drained.servers, id)
+		}
+	}
+	ids := make([]string, 0, len(servers))
+	for id := range servers {
+		if _, ok := m.drained.servers[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return ""
+	}
+	slices.Sort(ids)
+	return ids[rand.IntN(len(ids))]
+}
@@ -1,12 +1,16 @@
 package matchbase
 
 import (
+	"bytes"
 	"context"
+	"os"
 	"sync"
+	"sync/atomic"
 
 	"github.com/kevin-chtw/tw_common/storage"
 	"github.com/kevin-chtw/tw_common/utils"
 	"github.com/kevin-chtw/tw_proto/cproto"
+	"github.com/kevin-chtw/tw_proto/sproto"
 	"github.com/spf13/viper"
 	pitaya "github.com/topfreegames/pitaya/v3/pkg"
 	"github.com/topfreegames/pitaya/v3/pkg/logger"
@@ -21,11 +25,16 @@ type IMatch interface {
 type Match struct {
 	Sub       IMatch
 	App       pitaya.Pitaya
-	Viper     *viper.Viper
+	Viper     *viper.Viper // 启动时加载的配置，热更新后的配置用Config获取
 	Storage   *storage.ETCDMatching
 	playermgr *Playermgr
 	tables    sync.Map
-	tableIds  *TableIDs
+	tableIds  TableIDAllocator
+	drained   drainedServers
+	file      string
+	conf      atomic.Pointer[matchConf]
+	raw       []byte // 当前配置文件内容，由Matchmgr.mu保护
+	retiring  atomic.Bool
 }
 
 func NewMatch(app pitaya.Pitaya, file string, sub IMatch) *Match {
@@ -39,19 +48,41 @@ func NewMatch(app pitaya.Pitaya, file string, sub IMatch) *Match {
 		App:       app,
 		Viper:     viper.New(),
 		playermgr: NewPlayermgr(),
-		tableIds:  NewTableIDs(),
 		Storage:   module.(*storage.ETCDMatching),
 		tables:    sync.Map{},
+		file:      file,
 	}
 
-	m.initConfig(file)
+	if module, err := app.GetModule("tableids"); err == nil {
+		m.tableIds = module.(TableIDAllocator)
+	} else {
+		m.tableIds = NewTableIDs()
+	}
+
+	if err := m.initConfig(file); err != nil {
+		logger.Log.Errorf("load match config %s failed: %v", file, err)
+	}
 	return m
 }
 
 func (m *Match) initConfig(file string) error {
 	m.Viper.SetConfigType("yaml")
 	m.Viper.SetConfigFile(file)
-	return m.Viper.ReadInConfig()
+	conf := &matchConf{v: m.Viper, c: &MatchConfig{}}
+	m.conf.Store(conf)
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	m.raw = data
+	if err := m.Viper.ReadConfig(bytes.NewReader(data)); err != nil {
+		return err
+	}
+	conf.c, err = ParseMatchConfig(m.Viper)
+	if conf.c == nil {
+		conf.c = &MatchConfig{}
+	}
+	return err
 }
 
 func (m *Match) NewMatchAck(ctx context.Context, msg proto.Message) ([]byte, error) {
@@ -61,19 +92,14 @@ func (m *Match) NewMatchAck(ctx context.Context, msg proto.Message) ([]byte, err
 	}
 	out := &cproto.MatchAck{
 		Serverid: m.App.GetServerID(),
-		Matchid:  m.Viper.GetInt32("matchid"),
+		Matchid:  m.Config().GetInt32("matchid"),
 		Ack:      data,
 	}
 	return utils.Marshal(ctx, out)
 }
 
-func (m *Match) nextTableID() int32 {
-	id, err := m.tableIds.Take()
-	if err != nil {
-		logger.Log.Error(err.Error())
-		return 0
-	}
-	return id
+func (m *Match) nextTableID() (int32, error) {
+	return m.tableIds.Take()
 }
 
 func (m *Match) PutBackTableId(id int32) {
@@ -83,9 +109,9 @@ func (m *Match) PutBackTableId(id int32) {
 func (m *Match) NewStartClientAck(p *Player) *cproto.StartClientAck {
 	return &cproto.StartClientAck{
 		MatchType: m.App.GetServer().Type,
-		GameType:  m.Viper.GetString("game_type"),
+		GameType:  m.Config().GetString("game_type"),
 		ServerId:  m.App.GetServerID(),
-		MatchId:   m.Viper.GetInt32("matchid"),
+		MatchId:   m.Config().GetInt32("matchid"),
 		TableId:   p.TableId,
 	}
 }
@@ -107,6 +133,31 @@ func (m *Match) GetTable(id int32) *Table {
 	return nil
 }
 
+// HandleExitTable 处理游戏服踢人的ExitTableReq，从玩家当前的桌子上移除并返回玩家，由比赛决定补人或重新排队。
+// 游戏服会重发直到收到回复，玩家已经不在桌子上时返回nil
+func (m *Match) HandleExitTable(req *sproto.ExitTableReq) *Player {
+	p := m.GetMatchPlayer(req.Playerid)
+	if p == nil {
+		return nil
+	}
+	t := m.GetTable(p.TableId)
+	if t == nil {
+		return nil
+	}
+	return t.RemovePlayer(req.Playerid)
+}
+
+// lostTables ids中属于本比赛的桌子
+func (m *Match) lostTables(ids []int32) []*Table {
+	var tables []*Table
+	for _, id := range ids {
+		if t := m.GetTable(id); t != nil {
+			tables = append(tables, t)
+		}
+	}
+	return tables
+}
+
 func (m *Match) AddTable(t *Table) {
 	m.tables.Store(t.ID, t)
 }
@@ -118,7 +169,7 @@ func (m *Match) DelTable(id int32) {
 
 func (m *Match) AddMatchPlayer(player *Player) {
 	m.playermgr.Store(player)
-	if err := m.Storage.Put(player.ID, m.Viper.GetInt32("matchid")); err != nil {
+	if err := m.Storage.Put(player.ID, m.Config().GetInt32("matchid")); err != nil {
 		logger.Log.Error(err)
 	}
 }
@@ -2,8 +2,11 @@ package matchbase
 
 import (
 	"context"
-	"path/filepath"
+	"fmt"
+	"maps"
 	"runtime/debug"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/kevin-chtw/tw_proto/sproto"
@@ -35,9 +38,12 @@ func GetMatch(matchid int32) *Match {
 
 // Matchmgr 管理玩家
 type Matchmgr struct {
-	App    pitaya.Pitaya
-	Matchs map[int32]*Match
-	ticker *time.Ticker
+	App     pitaya.Pitaya
+	Matchs  map[int32]*Match
+	ticker  *time.Ticker
+	mu      sync.RWMutex
+	files   map[string]*Match // 配置文件 -> 比赛
+	changed atomic.Bool       // 配置目录有变化，下一次tick重新加载
 }
 
 // NewMatchmgr 创建玩家管理器
@@ -46,11 +52,20 @@ func NewMatchmgr(app pitaya.Pitaya) *Matchmgr {
 		App:    app,
 		Matchs: make(map[int32]*Match),
 		ticker: time.NewTicker(time.Second),
+		files:  make(map[string]*Match),
+	}
+	if module, err := app.GetModule("tableids"); err == nil {
+		if n, ok := module.(TableIDLossNotifier); ok {
+			n.SetOnLost(m.onTableIDsLost)
+		}
 	}
 	if err := m.LoadMatchs(); err != nil {
 		logger.Log.Panicf("加载比赛配置失败: %v", err)
 		return nil
 	}
+	if err := m.watch(); err != nil {
+		logger.Log.Errorf("watch match config failed, hot reload disabled: %v", err)
+	}
 	// 启动40秒定时上报match人数
 	go m.startReportPlayerCount()
 	go func() {
@@ -67,22 +82,45 @@ func NewMatchmgr(app pitaya.Pitaya) *Matchmgr {
 }
 
 func (m *Matchmgr) tick() {
-	for _, match := range m.Matchs {
+	if m.changed.CompareAndSwap(true, false) {
+		if err := m.Reload(); err != nil {
+			logger.Log.Errorf("reload match configs failed: %v", err)
+		}
+	}
+	for _, match := range m.matchs() {
 		match.Sub.Tick()
 	}
+	m.mu.Lock()
+	m.sweep()
+	m.mu.Unlock()
 }
 
-func (m *Matchmgr) LoadMatchs() error {
-	files, err := filepath.Glob(filepath.Join("etc", m.App.GetServer().Type, "*.yaml"))
-	if err != nil {
-		return err
-	}
-	for _, file := range files {
-		logger.Log.Infof("加载比赛配置: %s", file)
-		match := matchCreator(m.App, file)
-		m.Add(match)
+// matchs 所有比赛的快照
+func (m *Matchmgr) matchs() map[int32]*Match {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return maps.Clone(m.Matchs)
+}
+
+// onTableIDsLost 分配器丢失了使用中的ID，这些桌子的ID可能被其他服务器分配
+func (m *Matchmgr) onTableIDsLost(ids []int32) {
+	for matchID, match := range m.matchs() {
+		tables := match.lostTables(ids)
+		if len(tables) == 0 {
+			continue
+		}
+		for _, t := range tables {
+			logger.Log.Errorf("match %d table %d lost its id, other servers may reuse it", matchID, t.ID)
+		}
+		if sub, ok := match.Sub.(ITableIDsLost); ok {
+			sub.OnTableIDsLost(tables)
+		}
 	}
-	return nil
+}
+
+// LoadMatchs 启动时加载所有比赛，有不合法的配置文件时返回错误，不带着错误的配置启动
+func (m *Matchmgr) LoadMatchs() error {
+	return m.reload(true)
 }
 
 // startReportPlayerCount 启动定时上报match人数
@@ -98,14 +136,15 @@ func (m *Matchmgr) startReportPlayerCount() {
 // reportPlayerCount 上报所有match的玩家数量
 func (m *Matchmgr) reportPlayerCount() {
 	req := &sproto.TourneyUpdateReq{}
-	for matchID, match := range m.Matchs {
+	for matchID, match := range m.matchs() {
+		conf := match.Config()
 		info := &sproto.TourneyInfo{
 			Id:            matchID,
-			Name:          match.Viper.GetString("name"),
-			GameType:      match.Viper.GetString("game_type"),
+			Name:          conf.GetString("name"),
+			GameType:      conf.GetString("game_type"),
 			MatchType:     m.App.GetServer().Type,
 			Serverid:      m.App.GetServerID(),
-			SignCondition: match.Viper.GetString("sign_condition"),
+			SignCondition: conf.GetString("sign_condition"),
 			Online:        int32(match.playermgr.playerCount()),
 		}
 		req.Infos = append(req.Infos, info)
@@ -129,10 +168,20 @@ func (m *Matchmgr) sendTourneyReq(msg proto.Message) {
 	}
 }
 
-func (m *Matchmgr) Add(match *Match) {
-	m.Matchs[match.Viper.GetInt32("matchid")] = match
+// Add 添加比赛，matchid重复时不覆盖已有的比赛
+func (m *Matchmgr) Add(match *Match) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	matchID := match.MatchConfig().MatchID
+	if _, ok := m.Matchs[matchID]; ok {
+		return fmt.Errorf("matchid %d already used", matchID)
+	}
+	m.Matchs[matchID] = match
+	return nil
 }
 
 func (m *Matchmgr) Get(matchId int32) *Match {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 	return m.Matchs[matchId]
 }
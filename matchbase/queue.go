@@ -0,0 +1,408 @@
+package matchbase
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/kevin-chtw/tw_common/storage"
+	"github.com/kevin-chtw/tw_common/utils"
+	"github.com/kevin-chtw/tw_proto/sproto"
+	"github.com/spf13/viper"
+	"github.com/topfreegames/pitaya/v3/pkg/logger"
+	"google.golang.org/protobuf/proto"
+)
+
+// QueueConfig 排队配置，比赛配置中的queue段
+type QueueConfig struct {
+	GameCount     int32         // 每桌局数
+	InitialWindow float64       // 刚开始排队时能同桌的等级分差
+	WidenRate     float64       // 每等一秒范围扩大多少
+	MaxWindow     float64       // 范围上限，0不限制
+	BotWait       time.Duration // 等待超过这个时间用bot补满座位，0不用bot
+}
+
+// DefaultQueueConfig 默认排队配置
+var DefaultQueueConfig = QueueConfig{
+	GameCount:     1,
+	InitialWindow: 100,
+	WidenRate:     10,
+	MaxWindow:     400,
+	BotWait:       30 * time.Second,
+}
+
+// ParseQueueConfig 读取比赛配置的queue段，没有配置的项使用默认值，bot_wait单位为秒
+func ParseQueueConfig(v *viper.Viper) QueueConfig {
+	conf := DefaultQueueConfig
+	if v.IsSet("queue.game_count") {
+		conf.GameCount = v.GetInt32("queue.game_count")
+	}
+	if v.IsSet("queue.initial_window") {
+		conf.InitialWindow = v.GetFloat64("queue.initial_window")
+	}
+	if v.IsSet("queue.widen_rate") {
+		conf.WidenRate = v.GetFloat64("queue.widen_rate")
+	}
+	if v.IsSet("queue.max_window") {
+		conf.MaxWindow = v.GetFloat64("queue.max_window")
+	}
+	if v.IsSet("queue.bot_wait") {
+		conf.BotWait = time.Duration(v.GetInt64("queue.bot_wait")) * time.Second
+	}
+	return conf
+}
+
+var (
+	errEmptyParty   = errors.New("empty party")
+	errPartyTooBig  = errors.New("party larger than table")
+	errAlreadyQueue = errors.New("player already in queue")
+	errOnTable      = errors.New("player already on table")
+)
+
+// queueEntry 一起排队的玩家，组队的玩家坐同一桌
+type queueEntry struct {
+	players []*Player
+	rating  float64 // 平均等级分
+	since   time.Time
+}
+
+// queueGroup 凑成一桌的排队玩家，bots为需要补的bot数
+type queueGroup struct {
+	entries []*queueEntry
+	bots    int
+}
+
+// Queue 按等级分排队匹配，等得越久能接受的分差越大，等待超时用bot补位。
+// 凑满一桌后自己创建Table并加入玩家，比赛在IMatch.Tick中调用Tick，收到对局结果时调用OnResult
+type Queue struct {
+	match   *Match
+	conf    QueueConfig
+	clock   utils.Clock
+	store   storage.TableStore
+	onTable func(*Table)
+
+	mu      sync.Mutex
+	entries []*queueEntry // 按开始排队的时间排序
+	ratings map[string]Rating
+	scores  map[int32]map[string]int64 // 桌子 -> 玩家 -> 上一局结束时的分数
+}
+
+// NewQueue 创建排队匹配
+func NewQueue(m *Match, conf QueueConfig) *Queue {
+	return &Queue{
+		match:   m,
+		conf:    conf,
+		clock:   utils.SystemClock,
+		ratings: make(map[string]Rating),
+		scores:  make(map[int32]map[string]int64),
+	}
+}
+
+// SetClock 设置时钟，用于测试
+func (q *Queue) SetClock(c utils.Clock) {
+	q.clock = c
+}
+
+// SetRatingStore 设置等级分存储，为nil时只保存在内存
+func (q *Queue) SetRatingStore(store storage.TableStore) {
+	q.store = store
+}
+
+// SetOnTable 凑满一桌后调用，默认给玩家推送StartClientAck
+func (q *Queue) SetOnTable(fn func(*Table)) {
+	q.onTable = fn
+}
+
+// Enqueue 开始排队，多个玩家时组队坐同一桌
+func (q *Queue) Enqueue(players ...*Player) error {
+	if len(players) == 0 {
+		return errEmptyParty
+	}
+	if len(players) > int(q.playerCount()) {
+		return errPartyTooBig
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	entry := &queueEntry{players: players, since: q.clock.Now()}
+	for _, p := range players {
+		if p.TableId != 0 {
+			return errOnTable
+		}
+		if q.find(p.ID) >= 0 {
+			return errAlreadyQueue
+		}
+		entry.rating += q.rating(p.ID).Value / float64(len(players))
+	}
+	q.entries = append(q.entries, entry)
+	return nil
+}
+
+// Dequeue 取消排队，组队时整队取消
+func (q *Queue) Dequeue(playerID string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	i := q.find(playerID)
+	if i < 0 {
+		return false
+	}
+	q.entries = slices.Delete(q.entries, i, i+1)
+	return true
+}
+
+// Len 排队中的玩家数
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	count := 0
+	for _, e := range q.entries {
+		count += len(e.players)
+	}
+	return count
+}
+
+// Rating 获取玩家的等级分
+func (q *Queue) Rating(playerID string) Rating {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.rating(playerID)
+}
+
+// Tick 匹配排队的玩家并创建桌子
+func (q *Queue) Tick() {
+	for _, g := range q.matchGroups() {
+		q.seat(g)
+	}
+}
+
+// OnResult 根据GameResultReq的分数变化更新等级分，GameOverReq时不再跟踪这张桌子。
+// 和AcceptResult一起使用，重发的结果不要再调用
+func (q *Queue) OnResult(msg proto.Message) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	switch req := msg.(type) {
+	case *sproto.GameResultReq:
+		q.updateRatings(req)
+	case *sproto.GameOverReq:
+		delete(q.scores, req.Tableid)
+	}
+}
+
+func (q *Queue) playerCount() int32 {
+	return q.match.Config().GetInt32("player_per_table")
+}
+
+// find 需要持有q.mu
+func (q *Queue) find(playerID string) int {
+	return slices.IndexFunc(q.entries, func(e *queueEntry) bool {
+		return slices.ContainsFunc(e.players, func(p *Player) bool { return p.ID == playerID })
+	})
+}
+
+// rating 需要持有q.mu，不在内存时从存储加载
+func (q *Queue) rating(playerID string) Rating {
+	if r, ok := q.ratings[playerID]; ok {
+		return r
+	}
+	r := NewRating()
+	if q.store != nil {
+		data, err := q.store.Load(ratingKey(playerID))
+		if err == nil {
+			err = json.Unmarshal(data, &r)
+		}
+		if err != nil && !errors.Is(err, storage.ErrTableNotFound) {
+			logger.Log.Errorf("load rating of %s failed: %v", playerID, err)
+			r = NewRating()
+		}
+	}
+	q.ratings[playerID] = r
+	return r
+}
+
+// setRating 需要持有q.mu
+func (q *Queue) setRating(playerID string, r Rating) {
+	q.ratings[playerID] = r
+	if q.store == nil {
+		return
+	}
+	data, err := json.Marshal(r)
+	if err == nil {
+		err = q.store.Save(ratingKey(playerID), data)
+	}
+	if err != nil {
+		logger.Log.Errorf("save rating of %s failed: %v", playerID, err)
+	}
+}
+
+func ratingKey(playerID string) string {
+	return "rating:" + playerID
+}
+
+// window 排队时间越长能接受的等级分差越大
+func (q *Queue) window(e *queueEntry, now time.Time) float64 {
+	window := q.conf.InitialWindow + q.conf.WidenRate*now.Sub(e.since).Seconds()
+	if q.conf.MaxWindow > 0 {
+		window = min(window, q.conf.MaxWindow)
+	}
+	return window
+}
+
+// matchGroups 从等得最久的开始，找双方都能接受分差的玩家凑满一桌，
+// 凑不满且等待超过BotWait时用bot补位。凑成的玩家移出队列，比赛退役后不再凑桌
+func (q *Queue) matchGroups() []*queueGroup {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.match.Retiring() {
+		return nil
+	}
+	now := q.clock.Now()
+	size := int(q.playerCount())
+	used := make(map[*queueEntry]bool)
+	groups := make([]*queueGroup, 0)
+	for _, anchor := range q.entries {
+		if used[anchor] {
+			continue
+		}
+		window := q.window(anchor, now)
+		candidates := make([]*queueEntry, 0)
+		for _, e := range q.entries {
+			if e != anchor && !used[e] && math.Abs(e.rating-anchor.rating) <= min(window, q.window(e, now)) {
+				candidates = append(candidates, e)
+			}
+		}
+		// 分差小的优先，相同时等得久的优先
+		slices.SortStableFunc(candidates, func(a, b *queueEntry) int {
+			return cmp.Compare(math.Abs(a.rating-anchor.rating), math.Abs(b.rating-anchor.rating))
+		})
+
+		g := &queueGroup{entries: []*queueEntry{anchor}}
+		count := len(anchor.players)
+		for _, e := range candidates {
+			if count == size {
+				break
+			}
+			if count+len(e.players) <= size {
+				g.entries = append(g.entries, e)
+				count += len(e.players)
+			}
+		}
+		if count < size {
+			if q.conf.BotWait <= 0 || now.Sub(anchor.since) < q.conf.BotWait {
+				continue
+			}
+			g.bots = size - count
+		}
+		for _, e := range g.entries {
+			used[e] = true
+		}
+		groups = append(groups, g)
+	}
+	q.entries = slices.DeleteFunc(q.entries, func(e *queueEntry) bool { return used[e] })
+	return groups
+}
+
+// requeue 创建桌子失败，放回队列，保持原来的排队时间
+func (q *Queue) requeue(entries []*queueEntry) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.entries = append(q.entries, entries...)
+	slices.SortStableFunc(q.entries, func(a, b *queueEntry) int { return a.since.Compare(b.since) })
+}
+
+// seat 创建桌子并加入玩家，失败时取消桌子，除了加入失败的玩家其他人重新排队
+func (q *Queue) seat(g *queueGroup) {
+	t, err := NewTableErr(q.match, nil)
+	if err != nil {
+		logger.Log.Errorf("queue new table failed: %v", err)
+		q.requeue(g.entries)
+		return
+	}
+	if err := t.SendAddTableReq(q.conf.GameCount, "", nil); err != nil {
+		q.match.PutBackTableId(t.ID)
+		q.requeue(g.entries)
+		return
+	}
+	q.match.AddTable(t)
+
+	scores := make(map[string]int64)
+	for i, e := range g.entries {
+		for _, p := range e.players {
+			if err := t.AddPlayer(p); err != nil {
+				logger.Log.Errorf("queue add player %s to table %d failed: %v", p.ID, t.ID, err)
+				q.cancel(t)
+				q.requeue(slices.Delete(slices.Clone(g.entries), i, i+1))
+				return
+			}
+			scores[p.ID] = p.Score
+		}
+	}
+	matchID := q.match.Config().GetInt32("matchid")
+	for range g.bots {
+		seat := t.getSeat()
+		bot := NewPlayer(nil, context.Background(), fmt.Sprintf("bot_%d_%d_%d", matchID, t.ID, seat), matchID, 0)
+		bot.Bot = true
+		if err := t.AddPlayer(bot); err != nil {
+			logger.Log.Errorf("queue add bot to table %d failed: %v", t.ID, err)
+			q.cancel(t)
+			q.requeue(g.entries)
+			return
+		}
+	}
+
+	q.mu.Lock()
+	q.scores[t.ID] = scores
+	q.mu.Unlock()
+	if q.onTable != nil {
+		q.onTable(t)
+		return
+	}
+	for _, p := range t.Players {
+		if !p.Bot {
+			if err := q.match.PushMsg(p, q.match.NewStartClientAck(p)); err != nil {
+				logger.Log.Errorf("push start client to %s failed: %v", p.ID, err)
+			}
+		}
+	}
+}
+
+// cancel 取消已经创建的桌子，已经坐下的玩家离开座位
+func (q *Queue) cancel(t *Table) {
+	if err := t.SendCancelTableReq(); err != nil {
+		logger.Log.Errorf("cancel table %d failed: %v", t.ID, err)
+	}
+	for _, p := range t.Players {
+		t.RemovePlayer(p.ID)
+	}
+	q.match.DelTable(t.ID)
+}
+
+// updateRatings 需要持有q.mu，bot不参与计算
+func (q *Queue) updateRatings(req *sproto.GameResultReq) {
+	last, ok := q.scores[req.Tableid]
+	if !ok {
+		return
+	}
+	ids := make([]string, 0, len(last))
+	for id := range last {
+		if _, ok := req.Scores[id]; ok {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+	ratings := make([]Rating, len(ids))
+	deltas := make([]int64, len(ids))
+	for i, id := range ids {
+		ratings[i] = q.rating(id)
+		deltas[i] = req.Scores[id] - last[id]
+		last[id] = req.Scores[id]
+	}
+	for i, r := range UpdateRatings(ratings, deltas) {
+		q.setRating(ids[i], r)
+	}
+}
@@ -0,0 +1,67 @@
+package matchbase
+
+import (
+	"math"
+)
+
+// Rating 玩家等级分。Elo计算，K值随Deviation变化：
+// 新玩家不确定度大，等级分变化快，每打一局不确定度减小，类似Glicko
+type Rating struct {
+	Value     float64 `json:"value"`
+	Deviation float64 `json:"deviation"`
+	Games     int     `json:"games"`
+}
+
+const (
+	ratingInitial      = 1500
+	ratingMaxDeviation = 350 // 新玩家的不确定度
+	ratingMinDeviation = 50
+	ratingDecay        = 0.85 // 每局不确定度的衰减
+	ratingMinK         = 16   // 不确定度最小时的K值
+	ratingMaxK         = 64   // 新玩家的K值
+)
+
+// NewRating 新玩家的等级分
+func NewRating() Rating {
+	return Rating{Value: ratingInitial, Deviation: ratingMaxDeviation}
+}
+
+// k 不确定度越大K值越大
+func (r Rating) k() float64 {
+	ratio := (r.Deviation - ratingMinDeviation) / (ratingMaxDeviation - ratingMinDeviation)
+	return ratingMinK + (ratingMaxK-ratingMinK)*min(max(ratio, 0), 1)
+}
+
+// expected 对o的期望胜率
+func (r Rating) expected(o Rating) float64 {
+	return 1 / (1 + math.Pow(10, (o.Value-r.Value)/400))
+}
+
+// UpdateRatings 一局结束后按得分两两比较更新等级分，scores和ratings一一对应，
+// 得分高的算赢，相同算平，多人时每一对的变化除以对手数
+func UpdateRatings(ratings []Rating, scores []int64) []Rating {
+	result := make([]Rating, len(ratings))
+	copy(result, ratings)
+	if len(ratings) < 2 {
+		return result
+	}
+	for i, r := range ratings {
+		var sum float64
+		for j, o := range ratings {
+			if i == j {
+				continue
+			}
+			actual := 0.5
+			if scores[i] > scores[j] {
+				actual = 1
+			} else if scores[i] < scores[j] {
+				actual = 0
+			}
+			sum += actual - r.expected(o)
+		}
+		result[i].Value += r.k() * sum / float64(len(ratings)-1)
+		result[i].Deviation = max(r.Deviation*ratingDecay, ratingMinDeviation)
+		result[i].Games++
+	}
+	return result
+}
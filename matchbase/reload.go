@@ -0,0 +1,215 @@
+package matchbase
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+	"github.com/topfreegames/pitaya/v3/pkg/logger"
+)
+
+// matchConf 一份比赛配置，热更新时整体替换
+type matchConf struct {
+	v *viper.Viper
+	c *MatchConfig
+}
+
+// Config 当前的比赛配置，配置文件修改后返回新的配置。
+// 已经创建的桌子使用Table.Conf，不受影响
+func (m *Match) Config() *viper.Viper {
+	return m.conf.Load().v
+}
+
+// MatchConfig 当前配置的公共字段
+func (m *Match) MatchConfig() *MatchConfig {
+	return m.conf.Load().c
+}
+
+// Retiring 配置文件已经删除，不要再创建新桌子，桌子都结束后比赛被移除
+func (m *Match) Retiring() bool {
+	return m.retiring.Load()
+}
+
+// tableCount 进行中的桌子数
+func (m *Match) tableCount() int {
+	count := 0
+	m.tables.Range(func(any, any) bool {
+		count++
+		return true
+	})
+	return count
+}
+
+// reload 配置文件内容有变化时重新加载，记录变化的项。
+// 校验不通过时保留原来的配置，matchid不能修改
+func (m *Match) reload() error {
+	data, err := os.ReadFile(m.file)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(data, m.raw) {
+		return nil
+	}
+	conf, settings, err := readMatchConfig(m.file, data)
+	if err != nil {
+		return err
+	}
+	old := m.Config()
+	if conf.GetInt32("matchid") != old.GetInt32("matchid") {
+		return fmt.Errorf("matchid cannot change from %d to %d", old.GetInt32("matchid"), conf.GetInt32("matchid"))
+	}
+	m.raw = data
+	m.conf.Store(&matchConf{v: conf, c: settings})
+	logger.Log.Infof("match %d config reloaded from %s: %s", old.GetInt32("matchid"), m.file, strings.Join(configDiff(old, conf), ", "))
+	return nil
+}
+
+// configDiff 配置变化的项，形如key: old -> new，按key排序
+func configDiff(old, conf *viper.Viper) []string {
+	keys := slices.Concat(old.AllKeys(), conf.AllKeys())
+	slices.Sort(keys)
+	diff := make([]string, 0)
+	for _, key := range slices.Compact(keys) {
+		if a, b := old.Get(key), conf.Get(key); !reflect.DeepEqual(a, b) {
+			diff = append(diff, fmt.Sprintf("%s: %v -> %v", key, a, b))
+		}
+	}
+	return diff
+}
+
+// configDir 比赛配置目录
+func (m *Matchmgr) configDir() string {
+	return filepath.Join("etc", m.App.GetServer().Type)
+}
+
+// Reload 按配置目录同步比赛：新文件创建比赛，修改的文件更新配置，
+// 删除的文件让比赛退役，桌子都结束后移除。不合法的配置文件只记录日志，不影响其他比赛
+func (m *Matchmgr) Reload() error {
+	return m.reload(false)
+}
+
+// reload strict为true时返回所有不合法的新配置文件，启动时使用
+func (m *Matchmgr) reload(strict bool) error {
+	files, err := filepath.Glob(filepath.Join(m.configDir(), "*.yaml"))
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var errs []error
+	for _, file := range files {
+		if match, ok := m.files[file]; ok {
+			if err := match.reload(); err != nil {
+				logger.Log.Errorf("reload match config %s failed: %v", file, err)
+			}
+			if match.retiring.CompareAndSwap(true, false) {
+				logger.Log.Infof("match %d config %s restored", match.Config().GetInt32("matchid"), file)
+			}
+			continue
+		}
+		if err := m.validate(file); err != nil {
+			if strict {
+				errs = append(errs, fmt.Errorf("%s: %w", file, err))
+			}
+			logger.Log.Errorf("invalid match config %s: %v", file, err)
+			continue
+		}
+		logger.Log.Infof("加载比赛配置: %s", file)
+		match := matchCreator(m.App, file)
+		if match == nil {
+			if strict {
+				errs = append(errs, fmt.Errorf("%s: create match failed", file))
+			}
+			continue
+		}
+		m.Matchs[match.MatchConfig().MatchID] = match
+		m.files[file] = match
+	}
+	for file, match := range m.files {
+		if !slices.Contains(files, file) && match.retiring.CompareAndSwap(false, true) {
+			logger.Log.Infof("match %d config %s removed, retiring after %d tables finish",
+				match.Config().GetInt32("matchid"), file, match.tableCount())
+		}
+	}
+	m.sweep()
+	return errors.Join(errs...)
+}
+
+// validate 需要持有m.mu，新的配置文件要合法，matchid不能和已有的比赛重复
+func (m *Matchmgr) validate(file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	_, conf, err := readMatchConfig(file, data)
+	if err != nil {
+		return err
+	}
+	if rules, _ := gameRules(conf.GameType); rules == 0 && conf.Property != "" {
+		logger.Log.Warnf("%s: rule count of game_type %s not registered, property length not checked", file, conf.GameType)
+	}
+	if _, ok := m.Matchs[conf.MatchID]; ok {
+		return fmt.Errorf("matchid %d already used", conf.MatchID)
+	}
+	return nil
+}
+
+// sweep 需要持有m.mu，移除桌子都已结束的退役比赛
+func (m *Matchmgr) sweep() {
+	for file, match := range m.files {
+		if match.Retiring() && match.tableCount() == 0 {
+			matchID := match.Config().GetInt32("matchid")
+			delete(m.files, file)
+			delete(m.Matchs, matchID)
+			logger.Log.Infof("match %d retired", matchID)
+		}
+	}
+}
+
+// watch 监听配置目录，有变化时在下一次tick重新加载
+func (m *Matchmgr) watch() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err := watcher.Add(m.configDir()); err != nil {
+		watcher.Close()
+		return err
+	}
+	go func() {
+		defer watcher.Close()
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if strings.HasSuffix(event.Name, ".yaml") {
+					m.changed.Store(true)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				if !errors.Is(err, fsnotify.ErrEventOverflow) {
+					logger.Log.Errorf("watch match config failed: %v", err)
+				}
+				m.changed.Store(true)
+			}
+		}
+	}()
+	return nil
+}
+
+// ReloadMatchs 立即按配置目录同步比赛
+func ReloadMatchs() error {
+	return defaultMatchmgr.Reload()
+}
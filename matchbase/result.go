@@ -0,0 +1,40 @@
+package matchbase
+
+import (
+	"github.com/kevin-chtw/tw_proto/sproto"
+	"google.golang.org/protobuf/proto"
+)
+
+// resultKey 游戏服发件箱重发的消息按局数去重
+type resultKey struct {
+	curGameCount int32
+	name         string
+}
+
+// AcceptResult 游戏服会重发GameResultReq和GameOverReq直到收到回复，
+// 按(tableid, curGameCount)去重，已经处理过或者桌子已经删除时返回false，需要直接回复成功。
+// 去重记录跟着桌子，桌号归还后分配器要转一圈才会复用，旧桌子的重发早已结束
+func (m *Match) AcceptResult(msg proto.Message) bool {
+	var tableID, curGameCount int32
+	var name string
+	switch req := msg.(type) {
+	case *sproto.GameResultReq:
+		tableID, curGameCount, name = req.Tableid, req.CurGameCount, "result"
+	case *sproto.GameOverReq:
+		tableID, curGameCount, name = req.Tableid, req.CurGameCount, "over"
+	default:
+		return true
+	}
+	t := m.GetTable(tableID)
+	if t == nil {
+		return false
+	}
+	key := resultKey{curGameCount, name}
+	t.resultMu.Lock()
+	defer t.resultMu.Unlock()
+	if _, ok := t.results[key]; ok {
+		return false
+	}
+	t.results[key] = struct{}{}
+	return true
+}
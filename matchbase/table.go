@@ -3,9 +3,14 @@ package matchbase
 import (
 	"context"
 	"errors"
+	"maps"
+	"sync"
 
+	"github.com/kevin-chtw/tw_common/utils"
 	"github.com/kevin-chtw/tw_proto/cproto"
 	"github.com/kevin-chtw/tw_proto/sproto"
+	"github.com/spf13/viper"
+	pitayaerrors "github.com/topfreegames/pitaya/v3/pkg/errors"
 	"github.com/topfreegames/pitaya/v3/pkg/logger"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/anypb"
@@ -17,16 +22,48 @@ type Table struct {
 	ID          int32
 	PlayerCount int32
 	Players     map[string]*Player
+	ServerID    string       // 桌子所在的游戏服，为空时由pitaya按路由选择
+	Conf        *viper.Viper // 创建桌子时的比赛配置，热更新不影响已有的桌子
+	resultMu    sync.Mutex
+	results     map[resultKey]struct{} // 已经处理的对局结果
 }
 
+// NewTable 创建桌子，ID分配失败时只记录日志，返回的桌子ID为0。
+//
+// Deprecated: 使用NewTableErr，分配失败时返回错误
 func NewTable(m *Match, sub any) *Table {
+	t, err := NewTableErr(m, sub)
+	if err != nil {
+		logger.Log.Error(err.Error())
+		conf := m.Config()
+		return &Table{
+			Sub:         sub,
+			Match:       m,
+			PlayerCount: conf.GetInt32("player_per_table"),
+			Players:     make(map[string]*Player),
+			Conf:        conf,
+			results:     make(map[resultKey]struct{}),
+		}
+	}
+	return t
+}
+
+// NewTableErr 创建桌子，ID分配失败时返回错误，比如区间耗尽时返回ErrExhausted
+func NewTableErr(m *Match, sub any) (*Table, error) {
+	id, err := m.nextTableID()
+	if err != nil {
+		return nil, err
+	}
+	conf := m.Config()
 	return &Table{
 		Sub:         sub,
 		Match:       m,
-		ID:          m.nextTableID(),
-		PlayerCount: m.Viper.GetInt32("player_per_table"),
+		ID:          id,
+		PlayerCount: conf.GetInt32("player_per_table"),
 		Players:     make(map[string]*Player),
-	}
+		Conf:        conf,
+		results:     make(map[resultKey]struct{}),
+	}, nil
 }
 
 func (t *Table) IsOnTable(player *Player) bool {
@@ -73,22 +110,56 @@ func (t *Table) AddPlayer(player *Player) error {
 	return nil
 }
 
+// RemovePlayer 从桌子上移除玩家，不再通知游戏服，游戏服踢人时见Match.HandleExitTable
+func (t *Table) RemovePlayer(playerID string) *Player {
+	player, ok := t.Players[playerID]
+	if !ok {
+		return nil
+	}
+	delete(t.Players, playerID)
+	player.Seat = -1
+	player.TableId = 0
+	return player
+}
+
 func (t *Table) SendAddTableReq(gameCount int32, creator string, fdproperty map[string]int32) error {
 	req := &sproto.AddTableReq{
-		Property:    t.Match.Viper.GetString("property"),
-		ScoreBase:   t.Match.Viper.GetInt64("score_base"),
+		Property:    t.Conf.GetString("property"),
+		ScoreBase:   t.Conf.GetInt64("score_base"),
 		MatchType:   t.Match.App.GetServer().Type,
 		GameCount:   gameCount,
 		PlayerCount: t.PlayerCount,
-		Fdproperty:  fdproperty,
+		Fdproperty:  t.fdproperty(fdproperty),
 		Creator:     creator,
 	}
-	_, err := t.send2Game(req)
-	if err != nil {
-		logger.Log.Errorf("Failed to send add table request: %v", err)
-		return err
+	// 游戏服排空中时换一个游戏服重试
+	for {
+		t.ServerID = t.Match.pickGameServer()
+		_, err := t.send2Game(req)
+		if err == nil {
+			return nil
+		}
+		if t.ServerID == "" || pitayaerrors.CodeFromError(err) != utils.ErrCodeDraining {
+			logger.Log.Errorf("Failed to send add table request: %v", err)
+			t.ServerID = ""
+			return err
+		}
+		t.Match.MarkDrained(t.ServerID)
 	}
-	return nil
+}
+
+// fdproperty 比赛配置中的fdproperty作为默认值，俱乐部设置的房间属性优先
+func (t *Table) fdproperty(fdproperty map[string]int32) map[string]int32 {
+	defaults := t.Conf.GetStringMap("fdproperty")
+	if len(defaults) == 0 {
+		return fdproperty
+	}
+	merged := make(map[string]int32, len(defaults)+len(fdproperty))
+	for key := range defaults {
+		merged[key] = t.Conf.GetInt32("fdproperty." + key)
+	}
+	maps.Copy(merged, fdproperty)
+	return merged
 }
 
 func (t *Table) SendAddPlayer(player *Player) error {
@@ -134,9 +205,9 @@ func (t *Table) SendExitTableReq(player *Player) error {
 func (t *Table) SendStartClient(p *Player) {
 	startClientAck := &cproto.StartClientAck{
 		MatchType: t.Match.App.GetServer().Type,
-		GameType:  t.Match.Viper.GetString("game_type"),
+		GameType:  t.Conf.GetString("game_type"),
 		ServerId:  t.Match.App.GetServerID(),
-		MatchId:   t.Match.Viper.GetInt32("matchid"),
+		MatchId:   t.Conf.GetInt32("matchid"),
 		TableId:   t.ID,
 	}
 	data, err := t.Match.NewMatchAck(p.Ctx, startClientAck)
@@ -180,12 +251,18 @@ func (t *Table) send2Game(msg proto.Message) (*sproto.GameAck, error) {
 	}
 
 	req := &sproto.GameReq{
-		Matchid: t.Match.Viper.GetInt32("matchid"),
+		Matchid: t.Conf.GetInt32("matchid"),
 		Tableid: t.ID,
 		Req:     data,
 	}
 	rsp := &sproto.GameAck{}
-	if err = t.Match.App.RPC(context.Background(), t.Match.Viper.GetString("game_type")+".remote.message", rsp, req); err != nil {
+	route := t.Conf.GetString("game_type") + ".remote.message"
+	if t.ServerID != "" {
+		err = t.Match.App.RPCTo(context.Background(), t.ServerID, route, rsp, req)
+	} else {
+		err = t.Match.App.RPC(context.Background(), route, rsp, req)
+	}
+	if err != nil {
 		logger.Log.Errorf("Failed to send message to game server: %v", err)
 		return nil, err
 	}
@@ -1,64 +1,105 @@
 package matchbase
 
 import (
-	"errors"
 	"math/rand"
 	"sync"
+
+	"github.com/kevin-chtw/tw_common/storage"
 )
 
 const (
-	minID = 100000
-	maxID = 999999
+	minID = storage.MinTableID
+	maxID = storage.MaxTableID
 )
 
 var (
-	ErrExhausted = errors.New("all ids in range are used")
+	ErrExhausted = storage.ErrTableIDsExhausted
 )
 
-// TableIDs 负责生成不重复的 5 位数字
+// TableIDAllocator 桌子ID分配器，游戏服用比赛ID和桌子ID区分桌子，同一比赛的桌子ID不能重复。
+// 注册名为tableids的模块时所有比赛使用该模块，否则每个比赛使用进程内的TableIDs
+type TableIDAllocator interface {
+	// Take 返回一个未使用的ID，区间耗尽返回ErrExhausted
+	Take() (int32, error)
+	// PutBack 归还ID
+	PutBack(id int32)
+}
+
+// TableIDLossNotifier 分配器实现后，丢失使用中的ID时通知比赛，比如ETCDTableIDs的块在lease过期后被其他服务器租用
+type TableIDLossNotifier interface {
+	SetOnLost(f func(ids []int32))
+}
+
+// ITableIDsLost 比赛实现后处理ID可能和其他服务器重复的桌子，比如取消桌子让玩家重新排队，没有实现时只记录日志
+type ITableIDsLost interface {
+	OnTableIDsLost(tables []*Table)
+}
+
+// TableIDs 进程内的ID分配器，多个比赛服实例会分配出相同的ID，只用于单进程和测试
 type TableIDs struct {
-	mu      sync.Mutex
-	used    map[int32]struct{}
-	shuffle []int32
-	index   int
+	mu    sync.Mutex
+	used  map[int32]struct{} // 使用中的ID
+	min   int32
+	size  int64
+	start int64
+	step  int64 // 和size互质，按步长遍历区间得到打乱的顺序
+	index int64
 }
 
-// NewTableIDs 创建一个新的生成器
+// NewTableIDs 创建默认区间的6位数字生成器
 func NewTableIDs() *TableIDs {
-	n := maxID - minID + 1
-	shuffle := make([]int32, n)
-	for i := range shuffle {
-		shuffle[i] = minID + int32(i)
-	}
-	// Fisher–Yates shuffle
-	rand.Shuffle(n, func(i, j int) {
-		shuffle[i], shuffle[j] = shuffle[j], shuffle[i]
-	})
+	return NewTableIDRange(minID, maxID)
+}
 
+// NewTableIDRange 创建[min, max]区间的生成器，内存只和使用中的ID数量有关
+func NewTableIDRange(min, max int32) *TableIDs {
+	size := int64(max) - int64(min) + 1
 	return &TableIDs{
-		used:    make(map[int32]struct{}, n),
-		shuffle: shuffle,
-		index:   0,
+		used:  make(map[int32]struct{}),
+		min:   min,
+		size:  size,
+		start: rand.Int63n(size),
+		step:  coprimeStep(size),
 	}
 }
 
-// Take 返回一个未被使用过的数字；区间耗尽返回 ErrExhausted
+// coprimeStep 随机选择和n互质的步长
+func coprimeStep(n int64) int64 {
+	for {
+		step := rand.Int63n(n) + 1
+		if gcd(step, n) == 1 {
+			return step
+		}
+	}
+}
+
+func gcd(a, b int64) int64 {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// Take 返回一个未被使用的数字；区间耗尽返回 ErrExhausted
 func (g *TableIDs) Take() (int32, error) {
 	g.mu.Lock()
 	defer g.mu.Unlock()
 
-	for g.index < len(g.shuffle) {
-		id := g.shuffle[g.index]
-		g.index++
+	if int64(len(g.used)) >= g.size {
+		return 0, ErrExhausted
+	}
+	// 循环遍历区间，归还的ID在下一圈重新使用
+	for {
+		id := g.min + int32((g.start+g.index*g.step)%g.size)
+		g.index = (g.index + 1) % g.size
 		if _, ok := g.used[id]; !ok {
 			g.used[id] = struct{}{}
 			return id, nil
 		}
 	}
-	return 0, ErrExhausted
 }
 
-// PutBack 把某个数字归还到可用池（可选接口）
+// PutBack 把某个数字归还到可用池
 func (g *TableIDs) PutBack(id int32) {
 	g.mu.Lock()
 	defer g.mu.Unlock()
@@ -0,0 +1,561 @@
+package matchbase
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/kevin-chtw/tw_proto/sproto"
+	"github.com/spf13/viper"
+	"github.com/topfreegames/pitaya/v3/pkg/logger"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// TournamentFormat 赛制
+type TournamentFormat string
+
+const (
+	FormatKnockout TournamentFormat = "knockout" // 每桌前Advance名晋级且至少淘汰一人，剩一桌时打决赛桌
+	FormatSwiss    TournamentFormat = "swiss"    // 按积分配对打Rounds轮，不淘汰
+	FormatDingju   TournamentFormat = "dingju"   // 定局积分，打Rounds轮按累计分数排名
+)
+
+// TournamentConfig 赛制配置，比赛配置中的tournament段
+type TournamentConfig struct {
+	Format         TournamentFormat
+	Rounds         int   // swiss和dingju的轮数，不含决赛桌
+	GameCount      int32 // 每轮每桌局数
+	Advance        int   // knockout每桌晋级人数，人数不够的桌子少晋级一人
+	Eliminate      bool  // 每轮结束后淘汰分数低于EliminateBelow的玩家
+	EliminateBelow int64
+	FinalGameCount int32 // 决赛桌局数，swiss和dingju为0时不打决赛桌，knockout为0时使用GameCount
+}
+
+// ParseTournamentConfig 读取比赛配置的tournament段并校验，knockout每轮至少淘汰一人才能结束
+func ParseTournamentConfig(v *viper.Viper) (TournamentConfig, error) {
+	conf := TournamentConfig{
+		Format:         TournamentFormat(v.GetString("tournament.format")),
+		Rounds:         v.GetInt("tournament.rounds"),
+		GameCount:      max(v.GetInt32("tournament.game_count"), 1),
+		Advance:        v.GetInt("tournament.advance"),
+		Eliminate:      v.IsSet("tournament.eliminate_below"),
+		EliminateBelow: v.GetInt64("tournament.eliminate_below"),
+		FinalGameCount: v.GetInt32("tournament.final_game_count"),
+	}
+	size := v.GetInt("player_per_table")
+	switch conf.Format {
+	case FormatKnockout:
+		// 单人桌没有人可以淘汰
+		if size < 2 {
+			return conf, fmt.Errorf("knockout player_per_table %d must be at least 2", size)
+		}
+		if conf.Advance < 1 || conf.Advance >= size {
+			return conf, fmt.Errorf("knockout advance %d out of range [1, %d)", conf.Advance, size)
+		}
+	case FormatSwiss, FormatDingju:
+		if conf.Rounds < 1 {
+			return conf, fmt.Errorf("%s rounds %d must be positive", conf.Format, conf.Rounds)
+		}
+	default:
+		return conf, fmt.Errorf("unknown tournament format %q", conf.Format)
+	}
+	return conf, nil
+}
+
+// Standing 一名选手的排名
+type Standing struct {
+	Rank       int    `json:"rank"`
+	PlayerID   string `json:"uid"`
+	Score      int64  `json:"score"`
+	Points     int    `json:"points"`               // swiss积分，每赢同桌一人得1分
+	Eliminated int    `json:"eliminated,omitempty"` // 被淘汰的轮次
+}
+
+// TournamentStandings 轮间推送给选手的排名，以google.protobuf.Struct发送
+type TournamentStandings struct {
+	Round     int         `json:"round"`
+	Final     bool        `json:"final"`    // 下一轮是决赛桌
+	Finished  bool        `json:"finished"` // 比赛结束
+	Standings []*Standing `json:"standings"`
+}
+
+var (
+	errTournamentStarted = errors.New("tournament already started")
+	errTournamentPlayers = errors.New("not enough players")
+	errTournamentJoined  = errors.New("player already joined")
+)
+
+// entrant 参赛选手
+type entrant struct {
+	*Player
+	order      int   // 报名顺序
+	points     int   // swiss积分
+	roundScore int64 // 本轮开始时的分数
+	eliminated int
+	opponents  map[string]int // 交手次数
+}
+
+// Tournament 多轮赛事，每轮把剩下的选手分桌，所有桌子打完后按赛制晋级、淘汰并推送排名。
+// 比赛收到GameResultReq和GameOverReq时调用OnResult，桌子由Tournament创建和删除。
+// 持有mu时只决定分桌和排名，给游戏服的RPC和推送在释放mu之后执行
+type Tournament struct {
+	match   *Match
+	conf    TournamentConfig
+	onTable func(*Table)
+
+	mu       sync.Mutex
+	entrants []*entrant
+	round    int
+	final    bool                 // 当前轮是决赛桌
+	groups   [][]*entrant         // 本轮的分桌
+	tables   map[int32][]*entrant // 本轮进行中的桌子
+	pending  [][]*entrant         // 本轮创建失败等待重试的分组
+	seating  int                  // 正在创建桌子的分组数，创建完成前本轮不能结束
+	finished bool
+}
+
+// NewTournament 创建赛事
+func NewTournament(m *Match, conf TournamentConfig) *Tournament {
+	return &Tournament{
+		match:  m,
+		conf:   conf,
+		tables: make(map[int32][]*entrant),
+	}
+}
+
+// SetOnTable 每轮创建桌子后调用，默认给选手推送StartClientAck，调用时不持有锁
+func (t *Tournament) SetOnTable(fn func(*Table)) {
+	t.onTable = fn
+}
+
+// Join 报名，开始后不能报名
+func (t *Tournament) Join(p *Player) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.round > 0 {
+		return errTournamentStarted
+	}
+	if slices.ContainsFunc(t.entrants, func(e *entrant) bool { return e.ID == p.ID }) {
+		return errTournamentJoined
+	}
+	t.entrants = append(t.entrants, &entrant{Player: p, order: len(t.entrants), opponents: make(map[string]int)})
+	return nil
+}
+
+// Start 开始第一轮
+func (t *Tournament) Start() error {
+	t.mu.Lock()
+	if t.round > 0 {
+		t.mu.Unlock()
+		return errTournamentStarted
+	}
+	if len(t.entrants) < 2 {
+		t.mu.Unlock()
+		return errTournamentPlayers
+	}
+	t.final = t.conf.Format == FormatKnockout && len(t.entrants) <= t.tableSize()
+	work := &roundWork{}
+	t.startRound(work)
+	t.mu.Unlock()
+	t.run(work)
+	return nil
+}
+
+// Tick 重试本轮创建失败的桌子
+func (t *Tournament) Tick() {
+	t.mu.Lock()
+	work := &roundWork{groups: t.pending, gameCount: t.gameCount()}
+	t.pending = nil
+	t.seating += len(work.groups)
+	t.mu.Unlock()
+	t.run(work)
+}
+
+// Round 当前轮次，0表示还没开始
+func (t *Tournament) Round() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.round
+}
+
+// Finished 比赛是否结束
+func (t *Tournament) Finished() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.finished
+}
+
+// Standings 当前排名
+func (t *Tournament) Standings() []*Standing {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.standings()
+}
+
+// OnResult 处理对局结果，GameResultReq更新选手分数，GameOverReq时桌子结束，
+// 本轮所有桌子结束后进入下一轮。和AcceptResult一起使用，重发的结果不要再调用
+func (t *Tournament) OnResult(msg proto.Message) {
+	t.mu.Lock()
+	work := &roundWork{}
+	defer t.run(work)
+	defer t.mu.Unlock()
+	switch req := msg.(type) {
+	case *sproto.GameResultReq:
+		for _, e := range t.tables[req.Tableid] {
+			if score, ok := req.Scores[e.ID]; ok {
+				e.Score = score
+			}
+		}
+	case *sproto.GameOverReq:
+		if _, ok := t.tables[req.Tableid]; !ok {
+			return
+		}
+		if table := t.match.GetTable(req.Tableid); table != nil {
+			t.cancel(table, false)
+		}
+		delete(t.tables, req.Tableid)
+		if len(t.tables) == 0 && len(t.pending) == 0 && t.seating == 0 {
+			t.endRound(work)
+		}
+	}
+}
+
+func (t *Tournament) tableSize() int {
+	return int(t.match.Config().GetInt32("player_per_table"))
+}
+
+// active 需要持有t.mu，没有被淘汰的选手，按排名排序
+func (t *Tournament) active() []*entrant {
+	active := make([]*entrant, 0, len(t.entrants))
+	for _, e := range t.entrants {
+		if e.eliminated == 0 {
+			active = append(active, e)
+		}
+	}
+	slices.SortStableFunc(active, t.compare)
+	return active
+}
+
+// compare 淘汰晚的在前，然后swiss比积分，再比分数，最后比报名顺序
+func (t *Tournament) compare(a, b *entrant) int {
+	alive := func(e *entrant) int {
+		if e.eliminated == 0 {
+			return t.round + 1
+		}
+		return e.eliminated
+	}
+	if c := cmp.Compare(alive(b), alive(a)); c != 0 {
+		return c
+	}
+	if t.conf.Format == FormatSwiss {
+		if c := cmp.Compare(b.points, a.points); c != 0 {
+			return c
+		}
+	}
+	if c := cmp.Compare(b.Score, a.Score); c != 0 {
+		return c
+	}
+	return cmp.Compare(a.order, b.order)
+}
+
+// standings 需要持有t.mu
+func (t *Tournament) standings() []*Standing {
+	sorted := slices.Clone(t.entrants)
+	slices.SortStableFunc(sorted, t.compare)
+	standings := make([]*Standing, len(sorted))
+	for i, e := range sorted {
+		standings[i] = &Standing{Rank: i + 1, PlayerID: e.ID, Score: e.Score, Points: e.points, Eliminated: e.eliminated}
+	}
+	return standings
+}
+
+// roundWork 持有t.mu时决定、释放之后执行的推送和分桌
+type roundWork struct {
+	standings *structpb.Struct
+	players   []*Player
+	groups    [][]*entrant
+	gameCount int32
+}
+
+// run 不能持有t.mu，先推送排名再创建桌子
+func (t *Tournament) run(work *roundWork) {
+	for _, p := range work.players {
+		if err := t.match.PushMsg(p, work.standings); err != nil {
+			logger.Log.Errorf("push standings to %s failed: %v", p.ID, err)
+		}
+	}
+	for _, g := range work.groups {
+		t.seat(g, work.gameCount)
+	}
+}
+
+// startRound 需要持有t.mu，分桌开始新的一轮，桌子由work创建
+func (t *Tournament) startRound(work *roundWork) {
+	t.round++
+	active := t.active()
+	for _, e := range active {
+		e.roundScore = e.Score
+	}
+	logger.Log.Infof("match %d tournament round %d, %d players, final %v",
+		t.match.Config().GetInt32("matchid"), t.round, len(active), t.final)
+
+	var groups [][]*entrant
+	if t.conf.Format == FormatSwiss && !t.final {
+		groups = t.pairSwiss(active)
+	} else {
+		groups = t.pairSnake(active)
+	}
+	t.groups = groups
+	for _, g := range groups {
+		for _, e := range g {
+			for _, o := range g {
+				if o != e {
+					e.opponents[o.ID]++
+				}
+			}
+		}
+	}
+	t.seating += len(groups)
+	work.groups = groups
+	work.gameCount = t.gameCount()
+}
+
+// pairSnake 按排名蛇形分桌，每桌实力接近
+func (t *Tournament) pairSnake(active []*entrant) [][]*entrant {
+	count := (len(active) + t.tableSize() - 1) / t.tableSize()
+	groups := make([][]*entrant, count)
+	for i, e := range active {
+		row, col := i/count, i%count
+		if row%2 == 1 {
+			col = count - 1 - col
+		}
+		groups[col] = append(groups[col], e)
+	}
+	return groups
+}
+
+// pairSwiss 按排名从高到低，每桌找积分最接近且交手次数最少的对手
+func (t *Tournament) pairSwiss(active []*entrant) [][]*entrant {
+	size := t.tableSize()
+	rest := slices.Clone(active)
+	groups := make([][]*entrant, 0)
+	for len(rest) > 0 {
+		group := []*entrant{rest[0]}
+		rest = rest[1:]
+		for len(group) < size && len(rest) > 0 {
+			best := 0
+			for i, e := range rest {
+				if t.met(group, e) < t.met(group, rest[best]) {
+					best = i
+				}
+			}
+			group = append(group, rest[best])
+			rest = slices.Delete(rest, best, best+1)
+		}
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+// met e和group中选手的交手次数
+func (t *Tournament) met(group []*entrant, e *entrant) int {
+	count := 0
+	for _, g := range group {
+		count += e.opponents[g.ID]
+	}
+	return count
+}
+
+// gameCount 需要持有t.mu，本轮每桌局数
+func (t *Tournament) gameCount() int32 {
+	if t.final && t.conf.FinalGameCount > 0 {
+		return t.conf.FinalGameCount
+	}
+	return t.conf.GameCount
+}
+
+// seat 不能持有t.mu，创建桌子，人数不够时用bot补满，失败时放到pending等Tick重试
+func (t *Tournament) seat(group []*entrant, gameCount int32) {
+	table, err := NewTableErr(t.match, nil)
+	if err != nil {
+		logger.Log.Errorf("tournament new table failed: %v", err)
+		t.seated(nil, group)
+		return
+	}
+	if err := table.SendAddTableReq(gameCount, "", nil); err != nil {
+		t.match.PutBackTableId(table.ID)
+		t.seated(nil, group)
+		return
+	}
+	t.match.AddTable(table)
+	for _, e := range group {
+		// 选手上一轮的桌子可能还没在游戏服删除完
+		if err := table.AddPlayer(e.Player); err != nil {
+			logger.Log.Errorf("tournament add player %s to table %d failed: %v", e.ID, table.ID, err)
+			t.cancel(table, true)
+			t.seated(nil, group)
+			return
+		}
+	}
+	matchID := t.match.Config().GetInt32("matchid")
+	for len(table.Players) < int(table.PlayerCount) {
+		bot := NewPlayer(nil, context.Background(), fmt.Sprintf("bot_%d_%d_%d", matchID, table.ID, table.getSeat()), matchID, 0)
+		bot.Bot = true
+		if err := table.AddPlayer(bot); err != nil {
+			logger.Log.Errorf("tournament add bot to table %d failed: %v", table.ID, err)
+			t.cancel(table, true)
+			t.seated(nil, group)
+			return
+		}
+	}
+	t.seated(table, group)
+
+	if t.onTable != nil {
+		t.onTable(table)
+		return
+	}
+	for _, e := range group {
+		if err := t.match.PushMsg(e.Player, t.match.NewStartClientAck(e.Player)); err != nil {
+			logger.Log.Errorf("push start client to %s failed: %v", e.ID, err)
+		}
+	}
+}
+
+// seated 分组创建完成，table为nil时创建失败，等Tick重试
+func (t *Tournament) seated(table *Table, group []*entrant) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.seating--
+	if table == nil {
+		t.pending = append(t.pending, group)
+		return
+	}
+	t.tables[table.ID] = group
+}
+
+// cancel 让选手离开桌子并删除桌子，notify为true时通知游戏服取消，这时不能持有t.mu
+func (t *Tournament) cancel(table *Table, notify bool) {
+	if notify {
+		if err := table.SendCancelTableReq(); err != nil {
+			logger.Log.Errorf("cancel table %d failed: %v", table.ID, err)
+		}
+	}
+	for id := range table.Players {
+		table.RemovePlayer(id)
+	}
+	t.match.DelTable(table.ID)
+}
+
+// endRound 需要持有t.mu，按本轮结果晋级、淘汰，work推送排名后开始下一轮或结束
+func (t *Tournament) endRound(work *roundWork) {
+	// 每赢同桌一人得1分
+	for _, g := range t.groups {
+		for _, e := range g {
+			for _, o := range g {
+				if e.Score-e.roundScore > o.Score-o.roundScore {
+					e.points++
+				}
+			}
+		}
+	}
+	if t.final {
+		t.finish(work)
+		return
+	}
+	if t.conf.Format == FormatKnockout {
+		t.knockout()
+	}
+	if t.conf.Eliminate {
+		t.eliminateBelow()
+	}
+
+	active := t.active()
+	switch {
+	case len(active) <= 1:
+		t.finish(work)
+		return
+	case t.conf.Format == FormatKnockout:
+		t.final = len(active) <= t.tableSize()
+	case t.round >= t.conf.Rounds:
+		if t.conf.FinalGameCount <= 0 {
+			t.finish(work)
+			return
+		}
+		// 前几名进入决赛桌
+		for _, e := range active[min(t.tableSize(), len(active)):] {
+			e.eliminated = t.round
+		}
+		t.final = true
+	}
+	t.push(work, false)
+	t.startRound(work)
+}
+
+// knockout 需要持有t.mu，每桌本轮分数变化前Advance名晋级。
+// 蛇形分桌后有的桌子人数不满，这些桌子最多晋级len(group)-1人，保证每轮都有人被淘汰；
+// 不是决赛桌时选手比每桌人数多，至少有一桌不少于两人
+func (t *Tournament) knockout() {
+	for _, g := range t.groups {
+		group := slices.Clone(g)
+		slices.SortStableFunc(group, func(a, b *entrant) int {
+			return cmp.Compare(b.Score-b.roundScore, a.Score-a.roundScore)
+		})
+		advance := min(t.conf.Advance, max(len(group)-1, 1))
+		for _, e := range group[min(advance, len(group)):] {
+			e.eliminated = t.round
+		}
+	}
+}
+
+// eliminateBelow 需要持有t.mu，淘汰分数低于阈值的选手，全部低于阈值时保留分数最高的
+func (t *Tournament) eliminateBelow() {
+	active := t.active()
+	best := slices.MaxFunc(active, func(a, b *entrant) int { return cmp.Compare(a.Score, b.Score) })
+	for _, e := range active {
+		if e.Score < t.conf.EliminateBelow && (e != best || best.Score >= t.conf.EliminateBelow) {
+			e.eliminated = t.round
+		}
+	}
+}
+
+// finish 需要持有t.mu
+func (t *Tournament) finish(work *roundWork) {
+	t.finished = true
+	t.push(work, true)
+	logger.Log.Infof("match %d tournament finished after %d rounds", t.match.Config().GetInt32("matchid"), t.round)
+}
+
+// push 需要持有t.mu，work把排名推送给所有选手
+func (t *Tournament) push(work *roundWork, finished bool) {
+	msg, err := newStandingsMsg(&TournamentStandings{
+		Round:     t.round,
+		Final:     t.final && !finished,
+		Finished:  finished,
+		Standings: t.standings(),
+	})
+	if err != nil {
+		logger.Log.Errorf("encode standings failed: %v", err)
+		return
+	}
+	work.standings = msg
+	for _, e := range t.entrants {
+		work.players = append(work.players, e.Player)
+	}
+}
+
+func newStandingsMsg(standings *TournamentStandings) (*structpb.Struct, error) {
+	data, err := json.Marshal(standings)
+	if err != nil {
+		return nil, err
+	}
+	var value map[string]any
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+	return structpb.NewStruct(value)
+}
@@ -75,7 +75,7 @@ func (m *Match) ValidatePlayer(ctx context.Context, opts ...ValidateOption) (*Pl
 		if !options.allowCreateNewPlayer {
 			return nil, errors.New("player not found")
 		}
-		player = playerCreator(ctx, uid, m.Viper.GetInt32("matchid"), m.Viper.GetInt64("initial_chips"))
+		player = playerCreator(ctx, uid, m.Config().GetInt32("matchid"), m.Config().GetInt64("initial_chips"))
 	}
 
 	return player, nil
@@ -0,0 +1,165 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/topfreegames/pitaya/v3/pkg/config"
+	"github.com/topfreegames/pitaya/v3/pkg/modules"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/namespace"
+)
+
+// ErrTableNotFound 检查点不存在
+var ErrTableNotFound = errors.New("table checkpoint not found")
+
+// TableStore 游戏桌检查点存储，每个游戏服需要使用独立的目录或前缀
+type TableStore interface {
+	Save(key string, data []byte) error
+	Load(key string) ([]byte, error)
+	Delete(key string) error
+	// LoadAll 加载全部检查点 key -> data
+	LoadAll() (map[string][]byte, error)
+}
+
+// FileTableStore 每张桌子一个文件的检查点存储
+type FileTableStore struct {
+	dir string
+}
+
+// NewFileTableStore 创建文件检查点存储，目录不存在时自动创建
+func NewFileTableStore(dir string) (*FileTableStore, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	return &FileTableStore{dir: dir}, nil
+}
+
+func (s *FileTableStore) path(key string) string {
+	return filepath.Join(s.dir, strings.ReplaceAll(key, ":", "_")+".json")
+}
+
+// Save 先写临时文件再改名，避免进程中途退出留下半个文件
+func (s *FileTableStore) Save(key string, data []byte) error {
+	tmp := s.path(key) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path(key))
+}
+
+func (s *FileTableStore) Load(key string) ([]byte, error) {
+	data, err := os.ReadFile(s.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrTableNotFound
+	}
+	return data, err
+}
+
+func (s *FileTableStore) Delete(key string) error {
+	err := os.Remove(s.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (s *FileTableStore) LoadAll() (map[string][]byte, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string][]byte)
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".json")
+		if entry.IsDir() || !ok {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(s.dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		result[strings.ReplaceAll(name, "_", ":")] = data
+	}
+	return result, nil
+}
+
+// ETCDTableStore 使用etcd保存检查点，不绑定lease，进程重启后仍然存在
+type ETCDTableStore struct {
+	modules.Base
+	cli             *clientv3.Client
+	etcdEndpoints   []string
+	etcdPrefix      string
+	etcdDialTimeout time.Duration
+}
+
+// NewETCDTableStore 创建etcd检查点存储，prefix需要区分游戏服
+func NewETCDTableStore(conf config.ETCDBindingConfig, prefix string) *ETCDTableStore {
+	return &ETCDTableStore{
+		etcdEndpoints:   conf.Endpoints,
+		etcdPrefix:      conf.Prefix + prefix,
+		etcdDialTimeout: conf.DialTimeout,
+	}
+}
+
+func getTableStoreKey(key string) string {
+	return "tables/" + key
+}
+
+// Init starts the table store module
+func (s *ETCDTableStore) Init() error {
+	if s.cli == nil {
+		cli, err := clientv3.New(clientv3.Config{
+			Endpoints:   s.etcdEndpoints,
+			DialTimeout: s.etcdDialTimeout,
+		})
+		if err != nil {
+			return err
+		}
+		s.cli = cli
+	}
+	s.cli.KV = namespace.NewKV(s.cli.KV, s.etcdPrefix)
+	return nil
+}
+
+func (s *ETCDTableStore) Save(key string, data []byte) error {
+	_, err := s.cli.Put(context.Background(), getTableStoreKey(key), string(data))
+	return err
+}
+
+func (s *ETCDTableStore) Load(key string) ([]byte, error) {
+	rsp, err := s.cli.Get(context.Background(), getTableStoreKey(key))
+	if err != nil {
+		return nil, err
+	}
+	if len(rsp.Kvs) == 0 {
+		return nil, ErrTableNotFound
+	}
+	return rsp.Kvs[0].Value, nil
+}
+
+func (s *ETCDTableStore) Delete(key string) error {
+	_, err := s.cli.Delete(context.Background(), getTableStoreKey(key))
+	return err
+}
+
+func (s *ETCDTableStore) LoadAll() (map[string][]byte, error) {
+	rsp, err := s.cli.Get(context.Background(), getTableStoreKey(""), clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string][]byte, len(rsp.Kvs))
+	for _, kv := range rsp.Kvs {
+		result[strings.TrimPrefix(string(kv.Key), getTableStoreKey(""))] = kv.Value
+	}
+	return result, nil
+}
+
+// Shutdown closes the etcd client
+func (s *ETCDTableStore) Shutdown() error {
+	return s.cli.Close()
+}
@@ -0,0 +1,43 @@
+package storage_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/kevin-chtw/tw_common/storage"
+)
+
+func TestFileTableStore(t *testing.T) {
+	s, err := storage.NewFileTableStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Save("1001:1", []byte(`{"a":1}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Save("1001:2", []byte(`{"a":2}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Save("1001:1", []byte(`{"a":3}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := s.Load("1001:1")
+	if err != nil || string(data) != `{"a":3}` {
+		t.Fatalf("Load() = %s, %v", data, err)
+	}
+	all, err := s.LoadAll()
+	if err != nil || len(all) != 2 || string(all["1001:2"]) != `{"a":2}` {
+		t.Fatalf("LoadAll() = %v, %v", all, err)
+	}
+
+	if err := s.Delete("1001:1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete("1001:1"); err != nil {
+		t.Fatalf("delete twice: %v", err)
+	}
+	if _, err := s.Load("1001:1"); !errors.Is(err, storage.ErrTableNotFound) {
+		t.Fatalf("Load() after delete err = %v", err)
+	}
+}
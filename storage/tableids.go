@@ -0,0 +1,334 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/topfreegames/pitaya/v3/pkg/cluster"
+	"github.com/topfreegames/pitaya/v3/pkg/config"
+	"github.com/topfreegames/pitaya/v3/pkg/logger"
+	"github.com/topfreegames/pitaya/v3/pkg/modules"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/namespace"
+)
+
+const (
+	MinTableID = 100000
+	MaxTableID = 999999
+
+	tableIDBlockSize = 1000
+)
+
+// ErrTableIDsExhausted 桌子ID区间耗尽
+var ErrTableIDsExhausted = errors.New("all ids in range are used")
+
+// TableIDLeaser 租用和释放ID块，多个服务器同时租用同一块时只有一个成功
+type TableIDLeaser interface {
+	// Lease 块没有被租用时租用，返回是否成功
+	Lease(num int32) (bool, error)
+	// Release 释放本服务器租用的块
+	Release(num int32) error
+	// Renew lease重建后把本服务器租用的块换到新的lease，块已经过期删除时重新租用，返回是否成功
+	Renew(num int32) (bool, error)
+}
+
+// BlockTableIDs 把[min, max]分成若干块，只从租用的块中分配ID，块中的ID都归还后释放这块
+type BlockTableIDs struct {
+	leaser    TableIDLeaser
+	min       int32
+	max       int32
+	blockSize int32
+
+	mu      sync.Mutex
+	blocks  map[int32]*idBlock // 块号 -> 本服务器租用的块
+	current *idBlock
+	onLost  func(ids []int32)
+}
+
+// idBlock 租用的一块ID，内存只和块大小有关
+type idBlock struct {
+	num   int32
+	first int32
+	size  int32 // 最后一块可能不满
+	used  map[int32]struct{}
+	next  int32 // 下一个尝试的偏移
+}
+
+// NewBlockTableIDs 创建按块租用的分配器
+func NewBlockTableIDs(min, max, blockSize int32, leaser TableIDLeaser) *BlockTableIDs {
+	return &BlockTableIDs{
+		leaser:    leaser,
+		min:       min,
+		max:       max,
+		blockSize: blockSize,
+		blocks:    make(map[int32]*idBlock),
+	}
+}
+
+// SetOnLost 设置丢失块时的回调，ids是丢失的块中正在使用的ID，其他服务器可能会分配出相同的ID
+func (s *BlockTableIDs) SetOnLost(f func(ids []int32)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onLost = f
+}
+
+func (s *BlockTableIDs) blockCount() int32 {
+	return (s.max - s.min + s.blockSize) / s.blockSize
+}
+
+// Take 从租用的块中分配ID，块都用完时再租用一块
+func (s *BlockTableIDs) Take() (int32, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.current != nil {
+		if id, ok := s.current.take(); ok {
+			return id, nil
+		}
+	}
+	for _, block := range s.blocks {
+		if id, ok := block.take(); ok {
+			s.current = block
+			return id, nil
+		}
+	}
+	block, err := s.claim()
+	if err != nil {
+		return 0, err
+	}
+	s.current = block
+	id, _ := block.take()
+	return id, nil
+}
+
+// PutBack 归还ID，块中的ID都归还后释放这块，当前使用的块不释放
+func (s *BlockTableIDs) PutBack(id int32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if id < s.min || id > s.max {
+		return
+	}
+	block := s.blocks[(id-s.min)/s.blockSize]
+	if block == nil {
+		return
+	}
+	delete(block.used, id)
+	if len(block.used) > 0 || block == s.current {
+		return
+	}
+	delete(s.blocks, block.num)
+	if err := s.leaser.Release(block.num); err != nil {
+		logger.Log.Errorf("[table ids] release block %d failed: %v", block.num, err)
+	}
+}
+
+// claim 需要持有s.mu，从随机位置开始找一块没有被租用的块
+func (s *BlockTableIDs) claim() (*idBlock, error) {
+	count := s.blockCount()
+	start := rand.Int31n(count)
+	for i := range count {
+		num := (start + i) % count
+		if _, ok := s.blocks[num]; ok {
+			continue
+		}
+		ok, err := s.leaser.Lease(num)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			first := s.min + num*s.blockSize
+			size := min(s.blockSize, s.max-first+1)
+			block := &idBlock{num: num, first: first, size: size, used: make(map[int32]struct{}), next: rand.Int31n(size)}
+			s.blocks[num] = block
+			logger.Log.Infof("[table ids] claimed block %d", num)
+			return block, nil
+		}
+	}
+	return nil, ErrTableIDsExhausted
+}
+
+// Reclaim lease重建后用Renew把持有的块换到新的lease，已经被其他服务器租用的块不再分配，
+// 块中正在使用的ID通过SetOnLost的回调通知比赛
+func (s *BlockTableIDs) Reclaim() {
+	s.mu.Lock()
+	var lost []int32
+	for num, block := range s.blocks {
+		ok, err := s.leaser.Renew(num)
+		if err == nil && ok {
+			continue
+		}
+		logger.Log.Errorf("[table ids] lost block %d with %d ids in use: %v", num, len(block.used), err)
+		delete(s.blocks, num)
+		if block == s.current {
+			s.current = nil
+		}
+		for id := range block.used {
+			lost = append(lost, id)
+		}
+	}
+	onLost := s.onLost
+	s.mu.Unlock()
+
+	if len(lost) > 0 && onLost != nil {
+		slices.Sort(lost)
+		onLost(lost)
+	}
+}
+
+// take 从上次的位置循环查找未使用的ID
+func (b *idBlock) take() (int32, bool) {
+	if int32(len(b.used)) >= b.size {
+		return 0, false
+	}
+	for {
+		id := b.first + b.next
+		b.next = (b.next + 1) % b.size
+		if _, ok := b.used[id]; !ok {
+			b.used[id] = struct{}{}
+			return id, true
+		}
+	}
+}
+
+// ETCDTableIDs 集群内唯一的桌子ID分配器。每个服务器在etcd中用自己的lease租用块，
+// 服务器宕机lease过期后块自动释放，其他服务器可以重新租用
+type ETCDTableIDs struct {
+	modules.Base
+	*BlockTableIDs
+	cli             *clientv3.Client
+	etcdEndpoints   []string
+	etcdPrefix      string
+	etcdDialTimeout time.Duration
+	leaseTTL        time.Duration
+	leaseID         atomic.Int64
+	thisServer      *cluster.Server
+	stopChan        chan struct{}
+}
+
+// NewETCDTableIDs 创建etcd桌子ID分配器，注册为tableids模块后比赛自动使用
+func NewETCDTableIDs(server *cluster.Server, conf config.ETCDBindingConfig) *ETCDTableIDs {
+	s := &ETCDTableIDs{
+		thisServer:      server,
+		stopChan:        make(chan struct{}),
+		etcdEndpoints:   conf.Endpoints,
+		etcdPrefix:      conf.Prefix,
+		etcdDialTimeout: conf.DialTimeout,
+		leaseTTL:        conf.LeaseTTL,
+	}
+	s.BlockTableIDs = NewBlockTableIDs(MinTableID, MaxTableID, tableIDBlockSize, s)
+	return s
+}
+
+func getTableIDBlockKey(num int32) string {
+	return "tableids/" + strconv.Itoa(int(num))
+}
+
+// Lease 块不存在时用本服务器的lease创建
+func (s *ETCDTableIDs) Lease(num int32) (bool, error) {
+	key := getTableIDBlockKey(num)
+	rsp, err := s.cli.Txn(context.Background()).
+		If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+		Then(clientv3.OpPut(key, s.thisServer.ID, clientv3.WithLease(clientv3.LeaseID(s.leaseID.Load())))).
+		Commit()
+	if err != nil {
+		return false, err
+	}
+	return rsp.Succeeded, nil
+}
+
+// Renew 块的值还是本服务器ID时换到当前lease，旧lease还没过期时块仍然存在；
+// 已经过期删除时按Lease重新租用
+func (s *ETCDTableIDs) Renew(num int32) (bool, error) {
+	key := getTableIDBlockKey(num)
+	rsp, err := s.cli.Txn(context.Background()).
+		If(clientv3.Compare(clientv3.Value(key), "=", s.thisServer.ID)).
+		Then(clientv3.OpPut(key, s.thisServer.ID, clientv3.WithLease(clientv3.LeaseID(s.leaseID.Load())))).
+		Commit()
+	if err != nil {
+		return false, err
+	}
+	if rsp.Succeeded {
+		return true, nil
+	}
+	return s.Lease(num)
+}
+
+// Release 块还是本服务器的lease时删除
+func (s *ETCDTableIDs) Release(num int32) error {
+	key := getTableIDBlockKey(num)
+	_, err := s.cli.Txn(context.Background()).
+		If(clientv3.Compare(clientv3.LeaseValue(key), "=", clientv3.LeaseID(s.leaseID.Load()))).
+		Then(clientv3.OpDelete(key)).
+		Commit()
+	return err
+}
+
+func (s *ETCDTableIDs) watchLeaseChan(c <-chan *clientv3.LeaseKeepAliveResponse) {
+	for {
+		select {
+		case <-s.stopChan:
+			return
+		case kaRes := <-c:
+			if kaRes == nil {
+				logger.Log.Warn("[table ids] error renewing etcd lease, rebootstrapping")
+				for {
+					err := s.bootstrapLease()
+					if err != nil {
+						logger.Log.Warn("[table ids] error rebootstrapping lease, will retry in 5 seconds")
+						time.Sleep(5 * time.Second)
+						continue
+					}
+					s.Reclaim()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (s *ETCDTableIDs) bootstrapLease() error {
+	l, err := s.cli.Grant(context.TODO(), int64(s.leaseTTL.Seconds()))
+	if err != nil {
+		return err
+	}
+	s.leaseID.Store(int64(l.ID))
+	c, err := s.cli.KeepAlive(context.TODO(), l.ID)
+	if err != nil {
+		return err
+	}
+	<-c
+	go s.watchLeaseChan(c)
+	return nil
+}
+
+// Init starts the table ids module
+func (s *ETCDTableIDs) Init() error {
+	if s.cli == nil {
+		cli, err := clientv3.New(clientv3.Config{
+			Endpoints:   s.etcdEndpoints,
+			DialTimeout: s.etcdDialTimeout,
+		})
+		if err != nil {
+			return err
+		}
+		s.cli = cli
+	}
+	s.cli.KV = namespace.NewKV(s.cli.KV, s.etcdPrefix)
+	return s.bootstrapLease()
+}
+
+// Shutdown 撤销lease释放租用的块并关闭etcd客户端
+func (s *ETCDTableIDs) Shutdown() error {
+	close(s.stopChan)
+	if _, err := s.cli.Revoke(context.Background(), clientv3.LeaseID(s.leaseID.Load())); err != nil {
+		logger.Log.Warnf("[table ids] revoke lease failed: %v", err)
+	}
+	return s.cli.Close()
+}
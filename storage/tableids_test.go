@@ -0,0 +1,178 @@
+package storage_test
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/kevin-chtw/tw_common/storage"
+)
+
+// fakeLeaser 内存中的块租用，others是其他服务器租用的块
+type fakeLeaser struct {
+	mu       sync.Mutex
+	leased   map[int32]bool
+	others   map[int32]bool
+	released []int32
+	err      error
+}
+
+func newFakeLeaser(others ...int32) *fakeLeaser {
+	l := &fakeLeaser{leased: make(map[int32]bool), others: make(map[int32]bool)}
+	for _, num := range others {
+		l.others[num] = true
+	}
+	return l
+}
+
+func (l *fakeLeaser) Lease(num int32) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.err != nil {
+		return false, l.err
+	}
+	if l.leased[num] || l.others[num] {
+		return false, nil
+	}
+	l.leased[num] = true
+	return true, nil
+}
+
+// Renew 块没有被其他服务器租用时成功，不管旧的租用是否还在
+func (l *fakeLeaser) Renew(num int32) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.err != nil {
+		return false, l.err
+	}
+	if l.others[num] {
+		return false, nil
+	}
+	l.leased[num] = true
+	return true, nil
+}
+
+func (l *fakeLeaser) Release(num int32) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.leased, num)
+	l.released = append(l.released, num)
+	return nil
+}
+
+func takeN(t *testing.T, ids *storage.BlockTableIDs, n int) []int32 {
+	t.Helper()
+	taken := make([]int32, 0, n)
+	for range n {
+		id, err := ids.Take()
+		if err != nil {
+			t.Fatal(err)
+		}
+		taken = append(taken, id)
+	}
+	slices.Sort(taken)
+	return taken
+}
+
+func TestBlockTableIDsTake(t *testing.T) {
+	// [1, 25]分成3块，只有不满的最后一块可以租用
+	ids := storage.NewBlockTableIDs(1, 25, 10, newFakeLeaser(0, 1))
+	if taken := takeN(t, ids, 5); !slices.Equal(taken, []int32{21, 22, 23, 24, 25}) {
+		t.Fatalf("taken = %v", taken)
+	}
+	if _, err := ids.Take(); !errors.Is(err, storage.ErrTableIDsExhausted) {
+		t.Fatalf("err = %v", err)
+	}
+	// 归还的ID在下一圈重新使用
+	for _, id := range []int32{23, 21} {
+		ids.PutBack(id)
+	}
+	if taken := takeN(t, ids, 2); !slices.Equal(taken, []int32{21, 23}) {
+		t.Fatalf("taken = %v", taken)
+	}
+	ids.PutBack(26)
+	ids.PutBack(0)
+}
+
+func TestBlockTableIDsRelease(t *testing.T) {
+	leaser := newFakeLeaser()
+	ids := storage.NewBlockTableIDs(1, 20, 10, leaser)
+	first := takeN(t, ids, 10)
+	if first[9]-first[0] != 9 || (first[0]-1)%10 != 0 {
+		t.Fatalf("first block = %v", first)
+	}
+	second := takeN(t, ids, 10)
+	if len(leaser.leased) != 2 {
+		t.Fatalf("leased = %v", leaser.leased)
+	}
+	if _, err := ids.Take(); !errors.Is(err, storage.ErrTableIDsExhausted) {
+		t.Fatalf("err = %v", err)
+	}
+
+	// 块中的ID都归还后释放，当前使用的块不释放
+	for _, id := range first {
+		ids.PutBack(id)
+	}
+	if num := (first[0] - 1) / 10; !slices.Equal(leaser.released, []int32{num}) {
+		t.Fatalf("released = %v, want %d", leaser.released, num)
+	}
+	for _, id := range second {
+		ids.PutBack(id)
+	}
+	if len(leaser.released) != 1 {
+		t.Fatalf("released current block: %v", leaser.released)
+	}
+	if taken := takeN(t, ids, 10); !slices.Equal(taken, second) {
+		t.Fatalf("taken = %v, want %v", taken, second)
+	}
+}
+
+func TestBlockTableIDsReclaim(t *testing.T) {
+	leaser := newFakeLeaser()
+	ids := storage.NewBlockTableIDs(1, 30, 10, leaser)
+	var lost []int32
+	ids.SetOnLost(func(l []int32) { lost = l })
+
+	kept := takeN(t, ids, 10)
+	stolen := takeN(t, ids, 3)
+	keptNum, stolenNum := (kept[0]-1)/10, (stolen[0]-1)/10
+
+	// 旧lease还没过期时块仍然是本服务器的，换到新的lease
+	ids.Reclaim()
+	if lost != nil || len(leaser.leased) != 2 {
+		t.Fatalf("lost = %v, leased = %v", lost, leaser.leased)
+	}
+
+	// lease过期后其他服务器租用了一块
+	leaser.leased = make(map[int32]bool)
+	leaser.others[stolenNum] = true
+	ids.Reclaim()
+	if !slices.Equal(lost, stolen) {
+		t.Fatalf("lost = %v, want %v", lost, stolen)
+	}
+	if !leaser.leased[keptNum] || len(leaser.leased) != 1 {
+		t.Fatalf("leased = %v", leaser.leased)
+	}
+
+	// 丢失的块不再分配也不释放
+	ids.PutBack(stolen[0])
+	if len(leaser.released) != 0 {
+		t.Fatalf("released = %v", leaser.released)
+	}
+	id, err := ids.Take()
+	if err != nil || (id-1)/10 == stolenNum || (id-1)/10 == keptNum {
+		t.Fatalf("Take() = %d, %v", id, err)
+	}
+
+	// 租用失败时也算丢失
+	leaser.err = errors.New("etcd down")
+	lost = nil
+	ids.Reclaim()
+	if len(lost) != 11 {
+		t.Fatalf("lost %d ids", len(lost))
+	}
+	if _, err := ids.Take(); !errors.Is(err, leaser.err) {
+		t.Fatalf("err = %v", err)
+	}
+}
@@ -0,0 +1,113 @@
+package utils
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// Clock 时间来源，测试时可以替换为手动推进的时钟
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker 定时触发器
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// SystemClock 系统时钟
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) NewTicker(d time.Duration) Ticker {
+	return systemTicker{time.NewTicker(d)}
+}
+
+type systemTicker struct {
+	*time.Ticker
+}
+
+func (t systemTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
+
+// ManualClock 只在Advance时前进的时钟，用于测试超时逻辑
+type ManualClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	tickers []*manualTicker
+}
+
+// NewManualClock 创建从start开始的手动时钟
+func NewManualClock(start time.Time) *ManualClock {
+	return &ManualClock{now: start}
+}
+
+func (c *ManualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *ManualClock) NewTicker(d time.Duration) Ticker {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &manualTicker{
+		clock:  c,
+		c:      make(chan time.Time, 1),
+		period: d,
+		next:   c.now.Add(d),
+	}
+	c.tickers = append(c.tickers, t)
+	return t
+}
+
+// Advance 时钟前进d，到期的ticker和time.Ticker一样在接收方来不及处理时丢弃多余的触发
+func (c *ManualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	now := c.now
+	tickers := slices.Clone(c.tickers)
+	c.mu.Unlock()
+
+	for _, t := range tickers {
+		t.fire(now)
+	}
+}
+
+type manualTicker struct {
+	clock  *ManualClock
+	c      chan time.Time
+	period time.Duration
+	next   time.Time
+}
+
+func (t *manualTicker) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *manualTicker) Stop() {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	t.clock.tickers = slices.DeleteFunc(t.clock.tickers, func(o *manualTicker) bool { return o == t })
+}
+
+func (t *manualTicker) fire(now time.Time) {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	for !t.next.After(now) {
+		select {
+		case t.c <- t.next:
+		default:
+		}
+		t.next = t.next.Add(t.period)
+	}
+}
@@ -5,3 +5,6 @@ const (
 	MJSC   = "mjsc"
 	MJXL   = "mjxl"
 )
+
+// ErrCodeDraining 游戏服排空中拒绝新桌子的错误码，比赛服收到后换一个游戏服重试
+const ErrCodeDraining = "GAME-503"
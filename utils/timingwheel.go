@@ -0,0 +1,168 @@
+package utils
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	wheelBits   = 6
+	wheelSize   = 1 << wheelBits
+	wheelMask   = wheelSize - 1
+	wheelLevels = 4
+	wheelSpan   = int64(1) << (wheelBits * wheelLevels) // 能直接放下的最大tick数
+)
+
+// TimingWheel 分层时间轮，注册和取消都是O(1)，没有到期任务时不做任何事。
+// 每层64格，第0层每格一个tick，上一层每格是下一层一圈
+type TimingWheel struct {
+	mu      sync.Mutex
+	advance sync.Mutex // 保证Advance返回时到期的回调都已执行
+	clock   Clock
+	tick    time.Duration
+	start   time.Time
+	current int64 // 已经处理到的tick
+	slots   [wheelLevels][wheelSize]map[*WheelTimer]struct{}
+	count   int
+	seq     uint64
+}
+
+// WheelTimer 时间轮中的任务
+type WheelTimer struct {
+	expire int64  // 到期的tick
+	seq    uint64 // 同一个tick到期的任务按注册顺序执行
+	fn     func()
+	level  int
+	slot   int
+	done   bool // 已经触发或取消
+}
+
+// NewTimingWheel 创建精度为tick的时间轮，需要调用Run或Advance推进
+func NewTimingWheel(clock Clock, tick time.Duration) *TimingWheel {
+	w := &TimingWheel{
+		clock: clock,
+		tick:  tick,
+		start: clock.Now(),
+	}
+	for l := range w.slots {
+		for s := range w.slots[l] {
+			w.slots[l][s] = make(map[*WheelTimer]struct{})
+		}
+	}
+	return w
+}
+
+// AfterFunc d之后在推进时间轮的goroutine里执行fn，fn应该很快返回
+func (w *TimingWheel) AfterFunc(d time.Duration, fn func()) *WheelTimer {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	elapsed := w.clock.Now().Add(d).Sub(w.start)
+	expire := int64((elapsed + w.tick - 1) / w.tick) // 向上取整，不会提前触发
+	w.seq++
+	t := &WheelTimer{expire: expire, seq: w.seq, fn: fn}
+	w.add(t)
+	w.count++
+	return t
+}
+
+// Stop 取消任务，任务已经触发或取消时返回false
+func (w *TimingWheel) Stop(t *WheelTimer) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if t.done {
+		return false
+	}
+	t.done = true
+	delete(w.slots[t.level][t.slot], t)
+	w.count--
+	return true
+}
+
+// Len 等待触发的任务数
+func (w *TimingWheel) Len() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.count
+}
+
+// add 需要持有w.mu，到期时间已过的任务放到下一个tick
+func (w *TimingWheel) add(t *WheelTimer) {
+	expire := max(t.expire, w.current+1)
+	delta := min(expire-w.current, wheelSpan-1)
+	level := 0
+	for level < wheelLevels-1 && delta >= int64(1)<<(wheelBits*(level+1)) {
+		level++
+	}
+	t.level = level
+	t.slot = int(((w.current + delta) >> (wheelBits * level)) & wheelMask)
+	w.slots[level][t.slot][t] = struct{}{}
+}
+
+// Advance 推进到当前时间，执行所有到期的回调
+func (w *TimingWheel) Advance() {
+	w.advance.Lock()
+	defer w.advance.Unlock()
+	for _, fn := range w.expired() {
+		fn()
+	}
+}
+
+func (w *TimingWheel) expired() []func() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	target := int64(w.clock.Now().Sub(w.start) / w.tick)
+	var fns []func()
+	for w.current < target {
+		var due []*WheelTimer
+		w.current++
+		// 上层转到新的一格时把任务分散到下层，从高层往低层
+		for level := wheelLevels - 1; level > 0; level-- {
+			if w.current&(int64(1)<<(wheelBits*level)-1) != 0 {
+				continue
+			}
+			slot := int((w.current >> (wheelBits * level)) & wheelMask)
+			timers := w.slots[level][slot]
+			w.slots[level][slot] = make(map[*WheelTimer]struct{})
+			for t := range timers {
+				if t.expire <= w.current {
+					// 正好这个tick到期，放到第0层当前格
+					t.level, t.slot = 0, int(w.current&wheelMask)
+					w.slots[0][t.slot][t] = struct{}{}
+					continue
+				}
+				w.add(t)
+			}
+		}
+		slot := int(w.current & wheelMask)
+		for t := range w.slots[0][slot] {
+			if t.expire > w.current {
+				continue // 超过时间轮范围的任务，等下一圈
+			}
+			delete(w.slots[0][slot], t)
+			t.done = true
+			w.count--
+			due = append(due, t)
+		}
+		slices.SortFunc(due, func(a, b *WheelTimer) int { return cmp.Compare(a.seq, b.seq) })
+		for _, t := range due {
+			fns = append(fns, t.fn)
+		}
+	}
+	return fns
+}
+
+// Run 每个tick推进一次时间轮，直到stop关闭
+func (w *TimingWheel) Run(stop <-chan struct{}) {
+	ticker := w.clock.NewTicker(w.tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C():
+			w.Advance()
+		case <-stop:
+			return
+		}
+	}
+}
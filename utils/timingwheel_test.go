@@ -0,0 +1,96 @@
+package utils_test
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/kevin-chtw/tw_common/utils"
+)
+
+func newTestWheel() (*utils.TimingWheel, *utils.ManualClock) {
+	clock := utils.NewManualClock(time.Unix(1000, 0))
+	return utils.NewTimingWheel(clock, time.Millisecond), clock
+}
+
+func TestTimingWheelLevels(t *testing.T) {
+	w, clock := newTestWheel()
+	// 覆盖每一层和层与层之间的边界
+	delays := []time.Duration{1, 5, 63, 64, 65, 100, 4095, 4096, 4097, 262143, 262144, 300000}
+	fired := make(map[time.Duration]bool)
+	for _, d := range delays {
+		w.AfterFunc(d*time.Millisecond, func() { fired[d] = true })
+	}
+	if w.Len() != len(delays) {
+		t.Fatalf("Len() = %d", w.Len())
+	}
+
+	var now time.Duration
+	for i, d := range delays {
+		// 到期前一个tick不触发，到期时触发
+		clock.Advance((d - 1 - now) * time.Millisecond)
+		w.Advance()
+		if fired[d] {
+			t.Fatalf("%dms fired early", d)
+		}
+		clock.Advance(time.Millisecond)
+		w.Advance()
+		now = d
+		if !fired[d] {
+			t.Fatalf("%dms not fired", d)
+		}
+		if w.Len() != len(delays)-i-1 {
+			t.Fatalf("after %dms Len() = %d", d, w.Len())
+		}
+	}
+}
+
+func TestTimingWheelOrder(t *testing.T) {
+	w, clock := newTestWheel()
+	var got []int
+	// 先注册的在上层，降到第0层后和后注册的同一个tick到期，仍然按注册顺序执行
+	w.AfterFunc(130*time.Millisecond, func() { got = append(got, 0) })
+	clock.Advance(100 * time.Millisecond)
+	w.Advance()
+	for i := 1; i < 5; i++ {
+		w.AfterFunc(30*time.Millisecond, func() { got = append(got, i) })
+	}
+	// 已经过期的任务在下一个tick执行
+	w.AfterFunc(-time.Second, func() { got = append(got, -1) })
+	clock.Advance(time.Millisecond)
+	w.Advance()
+	if !slices.Equal(got, []int{-1}) {
+		t.Fatalf("got = %v", got)
+	}
+	clock.Advance(29 * time.Millisecond)
+	w.Advance()
+	if !slices.Equal(got, []int{-1, 0, 1, 2, 3, 4}) {
+		t.Fatalf("got = %v", got)
+	}
+}
+
+func TestTimingWheelStop(t *testing.T) {
+	w, clock := newTestWheel()
+	fired := 0
+	cascaded := w.AfterFunc(200*time.Millisecond, func() { fired++ })
+	upper := w.AfterFunc(5000*time.Millisecond, func() { fired++ })
+	done := w.AfterFunc(10*time.Millisecond, func() { fired++ })
+
+	// 192ms时200ms的任务从第1层降到第0层
+	clock.Advance(195 * time.Millisecond)
+	w.Advance()
+	if fired != 1 || w.Stop(done) {
+		t.Fatalf("fired = %d", fired)
+	}
+	if !w.Stop(cascaded) || w.Stop(cascaded) {
+		t.Fatal("stop cascaded timer")
+	}
+	if !w.Stop(upper) || w.Len() != 0 {
+		t.Fatalf("Len() = %d", w.Len())
+	}
+	clock.Advance(10 * time.Second)
+	w.Advance()
+	if fired != 1 {
+		t.Fatalf("fired = %d", fired)
+	}
+}